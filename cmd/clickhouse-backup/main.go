@@ -45,6 +45,27 @@ func main() {
 			Usage:    "internal parameter for API call",
 		},
 	}
+	storageFlag := cli.StringFlag{
+		Name:   "storage",
+		Hidden: false,
+		Usage:  "Remote storage name from `remote_storages` section or `default` for general->remote_storage, upload, create_remote and watch replicate backup to all remote storages when empty",
+	}
+	forceUnlockFlag := cli.BoolFlag{
+		Name:   "force-unlock",
+		Hidden: false,
+		Usage:  "Override remote storage lock held by another host, use it only when lock owner is dead and you can't wait until general->remote_lock_ttl expired",
+	}
+	consistentFlag := cli.BoolFlag{
+		Name:   "consistent",
+		Hidden: false,
+		Usage:  "Freeze all tables in parallel within clickhouse->consistent_freeze_timeout to get backup of related tables close to point-in-time",
+	}
+	formatFlag := cli.StringFlag{
+		Name:   "format",
+		Hidden: false,
+		Value:  "regular",
+		Usage:  "Backup format, regular freezes tables, logical exports data of each table and partition in general->logical_backup_format, which could be restored into other clickhouse-server version or changed schema",
+	}
 	cliapp.CommandNotFound = func(c *cli.Context, command string) {
 		fmt.Printf("Error. Unknown command: '%s'\n\n", command)
 		cli.ShowAppHelpAndExit(c, 1)
@@ -81,11 +102,11 @@ func main() {
 		{
 			Name:        "create",
 			Usage:       "Create new backup",
-			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--rbac] [--configs] <backup_name>",
+			UsageText:   "clickhouse-backup create [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--rbac] [--configs] [--consistent] [--format=regular|logical] <backup_name>",
 			Description: "Create new backup",
 			Action: func(c *cli.Context) error {
 				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.CreateBackup(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("configs"), version, c.Int("command-id"))
+				return b.CreateBackup(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("configs"), c.Bool("consistent"), c.String("format"), version, c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -113,18 +134,21 @@ func main() {
 					Hidden: false,
 					Usage:  "Backup ClickHouse server configuration files only",
 				},
+				consistentFlag,
+				formatFlag,
 			),
 		},
 		{
 			Name:        "create_remote",
 			Usage:       "Create and upload",
-			UsageText:   "clickhouse-backup create_remote [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--diff-from=<local_backup_name>] [--diff-from-remote=<local_backup_name>] [--schema] [--rbac] [--configs] [--resumable] <backup_name>",
+			UsageText:   "clickhouse-backup create_remote [--storage=<name>] [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--diff-from=<local_backup_name>] [--diff-from-remote=<local_backup_name>] [--schema] [--rbac] [--configs] [--consistent] [--format=regular|logical] [--resumable] <backup_name>",
 			Description: "Create and upload",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.CreateToRemote(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("configs"), c.Bool("resume"), version, c.Int("command-id"))
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, true))
+				return b.CreateToRemote(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("rbac"), c.Bool("configs"), c.Bool("consistent"), c.Bool("resume"), c.String("format"), version, c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
+				storageFlag,
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
@@ -165,17 +189,21 @@ func main() {
 					Hidden: false,
 					Usage:  "Save intermediate upload state and resume upload if backup exists on remote storage, ignore when `remote_storage: custom` or `use_embedded_backup_restore: true`",
 				},
+				consistentFlag,
+				formatFlag,
 			),
 		},
 		{
 			Name:      "upload",
 			Usage:     "Upload backup to remote storage",
-			UsageText: "clickhouse-backup upload [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--diff-from=<local_backup_name>] [--diff-from-remote=<remote_backup_name>] [--resumable] <backup_name>",
+			UsageText: "clickhouse-backup upload [--storage=<name>] [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--diff-from=<local_backup_name>] [--diff-from-remote=<remote_backup_name>] [--resumable] [--force-unlock] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.Upload(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"), c.Int("command-id"))
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, true))
+				return b.Upload(c.Args().First(), c.String("diff-from"), c.String("diff-from-remote"), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"), c.Bool("force-unlock"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
+				storageFlag,
+				forceUnlockFlag,
 				cli.StringFlag{
 					Name:   "diff-from",
 					Hidden: false,
@@ -211,22 +239,23 @@ func main() {
 		{
 			Name:      "list",
 			Usage:     "List list of backups",
-			UsageText: "clickhouse-backup list [all|local|remote] [latest|previous]",
+			UsageText: "clickhouse-backup list [--storage=<name>] [all|local|remote] [latest|previous]",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
 				return b.List(c.Args().Get(0), c.Args().Get(1))
 			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags, storageFlag),
 		},
 		{
 			Name:      "download",
 			Usage:     "Download backup from remote storage",
-			UsageText: "clickhouse-backup download [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--resumable] <backup_name>",
+			UsageText: "clickhouse-backup download [--storage=<name>] [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [-s, --schema] [--resumable] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
 				return b.Download(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("resume"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
+				storageFlag,
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
@@ -252,10 +281,10 @@ func main() {
 		{
 			Name:      "restore",
 			Usage:     "Create schema and restore data from backup",
-			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [-s, --schema] [-d, --data] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] <backup_name>",
+			UsageText: "clickhouse-backup restore  [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--restore-table-mapping=<originDB>.<originTable>:<targetDB>.<targetTable>[,<...>]] [--partitions=<partitions_names>] [-s, --schema] [-d, --data] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--via-insert] [--as-of=<timestamp>] <backup_name>",
 			Action: func(c *cli.Context) error {
 				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.Restore(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("ignore-dependencies"), c.Bool("rbac"), c.Bool("configs"), c.Int("command-id"))
+				return b.Restore(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("restore-table-mapping"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("ignore-dependencies"), c.Bool("rbac"), c.Bool("configs"), c.Bool("via-insert"), c.String("as-of"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
 				cli.StringFlag{
@@ -268,6 +297,11 @@ func main() {
 					Usage:  "Define the rule to restore data. For the database not defined in this struct, the program will not deal with it.",
 					Hidden: false,
 				},
+				cli.StringSliceFlag{
+					Name:   "restore-table-mapping",
+					Usage:  "Define the rule to restore table with other name, has priority over restore-database-mapping. For the table not defined in this struct, the program will not deal with it.",
+					Hidden: false,
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -303,17 +337,28 @@ func main() {
 					Hidden: false,
 					Usage:  "Restore CONFIG related files only",
 				},
+				cli.StringFlag{
+					Name:   "as-of",
+					Hidden: false,
+					Usage:  "Restore only parts which existed at timestamp, when backup name is empty choose the newest backup created at or before timestamp",
+				},
+				cli.BoolFlag{
+					Name:   "via-insert",
+					Hidden: false,
+					Usage:  "Attach backup parts to temporary table with schema from backup and copy data with INSERT ... SELECT, allows restore data into table with added columns or changed engine",
+				},
 			),
 		},
 		{
 			Name:      "restore_remote",
 			Usage:     "Download and restore",
-			UsageText: "clickhouse-backup restore_remote [--schema] [--data] [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--partitions=<partitions_names>] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--skip-rbac] [--skip-configs] [--resumable] <backup_name>",
+			UsageText: "clickhouse-backup restore_remote [--storage=<name>] [--schema] [--data] [-t, --tables=<db>.<table>] [-m, --restore-database-mapping=<originDB>:<targetDB>[,<...>]] [--restore-table-mapping=<originDB>.<originTable>:<targetDB>.<targetTable>[,<...>]] [--partitions=<partitions_names>] [--rm, --drop] [-i, --ignore-dependencies] [--rbac] [--configs] [--skip-rbac] [--skip-configs] [--resumable] [--via-insert] [--as-of=<timestamp>] <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.RestoreFromRemote(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("i"), c.Bool("rbac"), c.Bool("configs"), c.Bool("resume"), c.Int("command-id"))
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
+				return b.RestoreFromRemote(c.Args().First(), c.String("t"), c.StringSlice("restore-database-mapping"), c.StringSlice("restore-table-mapping"), c.StringSlice("partitions"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Bool("i"), c.Bool("rbac"), c.Bool("configs"), c.Bool("resume"), c.Bool("via-insert"), c.String("as-of"), c.Int("command-id"))
 			},
 			Flags: append(cliapp.Flags,
+				storageFlag,
 				cli.StringFlag{
 					Name:   "table, tables, t",
 					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
@@ -324,6 +369,11 @@ func main() {
 					Usage:  "Define the rule to restore data. For the database not defined in this struct, the program will not deal with it.",
 					Hidden: false,
 				},
+				cli.StringSliceFlag{
+					Name:   "restore-table-mapping",
+					Usage:  "Define the rule to restore table with other name, has priority over restore-database-mapping. For the table not defined in this struct, the program will not deal with it.",
+					Hidden: false,
+				},
 				cli.StringSliceFlag{
 					Name:   "partitions",
 					Hidden: false,
@@ -364,14 +414,83 @@ func main() {
 					Hidden: false,
 					Usage:  "Save intermediate upload state and resume upload if backup exists on remote storage, ignored with `remote_storage: custom` or `use_embedded_backup_restore: true`",
 				},
+				cli.StringFlag{
+					Name:   "as-of",
+					Hidden: false,
+					Usage:  "Restore only parts which existed at timestamp, when backup name is empty choose the newest remote backup created at or before timestamp",
+				},
+				cli.BoolFlag{
+					Name:   "via-insert",
+					Hidden: false,
+					Usage:  "Attach backup parts to temporary table with schema from backup and copy data with INSERT ... SELECT, allows restore data into table with added columns or changed engine",
+				},
+			),
+		},
+		{
+			Name:      "create_cluster",
+			Usage:     "Create and upload backup on one replica of each shard from cluster->name, and upload cluster manifest",
+			UsageText: "clickhouse-backup create_cluster [--storage=<name>] [-t, --tables=<db>.<table>] [--partitions=<partition_names>] [--schema] <backup_name>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
+				return b.CreateCluster(c.Args().First(), c.String("t"), c.StringSlice("partitions"), c.Bool("s"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				storageFlag,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
+					Hidden: false,
+				},
+				cli.StringSliceFlag{
+					Name:   "partitions",
+					Hidden: false,
+					Usage:  "partition names, separated by comma",
+				},
+				cli.BoolFlag{
+					Name:   "schema, s",
+					Hidden: false,
+					Usage:  "Schemas only",
+				},
+			),
+		},
+		{
+			Name:      "restore_cluster",
+			Usage:     "Restore each shard from cluster->name with own backup from cluster manifest",
+			UsageText: "clickhouse-backup restore_cluster [--storage=<name>] [-t, --tables=<db>.<table>] [-s, --schema] [-d, --data] [--rm, --drop] <backup_name>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
+				return b.RestoreCluster(c.Args().First(), c.String("t"), c.Bool("s"), c.Bool("d"), c.Bool("rm"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				storageFlag,
+				cli.StringFlag{
+					Name:   "table, tables, t",
+					Usage:  "table name patterns, separated by comma, allow ? and * as wildcard",
+					Hidden: false,
+				},
+				cli.BoolFlag{
+					Name:   "schema, s",
+					Hidden: false,
+					Usage:  "Restore schema only",
+				},
+				cli.BoolFlag{
+					Name:   "data, d",
+					Hidden: false,
+					Usage:  "Restore data only",
+				},
+				cli.BoolFlag{
+					Name:   "rm, drop",
+					Hidden: false,
+					Usage:  "Drop exists schema objects before restore",
+				},
 			),
 		},
 		{
 			Name:      "delete",
 			Usage:     "Delete specific backup",
-			UsageText: "clickhouse-backup delete <local|remote> <backup_name>",
+			UsageText: "clickhouse-backup delete [--storage=<name>] [--force-unlock] <local|remote> <backup_name>",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
 				if c.Args().Get(1) == "" {
 					log.Errorf("Backup name must be defined")
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
@@ -380,9 +499,71 @@ func main() {
 					log.Errorf("Unknown command '%s'\n", c.Args().Get(0))
 					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
 				}
-				return b.Delete(c.Args().Get(0), c.Args().Get(1), c.Int("command-id"))
+				return b.Delete(c.Args().Get(0), c.Args().Get(1), c.Bool("force-unlock"), c.Int("command-id"))
 			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags, storageFlag, forceUnlockFlag),
+		},
+		{
+			Name:      "verify",
+			Usage:     "Verify remote backup and all required backups without restore",
+			UsageText: "clickhouse-backup verify [--storage=<name>] [--checksums] [--format=text|json] <backup_name>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
+				if c.Args().First() == "" {
+					log.Errorf("Backup name must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				return b.Verify(c.Args().First(), c.Bool("checksums"), c.String("format"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				storageFlag,
+				cli.BoolFlag{
+					Name:   "checksums",
+					Hidden: false,
+					Usage:  "Download and unpack each part to temporary directory and validate files with part checksums.txt",
+				},
+				cli.StringFlag{
+					Name:   "format, f",
+					Hidden: false,
+					Value:  "text",
+					Usage:  "Report format, text or json",
+				},
+			),
+		},
+		{
+			Name:      "copy_remote",
+			Usage:     "Copy remote backup to another path or bucket of the same remote storage without download",
+			UsageText: "clickhouse-backup copy_remote [--storage=<name>] [--to-path=<path>] [--to-bucket=<bucket>] [--with-required] <backup_name>",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
+				if c.Args().First() == "" {
+					log.Errorf("Backup name must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				if c.String("to-path") == "" && c.String("to-bucket") == "" {
+					log.Errorf("--to-path or --to-bucket must be defined")
+					cli.ShowCommandHelpAndExit(c, c.Command.Name, 1)
+				}
+				return b.CopyRemote(c.Args().First(), c.String("to-path"), c.String("to-bucket"), c.Bool("with-required"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				storageFlag,
+				cli.StringFlag{
+					Name:   "to-path",
+					Hidden: false,
+					Usage:  "Destination path inside bucket or remote directory, current remote storage path used when empty",
+				},
+				cli.StringFlag{
+					Name:   "to-bucket",
+					Hidden: false,
+					Usage:  "Destination bucket for s3, gcs, cos or container for azblob, current bucket used when empty",
+				},
+				cli.BoolFlag{
+					Name:   "with-required",
+					Hidden: false,
+					Usage:  "Copy all backups from required_backup chain which don't exist on destination",
+				},
+			),
 		},
 		{
 			Name:  "default-config",
@@ -413,22 +594,59 @@ func main() {
 			Name:  "clean_remote_broken",
 			Usage: "Remove all broken remote backups",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
-				return b.CleanRemoteBroken(status.NotFromAPI)
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
+				return b.CleanRemoteBroken(c.Bool("force-unlock"), status.NotFromAPI)
 			},
-			Flags: cliapp.Flags,
+			Flags: append(cliapp.Flags, storageFlag, forceUnlockFlag),
+		},
+		{
+			Name:      "retention",
+			Usage:     "Remove remote backups which are not kept by backups_to_keep_remote and retention policy",
+			UsageText: "clickhouse-backup retention [--storage=<name>] [--dry-run] [--force-unlock]",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
+				return b.Retention(c.Bool("dry-run"), c.Bool("force-unlock"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				storageFlag,
+				forceUnlockFlag,
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print each remote backup with keep or delete action and reason, don't delete anything",
+				},
+			),
+		},
+		{
+			Name:      "tier",
+			Usage:     "Move data of remote backups older than tier_after to tier_storage_class",
+			UsageText: "clickhouse-backup tier [--storage=<name>] [--dry-run] [--force-unlock]",
+			Action: func(c *cli.Context) error {
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, false))
+				return b.Tier(c.Bool("dry-run"), c.Bool("force-unlock"), c.Int("command-id"))
+			},
+			Flags: append(cliapp.Flags,
+				storageFlag,
+				forceUnlockFlag,
+				cli.BoolFlag{
+					Name:   "dry-run",
+					Hidden: false,
+					Usage:  "Print each remote backup with current storage tier and action, don't move anything",
+				},
+			),
 		},
 
 		{
 			Name:        "watch",
 			Usage:       "Run infinite loop which create full + incremental backup sequence to allow efficient backup sequences",
-			UsageText:   "clickhouse-backup watch [--watch-interval=1h] [--full-interval=24h] [--watch-backup-name-template=shard{shard}-{type}-{time:20060102150405}] [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [--schema] [--rbac] [--configs]",
+			UsageText:   "clickhouse-backup watch [--storage=<name>] [--watch-interval=1h] [--full-interval=24h] [--watch-backup-name-template=shard{shard}-{type}-{time:20060102150405}] [-t, --tables=<db>.<table>] [--partitions=<partitions_names>] [--schema] [--rbac] [--configs]",
 			Description: "Create and upload",
 			Action: func(c *cli.Context) error {
-				b := backup.NewBackuper(config.GetConfigFromCli(c))
+				b := backup.NewBackuper(config.GetStorageConfigFromCli(c, true))
 				return b.Watch(c.String("watch-interval"), c.String("full-interval"), c.String("watch-backup-name-template"), c.String("tables"), c.StringSlice("partitions"), c.Bool("schema"), c.Bool("rbac"), c.Bool("configs"), version, c.Int("command-id"), nil, c)
 			},
 			Flags: append(cliapp.Flags,
+				storageFlag,
 				cli.StringFlag{
 					Name:   "watch-interval",
 					Usage:  "Interval for run `create_remote` + `delete local` for incremental backup, look format https://pkg.go.dev/time#ParseDuration",
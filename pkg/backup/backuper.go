@@ -23,6 +23,8 @@ type Backuper struct {
 	isEmbedded             bool
 	resume                 bool
 	resumableState         *resumable.State
+	// logicalStream - not nil when create_remote --format logical streams exported data into dst
+	logicalStream *logicalStream
 }
 
 func NewBackuper(cfg *config.Config) *Backuper {
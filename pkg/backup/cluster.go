@@ -0,0 +1,417 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/clickhouse"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/status"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/storage"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// clusterShard - replicas of one shard from system.clusters, Leader backs up replicated tables of shard and restore data
+type clusterShard struct {
+	ShardNum uint32
+	Leader   clickhouse.ClusterReplica
+	Replicas []clickhouse.ClusterReplica
+}
+
+// getClusterShards - group replicas of cluster by shard, local replica is preferred as shard leader, otherwise replica with lowest replica_num
+func (b *Backuper) getClusterShards(ctx context.Context) ([]clusterShard, error) {
+	if b.cfg.Cluster.Name == "" {
+		return nil, fmt.Errorf("cluster->name shall be defined, look to system.clusters for proper cluster name")
+	}
+	replicas, err := b.ch.GetClusterReplicas(ctx, b.cfg.Cluster.Name)
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]clusterShard, 0)
+	for _, replica := range replicas {
+		if len(shards) == 0 || shards[len(shards)-1].ShardNum != replica.ShardNum {
+			shards = append(shards, clusterShard{ShardNum: replica.ShardNum, Leader: replica})
+		}
+		shard := &shards[len(shards)-1]
+		shard.Replicas = append(shard.Replicas, replica)
+		if replica.IsLocal == 1 && shard.Leader.IsLocal != 1 {
+			shard.Leader = replica
+		}
+	}
+	return shards, nil
+}
+
+// CreateCluster - create and upload backup on leader replica of each shard concurrently, and upload cluster manifest which ties shard backups together
+func (b *Backuper) CreateCluster(backupName, tablePattern string, partitions []string, schemaOnly bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	startCreate := time.Now()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		backupName = NewBackupName()
+	}
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("create_cluster is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "create_cluster",
+	})
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	shards, err := b.getClusterShards(ctx)
+	if err != nil {
+		return err
+	}
+	tables, err := b.ch.GetTables(ctx, tablePattern)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if !table.Skip && strings.HasSuffix(table.Engine, "MergeTree") && !strings.HasPrefix(table.Engine, "Replicated") {
+			log.Warnf("`%s`.`%s` is not replicated, only data from leader replica of each shard will backup", table.Database, table.Name)
+		}
+	}
+	manifest := metadata.ClusterBackupMetadata{
+		BackupName:   backupName,
+		Cluster:      b.cfg.Cluster.Name,
+		CreationDate: time.Now().UTC(),
+	}
+	var flags []string
+	if schemaOnly {
+		flags = append(flags, "--schema")
+	}
+	client, err := b.newClusterHTTPClient()
+	if err != nil {
+		return err
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	for _, shard := range shards {
+		shardBackup := metadata.ClusterShardBackups{
+			ShardNum:   shard.ShardNum,
+			Host:       shard.Leader.HostName,
+			BackupName: fmt.Sprintf("%s_shard%d", backupName, shard.ShardNum),
+		}
+		manifest.Shards = append(manifest.Shards, shardBackup)
+		command := b.getClusterCommand("create_remote", shardBackup.BackupName, tablePattern, partitions, flags...)
+		g.Go(func() error {
+			return b.runClusterCommand(gCtx, client, shardBackup.Host, command)
+		})
+	}
+	createErr := g.Wait()
+	// manifest of failed cluster backup is uploaded too, so retention deletes finished shard backups with it
+	manifestCtx := ctx
+	if createErr != nil {
+		manifest.Failed = createErr.Error()
+		manifestCtx = context.Background()
+	}
+	bd, err := storage.NewBackupDestination(manifestCtx, b.cfg, b.ch, false)
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(manifestCtx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(manifestCtx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	if err = bd.PutClusterManifest(manifestCtx, manifest); err != nil {
+		if createErr != nil {
+			log.Errorf("can't upload failed cluster manifest, shard backups shall be deleted manually: %v", err)
+			return fmt.Errorf("one of create_cluster go-routine return error: %v", createErr)
+		}
+		return fmt.Errorf("can't upload cluster manifest: %v", err)
+	}
+	if createErr != nil {
+		return fmt.Errorf("one of create_cluster go-routine return error: %v", createErr)
+	}
+	log.WithFields(apexLog.Fields{
+		"shards":   len(manifest.Shards),
+		"duration": utils.HumanizeDuration(time.Since(startCreate)),
+	}).Info("done")
+	return nil
+}
+
+// RestoreCluster - restore each shard from own backup, leader replica restore schema and data, other replicas restore schema only and fetch data via replication
+func (b *Backuper) RestoreCluster(backupName, tablePattern string, schemaOnly, dataOnly, dropTable bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	startRestore := time.Now()
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("select cluster backup for restore")
+	}
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("restore_cluster is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore_cluster",
+	})
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false)
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	manifest, err := bd.GetClusterManifest(ctx, backupName)
+	if closeErr := bd.Close(ctx); closeErr != nil {
+		b.log.Warnf("can't close BackupDestination error: %v", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	if manifest.Failed != "" {
+		return fmt.Errorf("cluster backup '%s' is failed: %s", backupName, manifest.Failed)
+	}
+	shards, err := b.getClusterShards(ctx)
+	if err != nil {
+		return err
+	}
+	shardsMap := make(map[uint32]clusterShard, len(shards))
+	for _, shard := range shards {
+		shardsMap[shard.ShardNum] = shard
+	}
+	for _, shardBackup := range manifest.Shards {
+		if _, exists := shardsMap[shardBackup.ShardNum]; !exists {
+			return fmt.Errorf("shard %d from '%s' not found in cluster '%s'", shardBackup.ShardNum, backupName, b.cfg.Cluster.Name)
+		}
+	}
+	var flags []string
+	if schemaOnly {
+		flags = append(flags, "--schema")
+	}
+	if dataOnly {
+		flags = append(flags, "--data")
+	}
+	if dropTable {
+		flags = append(flags, "--rm")
+	}
+	client, err := b.newClusterHTTPClient()
+	if err != nil {
+		return err
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	for _, shardBackup := range manifest.Shards {
+		shard := shardsMap[shardBackup.ShardNum]
+		for _, replica := range shard.Replicas {
+			replicaFlags := flags
+			if replica.HostName != shard.Leader.HostName {
+				if dataOnly {
+					continue
+				}
+				replicaFlags = append([]string{"--schema"}, flags...)
+				if schemaOnly {
+					replicaFlags = flags
+				}
+			}
+			host := replica.HostName
+			command := b.getClusterCommand("restore_remote", shardBackup.BackupName, tablePattern, nil, replicaFlags...)
+			g.Go(func() error {
+				return b.runClusterCommand(gCtx, client, host, command)
+			})
+		}
+	}
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("one of restore_cluster go-routine return error: %v", err)
+	}
+	log.WithFields(apexLog.Fields{
+		"shards":   len(manifest.Shards),
+		"duration": utils.HumanizeDuration(time.Since(startRestore)),
+	}).Info("done")
+	return nil
+}
+
+func (b *Backuper) getClusterCommand(command, backupName, tablePattern string, partitions []string, flags ...string) string {
+	args := []string{command}
+	if b.cfg.StorageName != "" {
+		args = append(args, fmt.Sprintf("--storage=\"%s\"", b.cfg.StorageName))
+	}
+	if tablePattern != "" {
+		args = append(args, fmt.Sprintf("--tables=\"%s\"", tablePattern))
+	}
+	if len(partitions) > 0 {
+		args = append(args, fmt.Sprintf("--partitions=\"%s\"", strings.Join(partitions, ",")))
+	}
+	args = append(args, flags...)
+	args = append(args, backupName)
+	return strings.Join(args, " ")
+}
+
+// newClusterHTTPClient - client for REST API of other hosts, each request is limited by cluster->request_timeout
+// when api.secure is enabled, certificates are verified with cluster->tls_ca or with system CA and api.certificate_file, which is usually shared by all hosts
+func (b *Backuper) newClusterHTTPClient() (*http.Client, error) {
+	client := &http.Client{Timeout: b.cfg.Cluster.RequestTimeoutDuration}
+	if !b.cfg.API.Secure {
+		return client, nil
+	}
+	caFile := b.cfg.Cluster.TLSCa
+	certPool := x509.NewCertPool()
+	if caFile == "" {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("can't load system CA: %v", err)
+		}
+		certPool = systemPool
+		caFile = b.cfg.API.CertificateFile
+	}
+	if caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s: %v", caFile, err)
+		}
+		if !certPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("AppendCertsFromPEM %s return false", caFile)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: certPool}
+	client.Transport = transport
+	return client, nil
+}
+
+func (b *Backuper) getClusterAPIURL(host, query string) string {
+	scheme := "http"
+	if b.cfg.API.Secure {
+		scheme = "https"
+	}
+	apiURL := fmt.Sprintf("%s://%s:%d/backup/actions", scheme, host, b.cfg.Cluster.APIPort)
+	if query != "" {
+		apiURL += "?" + query
+	}
+	return apiURL
+}
+
+func (b *Backuper) doClusterAPIRequest(ctx context.Context, client *http.Client, method, apiURL string, body []byte) ([]status.ActionRowStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if b.cfg.API.Username != "" {
+		req.SetBasicAuth(b.cfg.API.Username, b.cfg.API.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			b.log.Warnf("can't close %s response body: %v", apiURL, err)
+		}
+	}()
+	rows := make([]status.ActionRowStatus, 0)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s %s return %d: %s", method, apiURL, resp.StatusCode, string(line))
+		}
+		row := status.ActionRowStatus{}
+		if err := json.Unmarshal(line, &row); err != nil {
+			return nil, fmt.Errorf("can't parse %s response: %v", apiURL, err)
+		}
+		rows = append(rows, row)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s return %d", method, apiURL, resp.StatusCode)
+	}
+	return rows, nil
+}
+
+// getClusterCommandStatus - return status of all runs of command, filter by substring on server side, so other commands shall be skipped
+func (b *Backuper) getClusterCommandStatus(ctx context.Context, client *http.Client, statusURL, command string) ([]status.ActionRowStatus, error) {
+	rows, err := b.doClusterAPIRequest(ctx, client, http.MethodGet, statusURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	commandRows := make([]status.ActionRowStatus, 0, len(rows))
+	for _, row := range rows {
+		if row.Command == command {
+			commandRows = append(commandRows, row)
+		}
+	}
+	return commandRows, nil
+}
+
+// runClusterCommand - send command to /backup/actions of clickhouse-backup server on host and wait while it finish
+func (b *Backuper) runClusterCommand(ctx context.Context, client *http.Client, host, command string) error {
+	log := b.log.WithField("host", host).WithField("command", command)
+	statusURL := b.getClusterAPIURL(host, "filter="+url.QueryEscape(command))
+	previousRows, err := b.getClusterCommandStatus(ctx, client, statusURL, command)
+	if err != nil {
+		return fmt.Errorf("%s: %v", host, err)
+	}
+	body, err := json.Marshal(status.ActionRowStatus{Command: command})
+	if err != nil {
+		return err
+	}
+	if _, err = b.doClusterAPIRequest(ctx, client, http.MethodPost, b.getClusterAPIURL(host, ""), body); err != nil {
+		return fmt.Errorf("%s: %v", host, err)
+	}
+	log.Info("started")
+	ctx, cancel := context.WithTimeout(ctx, b.cfg.Cluster.TimeoutDuration)
+	defer cancel()
+	ticker := time.NewTicker(b.cfg.Cluster.PollDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: `%s` doesn't finish: %v", host, command, ctx.Err())
+		case <-ticker.C:
+			rows, err := b.getClusterCommandStatus(ctx, client, statusURL, command)
+			if err != nil {
+				log.Warnf("can't get status: %v", err)
+				continue
+			}
+			// command is started asynchronously, so previous runs of the same command shall be ignored
+			if len(rows) <= len(previousRows) {
+				continue
+			}
+			lastRow := rows[len(rows)-1]
+			switch lastRow.Status {
+			case status.InProgressStatus:
+				continue
+			case status.SuccessStatus:
+				log.Info("done")
+				return nil
+			default:
+				return fmt.Errorf("%s: `%s` finished with status %s: %s", host, command, lastRow.Status, lastRow.Error)
+			}
+		}
+	}
+}
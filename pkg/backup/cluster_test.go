@@ -0,0 +1,32 @@
+package backup
+
+import (
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+)
+
+func TestNewClusterHTTPClient(t *testing.T) {
+	cfg := config.DefaultConfig()
+	b := &Backuper{cfg: cfg}
+	client, err := b.newClusterHTTPClient()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, client.Timeout)
+	assert.Nil(t, client.Transport)
+
+	cfg.API.Secure = true
+	client, err = b.newClusterHTTPClient()
+	assert.NoError(t, err)
+	transport, isHTTPTransport := client.Transport.(*http.Transport)
+	assert.True(t, isHTTPTransport)
+	assert.NotNil(t, transport.TLSClientConfig.RootCAs)
+
+	cfg.Cluster.TLSCa = path.Join(t.TempDir(), "absent.pem")
+	_, err = b.newClusterHTTPClient()
+	assert.Error(t, err)
+}
@@ -0,0 +1,325 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/status"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/storage"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// CopyRemote - copy remote backup as is to another path or bucket of the same remote storage
+func (b *Backuper) CopyRemote(backupName, toPath, toBucket string, withRequired bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	return b.CopyRemoteWithContext(ctx, backupName, toPath, toBucket, withRequired)
+}
+
+// CopyRemoteWithContext - copy remote backup and optionally all backups from RequiredBackup chain, use server-side copy when remote storage supports it
+func (b *Backuper) CopyRemoteWithContext(ctx context.Context, backupName, toPath, toBucket string, withRequired bool) error {
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return fmt.Errorf("backup name is required")
+	}
+	if toPath == "" && toBucket == "" {
+		return fmt.Errorf("--to-path or --to-bucket is required")
+	}
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return fmt.Errorf("copy_remote is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "copy_remote",
+	})
+	start := time.Now()
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	dstCfg, err := getCopyRemoteConfig(b.cfg, toPath, toBucket)
+	if err != nil {
+		return err
+	}
+	if err := b.init(ctx, nil); err != nil {
+		return err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	dst, err := storage.NewBackupDestination(ctx, dstCfg, b.ch, false)
+	if err != nil {
+		return err
+	}
+	if err := dst.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to %s: %v", dst.Kind(), err)
+	}
+	defer func() {
+		if err := dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close copy destination error: %v", err)
+		}
+	}()
+	// copied backup is added to _index.json of destination, so it shall not run concurrently with upload or delete on destination
+	ctx, unlock, err := dst.Lock(ctx, "copy_remote "+backupName, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	backupList, err := b.dst.BackupList(ctx, true, "")
+	if err != nil {
+		return err
+	}
+	remoteBackups := make(map[string]storage.Backup, len(backupList))
+	for _, backup := range backupList {
+		remoteBackups[backup.BackupName] = backup
+	}
+	// copy required backups first, to keep the chain restorable on destination at any time
+	var copyChain []storage.Backup
+	for name := backupName; name != ""; {
+		backup, exists := remoteBackups[name]
+		if !exists {
+			return fmt.Errorf("%s not found on remote storage", name)
+		}
+		if backup.Broken != "" {
+			return fmt.Errorf("%s is broken on remote storage: %s", name, backup.Broken)
+		}
+		copyChain = append([]storage.Backup{backup}, copyChain...)
+		if !withRequired {
+			if backup.RequiredBackup != "" {
+				log.Warnf("%s requires %s, use --with-required to copy whole chain", name, backup.RequiredBackup)
+			}
+			break
+		}
+		name = backup.RequiredBackup
+	}
+	for _, backup := range copyChain {
+		exists, err := isRemoteBackupExists(ctx, dst, backup)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if backup.BackupName == backupName {
+				return fmt.Errorf("%s already exists on destination remote storage", backupName)
+			}
+			log.Infof("required backup %s already exists on destination, skip", backup.BackupName)
+			continue
+		}
+		if err := b.copyRemoteBackup(ctx, backup, dst); err != nil {
+			return fmt.Errorf("can't copy %s: %v", backup.BackupName, err)
+		}
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).Info("done")
+	return nil
+}
+
+// copyRemoteBackup - copy each file of remote backup, metadata.json copied last, so backup is not visible on destination until all data copied
+func (b *Backuper) copyRemoteBackup(ctx context.Context, backup storage.Backup, dst *storage.BackupDestination) error {
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backup.BackupName,
+		"operation": "copy_remote",
+	})
+	start := time.Now()
+	if backup.Legacy {
+		archiveName := fmt.Sprintf("%s.%s", backup.BackupName, backup.FileExtension)
+		if err := b.dst.CopyObject(ctx, archiveName, dst); err != nil {
+			return err
+		}
+		return verifyRemoteCopy(ctx, b.dst, dst, archiveName)
+	}
+	srcFiles, srcSize, err := listRemoteBackupFiles(ctx, b.dst, backup.BackupName)
+	if err != nil {
+		return err
+	}
+	metadataKey := path.Join(backup.BackupName, "metadata.json")
+	copyConcurrency := int(b.cfg.General.UploadConcurrency)
+	if copyConcurrency < 1 {
+		copyConcurrency = 1
+	}
+	copyGroup, copyCtx := errgroup.WithContext(ctx)
+	copyGroup.SetLimit(copyConcurrency)
+	for key := range srcFiles {
+		if key == metadataKey {
+			continue
+		}
+		remoteKey := key
+		copyGroup.Go(func() error {
+			if err := b.dst.CopyObject(copyCtx, remoteKey, dst); err != nil {
+				return fmt.Errorf("%s: %v", remoteKey, err)
+			}
+			log.Debugf("%s copied", remoteKey)
+			return nil
+		})
+	}
+	if err := copyGroup.Wait(); err != nil {
+		return err
+	}
+	// content addressed parts are shared between backups, copy only parts which are absent on destination
+	contentParts, err := b.dst.GetContentPartsRefs(ctx, backup.BackupName)
+	if err != nil {
+		return err
+	}
+	contentGroup, contentCtx := errgroup.WithContext(ctx)
+	contentGroup.SetLimit(copyConcurrency)
+	for _, key := range contentParts {
+		contentKey := key
+		contentGroup.Go(func() error {
+			if err := b.dst.CopyContentPart(contentCtx, contentKey, dst); err != nil {
+				return fmt.Errorf("%s: %v", contentKey, err)
+			}
+			log.Debugf("%s copied", contentKey)
+			return nil
+		})
+	}
+	if err := contentGroup.Wait(); err != nil {
+		return err
+	}
+	dstFiles, dstSize, err := listRemoteBackupFiles(ctx, dst, backup.BackupName)
+	if err != nil {
+		return err
+	}
+	if _, exists := srcFiles[metadataKey]; exists {
+		dstFiles[metadataKey] = srcFiles[metadataKey]
+		dstSize += srcFiles[metadataKey]
+	}
+	if len(dstFiles) != len(srcFiles) || dstSize != srcSize {
+		return fmt.Errorf("copy verification failed, source has %d objects %d bytes, destination has %d objects %d bytes", len(srcFiles), srcSize, len(dstFiles), dstSize)
+	}
+	if _, exists := srcFiles[metadataKey]; exists {
+		if err := b.dst.CopyObject(ctx, metadataKey, dst); err != nil {
+			return fmt.Errorf("%s: %v", metadataKey, err)
+		}
+		if err := verifyRemoteCopy(ctx, b.dst, dst, metadataKey); err != nil {
+			return err
+		}
+		dst.AddToRemoteIndex(ctx, backup.BackupMetadata)
+	}
+	log.WithFields(apexLog.Fields{
+		"objects":  len(srcFiles),
+		"size":     utils.FormatBytes(uint64(srcSize)),
+		"duration": utils.HumanizeDuration(time.Since(start)),
+	}).Info("done")
+	return nil
+}
+
+// listRemoteBackupFiles - return size of each backup file by remote key, skip azblob directory markers like RemoveBackup do
+func listRemoteBackupFiles(ctx context.Context, bd *storage.BackupDestination, backupName string) (map[string]int64, int64, error) {
+	files := map[string]int64{}
+	totalSize := int64(0)
+	err := bd.Walk(ctx, backupName+"/", true, func(ctx context.Context, f storage.RemoteFile) error {
+		if bd.Kind() == "azblob" && f.Size() == 0 && f.LastModified().IsZero() {
+			return nil
+		}
+		files[path.Join(backupName, f.Name())] = f.Size()
+		totalSize += f.Size()
+		return nil
+	})
+	return files, totalSize, err
+}
+
+func verifyRemoteCopy(ctx context.Context, src, dst *storage.BackupDestination, key string) error {
+	srcFile, err := src.StatFile(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	dstFile, err := dst.StatFile(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%s: can't stat copied file: %v", key, err)
+	}
+	if srcFile.Size() != dstFile.Size() {
+		return fmt.Errorf("%s: copy verification failed, source size %d, destination size %d", key, srcFile.Size(), dstFile.Size())
+	}
+	return nil
+}
+
+func isRemoteBackupExists(ctx context.Context, bd *storage.BackupDestination, backup storage.Backup) (bool, error) {
+	key := path.Join(backup.BackupName, "metadata.json")
+	if backup.Legacy {
+		key = fmt.Sprintf("%s.%s", backup.BackupName, backup.FileExtension)
+	}
+	_, err := bd.StatFile(ctx, key)
+	if err == storage.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getCopyRemoteConfig - return copy of config where remote storage path and bucket replaced with copy_remote destination
+func getCopyRemoteConfig(cfg *config.Config, toPath, toBucket string) (*config.Config, error) {
+	dstCfg := *cfg
+	switch cfg.General.RemoteStorage {
+	case "s3":
+		if toPath != "" {
+			dstCfg.S3.Path = toPath
+		}
+		if toBucket != "" {
+			dstCfg.S3.Bucket = toBucket
+		}
+	case "gcs":
+		if toPath != "" {
+			dstCfg.GCS.Path = toPath
+		}
+		if toBucket != "" {
+			dstCfg.GCS.Bucket = toBucket
+		}
+	case "azblob":
+		if toPath != "" {
+			dstCfg.AzureBlob.Path = toPath
+		}
+		if toBucket != "" {
+			dstCfg.AzureBlob.Container = toBucket
+		}
+	case "cos":
+		if toPath != "" {
+			dstCfg.COS.Path = toPath
+		}
+		if toBucket != "" {
+			// bucket is the first label of cos->url host, https://<bucket>-<appid>.cos.<region>.myqcloud.com
+			u, err := url.Parse(cfg.COS.RowURL)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse cos->url: %v", err)
+			}
+			hostParts := strings.SplitN(u.Host, ".", 2)
+			if len(hostParts) != 2 {
+				return nil, fmt.Errorf("can't find bucket in cos->url %s", cfg.COS.RowURL)
+			}
+			u.Host = toBucket + "." + hostParts[1]
+			dstCfg.COS.RowURL = u.String()
+		}
+	case "ftp", "sftp", "fs", "webdav", "hdfs":
+		if toBucket != "" {
+			return nil, fmt.Errorf("--to-bucket is not supported for remote_storage: %s, use --to-path", cfg.General.RemoteStorage)
+		}
+		switch cfg.General.RemoteStorage {
+		case "ftp":
+			dstCfg.FTP.Path = toPath
+		case "sftp":
+			dstCfg.SFTP.Path = toPath
+		case "fs":
+			dstCfg.FS.Path = toPath
+		case "webdav":
+			dstCfg.WebDAV.Path = toPath
+		case "hdfs":
+			dstCfg.HDFS.Path = toPath
+		}
+	default:
+		return nil, fmt.Errorf("copy_remote is not supported for remote_storage: %s", cfg.General.RemoteStorage)
+	}
+	return &dstCfg, nil
+}
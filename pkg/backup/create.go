@@ -10,6 +10,8 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AlexAkulov/clickhouse-backup/pkg/clickhouse"
@@ -20,6 +22,7 @@ import (
 	apexLog "github.com/apex/log"
 	"github.com/google/uuid"
 	recursiveCopy "github.com/otiai10/copy"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
@@ -75,7 +78,7 @@ func NewBackupName() string {
 
 // CreateBackup - create new backup of all tables matched by tablePattern
 // If backupName is empty string will use default backup name
-func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []string, schemaOnly, rbacOnly, configsOnly bool, version string, commandId int) error {
+func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []string, schemaOnly, rbacOnly, configsOnly, consistent bool, backupFormat, version string, commandId int) error {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -85,6 +88,13 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 
 	startBackup := time.Now()
 	doBackupData := !schemaOnly
+	if backupFormat != "" && backupFormat != "regular" && backupFormat != "logical" {
+		return fmt.Errorf("unknown backup format '%s', expected regular or logical", backupFormat)
+	}
+	logical := backupFormat == "logical"
+	if logical && consistent {
+		return fmt.Errorf("--consistent is not compatible with --format logical, logical backup doesn't freeze tables")
+	}
 	if backupName == "" {
 		backupName = NewBackupName()
 	}
@@ -134,10 +144,13 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 	}
 	partitionsToBackupMap, partitions := filesystemhelper.CreatePartitionsToBackupMap(partitions)
 	// create
-	if b.cfg.ClickHouse.UseEmbeddedBackupRestore {
+	if b.cfg.ClickHouse.UseEmbeddedBackupRestore && !logical {
+		if consistent {
+			log.Warn("--consistent is ignored for use_embedded_backup_restore: true")
+		}
 		err = b.createBackupEmbedded(ctx, backupName, tablePattern, partitions, partitionsToBackupMap, schemaOnly, rbacOnly, configsOnly, tables, allDatabases, allFunctions, disks, diskMap, log, startBackup, version)
 	} else {
-		err = b.createBackupLocal(ctx, backupName, partitionsToBackupMap, tables, doBackupData, schemaOnly, rbacOnly, configsOnly, version, disks, diskMap, allDatabases, allFunctions, log, startBackup)
+		err = b.createBackupLocal(ctx, backupName, partitionsToBackupMap, tables, doBackupData, schemaOnly, rbacOnly, configsOnly, consistent, logical, version, disks, diskMap, allDatabases, allFunctions, log, startBackup)
 	}
 	if err != nil {
 		return err
@@ -150,7 +163,7 @@ func (b *Backuper) CreateBackup(backupName, tablePattern string, partitions []st
 	return nil
 }
 
-func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, partitionsToBackupMap common.EmptyMap, tables []clickhouse.Table, doBackupData bool, schemaOnly bool, rbacOnly bool, configsOnly bool, version string, disks []clickhouse.Disk, diskMap map[string]string, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, log *apexLog.Entry, startBackup time.Time) error {
+func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, partitionsToBackupMap common.EmptyMap, tables []clickhouse.Table, doBackupData bool, schemaOnly bool, rbacOnly bool, configsOnly bool, consistent bool, logical bool, version string, disks []clickhouse.Disk, diskMap map[string]string, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, log *apexLog.Entry, startBackup time.Time) error {
 	// Create backup dir on all clickhouse disks
 	for _, disk := range disks {
 		if err := filesystemhelper.Mkdir(path.Join(disk.Path, "backup"), b.ch, disks); err != nil {
@@ -172,37 +185,107 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, par
 		}
 	}
 	var backupDataSize, backupMetadataSize uint64
-
-	var tableMetas []metadata.TableTitle
-	for _, table := range tables {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			log := log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name))
-			if table.Skip {
-				continue
+	// version is cached by first call, FreezeTable use it from concurrent goroutines
+	if doBackupData && !logical {
+		if _, err = b.ch.GetVersion(ctx); err != nil {
+			return err
+		}
+	}
+	// shadow of tables which are frozen, but not moved to backup folder yet, shall be removed when backup failed
+	shadowBackupUUIDs := make([]string, 0)
+	var shadowBackupUUIDsMutex sync.Mutex
+	cleanupFailedBackup := func() {
+		if removeBackupErr := b.RemoveBackupLocal(context.Background(), backupName, disks); removeBackupErr != nil {
+			log.Error(removeBackupErr.Error())
+		}
+		// fix corner cases after https://github.com/AlexAkulov/clickhouse-backup/issues/379
+		shadowBackupUUIDsMutex.Lock()
+		defer shadowBackupUUIDsMutex.Unlock()
+		b.cleanShadows(shadowBackupUUIDs, disks, log)
+	}
+
+	var frozenTables map[metadata.TableTitle]frozenTable
+	var freezeSkew *time.Duration
+	if consistent && doBackupData {
+		var skew time.Duration
+		var frozenShadowBackupUUIDs []string
+		frozenTables, frozenShadowBackupUUIDs, skew, err = b.freezeTablesConsistent(ctx, tables, log)
+		shadowBackupUUIDs = append(shadowBackupUUIDs, frozenShadowBackupUUIDs...)
+		if err != nil {
+			log.Error(err.Error())
+			cleanupFailedBackup()
+			return err
+		}
+		freezeSkew = &skew
+	}
+
+	freezeConcurrency := int(b.cfg.General.FreezeConcurrency)
+	if freezeConcurrency < 1 {
+		freezeConcurrency = 1
+	}
+	log.Debugf("prepare table concurrent semaphore with freeze_concurrency=%d len(tables)=%d", freezeConcurrency, len(tables))
+	// tableMetas keep order of tables, nil for skipped and not finished tables
+	tableMetas := make([]*metadata.TableTitle, len(tables))
+	var tableErrors []string
+	var tableErrorsMutex sync.Mutex
+	createGroup, createCtx := errgroup.WithContext(ctx)
+	createGroup.SetLimit(freezeConcurrency)
+	for i := range tables {
+		if tables[i].Skip {
+			continue
+		}
+		idx := i
+		table := tables[i]
+		createGroup.Go(func() error {
+			if err := createCtx.Err(); err != nil {
+				return err
 			}
+			log := log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name))
 			var realSize map[string]int64
 			var disksToPartsMap map[string][]metadata.Part
+			var freezeTime *time.Time
+			var dataFormat string
+			var columns []metadata.Column
+			var dataSkipped string
+			var err error
 			if doBackupData {
 				log.Debug("create data")
-				shadowBackupUUID := strings.ReplaceAll(uuid.New().String(), "-", "")
-				disksToPartsMap, realSize, err = b.AddTableToBackup(ctx, backupName, shadowBackupUUID, disks, &table, partitionsToBackupMap)
-				if err != nil {
-					log.Error(err.Error())
-					if removeBackupErr := b.RemoveBackupLocal(ctx, backupName, disks); removeBackupErr != nil {
-						log.Error(removeBackupErr.Error())
-					}
-					// fix corner cases after https://github.com/AlexAkulov/clickhouse-backup/issues/379
-					if cleanShadowErr := b.Clean(ctx); cleanShadowErr != nil {
-						log.Error(cleanShadowErr.Error())
+				if logical && (isFreezeSupported(&table) || isExportSupported(&table)) {
+					dataFormat = b.cfg.General.LogicalBackupFormat
+					byPartition := b.cfg.General.LogicalByPartition && strings.HasSuffix(table.Engine, "MergeTree")
+					disksToPartsMap, realSize, columns, err = b.exportTable(createCtx, backupName, &table, dataFormat, byPartition, partitionsToBackupMap, disks, log)
+				} else if logical {
+					log.WithField("engine", table.Engine).Debug("skip table data backup")
+				} else if isExportSupported(&table) {
+					dataFormat = metadata.DataFormatNative
+					disksToPartsMap, realSize, columns, err = b.exportTable(createCtx, backupName, &table, dataFormat, false, partitionsToBackupMap, disks, log)
+				} else if frozenTables != nil {
+					if frozen, isFrozen := frozenTables[metadata.TableTitle{Database: table.Database, Table: table.Name}]; isFrozen {
+						freezeTime = &frozen.freezeTime
+						disksToPartsMap, realSize, err = b.moveShadowToBackup(createCtx, backupName, frozen.shadowBackupUUID, disks, &table, partitionsToBackupMap, log)
 					}
-					return err
+				} else {
+					shadowBackupUUID := strings.ReplaceAll(uuid.New().String(), "-", "")
+					shadowBackupUUIDsMutex.Lock()
+					shadowBackupUUIDs = append(shadowBackupUUIDs, shadowBackupUUID)
+					shadowBackupUUIDsMutex.Unlock()
+					disksToPartsMap, realSize, err = b.AddTableToBackup(createCtx, backupName, shadowBackupUUID, disks, &table, partitionsToBackupMap)
+				}
+				if errors.Is(err, errSelectNotSupported) {
+					log.Warnf("only schema will backup, data skip reason is saved into table metadata: %v", err)
+					dataSkipped = err.Error()
+					err = nil
+				}
+				if err != nil {
+					return collectTableError(createCtx, &tableErrorsMutex, &tableErrors, table, err, log)
+				}
+				// empty table or engine which doesn't allow SELECT, nothing is exported
+				if len(disksToPartsMap) == 0 {
+					dataFormat = ""
 				}
 				// more precise data size calculation
 				for _, size := range realSize {
-					backupDataSize += uint64(size)
+					atomic.AddUint64(&backupDataSize, uint64(size))
 				}
 			}
 			log.Debug("create metadata")
@@ -214,19 +297,37 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, par
 				Size:         realSize,
 				Parts:        disksToPartsMap,
 				MetadataOnly: schemaOnly,
+				FreezeTime:   freezeTime,
+				DataFormat:   dataFormat,
+				Columns:      columns,
+				DataSkipped:  dataSkipped,
 			}, disks)
 			if err != nil {
-				if removeBackupErr := b.RemoveBackupLocal(ctx, backupName, disks); removeBackupErr != nil {
-					log.Error(removeBackupErr.Error())
-				}
-				return err
+				return collectTableError(createCtx, &tableErrorsMutex, &tableErrors, table, err, log)
 			}
-			backupMetadataSize += metadataSize
-			tableMetas = append(tableMetas, metadata.TableTitle{
+			atomic.AddUint64(&backupMetadataSize, metadataSize)
+			tableMetas[idx] = &metadata.TableTitle{
 				Database: table.Database,
 				Table:    table.Name,
-			})
+			}
 			log.Infof("done")
+			return nil
+		})
+	}
+	if err = createGroup.Wait(); err != nil {
+		cleanupFailedBackup()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if len(tableErrors) > 0 {
+			return fmt.Errorf("can't create backup for %d tables: %s", len(tableErrors), strings.Join(tableErrors, "; "))
+		}
+		return err
+	}
+	var tableTitles []metadata.TableTitle
+	for _, tableTitle := range tableMetas {
+		if tableTitle != nil {
+			tableTitles = append(tableTitles, *tableTitle)
 		}
 	}
 	backupRBACSize, backupConfigSize := uint64(0), uint64(0)
@@ -247,7 +348,11 @@ func (b *Backuper) createBackupLocal(ctx context.Context, backupName string, par
 	}
 
 	backupMetaFile := path.Join(defaultPath, "backup", backupName, "metadata.json")
-	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, version, "regular", diskMap, disks, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize, tableMetas, allDatabases, allFunctions, log); err != nil {
+	tags := "regular"
+	if logical {
+		tags = "logical"
+	}
+	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, version, tags, diskMap, disks, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize, tableTitles, allDatabases, allFunctions, freezeSkew, log); err != nil {
 		return err
 	}
 	log.WithField("duration", utils.HumanizeDuration(time.Since(startBackup))).Info("done")
@@ -359,7 +464,7 @@ func (b *Backuper) createBackupEmbedded(ctx context.Context, backupName, tablePa
 		}
 	}
 	backupMetaFile := path.Join(diskMap[b.cfg.ClickHouse.EmbeddedBackupDisk], backupName, "metadata.json")
-	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, backupVersion, "embedded", diskMap, disks, backupDataSize[0], backupMetadataSize, 0, 0, tableMetas, allDatabases, allFunctions, log); err != nil {
+	if err := b.createBackupMetadata(ctx, backupMetaFile, backupName, backupVersion, "embedded", diskMap, disks, backupDataSize[0], backupMetadataSize, 0, 0, tableMetas, allDatabases, allFunctions, nil, log); err != nil {
 		return err
 	}
 
@@ -464,7 +569,7 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 	}
 
 	// backup data
-	if !strings.HasSuffix(table.Engine, "MergeTree") && table.Engine != "MaterializedMySQL" && table.Engine != "MaterializedPostgreSQL" {
+	if !isFreezeSupported(table) {
 		log.WithField("engine", table.Engine).Debug("skip table backup")
 		return nil, nil, nil
 	}
@@ -472,6 +577,27 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 		return nil, nil, err
 	}
 	log.Debug("frozen")
+	return b.moveShadowToBackup(ctx, backupName, shadowBackupUUID, diskList, table, partitionsToBackupMap, log)
+}
+
+// collectTableError - save error of table for summary, errors after cancel of other tables are not collected, cause they are consequence of first error
+func collectTableError(ctx context.Context, tableErrorsMutex *sync.Mutex, tableErrors *[]string, table clickhouse.Table, err error, log *apexLog.Entry) error {
+	if ctx.Err() == nil {
+		log.Error(err.Error())
+		tableErrorsMutex.Lock()
+		*tableErrors = append(*tableErrors, fmt.Sprintf("%s.%s: %v", table.Database, table.Name, err))
+		tableErrorsMutex.Unlock()
+	}
+	return err
+}
+
+// isFreezeSupported - only MergeTree family and Materialized* database engines tables could be frozen
+func isFreezeSupported(table *clickhouse.Table) bool {
+	return strings.HasSuffix(table.Engine, "MergeTree") || table.Engine == "MaterializedMySQL" || table.Engine == "MaterializedPostgreSQL"
+}
+
+// moveShadowToBackup - move parts of frozen table from shadow/shadowBackupUUID to backup folder on each disk
+func (b *Backuper) moveShadowToBackup(ctx context.Context, backupName, shadowBackupUUID string, diskList []clickhouse.Disk, table *clickhouse.Table, partitionsToBackupMap common.EmptyMap, log *apexLog.Entry) (map[string][]metadata.Part, map[string]int64, error) {
 	realSize := map[string]int64{}
 	disksToPartsMap := map[string][]metadata.Part{}
 	for _, disk := range diskList {
@@ -508,7 +634,7 @@ func (b *Backuper) AddTableToBackup(ctx context.Context, backupName, shadowBacku
 	return disksToPartsMap, realSize, nil
 }
 
-func (b *Backuper) createBackupMetadata(ctx context.Context, backupMetaFile, backupName, version, tags string, diskMap map[string]string, disks []clickhouse.Disk, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize uint64, tableMetas []metadata.TableTitle, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, log *apexLog.Entry) error {
+func (b *Backuper) createBackupMetadata(ctx context.Context, backupMetaFile, backupName, version, tags string, diskMap map[string]string, disks []clickhouse.Disk, backupDataSize, backupMetadataSize, backupRBACSize, backupConfigSize uint64, tableMetas []metadata.TableTitle, allDatabases []clickhouse.Database, allFunctions []clickhouse.Function, freezeSkew *time.Duration, log *apexLog.Entry) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -528,6 +654,11 @@ func (b *Backuper) createBackupMetadata(ctx context.Context, backupMetaFile, bac
 			Databases:               []metadata.DatabasesMeta{},
 			Functions:               []metadata.FunctionsMeta{},
 		}
+		// freezeSkew is defined only for --consistent
+		if freezeSkew != nil {
+			backupMetadata.ConsistentFreeze = true
+			backupMetadata.FreezeSkewMs = freezeSkew.Milliseconds()
+		}
 		for _, database := range allDatabases {
 			backupMetadata.Databases = append(backupMetadata.Databases, metadata.DatabasesMeta(database))
 		}
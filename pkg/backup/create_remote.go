@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"github.com/AlexAkulov/clickhouse-backup/pkg/status"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/utils"
 )
 
-func (b *Backuper) CreateToRemote(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, rbac, backupConfig, resume bool, version string, commandId int) error {
+func (b *Backuper) CreateToRemote(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, rbac, backupConfig, consistent, resume bool, backupFormat, version string, commandId int) error {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -16,12 +17,26 @@ func (b *Backuper) CreateToRemote(backupName, diffFrom, diffFromRemote, tablePat
 	if backupName == "" {
 		backupName = NewBackupName()
 	}
-	if err := b.CreateBackup(backupName, tablePattern, partitions, schemaOnly, rbac, backupConfig, version, commandId); err != nil {
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	// logical backup data is streamed into remote storage, so local backup contains only metadata and is removed after upload
+	if backupFormat == "logical" && !schemaOnly && b.isLogicalStreamSupported(resume) {
+		closeStream, err := b.openLogicalStream(ctx, backupName)
+		if err != nil {
+			return err
+		}
+		defer closeStream()
+	}
+	if err := b.CreateBackup(backupName, tablePattern, partitions, schemaOnly, rbac, backupConfig, consistent, backupFormat, version, commandId); err != nil {
 		return err
 	}
-	if err := b.Upload(backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, commandId); err != nil {
+	if err := b.Upload(backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, false, commandId); err != nil {
 		return err
 	}
+	if b.logicalStream != nil {
+		if err := b.RemoveBackupLocal(ctx, backupName, nil); err != nil {
+			return fmt.Errorf("can't remove local metadata of streamed backup: %v", err)
+		}
+	}
 
 	if err := b.RemoveOldBackupsLocal(ctx, false, nil); err != nil {
 		return fmt.Errorf("can't remove old local backups: %v", err)
@@ -44,15 +44,28 @@ func (b *Backuper) cleanDir(dirName string) error {
 		return err
 	} else {
 		for _, item := range items {
-			err = os.RemoveAll(item.Name())
-			return err
+			if err = os.RemoveAll(path.Join(dirName, item.Name())); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-// Delete - remove local or remote backup
-func (b *Backuper) Delete(backupType, backupName string, commandId int) error {
+// cleanShadows - remove shadow/shadowBackupUUID on each disk, unlike Clean it doesn't touch shadow of other freezes which could run in parallel
+func (b *Backuper) cleanShadows(shadowBackupUUIDs []string, disks []clickhouse.Disk, log *apexLog.Entry) {
+	for _, shadowBackupUUID := range shadowBackupUUIDs {
+		for _, disk := range disks {
+			shadowPath := path.Join(disk.Path, "shadow", shadowBackupUUID)
+			if err := os.RemoveAll(shadowPath); err != nil {
+				log.Errorf("can't clean '%s': %v", shadowPath, err)
+			}
+		}
+	}
+}
+
+// Delete - remove local or remote backup, forceUnlock overrides remote lock held by another host
+func (b *Backuper) Delete(backupType, backupName string, forceUnlock bool, commandId int) error {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -64,7 +77,7 @@ func (b *Backuper) Delete(backupType, backupName string, commandId int) error {
 	case "local":
 		return b.RemoveBackupLocal(ctx, backupName, nil)
 	case "remote":
-		return b.RemoveBackupRemote(ctx, backupName)
+		return b.RemoveBackupRemote(ctx, backupName, forceUnlock)
 	default:
 		return fmt.Errorf("unknown backup type")
 	}
@@ -134,7 +147,7 @@ func (b *Backuper) RemoveBackupLocal(ctx context.Context, backupName string, dis
 	return fmt.Errorf("'%s' is not found on local storage", backupName)
 }
 
-func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) error {
+func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string, forceUnlock bool) error {
 	log := b.log.WithField("logger", "RemoveBackupRemote")
 	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
 	start := time.Now()
@@ -164,6 +177,11 @@ func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) er
 			b.log.Warnf("can't close BackupDestination error: %v", err)
 		}
 	}()
+	ctx, unlock, err := bd.Lock(ctx, "delete "+backupName, forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	backupList, err := bd.BackupList(ctx, true, backupName)
 	if err != nil {
@@ -171,6 +189,9 @@ func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) er
 	}
 	for _, backup := range backupList {
 		if backup.BackupName == backupName {
+			if reason := backup.ObjectLockReason(); reason != "" {
+				return fmt.Errorf("'%s' is protected by %s, can't delete it", backupName, reason)
+			}
 			if err := bd.RemoveBackup(ctx, backup); err != nil {
 				log.Warnf("bd.RemoveBackup return error: %v", err)
 				return err
@@ -187,24 +208,82 @@ func (b *Backuper) RemoveBackupRemote(ctx context.Context, backupName string) er
 	return fmt.Errorf("'%s' is not found on remote storage", backupName)
 }
 
-func (b *Backuper) CleanRemoteBroken(commandId int) error {
+// CleanRemoteBroken - remove broken remote backups, list is read under remote lock, so backup which is uploading by other host right now is not removed
+func (b *Backuper) CleanRemoteBroken(forceUnlock bool, commandId int) error {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
 	}
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
+	log := b.log.WithField("logger", "CleanRemoteBroken")
+
+	if b.cfg.General.RemoteStorage == "none" {
+		err := errors.New("aborted: RemoteStorage set to \"none\"")
+		log.Error(err.Error())
+		return err
+	}
+	if b.cfg.General.RemoteStorage == "custom" {
+		remoteBackups, err := custom.List(ctx, b.cfg)
+		if err != nil {
+			return err
+		}
+		for _, backup := range remoteBackups {
+			if backup.Broken != "" {
+				if err = custom.DeleteRemote(ctx, b.cfg, backup.BackupName); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := b.ch.Connect(); err != nil {
+		return fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false)
+	if err != nil {
+		return err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	ctx, unlock, err := bd.Lock(ctx, "clean_remote_broken", forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	remoteBackups, err := b.GetRemoteBackups(ctx, true)
+	// broken backups are absent in _index.json
+	remoteBackups, err := bd.BackupListFull(ctx, true)
 	if err != nil {
 		return err
 	}
 	for _, backup := range remoteBackups {
-		if backup.Broken != "" {
-			if err = b.RemoveBackupRemote(ctx, backup.BackupName); err != nil {
-				return err
-			}
+		if backup.Broken == "" {
+			continue
+		}
+		start := time.Now()
+		if reason := backup.ObjectLockReason(); reason != "" {
+			return fmt.Errorf("'%s' is protected by %s, can't delete it", backup.BackupName, reason)
+		}
+		if err = bd.RemoveBackup(ctx, backup); err != nil {
+			log.Warnf("bd.RemoveBackup return error: %v", err)
+			return err
 		}
+		log.WithFields(apexLog.Fields{
+			"backup":    backup.BackupName,
+			"broken":    backup.Broken,
+			"location":  "remote",
+			"operation": "delete",
+			"duration":  utils.HumanizeDuration(time.Since(start)),
+		}).Info("done")
 	}
 	return nil
 }
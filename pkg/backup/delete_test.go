@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanDir(t *testing.T) {
+	shadowDir := t.TempDir()
+	workDir := t.TempDir()
+	for _, dir := range []string{shadowDir, workDir} {
+		require.NoError(t, os.MkdirAll(path.Join(dir, "1", "data", "db", "table", "all_1_1_0"), 0750))
+		require.NoError(t, os.WriteFile(path.Join(dir, "1", "data", "db", "table", "all_1_1_0", "checksums.txt"), []byte("checksums"), 0640))
+		require.NoError(t, os.WriteFile(path.Join(dir, "increment.txt"), []byte("1"), 0640))
+	}
+	// items shall be removed relative to cleaned directory, not relative to current working directory
+	currentDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(workDir))
+	defer func() {
+		require.NoError(t, os.Chdir(currentDir))
+	}()
+
+	b := &Backuper{}
+	assert.NoError(t, b.cleanDir(shadowDir))
+	items, err := os.ReadDir(shadowDir)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	items, err = os.ReadDir(workDir)
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.FileExists(t, path.Join(workDir, "1", "data", "db", "table", "all_1_1_0", "checksums.txt"))
+
+	assert.Error(t, b.cleanDir(path.Join(shadowDir, "not_exists")))
+}
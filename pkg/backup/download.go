@@ -55,7 +55,7 @@ func (b *Backuper) legacyDownload(ctx context.Context, backupName string) error
 	}()
 	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
-		return bd.DownloadCompressedStream(ctx, backupName, path.Join(b.DefaultDataPath, "backup", backupName))
+		return bd.DownloadCompressedStream(ctx, backupName, path.Join(b.DefaultDataPath, "backup", backupName), "")
 	})
 	if err != nil {
 		return err
@@ -151,6 +151,14 @@ func (b *Backuper) Download(backupName string, tablePattern string, partitions [
 	tablesForDownload := parseTablePatternForDownload(remoteBackup.Tables, tablePattern)
 	tableMetadataAfterDownload := make([]metadata.TableMetadata, len(tablesForDownload))
 
+	if !schemaOnly {
+		if err := b.restoreArchivedBackup(ctx, remoteBackup); err != nil {
+			return err
+		}
+		if err := b.dst.AllowPlainContentParts(ctx, backupName); err != nil {
+			return err
+		}
+	}
 	if !schemaOnly && !b.cfg.General.DownloadByPart && remoteBackup.RequiredBackup != "" {
 		err := b.Download(remoteBackup.RequiredBackup, tablePattern, partitions, schemaOnly, b.resume, commandId)
 		if err != nil && err != ErrBackupIsAlreadyExists {
@@ -391,14 +399,15 @@ func (b *Backuper) downloadTableMetadata(ctx context.Context, backupName string,
 }
 
 func (b *Backuper) downloadRBACData(ctx context.Context, remoteBackup storage.Backup) (uint64, error) {
-	return b.downloadBackupRelatedDir(ctx, remoteBackup, "access")
+	return b.downloadBackupRelatedDir(ctx, remoteBackup, "access", remoteBackup.RBACSize, remoteBackup.RBACChecksum)
 }
 
 func (b *Backuper) downloadConfigData(ctx context.Context, remoteBackup storage.Backup) (uint64, error) {
-	return b.downloadBackupRelatedDir(ctx, remoteBackup, "configs")
+	return b.downloadBackupRelatedDir(ctx, remoteBackup, "configs", remoteBackup.ConfigSize, remoteBackup.ConfigChecksum)
 }
 
-func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup storage.Backup, prefix string) (uint64, error) {
+// downloadBackupRelatedDir - download access or configs archive, expectedSize and expectedChecksum are empty for backups created before they were recorded
+func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup storage.Backup, prefix string, expectedSize uint64, expectedChecksum string) (uint64, error) {
 	log := b.log.WithField("logger", "downloadBackupRelatedDir")
 	archiveFile := fmt.Sprintf("%s.%s", prefix, b.cfg.GetArchiveExtension())
 	remoteFile := path.Join(remoteBackup.BackupName, archiveFile)
@@ -411,9 +420,12 @@ func (b *Backuper) downloadBackupRelatedDir(ctx context.Context, remoteBackup st
 		log.Debugf("%s not exists on remote storage, skip download", remoteFile)
 		return 0, nil
 	}
+	if expectedSize != 0 && uint64(remoteFileInfo.Size()) != expectedSize {
+		return 0, fmt.Errorf("%s size mismatch, remote object is corrupted: got %d bytes, expected %d bytes", remoteFile, remoteFileInfo.Size(), expectedSize)
+	}
 	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
-		return b.dst.DownloadCompressedStream(ctx, remoteFile, localDir)
+		return b.dst.DownloadCompressedStream(ctx, remoteFile, localDir, expectedChecksum)
 	})
 	if err != nil {
 		return 0, err
@@ -453,6 +465,7 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 				tableLocalDir := b.getLocalBackupDataPathForTable(remoteBackup.BackupName, disk, dbAndTableDir)
 				downloadOffset[disk] += 1
 				tableRemoteFile := path.Join(remoteBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), archiveFile)
+				expectedChecksum := table.Checksums[archiveFile]
 				g.Go(func() error {
 					defer s.Release(1)
 					log.Debugf("start download %s", tableRemoteFile)
@@ -461,10 +474,10 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 					}
 					retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
 					err := retry.RunCtx(dataCtx, func(dataCtx context.Context) error {
-						return b.dst.DownloadCompressedStream(dataCtx, tableRemoteFile, tableLocalDir)
+						return b.dst.DownloadCompressedStream(dataCtx, tableRemoteFile, tableLocalDir, expectedChecksum)
 					})
 					if err != nil {
-						return err
+						return fmt.Errorf("can't download %s.%s archive %s: %v", table.Database, table.Table, archiveFile, err)
 					}
 					if b.resume {
 						b.resumableState.AppendToState(tableRemoteFile)
@@ -490,7 +503,7 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 				tableLocalPath = path.Join(diskPath, remoteBackup.BackupName, "data", dbAndTableDir)
 			}
 			for _, part := range parts {
-				if part.Required {
+				if part.Required || part.ContentKey != "" {
 					continue
 				}
 				partRemotePath := path.Join(tableRemotePath, part.Name)
@@ -499,14 +512,16 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 					break breakByErrorDirectory
 				}
 				partLocalPath := path.Join(tableLocalPath, part.Name)
+				partName := part.Name
+				expectedChecksums := filterChecksumsByPrefix(table.Checksums, path.Join(disk, part.Name))
 				g.Go(func() error {
 					defer s.Release(1)
 					log.Debugf("start %s -> %s", partRemotePath, partLocalPath)
 					if b.resume && b.resumableState.IsAlreadyProcessed(partRemotePath) {
 						return nil
 					}
-					if err := b.dst.DownloadPath(dataCtx, 0, partRemotePath, partLocalPath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration); err != nil {
-						return err
+					if err := b.dst.DownloadPath(dataCtx, 0, partRemotePath, partLocalPath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, expectedChecksums); err != nil {
+						return fmt.Errorf("can't download %s.%s part %s: %v", table.Database, table.Table, partName, err)
 					}
 					if b.resume {
 						b.resumableState.AppendToState(partRemotePath)
@@ -517,6 +532,32 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 			}
 		}
 	}
+	if !b.isEmbedded {
+	breakByErrorContentParts:
+		for disk, parts := range table.Parts {
+			tableLocalDir := b.getLocalBackupDataPathForTable(remoteBackup.BackupName, disk, dbAndTableDir)
+			for _, part := range parts {
+				if part.Required || part.ContentKey == "" {
+					continue
+				}
+				if err := s.Acquire(dataCtx, 1); err != nil {
+					log.Errorf("can't acquire semaphore %s content part: %v", part.ContentKey, err)
+					break breakByErrorContentParts
+				}
+				partLocalPath := path.Join(tableLocalDir, part.Name)
+				contentKey := part.ContentKey
+				partName := part.Name
+				expectedChecksums := filterChecksumsByPrefix(table.Checksums, path.Join(disk, part.Name))
+				g.Go(func() error {
+					defer s.Release(1)
+					if err := b.downloadContentPart(dataCtx, contentKey, partLocalPath, table.Checksums[contentKey], expectedChecksums); err != nil {
+						return fmt.Errorf("can't download %s.%s part %s: %v", table.Database, table.Table, partName, err)
+					}
+					return nil
+				})
+			}
+		}
+	}
 	if err := g.Wait(); err != nil {
 		return fmt.Errorf("one of downloadTableData go-routine return error: %v", err)
 	}
@@ -531,6 +572,31 @@ func (b *Backuper) downloadTableData(ctx context.Context, remoteBackup metadata.
 	return nil
 }
 
+// downloadContentPart - download part from shared parts store into part directory, archive contains files relative to part directory
+func (b *Backuper) downloadContentPart(ctx context.Context, contentKey, partLocalPath, expectedChecksum string, expectedChecksums map[string]string) error {
+	log := b.log.WithField("logger", "downloadContentPart")
+	if b.resume && b.resumableState.IsAlreadyProcessed(partLocalPath) {
+		return nil
+	}
+	log.Debugf("start %s -> %s", contentKey, partLocalPath)
+	if storage.IsContentPartArchive(contentKey) {
+		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		err := retry.RunCtx(ctx, func(ctx context.Context) error {
+			return b.dst.DownloadCompressedStream(ctx, contentKey, partLocalPath, expectedChecksum)
+		})
+		if err != nil {
+			return err
+		}
+	} else if err := b.dst.DownloadPath(ctx, 0, contentKey, partLocalPath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, expectedChecksums); err != nil {
+		return err
+	}
+	if b.resume {
+		b.resumableState.AppendToState(partLocalPath)
+	}
+	log.Debugf("finish %s -> %s", contentKey, partLocalPath)
+	return nil
+}
+
 func (b *Backuper) downloadDiffParts(ctx context.Context, remoteBackup metadata.BackupMetadata, table metadata.TableMetadata, dbAndTableDir string) error {
 	log := b.log.WithField("operation", "downloadDiffParts")
 	log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Table)).Debug("start")
@@ -571,12 +637,12 @@ breakByError:
 						return err
 					}
 
-					for tableRemoteFile, tableLocalDir := range tableRemoteFiles {
-						err = b.downloadDiffRemoteFile(downloadDiffCtx, diffRemoteFilesLock, diffRemoteFilesCache, tableRemoteFile, tableLocalDir)
+					for tableRemoteFile, diffFile := range tableRemoteFiles {
+						err = b.downloadDiffRemoteFile(downloadDiffCtx, diffRemoteFilesLock, diffRemoteFilesCache, tableRemoteFile, diffFile)
 						if err != nil {
-							return err
+							return fmt.Errorf("can't download %s.%s part %s from %s, key %s: %v", table.Database, table.Table, partForDownload.Name, diffFile.backupName, diffFile.key, err)
 						}
-						downloadedPartPath := path.Join(tableLocalDir, partForDownload.Name)
+						downloadedPartPath := path.Join(diffFile.localDir, partForDownload.Name)
 						if downloadedPartPath != existsPath {
 							info, err := os.Stat(downloadedPartPath)
 							if err == nil {
@@ -617,7 +683,27 @@ breakByError:
 	return nil
 }
 
-func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLock *sync.Mutex, diffRemoteFilesCache map[string]*sync.Mutex, tableRemoteFile string, tableLocalDir string) error {
+// diffRemoteFile - local destination of remote archive or directory from required backup, checksums and sizes of required table metadata verify the download
+type diffRemoteFile struct {
+	localDir   string
+	backupName string
+	// key - key of remote object in Checksums and RemoteSizes, for directory it's a prefix of keys
+	key         string
+	checksums   map[string]string
+	remoteSizes map[string]int64
+}
+
+func newDiffRemoteFile(localDir string, requiredTable *metadata.TableMetadata, backupName, key string) diffRemoteFile {
+	return diffRemoteFile{
+		localDir:    localDir,
+		backupName:  backupName,
+		key:         key,
+		checksums:   requiredTable.Checksums,
+		remoteSizes: requiredTable.RemoteSizes,
+	}
+}
+
+func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLock *sync.Mutex, diffRemoteFilesCache map[string]*sync.Mutex, tableRemoteFile string, diffFile diffRemoteFile) error {
 	log := b.log.WithField("logger", "downloadDiffRemoteFile")
 	diffRemoteFilesLock.Lock()
 	namedLock, isCached := diffRemoteFilesCache[tableRemoteFile]
@@ -633,10 +719,18 @@ func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLo
 		diffRemoteFilesCache[tableRemoteFile] = namedLock
 		namedLock.Lock()
 		diffRemoteFilesLock.Unlock()
+		tableLocalDir := diffFile.localDir
 		if path.Ext(tableRemoteFile) != "" {
+			remoteFileInfo, err := b.dst.StatFile(ctx, tableRemoteFile)
+			if err != nil {
+				return fmt.Errorf("can't stat %s: %v", tableRemoteFile, err)
+			}
+			if err = checkRemoteSize(diffFile.remoteSizes, diffFile.key, remoteFileInfo.Size()); err != nil {
+				return err
+			}
 			retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
-			err := retry.RunCtx(ctx, func(ctx context.Context) error {
-				return b.dst.DownloadCompressedStream(ctx, tableRemoteFile, tableLocalDir)
+			err = retry.RunCtx(ctx, func(ctx context.Context) error {
+				return b.dst.DownloadCompressedStream(ctx, tableRemoteFile, tableLocalDir, diffFile.checksums[diffFile.key])
 			})
 			if err != nil {
 				log.Warnf("DownloadCompressedStream %s -> %s return error: %v", tableRemoteFile, tableLocalDir, err)
@@ -644,7 +738,10 @@ func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLo
 			}
 		} else {
 			// remoteFile could be a directory
-			if err := b.dst.DownloadPath(ctx, 0, tableRemoteFile, tableLocalDir, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration); err != nil {
+			if _, _, err := b.verifyRemotePath(ctx, tableRemoteFile, filterRemoteSizesByPrefix(diffFile.remoteSizes, diffFile.key)); err != nil {
+				return err
+			}
+			if err := b.dst.DownloadPath(ctx, 0, tableRemoteFile, tableLocalDir, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, filterChecksumsByPrefix(diffFile.checksums, diffFile.key)); err != nil {
 				log.Warnf("DownloadPath %s -> %s return error: %v", tableRemoteFile, tableLocalDir, err)
 				return err
 			}
@@ -655,6 +752,17 @@ func (b *Backuper) downloadDiffRemoteFile(ctx context.Context, diffRemoteFilesLo
 	return nil
 }
 
+// filterChecksumsByPrefix - return checksums for files inside prefix, keys become relative to prefix
+func filterChecksumsByPrefix(checksums map[string]string, prefix string) map[string]string {
+	filtered := make(map[string]string)
+	for key, checksum := range checksums {
+		if strings.HasPrefix(key, prefix+"/") {
+			filtered[strings.TrimPrefix(key, prefix+"/")] = checksum
+		}
+	}
+	return filtered
+}
+
 func (b *Backuper) checkNewPath(newPath string, part metadata.Part) error {
 	info, err := os.Stat(newPath)
 	if err != nil && !os.IsNotExist(err) {
@@ -669,7 +777,7 @@ func (b *Backuper) checkNewPath(newPath string, part metadata.Part) error {
 	return nil
 }
 
-func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadata.BackupMetadata, table metadata.TableMetadata, disk string, part metadata.Part, log *apexLog.Entry) (map[string]string, error) {
+func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadata.BackupMetadata, table metadata.TableMetadata, disk string, part metadata.Part, log *apexLog.Entry) (map[string]diffRemoteFile, error) {
 	var requiredTable *metadata.TableMetadata
 	log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffBackupFilesRemote"}).Debugf("start")
 	requiredBackup, err := b.ReadBackupMetadataRemote(ctx, backup.RequiredBackup)
@@ -688,9 +796,21 @@ func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadat
 		return tableRemoteFiles, nil
 	}
 
+	// part of required backup could be stored in shared parts store
+	for _, requiredPart := range requiredTable.Parts[disk] {
+		if requiredPart.Name == part.Name && requiredPart.ContentKey != "" {
+			partLocalDir := path.Join(b.DiskToPathMap[disk], "backup", requiredBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), disk, part.Name)
+			diffFile := newDiffRemoteFile(partLocalDir, requiredTable, requiredBackup.BackupName, requiredPart.ContentKey)
+			if !storage.IsContentPartArchive(requiredPart.ContentKey) {
+				diffFile.key = path.Join(disk, part.Name)
+			}
+			return map[string]diffRemoteFile{requiredPart.ContentKey: diffFile}, nil
+		}
+	}
+
 	found = false
 	// try to find part on the same disk
-	tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, disk, disk, part)
+	tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, requiredTable, disk, disk, part)
 	if found {
 		return tableRemoteFiles, nil
 	}
@@ -698,21 +818,21 @@ func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadat
 	// try to find part on other disks
 	for requiredDisk := range requiredBackup.Disks {
 		if requiredDisk != disk {
-			tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, disk, requiredDisk, part)
+			tableRemoteFiles, err, found = b.findDiffOnePart(ctx, requiredBackup, table, requiredTable, disk, requiredDisk, part)
 			if found {
 				return tableRemoteFiles, nil
 			}
 		}
 	}
 	// find one or multiple big files, disk_X.tar files by part.Name
-	tableRemoteFiles = make(map[string]string)
+	tableRemoteFiles = make(map[string]diffRemoteFile)
 	for requiredDisk, requiredParts := range requiredTable.Parts {
 		for _, requiredPart := range requiredParts {
 			if part.Name == requiredPart.Name {
 				localTableDir := path.Join(b.DiskToPathMap[disk], "backup", requiredBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), disk)
-				for _, remoteFile := range requiredTable.Files[requiredDisk] {
-					remoteFile = path.Join(requiredBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), remoteFile)
-					tableRemoteFiles[remoteFile] = localTableDir
+				for _, archiveName := range requiredTable.Files[requiredDisk] {
+					remoteFile := path.Join(requiredBackup.BackupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table), archiveName)
+					tableRemoteFiles[remoteFile] = newDiffRemoteFile(localTableDir, requiredTable, requiredBackup.BackupName, archiveName)
 				}
 			}
 		}
@@ -724,7 +844,7 @@ func (b *Backuper) findDiffBackupFilesRemote(ctx context.Context, backup metadat
 	return nil, fmt.Errorf("%s.%s %s not found on %s and all required backups sequence", table.Database, table.Table, part.Name, requiredBackup.BackupName)
 }
 
-func (b *Backuper) findDiffRecursive(ctx context.Context, requiredBackup *metadata.BackupMetadata, log *apexLog.Entry, table metadata.TableMetadata, requiredTable *metadata.TableMetadata, part metadata.Part, disk string) (map[string]string, bool, error) {
+func (b *Backuper) findDiffRecursive(ctx context.Context, requiredBackup *metadata.BackupMetadata, log *apexLog.Entry, table metadata.TableMetadata, requiredTable *metadata.TableMetadata, part metadata.Part, disk string) (map[string]diffRemoteFile, bool, error) {
 	log.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffRecursive"}).Debugf("start")
 	found := false
 	for _, requiredParts := range requiredTable.Parts {
@@ -749,20 +869,20 @@ func (b *Backuper) findDiffRecursive(ctx context.Context, requiredBackup *metada
 	return nil, false, nil
 }
 
-func (b *Backuper) findDiffOnePart(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (map[string]string, error, bool) {
+func (b *Backuper) findDiffOnePart(ctx context.Context, requiredBackup *metadata.BackupMetadata, table metadata.TableMetadata, requiredTable *metadata.TableMetadata, localDisk, remoteDisk string, part metadata.Part) (map[string]diffRemoteFile, error, bool) {
 	log := apexLog.WithFields(apexLog.Fields{"database": table.Database, "table": table.Table, "part": part.Name, "logger": "findDiffOnePart"})
 	log.Debugf("start")
-	tableRemoteFiles := make(map[string]string)
+	tableRemoteFiles := make(map[string]diffRemoteFile)
 	// find same disk and part name archive
 	if requiredBackup.DataFormat != "directory" {
 		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartArchive(ctx, requiredBackup, table, localDisk, remoteDisk, part); err == nil {
-			tableRemoteFiles[tableRemoteFile] = tableLocalDir
+			tableRemoteFiles[tableRemoteFile] = newDiffRemoteFile(tableLocalDir, requiredTable, requiredBackup.BackupName, path.Base(tableRemoteFile))
 			return tableRemoteFiles, nil, true
 		}
 	} else {
 		// find same disk and part name directory
 		if tableRemoteFile, tableLocalDir, err := b.findDiffOnePartDirectory(ctx, requiredBackup, table, localDisk, remoteDisk, part); err == nil {
-			tableRemoteFiles[tableRemoteFile] = tableLocalDir
+			tableRemoteFiles[tableRemoteFile] = newDiffRemoteFile(tableLocalDir, requiredTable, requiredBackup.BackupName, path.Join(remoteDisk, part.Name))
 			return tableRemoteFiles, nil, true
 		}
 	}
@@ -815,6 +935,10 @@ func (b *Backuper) ReadBackupMetadataRemote(ctx context.Context, backupName stri
 	}
 	for _, backup := range backupList {
 		if backup.BackupName == backupName {
+			// required backups and verified backups could reference content addressed parts uploaded without encryption
+			if err = b.dst.AllowPlainContentParts(ctx, backupName); err != nil {
+				return nil, err
+			}
 			return &backup.BackupMetadata, nil
 		}
 	}
@@ -0,0 +1,430 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/clickhouse"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/common"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+	"github.com/google/uuid"
+	recursiveCopy "github.com/otiai10/copy"
+)
+
+const (
+	// exportDataPart - table exported without partitions is stored as single part, so upload and download handle it as usual
+	exportDataPart = "native"
+	// userFilesDir - temporary directory inside user_files_path for file() table function
+	userFilesDir = "clickhouse-backup"
+)
+
+// exportEngines - engines which can't be frozen, data of these tables is exported with SELECT
+var exportEngines = map[string]struct{}{
+	"Log":             {},
+	"TinyLog":         {},
+	"StripeLog":       {},
+	"Memory":          {},
+	"Set":             {},
+	"Join":            {},
+	"EmbeddedRocksDB": {},
+}
+
+// exportFormats - clickhouse format name for each data_format in table metadata
+var exportFormats = map[string]string{
+	metadata.DataFormatNative:  "Native",
+	metadata.DataFormatParquet: "Parquet",
+}
+
+// errSelectNotSupported - table engine doesn't allow SELECT, data of such table can't be exported and is recorded as skipped in table metadata
+var errSelectNotSupported = errors.New("engine doesn't support SELECT")
+
+// isExportSupported - data of table could be exported with SELECT ... FORMAT Native
+func isExportSupported(table *clickhouse.Table) bool {
+	_, isExportEngine := exportEngines[table.Engine]
+	return isExportEngine
+}
+
+// exportDataFile - name of exported file inside part directory
+func exportDataFile(dataFormat string) string {
+	return "data." + dataFormat
+}
+
+// exportFileColumns - return column list for SELECT and INSERT, and quoted structure argument for file() table function
+func exportFileColumns(columns []clickhouse.Column) (string, string) {
+	names := make([]string, len(columns))
+	structure := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = fmt.Sprintf("`%s`", strings.ReplaceAll(column.Name, "`", "\\`"))
+		structure[i] = fmt.Sprintf("%s %s", names[i], column.Type)
+	}
+	return strings.Join(names, ", "), clickhouse.QuoteString(strings.Join(structure, ", "))
+}
+
+// logicalStream - create_remote --format logical streams exported data into remote storage, so local backup contains only metadata
+// upload uses checksums and sizes of streamed tables instead of uploading local data
+type logicalStream struct {
+	// ctx - canceled when remote lock is lost
+	ctx    context.Context
+	mu     sync.Mutex
+	tables map[metadata.TableTitle]streamedTable
+}
+
+// streamedTable - keys are relative to table shadow remote path like in TableMetadata.Checksums
+type streamedTable struct {
+	checksums   map[string]string
+	remoteSizes map[string]int64
+	storedSize  int64
+}
+
+func (s *logicalStream) add(tableTitle metadata.TableTitle, table streamedTable) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[tableTitle] = table
+}
+
+func (s *logicalStream) get(tableTitle metadata.TableTitle) (streamedTable, bool) {
+	if s == nil {
+		return streamedTable{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	table, exists := s.tables[tableTitle]
+	return table, exists
+}
+
+// isLogicalStreamSupported - exported data is streamed only into single remote storage, custom storage uploads local backup with own commands and resumable upload requires local data
+func (b *Backuper) isLogicalStreamSupported(resume bool) bool {
+	return len(b.cfg.RemoteStorages) == 0 && b.cfg.General.RemoteStorage != "none" && b.cfg.General.RemoteStorage != "custom" && !resume
+}
+
+// openLogicalStream - connect to remote storage and lock it until streamed backup is uploaded, upload uses the same BackupDestination, so its lock is reentrant
+func (b *Backuper) openLogicalStream(ctx context.Context, backupName string) (func(), error) {
+	if err := b.ch.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err := b.init(ctx, nil); err != nil {
+		return nil, err
+	}
+	closeDst := func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}
+	lockCtx, unlock, err := b.dst.Lock(ctx, "create_remote "+backupName, false)
+	if err != nil {
+		closeDst()
+		return nil, err
+	}
+	remoteBackups, err := b.dst.BackupList(ctx, false, "")
+	if err != nil {
+		unlock()
+		closeDst()
+		return nil, err
+	}
+	for _, remoteBackup := range remoteBackups {
+		if remoteBackup.BackupName == backupName {
+			unlock()
+			closeDst()
+			return nil, fmt.Errorf("'%s' already exists on remote storage", backupName)
+		}
+	}
+	b.logicalStream = &logicalStream{ctx: lockCtx, tables: map[metadata.TableTitle]streamedTable{}}
+	return func() {
+		b.logicalStream = nil
+		unlock()
+		closeDst()
+	}, nil
+}
+
+// exportTable - dump table data with INSERT INTO FUNCTION file(...) SELECT, each dump is moved into backup folder as part on default disk
+// byPartition exports each partition into separate part named as partition_id, otherwise whole table is exported into single part
+// clickhouse-server writes files itself without passing data through clickhouse-backup, free space on default disk is required for exported data
+// when logicalStream is open, SELECT result is streamed from HTTP interface into remote storage instead, and nothing is written into local backup folder
+func (b *Backuper) exportTable(ctx context.Context, backupName string, table *clickhouse.Table, dataFormat string, byPartition bool, partitionsToBackupMap common.EmptyMap, diskList []clickhouse.Disk, log *apexLog.Entry) (map[string][]metadata.Part, map[string]int64, []metadata.Column, error) {
+	columns, err := b.ch.GetInsertableColumns(ctx, table.Database, table.Name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var userFilesPath, defaultPath string
+	if b.logicalStream == nil {
+		if userFilesPath, err = b.ch.GetUserFilesPath(ctx, diskList); err != nil {
+			return nil, nil, nil, err
+		}
+		if defaultPath, err = b.ch.GetDefaultPath(diskList); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	columnList, structure := exportFileColumns(columns)
+	selectSQL := fmt.Sprintf("SELECT %s FROM `%s`.`%s`", columnList, table.Database, table.Name)
+	var partitionIDs []string
+	if byPartition {
+		if partitionIDs, err = b.ch.GetPartitionIDs(ctx, table.Database, table.Name); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	exportQueries := splitExportQuery(selectSQL, byPartition, partitionIDs, partitionsToBackupMap)
+
+	encodedTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Name))
+	tableBackupPath := path.Join(defaultPath, "backup", backupName, "shadow", encodedTablePath, "default")
+	tableRemotePath := path.Join(backupName, "shadow", encodedTablePath)
+	streamed := streamedTable{checksums: map[string]string{}, remoteSizes: map[string]int64{}}
+	parts := make([]metadata.Part, 0, len(exportQueries))
+	size := int64(0)
+	for partName, exportSQL := range exportQueries {
+		select {
+		case <-ctx.Done():
+			return nil, nil, nil, ctx.Err()
+		default:
+			var partSize int64
+			if b.logicalStream != nil {
+				checksumKey := path.Join("default", partName, exportDataFile(dataFormat))
+				var checksum string
+				var storedSize int64
+				checksum, partSize, storedSize, err = b.streamDataFile(ctx, fmt.Sprintf("%s FORMAT %s", exportSQL, exportFormats[dataFormat]), path.Join(tableRemotePath, checksumKey))
+				if err == nil && partSize > 0 {
+					streamed.checksums[checksumKey] = checksum
+					streamed.remoteSizes[checksumKey] = storedSize
+					streamed.storedSize += storedSize
+				}
+			} else {
+				dataFile := path.Join(tableBackupPath, partName, exportDataFile(dataFormat))
+				partSize, err = b.exportDataFile(ctx, exportSQL, dataFormat, structure, userFilesPath, dataFile, diskList)
+			}
+			if err != nil {
+				// code: 48, NOT_IMPLEMENTED, Set engine doesn't allow SELECT, HTTP interface reports it as "Code: 48"
+				if strings.Contains(strings.ToLower(err.Error()), "code: 48") {
+					return nil, nil, nil, fmt.Errorf("%s %w: %v", table.Engine, errSelectNotSupported, err)
+				}
+				return nil, nil, nil, fmt.Errorf("can't export %s.%s: %v", table.Database, table.Name, err)
+			}
+			if partSize == 0 {
+				log.WithField("part", partName).Debug("nothing exported")
+				continue
+			}
+			parts = append(parts, metadata.Part{Name: partName, Size: partSize})
+			size += partSize
+		}
+	}
+	if len(parts) == 0 {
+		return nil, nil, nil, nil
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Name < parts[j].Name
+	})
+	if b.logicalStream != nil {
+		b.logicalStream.add(metadata.TableTitle{Database: table.Database, Table: table.Name}, streamed)
+	}
+	exportedColumns := make([]metadata.Column, len(columns))
+	for i := range columns {
+		exportedColumns[i] = metadata.Column{Name: columns[i].Name, Type: columns[i].Type}
+	}
+	log.WithField("size", utils.FormatBytes(uint64(size))).WithField("parts", len(parts)).Debugf("exported in %s format", dataFormat)
+	return map[string][]metadata.Part{"default": parts}, map[string]int64{"default": size}, exportedColumns, nil
+}
+
+// splitExportQuery - return export query for each part name, byPartition splits selectSQL by partition_id, partitions absent in non-empty partitionsToBackupMap are skipped
+func splitExportQuery(selectSQL string, byPartition bool, partitionIDs []string, partitionsToBackupMap common.EmptyMap) map[string]string {
+	if !byPartition {
+		return map[string]string{exportDataPart: selectSQL}
+	}
+	exportQueries := make(map[string]string, len(partitionIDs))
+	for _, partitionID := range partitionIDs {
+		if len(partitionsToBackupMap) > 0 {
+			if _, isRequired := partitionsToBackupMap[partitionID]; !isRequired {
+				continue
+			}
+		}
+		exportQueries[partitionID] = fmt.Sprintf("%s WHERE _partition_id='%s'", selectSQL, partitionID)
+	}
+	return exportQueries
+}
+
+// exportDataFile - execute exportSQL into file inside user_files_path and move it to dataFile, return 0 size when nothing exported
+func (b *Backuper) exportDataFile(ctx context.Context, exportSQL, dataFormat, structure, userFilesPath, dataFile string, diskList []clickhouse.Disk) (int64, error) {
+	exportDir := path.Join(userFilesDir, strings.ReplaceAll(uuid.New().String(), "-", ""))
+	if err := filesystemhelper.MkdirAll(path.Join(userFilesPath, exportDir), b.ch, diskList); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := os.RemoveAll(path.Join(userFilesPath, exportDir)); err != nil {
+			apexLog.Warnf("can't remove %s: %v", path.Join(userFilesPath, exportDir), err)
+		}
+	}()
+	exportFile := path.Join(exportDir, exportDataFile(dataFormat))
+	if _, err := b.ch.QueryContext(ctx, fmt.Sprintf("INSERT INTO FUNCTION file(%s, '%s', %s) %s", clickhouse.QuoteString(exportFile), exportFormats[dataFormat], structure, exportSQL)); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path.Join(userFilesPath, exportFile))
+	if os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if err = filesystemhelper.MkdirAll(path.Dir(dataFile), b.ch, diskList); err != nil && !os.IsExist(err) {
+		return 0, err
+	}
+	if err = moveFile(path.Join(userFilesPath, exportFile), dataFile); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// streamDataFile - stream result of exportSQL from clickhouse-server HTTP interface into remote key, return checksum and size of content and size of stored object, empty result is not stored
+func (b *Backuper) streamDataFile(ctx context.Context, exportSQL, key string) (string, int64, int64, error) {
+	// stop streaming when remote lock is lost, stream can't be retried, so upload is not retried too
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-b.logicalStream.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	body, err := b.ch.QueryStream(ctx, exportSQL)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer func() {
+		if err := body.Close(); err != nil {
+			apexLog.Warnf("can't close %s stream: %v", key, err)
+		}
+	}()
+	checksum, size, storedSize, err := b.dst.UploadStream(ctx, key, body)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("can't stream into %s: %v", key, err)
+	}
+	if size == 0 {
+		if err = b.dst.DeleteFile(ctx, key); err != nil {
+			return "", 0, 0, fmt.Errorf("can't delete empty %s: %v", key, err)
+		}
+	}
+	return checksum, size, storedSize, nil
+}
+
+// restoreTableDataExported - load data exported by exportTable into destination table with INSERT ... SELECT FROM file(...)
+func (b *Backuper) restoreTableDataExported(ctx context.Context, backupName string, table metadata.TableMetadata, dstDatabase, dstTable string, diskMap map[string]string, disks []clickhouse.Disk, log *apexLog.Entry) error {
+	if _, isKnownFormat := exportFormats[table.DataFormat]; !isKnownFormat {
+		return fmt.Errorf("unknown data_format '%s'", table.DataFormat)
+	}
+	dstColumns, err := b.ch.GetInsertableColumns(ctx, dstDatabase, dstTable)
+	if err != nil {
+		return err
+	}
+	// backups without columns have the same structure with destination table
+	srcColumns := dstColumns
+	if len(table.Columns) > 0 {
+		srcColumns = make([]clickhouse.Column, len(table.Columns))
+		for i := range table.Columns {
+			srcColumns[i] = clickhouse.Column{Name: table.Columns[i].Name, Type: table.Columns[i].Type}
+		}
+	}
+	insertColumns, err := mapColumns(srcColumns, dstColumns, log)
+	if err != nil {
+		return err
+	}
+	columnList, _ := exportFileColumns(insertColumns)
+	_, structure := exportFileColumns(srcColumns)
+	userFilesPath, err := b.ch.GetUserFilesPath(ctx, disks)
+	if err != nil {
+		return err
+	}
+	encodedTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	for disk, parts := range table.Parts {
+		diskPath, diskExists := diskMap[disk]
+		if !diskExists {
+			diskPath = diskMap["default"]
+		}
+		for _, part := range parts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				dataFile := path.Join(diskPath, "backup", backupName, "shadow", encodedTablePath, disk, part.Name, exportDataFile(table.DataFormat))
+				importSQL := func(importFile string) string {
+					return fmt.Sprintf("INSERT INTO `%s`.`%s` (%s) SELECT %s FROM file(%s, '%s', %s)", dstDatabase, dstTable, columnList, columnList, clickhouse.QuoteString(importFile), exportFormats[table.DataFormat], structure)
+				}
+				if err := b.importDataFile(ctx, dataFile, userFilesPath, importSQL, disks); err != nil {
+					return err
+				}
+				log.WithField("part", part.Name).Debug("imported")
+			}
+		}
+	}
+	return nil
+}
+
+// mapColumns - return source columns which present in destination table, columns absent in source are filled by destination defaults, types are converted by INSERT ... SELECT
+func mapColumns(srcColumns, dstColumns []clickhouse.Column, log *apexLog.Entry) ([]clickhouse.Column, error) {
+	dstColumnsMap := make(map[string]string, len(dstColumns))
+	for _, column := range dstColumns {
+		dstColumnsMap[column.Name] = column.Type
+	}
+	mappedColumns := make([]clickhouse.Column, 0, len(srcColumns))
+	for _, column := range srcColumns {
+		dstType, exists := dstColumnsMap[column.Name]
+		if !exists {
+			log.Warnf("column `%s` is absent in destination table, skip it", column.Name)
+			continue
+		}
+		if dstType != column.Type {
+			log.Infof("column `%s` will convert from %s to %s", column.Name, column.Type, dstType)
+		}
+		mappedColumns = append(mappedColumns, column)
+		delete(dstColumnsMap, column.Name)
+	}
+	for name := range dstColumnsMap {
+		log.Infof("column `%s` is absent in backup, it will fill with default", name)
+	}
+	if len(mappedColumns) == 0 {
+		return nil, fmt.Errorf("backup and destination table don't have common columns")
+	}
+	return mappedColumns, nil
+}
+
+// importDataFile - make file visible for file() table function and execute query returned by importSQL for path relative to user_files_path
+func (b *Backuper) importDataFile(ctx context.Context, dataFile, userFilesPath string, importSQL func(importFile string) string, disks []clickhouse.Disk) error {
+	importDir := path.Join(userFilesDir, strings.ReplaceAll(uuid.New().String(), "-", ""))
+	if err := filesystemhelper.MkdirAll(path.Join(userFilesPath, importDir), b.ch, disks); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(path.Join(userFilesPath, importDir)); err != nil {
+			apexLog.Warnf("can't remove %s: %v", path.Join(userFilesPath, importDir), err)
+		}
+	}()
+	importFile := path.Join(importDir, path.Base(dataFile))
+	if err := os.Link(dataFile, path.Join(userFilesPath, importFile)); err != nil {
+		if err = recursiveCopy.Copy(dataFile, path.Join(userFilesPath, importFile)); err != nil {
+			return fmt.Errorf("can't copy %s to %s: %v", dataFile, path.Join(userFilesPath, importFile), err)
+		}
+	}
+	if err := filesystemhelper.Chown(path.Join(userFilesPath, importFile), b.ch, disks, false); err != nil {
+		return err
+	}
+	if _, err := b.ch.QueryContext(ctx, importSQL(importFile)); err != nil {
+		return fmt.Errorf("can't import %s: %v", dataFile, err)
+	}
+	return nil
+}
+
+// moveFile - rename file, copy it when source and destination are placed on different filesystems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := recursiveCopy.Copy(src, dst); err != nil {
+		return fmt.Errorf("can't copy %s to %s: %v", src, dst, err)
+	}
+	return os.Remove(src)
+}
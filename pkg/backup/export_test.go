@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"testing"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/clickhouse"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/common"
+)
+
+func TestSplitExportQuery(t *testing.T) {
+	selectSQL := "SELECT `id` FROM `default`.`t`"
+	testCases := []struct {
+		name          string
+		byPartition   bool
+		partitionIDs  []string
+		partitionsMap common.EmptyMap
+		expected      map[string]string
+	}{
+		{
+			name:     "whole table",
+			expected: map[string]string{exportDataPart: selectSQL},
+		},
+		{
+			name:         "each partition",
+			byPartition:  true,
+			partitionIDs: []string{"202301", "202302"},
+			expected: map[string]string{
+				"202301": selectSQL + " WHERE _partition_id='202301'",
+				"202302": selectSQL + " WHERE _partition_id='202302'",
+			},
+		},
+		{
+			name:          "required partitions",
+			byPartition:   true,
+			partitionIDs:  []string{"202301", "202302"},
+			partitionsMap: common.EmptyMap{"202302": {}},
+			expected: map[string]string{
+				"202302": selectSQL + " WHERE _partition_id='202302'",
+			},
+		},
+		{
+			name:         "empty table",
+			byPartition:  true,
+			partitionIDs: []string{},
+			expected:     map[string]string{},
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, splitExportQuery(selectSQL, tc.byPartition, tc.partitionIDs, tc.partitionsMap), tc.name)
+	}
+}
+
+func TestExportFileColumns(t *testing.T) {
+	columnList, structure := exportFileColumns([]clickhouse.Column{
+		{Name: "id", Type: "UInt64"},
+		{Name: "we`ird", Type: "Enum8('a' = 1)"},
+	})
+	assert.Equal(t, "`id`, `we\\`ird`", columnList)
+	assert.Equal(t, "'`id` UInt64, `we\\\\`ird` Enum8(\\'a\\' = 1)'", structure)
+}
+
+func TestMapColumns(t *testing.T) {
+	log := apexLog.WithField("test", "TestMapColumns")
+	testCases := []struct {
+		name       string
+		srcColumns []clickhouse.Column
+		dstColumns []clickhouse.Column
+		expected   []clickhouse.Column
+		isErr      bool
+	}{
+		{
+			name:       "same structure",
+			srcColumns: []clickhouse.Column{{Name: "id", Type: "UInt64"}, {Name: "s", Type: "String"}},
+			dstColumns: []clickhouse.Column{{Name: "id", Type: "UInt64"}, {Name: "s", Type: "String"}},
+			expected:   []clickhouse.Column{{Name: "id", Type: "UInt64"}, {Name: "s", Type: "String"}},
+		},
+		{
+			name:       "changed type and order",
+			srcColumns: []clickhouse.Column{{Name: "id", Type: "UInt32"}, {Name: "s", Type: "String"}},
+			dstColumns: []clickhouse.Column{{Name: "s", Type: "LowCardinality(String)"}, {Name: "id", Type: "UInt64"}},
+			expected:   []clickhouse.Column{{Name: "id", Type: "UInt32"}, {Name: "s", Type: "String"}},
+		},
+		{
+			name:       "dropped and added columns",
+			srcColumns: []clickhouse.Column{{Name: "id", Type: "UInt64"}, {Name: "old", Type: "String"}},
+			dstColumns: []clickhouse.Column{{Name: "id", Type: "UInt64"}, {Name: "new", Type: "String"}},
+			expected:   []clickhouse.Column{{Name: "id", Type: "UInt64"}},
+		},
+		{
+			name:       "no common columns",
+			srcColumns: []clickhouse.Column{{Name: "old", Type: "String"}},
+			dstColumns: []clickhouse.Column{{Name: "new", Type: "String"}},
+			isErr:      true,
+		},
+	}
+	for _, tc := range testCases {
+		columns, err := mapColumns(tc.srcColumns, tc.dstColumns, log)
+		if tc.isErr {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expected, columns, tc.name)
+	}
+}
@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/clickhouse"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+// frozenTable - result of consistent freeze, shadow is moved to backup folder after all tables are frozen
+type frozenTable struct {
+	shadowBackupUUID string
+	freezeTime       time.Time
+}
+
+// freezeTablesConsistent - freeze all tables in parallel within clickhouse->consistent_freeze_timeout, so backup of related tables is close to point-in-time
+// replicas are synced before freeze window, merges are stopped during the window when clickhouse->consistent_stop_merges enabled
+// sync and freeze run at most clickhouse->consistent_freeze_concurrency queries in parallel, so skew depends on this limit when tables count is bigger
+// return frozen tables, shadow of each issued FREEZE and max skew between freeze time of tables
+// shadow is recorded before FREEZE is issued, so caller shall clean all returned shadows when error returned, including FREEZE interrupted by timeout or cancel
+func (b *Backuper) freezeTablesConsistent(ctx context.Context, tables []clickhouse.Table, log *apexLog.Entry) (map[metadata.TableTitle]frozenTable, []string, time.Duration, error) {
+	tablesForFreeze := make([]*clickhouse.Table, 0, len(tables))
+	for i := range tables {
+		if !tables[i].Skip && isFreezeSupported(&tables[i]) {
+			tablesForFreeze = append(tablesForFreeze, &tables[i])
+		}
+	}
+	frozenTables := make(map[metadata.TableTitle]frozenTable, len(tablesForFreeze))
+	shadowBackupUUIDs := make([]string, 0, len(tablesForFreeze))
+	if len(tablesForFreeze) == 0 {
+		return frozenTables, shadowBackupUUIDs, 0, nil
+	}
+	// version is cached by first call, FreezeTableWithoutSync use it concurrently
+	if _, err := b.ch.GetVersion(ctx); err != nil {
+		return nil, nil, 0, err
+	}
+	freezeConcurrency := b.cfg.ClickHouse.ConsistentFreezeConcurrency
+	if freezeConcurrency < 1 {
+		freezeConcurrency = 1
+	}
+	log.Debugf("consistent freeze of %d tables with consistent_freeze_concurrency=%d", len(tablesForFreeze), freezeConcurrency)
+	// SyncReplica only logs errors, so errgroup is used as bounded WaitGroup
+	var syncGroup errgroup.Group
+	syncGroup.SetLimit(freezeConcurrency)
+	for _, table := range tablesForFreeze {
+		table := table
+		syncGroup.Go(func() error {
+			b.ch.SyncReplica(ctx, table)
+			return nil
+		})
+	}
+	_ = syncGroup.Wait()
+
+	if b.cfg.ClickHouse.ConsistentStopMerges {
+		stoppedTables := make([]*clickhouse.Table, 0, len(tablesForFreeze))
+		defer func() {
+			for _, table := range stoppedTables {
+				if _, err := b.ch.QueryContext(context.Background(), fmt.Sprintf("SYSTEM START MERGES `%s`.`%s`", table.Database, table.Name)); err != nil {
+					log.Errorf("can't start merges for %s.%s: %v", table.Database, table.Name, err)
+				}
+			}
+		}()
+		for _, table := range tablesForFreeze {
+			if _, err := b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM STOP MERGES `%s`.`%s`", table.Database, table.Name)); err != nil {
+				return nil, nil, 0, fmt.Errorf("can't stop merges for %s.%s: %v", table.Database, table.Name, err)
+			}
+			stoppedTables = append(stoppedTables, table)
+		}
+	}
+
+	windowCtx, cancel := context.WithCancel(ctx)
+	if b.cfg.ClickHouse.ConsistentFreezeDuration > 0 {
+		windowCtx, cancel = context.WithTimeout(ctx, b.cfg.ClickHouse.ConsistentFreezeDuration)
+	}
+	defer cancel()
+	start := time.Now()
+	var frozenTablesMutex sync.Mutex
+	freezeGroup, freezeCtx := errgroup.WithContext(windowCtx)
+	freezeGroup.SetLimit(freezeConcurrency)
+	for _, table := range tablesForFreeze {
+		table := table
+		freezeGroup.Go(func() error {
+			shadowBackupUUID := strings.ReplaceAll(uuid.New().String(), "-", "")
+			frozenTablesMutex.Lock()
+			shadowBackupUUIDs = append(shadowBackupUUIDs, shadowBackupUUID)
+			frozenTablesMutex.Unlock()
+			if err := b.ch.FreezeTableWithoutSync(freezeCtx, table, shadowBackupUUID); err != nil {
+				return fmt.Errorf("can't freeze %s.%s: %v", table.Database, table.Name, err)
+			}
+			frozenTablesMutex.Lock()
+			frozenTables[metadata.TableTitle{Database: table.Database, Table: table.Name}] = frozenTable{
+				shadowBackupUUID: shadowBackupUUID,
+				freezeTime:       time.Now().UTC(),
+			}
+			frozenTablesMutex.Unlock()
+			return nil
+		})
+	}
+	if err := freezeGroup.Wait(); err != nil {
+		if windowCtx.Err() == context.DeadlineExceeded {
+			return frozenTables, shadowBackupUUIDs, 0, fmt.Errorf("consistent freeze of %d tables is not finished during consistent_freeze_timeout=%s: %v", len(tablesForFreeze), b.cfg.ClickHouse.ConsistentFreezeTimeout, err)
+		}
+		return frozenTables, shadowBackupUUIDs, 0, err
+	}
+	var minFreezeTime, maxFreezeTime time.Time
+	for _, frozen := range frozenTables {
+		if minFreezeTime.IsZero() || frozen.freezeTime.Before(minFreezeTime) {
+			minFreezeTime = frozen.freezeTime
+		}
+		if frozen.freezeTime.After(maxFreezeTime) {
+			maxFreezeTime = frozen.freezeTime
+		}
+	}
+	freezeSkew := maxFreezeTime.Sub(minFreezeTime)
+	log.WithFields(apexLog.Fields{
+		"tables":   len(tablesForFreeze),
+		"duration": utils.HumanizeDuration(time.Since(start)),
+		"skew":     utils.HumanizeDuration(freezeSkew),
+	}).Info("consistent freeze done")
+	return frozenTables, shadowBackupUUIDs, freezeSkew, nil
+}
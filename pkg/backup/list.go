@@ -307,7 +307,44 @@ func (b *Backuper) PrintRemoteBackups(ctx context.Context, format string) error
 	if err != nil {
 		return err
 	}
-	return printBackupsRemote(w, backupList, format)
+	if err = printBackupsRemote(w, backupList, format); err != nil {
+		return err
+	}
+	if b.cfg.General.RemoteStorage == "custom" {
+		return nil
+	}
+	remoteLock, err := b.GetRemoteLock(ctx)
+	if err != nil {
+		log.Warnf("can't get remote lock: %v", err)
+	} else if remoteLock != nil && remoteLock.IsExpired() {
+		log.Infof("remote storage lock is expired: %s", remoteLock)
+	} else if remoteLock != nil {
+		log.Warnf("remote storage is locked: %s", remoteLock)
+	}
+	return nil
+}
+
+// GetRemoteLock - get current lock of remote storage, nil when remote storage is not locked
+func (b *Backuper) GetRemoteLock(ctx context.Context) (*storage.RemoteLock, error) {
+	if !b.ch.IsOpen {
+		if err := b.ch.Connect(); err != nil {
+			return nil, err
+		}
+		defer b.ch.Close()
+	}
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := bd.Connect(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	return bd.GetRemoteLock(ctx)
 }
 
 func (b *Backuper) getLocalBackup(ctx context.Context, backupName string, disks []clickhouse.Disk) (*LocalBackup, []clickhouse.Disk, error) {
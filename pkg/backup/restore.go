@@ -28,7 +28,7 @@ import (
 var CreateDatabaseRE = regexp.MustCompile(`(?m)^CREATE DATABASE (\s*)(\S+)(\s*)`)
 
 // Restore - restore tables matched by tablePattern from backupName
-func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, partitions []string, schemaOnly, dataOnly, dropTable, ignoreDependencies, rbacOnly, configsOnly bool, commandId int) error {
+func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, tableMapping, partitions []string, schemaOnly, dataOnly, dropTable, ignoreDependencies, rbacOnly, configsOnly, viaInsert bool, asOf string, commandId int) error {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -39,6 +39,13 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 	if err := b.prepareRestoreDatabaseMapping(databaseMapping); err != nil {
 		return err
 	}
+	if err := b.prepareRestoreTableMapping(tableMapping); err != nil {
+		return err
+	}
+	asOfTime, err := parseAsOf(asOf)
+	if err != nil {
+		return err
+	}
 
 	log := apexLog.WithFields(apexLog.Fields{
 		"backup":    backupName,
@@ -51,6 +58,13 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 	}
 	defer b.ch.Close()
 
+	if backupName == "" && !asOfTime.IsZero() {
+		if backupName, err = b.selectLocalBackupAsOf(ctx, asOfTime, log); err != nil {
+			return err
+		}
+		log = log.WithField("backup", backupName)
+		log.Infof("selected as newest backup created at or before %s", asOfTime.Format(time.RFC3339))
+	}
 	if backupName == "" {
 		_ = b.PrintLocalBackups(ctx, "all")
 		return fmt.Errorf("select backup for restore")
@@ -89,14 +103,20 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 			return err
 		}
 		if schemaOnly || doRestoreData {
+			selectedTables := parseTablePatternForDownload(backupMetadata.Tables, tablePattern)
 			for _, database := range backupMetadata.Databases {
 				targetDB := database.Name
 				if !IsInformationSchema(targetDB) {
-					if err = b.restoreEmptyDatabase(ctx, targetDB, database, dropTable, schemaOnly); err != nil {
+					// live database of tables which are restored with other names shall not be dropped
+					dropDatabase := dropTable && !b.isDatabaseTableMapped(database.Name, selectedTables)
+					if err = b.restoreEmptyDatabase(ctx, targetDB, database, dropDatabase, schemaOnly); err != nil {
 						return err
 					}
 				}
 			}
+			if err = b.restoreTableMappingDatabases(ctx, backupMetadata.Databases); err != nil {
+				return err
+			}
 			for _, function := range backupMetadata.Functions {
 				if err = b.ch.CreateUserDefinedFunction(function.Name, function.CreateQuery, b.cfg.General.RestoreSchemaOnCluster); err != nil {
 					return err
@@ -152,7 +172,7 @@ func (b *Backuper) Restore(backupName, tablePattern string, databaseMapping, par
 	}
 	if dataOnly || (schemaOnly == dataOnly) {
 		partitionsToRestore, partitions := filesystemhelper.CreatePartitionsToBackupMap(partitions)
-		if err := b.RestoreData(ctx, backupName, tablePattern, partitions, partitionsToRestore, disks, isEmbedded); err != nil {
+		if err := b.RestoreData(ctx, backupName, tablePattern, partitions, partitionsToRestore, disks, isEmbedded, viaInsert, asOfTime); err != nil {
 			return err
 		}
 	}
@@ -197,6 +217,99 @@ func (b *Backuper) prepareRestoreDatabaseMapping(databaseMapping []string) error
 	return nil
 }
 
+func (b *Backuper) prepareRestoreTableMapping(tableMapping []string) error {
+	for i := 0; i < len(tableMapping); i++ {
+		splitByCommas := strings.Split(tableMapping[i], ",")
+		for _, m := range splitByCommas {
+			splitByColon := strings.Split(m, ":")
+			if len(splitByColon) != 2 {
+				return fmt.Errorf("restore-table-mapping %s should only have srcDatabase.srcTable:dstDatabase.dstTable format for each map rule", m)
+			}
+			b.cfg.General.RestoreTableMapping[splitByColon[0]] = splitByColon[1]
+		}
+	}
+	for src, dst := range b.cfg.General.RestoreTableMapping {
+		if _, _, err := splitTableMappingName(src); err != nil {
+			return err
+		}
+		if _, _, err := splitTableMappingName(dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getRestoreTarget - return database and table name for restore after apply restore_table_mapping and restore_database_mapping
+func (b *Backuper) getRestoreTarget(database, table string) (string, string) {
+	if target, isMapped := b.cfg.General.RestoreTableMapping[database+"."+table]; isMapped {
+		if targetDB, targetTable, err := splitTableMappingName(target); err == nil {
+			return targetDB, targetTable
+		}
+	}
+	if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[database]; isMapped {
+		return targetDB, table
+	}
+	return database, table
+}
+
+// isDatabaseTableMapped - all selected tables of database are restored with other names by restore_table_mapping
+func (b *Backuper) isDatabaseTableMapped(database string, tables []metadata.TableTitle) bool {
+	isFound := false
+	for _, t := range tables {
+		if t.Database != database {
+			continue
+		}
+		if _, isMapped := b.cfg.General.RestoreTableMapping[t.Database+"."+t.Table]; !isMapped {
+			return false
+		}
+		isFound = true
+	}
+	return isFound
+}
+
+// restoreTableMappingDatabases - create target databases of restore_table_mapping which are absent in backup, they get engine of source table database
+func (b *Backuper) restoreTableMappingDatabases(ctx context.Context, databases []metadata.DatabasesMeta) error {
+	sourceDatabases := make(map[string]metadata.DatabasesMeta, len(databases))
+	createdDatabases := make(map[string]struct{}, len(databases))
+	for _, database := range databases {
+		sourceDatabases[database.Name] = database
+		targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[database.Name]
+		if !isMapped {
+			targetDB = database.Name
+		}
+		createdDatabases[targetDB] = struct{}{}
+	}
+	for src, dst := range b.cfg.General.RestoreTableMapping {
+		srcDB, _, err := splitTableMappingName(src)
+		if err != nil {
+			return err
+		}
+		dstDB, _, err := splitTableMappingName(dst)
+		if err != nil {
+			return err
+		}
+		database, isSourceExists := sourceDatabases[srcDB]
+		if _, isCreated := createdDatabases[dstDB]; isCreated || !isSourceExists || IsInformationSchema(dstDB) {
+			continue
+		}
+		substitution := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS ${1}`%s`${3}", dstDB)
+		if err = b.ch.CreateDatabaseFromQuery(ctx, CreateDatabaseRE.ReplaceAllString(database.Query, substitution), b.cfg.General.RestoreSchemaOnCluster); err != nil {
+			return err
+		}
+		createdDatabases[dstDB] = struct{}{}
+	}
+	return nil
+}
+
+func (b *Backuper) isRestoreTableMappingTarget(database, table string) bool {
+	for _, target := range b.cfg.General.RestoreTableMapping {
+		if target == database+"."+table {
+			return true
+		}
+	}
+	return false
+}
+
 // restoreRBAC - copy backup_name>/rbac folder to access_data_path
 func (b *Backuper) restoreRBAC(ctx context.Context, backupName string, disks []clickhouse.Disk) error {
 	log := b.log.WithField("logger", "restoreRBAC")
@@ -315,7 +428,13 @@ func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern s
 	}
 	// if restore-database-mapping specified, create database in mapping rules instead of in backup files.
 	if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
-		err = changeTableQueryToAdjustDatabaseMapping(&tablesForRestore, b.cfg.General.RestoreDatabaseMapping)
+		err = changeTableQueryToAdjustDatabaseMapping(&tablesForRestore, b.cfg.General.RestoreDatabaseMapping, b.cfg.General.RestoreTableMapping)
+		if err != nil {
+			return err
+		}
+	}
+	if len(b.cfg.General.RestoreTableMapping) > 0 {
+		err = changeTableQueryToAdjustTableMapping(&tablesForRestore, b.cfg.General.RestoreTableMapping)
 		if err != nil {
 			return err
 		}
@@ -326,6 +445,17 @@ func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern s
 	if dropErr := b.dropExistsTables(tablesForRestore, ignoreDependencies, version, log); dropErr != nil {
 		return dropErr
 	}
+	if !isEmbedded {
+		// replicas for both original and changed zookeeper path shall be dropped, otherwise CREATE TABLE fails when old replica is alive in zookeeper
+		tablesForDropReplica := append(ListOfTables{}, tablesForRestore...)
+		if b.cfg.General.RestoreZookeeperPath != "" {
+			changeTableQueryToAdjustZookeeperPath(&tablesForRestore, b.cfg.General.RestoreZookeeperPath)
+			tablesForDropReplica = append(tablesForDropReplica, tablesForRestore...)
+		}
+		if dropTable && b.cfg.General.RestoreDropReplica {
+			b.dropZookeeperReplicas(ctx, tablesForDropReplica, log)
+		}
+	}
 	var restoreErr error
 	if isEmbedded {
 		restoreErr = b.restoreSchemaEmbedded(backupName, tablesForRestore)
@@ -339,6 +469,81 @@ func (b *Backuper) RestoreSchema(ctx context.Context, backupName, tablePattern s
 }
 
 var UUIDWithReplicatedMergeTreeRE = regexp.MustCompile(`^(.+)(UUID)(\s+)'([^']+)'(.+)({uuid})(.*)`)
+var tableUUIDRE = regexp.MustCompile(`UUID '([a-f\d\-]+)'`)
+var replicatedZookeeperPathAndReplicaRE = regexp.MustCompile(`Replicated\w*MergeTree\s*\(\s*'([^']+)'\s*,\s*'([^']+)'`)
+
+// zookeeperReplica - zookeeper path and replica name from Replicated*MergeTree engine arguments with applied macros
+type zookeeperReplica struct {
+	Database string
+	Table    string
+	ZkPath   string
+	Replica  string
+}
+
+// resolveZookeeperReplicas - return unique zookeeper path and replica pairs for Replicated tables, {database}, {table} and {uuid} are taken from table metadata, other macros are applied via applyMacros
+func resolveZookeeperReplicas(tables ListOfTables, applyMacros func(string) (string, error), log *apexLog.Entry) []zookeeperReplica {
+	replicas := make([]zookeeperReplica, 0)
+	resolved := common.EmptyMap{}
+	for _, t := range tables {
+		groups := replicatedZookeeperPathAndReplicaRE.FindStringSubmatch(t.Query)
+		if groups == nil {
+			continue
+		}
+		zkPath, replica := groups[1], groups[2]
+		tableUUID := ""
+		if uuidGroups := tableUUIDRE.FindStringSubmatch(t.Query); uuidGroups != nil {
+			tableUUID = uuidGroups[1]
+		}
+		if tableUUID == "" && strings.Contains(zkPath+replica, "{uuid}") {
+			log.Warnf("can't drop replica %s from %s for `%s`.`%s`, table query doesn't contains UUID", replica, zkPath, t.Database, t.Table)
+			continue
+		}
+		tableMacros := strings.NewReplacer("{database}", t.Database, "{table}", t.Table, "{uuid}", tableUUID)
+		var err error
+		if zkPath, err = applyMacros(tableMacros.Replace(zkPath)); err != nil {
+			log.Warnf("can't apply macros to %s: %v", zkPath, err)
+			continue
+		}
+		if replica, err = applyMacros(tableMacros.Replace(replica)); err != nil {
+			log.Warnf("can't apply macros to %s: %v", replica, err)
+			continue
+		}
+		replicaPath := path.Join(zkPath, "replicas", replica)
+		if _, exists := resolved[replicaPath]; exists {
+			continue
+		}
+		resolved[replicaPath] = struct{}{}
+		replicas = append(replicas, zookeeperReplica{Database: t.Database, Table: t.Table, ZkPath: zkPath, Replica: replica})
+	}
+	return replicas
+}
+
+// dropZookeeperReplicas - remove replica metadata which left in zookeeper after lost server or previous cluster, otherwise CREATE TABLE fails with REPLICA_ALREADY_EXISTS
+// replicas which still used by some table in system.replicas are skipped
+func (b *Backuper) dropZookeeperReplicas(ctx context.Context, tables ListOfTables, log *apexLog.Entry) {
+	replicas := resolveZookeeperReplicas(tables, func(s string) (string, error) {
+		return b.ch.ApplyMacros(ctx, s)
+	}, log)
+	if len(replicas) == 0 {
+		return
+	}
+	usedReplicaPaths, err := b.ch.GetReplicaPaths(ctx)
+	if err != nil {
+		log.Warnf("can't get replica paths from system.replicas, skip drop replicas: %v", err)
+		return
+	}
+	for _, r := range replicas {
+		if _, isUsed := usedReplicaPaths[path.Join(r.ZkPath, "replicas", r.Replica)]; isUsed {
+			log.Warnf("skip drop replica %s from %s for `%s`.`%s`, replica is used by existing table in system.replicas", r.Replica, r.ZkPath, r.Database, r.Table)
+			continue
+		}
+		if _, err := b.ch.QueryContext(ctx, fmt.Sprintf("SYSTEM DROP REPLICA %s FROM ZKPATH %s", clickhouse.QuoteString(r.Replica), clickhouse.QuoteString(r.ZkPath))); err != nil {
+			log.Warnf("can't drop replica %s from %s for `%s`.`%s`: %v", r.Replica, r.ZkPath, r.Database, r.Table, err)
+			continue
+		}
+		log.WithField("table", fmt.Sprintf("%s.%s", r.Database, r.Table)).Infof("replica %s dropped from %s", r.Replica, r.ZkPath)
+	}
+}
 
 func (b *Backuper) restoreSchemaEmbedded(backupName string, tablesForRestore ListOfTables) error {
 	return b.restoreEmbedded(backupName, true, tablesForRestore, nil)
@@ -373,7 +578,10 @@ func (b *Backuper) restoreSchemaRegular(tablesForRestore ListOfTables, version i
 			// https://github.com/AlexAkulov/clickhouse-backup/issues/466
 			if b.cfg.General.RestoreSchemaOnCluster == "" && strings.Contains(schema.Query, "{uuid}") && strings.Contains(schema.Query, "Replicated") {
 				if !strings.Contains(schema.Query, "UUID") {
-					log.Warnf("table query doesn't contains UUID, can't guarantee properly restore for ReplicatedMergeTree")
+					// UUID removed by restore_table_mapping, new UUID will generate for new zookeeper path
+					if !b.isRestoreTableMappingTarget(schema.Database, schema.Table) {
+						log.Warnf("table query doesn't contains UUID, can't guarantee properly restore for ReplicatedMergeTree")
+					}
 				} else {
 					schema.Query = UUIDWithReplicatedMergeTreeRE.ReplaceAllString(schema.Query, "$1$2$3'$4'$5$4$7")
 				}
@@ -463,7 +671,7 @@ func (b *Backuper) dropExistsTables(tablesForDrop ListOfTables, ignoreDependenci
 }
 
 // RestoreData - restore data for tables matched by tablePattern from backupName
-func (b *Backuper) RestoreData(ctx context.Context, backupName string, tablePattern string, partitions []string, partitionsToRestore common.EmptyMap, disks []clickhouse.Disk, isEmbedded bool) error {
+func (b *Backuper) RestoreData(ctx context.Context, backupName string, tablePattern string, partitions []string, partitionsToRestore common.EmptyMap, disks []clickhouse.Disk, isEmbedded, viaInsert bool, asOf time.Time) error {
 	startRestore := time.Now()
 	log := apexLog.WithFields(apexLog.Fields{
 		"backup":    backupName,
@@ -502,10 +710,25 @@ func (b *Backuper) RestoreData(ctx context.Context, backupName string, tablePatt
 		return fmt.Errorf("no have found schemas by %s in %s", tablePattern, backupName)
 	}
 	log.Debugf("found %d tables with data in backup", len(tablesForRestore))
+	if !asOf.IsZero() {
+		if isEmbedded {
+			return fmt.Errorf("--as-of is not supported for backups created with use_embedded_backup_restore: true")
+		}
+		for i := range tablesForRestore {
+			tableLog := log.WithField("table", fmt.Sprintf("%s.%s", tablesForRestore[i].Database, tablesForRestore[i].Table))
+			b.resolveRequiredPartsModificationTime(ctx, backup.BackupMetadata, &tablesForRestore[i], defaultDataPath, tableLog)
+			if err = filterPartsAsOf(&tablesForRestore[i], backup.CreationDate, asOf, tableLog); err != nil {
+				return fmt.Errorf("can't restore as of %s: %v", asOf.Format(time.RFC3339), err)
+			}
+		}
+	}
 	if isEmbedded {
+		if viaInsert {
+			return fmt.Errorf("--via-insert is not supported for backups created with use_embedded_backup_restore: true")
+		}
 		err = b.restoreDataEmbedded(backupName, tablesForRestore, partitions)
 	} else {
-		err = b.restoreDataRegular(ctx, backupName, tablePattern, tablesForRestore, diskMap, disks, log)
+		err = b.restoreDataRegular(ctx, backupName, tablePattern, tablesForRestore, diskMap, disks, viaInsert, log)
 	}
 	if err != nil {
 		return err
@@ -518,7 +741,11 @@ func (b *Backuper) restoreDataEmbedded(backupName string, tablesForRestore ListO
 	return b.restoreEmbedded(backupName, false, tablesForRestore, partitions)
 }
 
-func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, tablePattern string, tablesForRestore ListOfTables, diskMap map[string]string, disks []clickhouse.Disk, log *apexLog.Entry) error {
+func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, tablePattern string, tablesForRestore ListOfTables, diskMap map[string]string, disks []clickhouse.Disk, viaInsert bool, log *apexLog.Entry) error {
+	// tablePattern matches tables in backup, mapped tables could have other names
+	if len(b.cfg.General.RestoreTableMapping) > 0 {
+		tablePattern = ""
+	}
 	chTables, err := b.ch.GetTables(ctx, tablePattern)
 	if err != nil {
 		return err
@@ -556,11 +783,7 @@ func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, ta
 	var missingTables []string
 	for _, tableForRestore := range tablesForRestore {
 		found := false
-		if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
-			if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[tableForRestore.Database]; isMapped {
-				tableForRestore.Database = targetDB
-			}
-		}
+		tableForRestore.Database, tableForRestore.Table = b.getRestoreTarget(tableForRestore.Database, tableForRestore.Table)
 		for _, chTable := range chTables {
 			if (tableForRestore.Database == chTable.Database) && (tableForRestore.Table == chTable.Name) {
 				found = true
@@ -576,20 +799,34 @@ func (b *Backuper) restoreDataRegular(ctx context.Context, backupName string, ta
 	}
 
 	for i, table := range tablesForRestore {
-		// need mapped database path and original table.Database for CopyDataToDetached
-		dstDatabase := table.Database
-		if len(b.cfg.General.RestoreDatabaseMapping) > 0 {
-			if targetDB, isMapped := b.cfg.General.RestoreDatabaseMapping[table.Database]; isMapped {
-				dstDatabase = targetDB
-				tablesForRestore[i].Database = targetDB
-			}
-		}
-		log := log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, table.Table))
+		// need mapped database and table for AttachPartitions and original table.Database and table.Table for CopyDataToDetached
+		dstDatabase, dstTableName := b.getRestoreTarget(table.Database, table.Table)
+		tablesForRestore[i].Database = dstDatabase
+		tablesForRestore[i].Table = dstTableName
+		log := log.WithField("table", fmt.Sprintf("%s.%s", dstDatabase, dstTableName))
 		dstTable, ok := dstTablesMap[metadata.TableTitle{
 			Database: dstDatabase,
-			Table:    table.Table}]
+			Table:    dstTableName}]
 		if !ok {
-			return fmt.Errorf("can't find '%s.%s' in current system.tables", dstDatabase, table.Table)
+			return fmt.Errorf("can't find '%s.%s' in current system.tables", dstDatabase, dstTableName)
+		}
+		if table.DataSkipped != "" {
+			log.Warnf("data was not backed up, only schema is restored: %s", table.DataSkipped)
+			continue
+		}
+		if table.DataFormat != "" {
+			if err := b.restoreTableDataExported(ctx, backupName, table, dstDatabase, dstTableName, diskMap, disks, log); err != nil {
+				return fmt.Errorf("can't restore '%s.%s': %v", table.Database, table.Table, err)
+			}
+			log.Info("done")
+			continue
+		}
+		if viaInsert && isRestoreViaInsertSupported(table) {
+			if err := b.restoreDataViaInsert(ctx, backupName, table, dstDatabase, dstTableName, disks, log); err != nil {
+				return fmt.Errorf("can't restore '%s.%s' via insert: %v", table.Database, table.Table, err)
+			}
+			log.Info("done")
+			continue
 		}
 		if err := filesystemhelper.CopyDataToDetached(backupName, table, disks, dstTable.DataPaths, b.ch); err != nil {
 			return fmt.Errorf("can't restore '%s.%s': %v", table.Database, table.Table, err)
@@ -613,8 +850,8 @@ func (b *Backuper) restoreEmbedded(backupName string, restoreOnlySchema bool, ta
 			if strings.Contains(t.Query, " DICTIONARY ") {
 				kind = "DICTIONARY"
 			}
-			if newDb, isMapped := b.cfg.General.RestoreDatabaseMapping[t.Database]; isMapped {
-				tablesSQL += fmt.Sprintf("%s `%s`.`%s` AS `%s`.`%s`", kind, t.Database, t.Table, newDb, t.Table)
+			if newDb, newTable := b.getRestoreTarget(t.Database, t.Table); newDb != t.Database || newTable != t.Table {
+				tablesSQL += fmt.Sprintf("%s `%s`.`%s` AS `%s`.`%s`", kind, t.Database, t.Table, newDb, newTable)
 			} else {
 				tablesSQL += fmt.Sprintf("%s `%s`.`%s`", kind, t.Database, t.Table)
 			}
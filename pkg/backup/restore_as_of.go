@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/common"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+)
+
+var asOfLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseAsOf - parse --as-of value, timestamp without timezone means local time
+func parseAsOf(asOf string) (time.Time, error) {
+	if asOf == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range asOfLayouts {
+		if t, err := time.ParseInLocation(layout, asOf, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse --as-of=%s, use RFC3339 or `YYYY-MM-DD hh:mm:ss` format", asOf)
+}
+
+// selectBackupAsOf - return the newest backup created at or before asOf, backups with incomplete RequiredBackup chain are skipped, cause their required parts can't be restored
+func selectBackupAsOf(backups []metadata.BackupMetadata, asOf time.Time, log *apexLog.Entry) (string, error) {
+	backupsByName := make(map[string]metadata.BackupMetadata, len(backups))
+	for _, backup := range backups {
+		backupsByName[backup.BackupName] = backup
+	}
+	candidates := make([]metadata.BackupMetadata, 0, len(backups))
+	for _, backup := range backups {
+		if !backup.CreationDate.After(asOf) {
+			candidates = append(candidates, backup)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].CreationDate.After(candidates[j].CreationDate)
+	})
+	for _, backup := range candidates {
+		if missedBackup := findMissedRequiredBackup(backupsByName, backup); missedBackup != "" {
+			log.Warnf("skip %s created at %s, required backup %s is not found or broken", backup.BackupName, backup.CreationDate.Format(time.RFC3339), missedBackup)
+			continue
+		}
+		return backup.BackupName, nil
+	}
+	return "", fmt.Errorf("no backups with complete required_backup chain created at or before %s", asOf.Format(time.RFC3339))
+}
+
+// findMissedRequiredBackup - walk RequiredBackup chain, return name of first required backup which is absent
+func findMissedRequiredBackup(backupsByName map[string]metadata.BackupMetadata, backup metadata.BackupMetadata) string {
+	visited := map[string]struct{}{backup.BackupName: {}}
+	for requiredName := backup.RequiredBackup; requiredName != ""; {
+		if _, isVisited := visited[requiredName]; isVisited {
+			return requiredName
+		}
+		visited[requiredName] = struct{}{}
+		requiredBackup, exists := backupsByName[requiredName]
+		if !exists {
+			return requiredName
+		}
+		requiredName = requiredBackup.RequiredBackup
+	}
+	return ""
+}
+
+// selectLocalBackupAsOf - return the newest local backup created at or before asOf
+func (b *Backuper) selectLocalBackupAsOf(ctx context.Context, asOf time.Time, log *apexLog.Entry) (string, error) {
+	localBackups, _, err := b.GetLocalBackups(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	backups := make([]metadata.BackupMetadata, 0, len(localBackups))
+	for _, backup := range localBackups {
+		if !backup.Legacy && backup.Broken == "" {
+			backups = append(backups, backup.BackupMetadata)
+		}
+	}
+	return selectBackupAsOf(backups, asOf, log)
+}
+
+// selectRemoteBackupAsOf - return the newest remote backup created at or before asOf, required backups will be downloaded with it
+func (b *Backuper) selectRemoteBackupAsOf(ctx context.Context, asOf time.Time, log *apexLog.Entry) (string, error) {
+	remoteBackups, err := b.GetRemoteBackups(ctx, true)
+	if err != nil {
+		return "", err
+	}
+	backups := make([]metadata.BackupMetadata, 0, len(remoteBackups))
+	for _, backup := range remoteBackups {
+		if !backup.Legacy && backup.Broken == "" {
+			backups = append(backups, backup.BackupMetadata)
+		}
+	}
+	return selectBackupAsOf(backups, asOf, log)
+}
+
+// isInsertedPart - level 0 part without mutation version is created by INSERT, other parts are result of merge or mutation of older parts, projections follow their parent part
+func isInsertedPart(partName string) bool {
+	fields := strings.Split(strings.Split(partName, "/")[0], "_")
+	return len(fields) == 4 && fields[3] == "0"
+}
+
+// resolveRequiredPartsModificationTime - required parts of incremental backup uploaded by previous versions don't have modification_time, take it from local metadata of RequiredBackup chain
+func (b *Backuper) resolveRequiredPartsModificationTime(ctx context.Context, backup metadata.BackupMetadata, table *metadata.TableMetadata, defaultDataPath string, log *apexLog.Entry) {
+	tableMetadataFile := path.Join("metadata", common.TablePathEncode(table.Database), fmt.Sprintf("%s.json", common.TablePathEncode(table.Table)))
+	for requiredName := backup.RequiredBackup; requiredName != ""; {
+		unresolved := 0
+		for disk := range table.Parts {
+			for _, part := range table.Parts[disk] {
+				if part.Required && part.ModificationTime == nil {
+					unresolved++
+				}
+			}
+		}
+		if unresolved == 0 {
+			return
+		}
+		requiredBackup, err := b.ReadBackupMetadataLocal(ctx, requiredName)
+		if err != nil {
+			log.Debugf("can't read required backup %s: %v", requiredName, err)
+			return
+		}
+		requiredTable := metadata.TableMetadata{}
+		if _, err = requiredTable.Load(path.Join(defaultDataPath, "backup", requiredName, tableMetadataFile)); err != nil {
+			log.Debugf("can't read table metadata from required backup %s: %v", requiredName, err)
+			return
+		}
+		for disk := range table.Parts {
+			for i, part := range table.Parts[disk] {
+				if !part.Required || part.ModificationTime != nil {
+					continue
+				}
+				for _, requiredPart := range requiredTable.Parts[disk] {
+					if requiredPart.Name == part.Name {
+						table.Parts[disk][i].ModificationTime = requiredPart.ModificationTime
+						break
+					}
+				}
+			}
+		}
+		requiredName = requiredBackup.RequiredBackup
+	}
+}
+
+// filterPartsAsOf - keep only parts which existed at asOf and log effective snapshot time of table
+// all parts existed at asOf when backup was created at or before asOf, otherwise parts inserted after asOf are skipped
+// asOf can't be honoured when part was merged or mutated after asOf, cause source parts are not in backup, or when part modification time is unknown
+func filterPartsAsOf(table *metadata.TableMetadata, createdAt, asOf time.Time, log *apexLog.Entry) error {
+	var snapshot time.Time
+	skipped := 0
+	isCreatedBeforeAsOf := !createdAt.IsZero() && !createdAt.After(asOf)
+	for disk, parts := range table.Parts {
+		filteredParts := make([]metadata.Part, 0, len(parts))
+		for _, part := range parts {
+			if !isCreatedBeforeAsOf {
+				if part.ModificationTime == nil {
+					return fmt.Errorf("%s.%s part %s on disk %s doesn't have modification_time, can't check it existed at %s, backup was created at %s", table.Database, table.Table, part.Name, disk, asOf.Format(time.RFC3339), createdAt.Format(time.RFC3339))
+				}
+				if part.ModificationTime.After(asOf) {
+					if !isInsertedPart(part.Name) {
+						return fmt.Errorf("%s.%s part %s on disk %s was merged or mutated at %s after %s, it could contain rows inserted later, use backup created at or before %s", table.Database, table.Table, part.Name, disk, part.ModificationTime.Format(time.RFC3339), asOf.Format(time.RFC3339), asOf.Format(time.RFC3339))
+					}
+					log.WithField("disk", disk).WithField("part", part.Name).Debugf("skipped, inserted at %s", part.ModificationTime.Format(time.RFC3339))
+					skipped++
+					continue
+				}
+			}
+			if part.ModificationTime != nil && part.ModificationTime.After(snapshot) {
+				snapshot = *part.ModificationTime
+			}
+			filteredParts = append(filteredParts, part)
+		}
+		table.Parts[disk] = filteredParts
+	}
+	snapshotStr := "unknown"
+	if !snapshot.IsZero() {
+		snapshotStr = snapshot.Format(time.RFC3339)
+	}
+	log.WithFields(apexLog.Fields{
+		"as_of":         asOf.Format(time.RFC3339),
+		"snapshot":      snapshotStr,
+		"skipped_parts": skipped,
+	}).Info("effective snapshot time")
+	return nil
+}
@@ -0,0 +1,173 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+)
+
+func TestSelectBackupAsOf(t *testing.T) {
+	asOf := time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time {
+		return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	testCases := []struct {
+		name     string
+		backups  []metadata.BackupMetadata
+		expected string
+		isErr    bool
+	}{
+		{
+			name: "newest before asOf",
+			backups: []metadata.BackupMetadata{
+				{BackupName: "b1", CreationDate: day(1)},
+				{BackupName: "b2", CreationDate: day(5)},
+				{BackupName: "b3", CreationDate: day(11)},
+			},
+			expected: "b2",
+		},
+		{
+			name: "created exactly at asOf",
+			backups: []metadata.BackupMetadata{
+				{BackupName: "b1", CreationDate: day(1)},
+				{BackupName: "b2", CreationDate: asOf},
+			},
+			expected: "b2",
+		},
+		{
+			name: "complete required chain",
+			backups: []metadata.BackupMetadata{
+				{BackupName: "full", CreationDate: day(1)},
+				{BackupName: "inc1", CreationDate: day(2), RequiredBackup: "full"},
+				{BackupName: "inc2", CreationDate: day(3), RequiredBackup: "inc1"},
+			},
+			expected: "inc2",
+		},
+		{
+			name: "skip incomplete required chain",
+			backups: []metadata.BackupMetadata{
+				{BackupName: "full", CreationDate: day(1)},
+				{BackupName: "inc1", CreationDate: day(2), RequiredBackup: "full"},
+				{BackupName: "inc2", CreationDate: day(3), RequiredBackup: "deleted"},
+			},
+			expected: "inc1",
+		},
+		{
+			name: "skip required loop",
+			backups: []metadata.BackupMetadata{
+				{BackupName: "full", CreationDate: day(1)},
+				{BackupName: "inc1", CreationDate: day(2), RequiredBackup: "inc2"},
+				{BackupName: "inc2", CreationDate: day(3), RequiredBackup: "inc1"},
+			},
+			expected: "full",
+		},
+		{
+			name: "all after asOf",
+			backups: []metadata.BackupMetadata{
+				{BackupName: "b1", CreationDate: day(11)},
+			},
+			isErr: true,
+		},
+		{
+			name:  "empty",
+			isErr: true,
+		},
+	}
+	log := apexLog.WithField("test", "TestSelectBackupAsOf")
+	for _, tc := range testCases {
+		backupName, err := selectBackupAsOf(tc.backups, asOf, log)
+		if tc.isErr {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expected, backupName, tc.name)
+	}
+}
+
+func TestIsInsertedPart(t *testing.T) {
+	testCases := map[string]bool{
+		"all_1_1_0":           true,
+		"202301_5_5_0":        true,
+		"all_1_5_1":           false,
+		"all_1_1_0_7":         false,
+		"all_1_1_0/proj.proj": true,
+		"all_1_3_1/proj.proj": false,
+	}
+	for partName, expected := range testCases {
+		assert.Equal(t, expected, isInsertedPart(partName), partName)
+	}
+}
+
+func TestFilterPartsAsOf(t *testing.T) {
+	asOf := time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC)
+	before := asOf.Add(-time.Hour)
+	after := asOf.Add(time.Hour)
+	part := func(name string, modificationTime *time.Time) metadata.Part {
+		return metadata.Part{Name: name, ModificationTime: modificationTime}
+	}
+	partNames := func(table metadata.TableMetadata) []string {
+		names := make([]string, 0)
+		for _, p := range table.Parts["default"] {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+	testCases := []struct {
+		name      string
+		createdAt time.Time
+		parts     []metadata.Part
+		expected  []string
+		isErr     bool
+	}{
+		{
+			name:      "backup created before asOf keeps all parts",
+			createdAt: before,
+			parts:     []metadata.Part{part("all_1_1_0", nil), part("all_1_5_1", &before)},
+			expected:  []string{"all_1_1_0", "all_1_5_1"},
+		},
+		{
+			name:      "skip parts inserted after asOf",
+			createdAt: after,
+			parts:     []metadata.Part{part("all_1_1_0", &before), part("all_2_2_0", &after), part("all_2_2_0/proj.proj", &after)},
+			expected:  []string{"all_1_1_0"},
+		},
+		{
+			name:      "fail on part merged after asOf",
+			createdAt: after,
+			parts:     []metadata.Part{part("all_1_1_0", &before), part("all_1_5_1", &after)},
+			isErr:     true,
+		},
+		{
+			name:      "fail on part mutated after asOf",
+			createdAt: after,
+			parts:     []metadata.Part{part("all_1_1_0_7", &after)},
+			isErr:     true,
+		},
+		{
+			name:      "fail on unknown modification time",
+			createdAt: after,
+			parts:     []metadata.Part{part("all_1_1_0", nil)},
+			isErr:     true,
+		},
+	}
+	log := apexLog.WithField("test", "TestFilterPartsAsOf")
+	for _, tc := range testCases {
+		table := metadata.TableMetadata{
+			Database: "default",
+			Table:    "t",
+			Parts:    map[string][]metadata.Part{"default": tc.parts},
+		}
+		err := filterPartsAsOf(&table, tc.createdAt, asOf, log)
+		if tc.isErr {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expected, partNames(table), tc.name)
+	}
+}
@@ -1,8 +1,33 @@
 package backup
 
-func (b *Backuper) RestoreFromRemote(backupName, tablePattern string, databaseMapping, partitions []string, schemaOnly, dataOnly, dropTable, ignoreDependencies, rbacOnly, configsOnly, resume bool, commandId int) error {
+import (
+	"context"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/status"
+	apexLog "github.com/apex/log"
+)
+
+func (b *Backuper) RestoreFromRemote(backupName, tablePattern string, databaseMapping, tableMapping, partitions []string, schemaOnly, dataOnly, dropTable, ignoreDependencies, rbacOnly, configsOnly, resume, viaInsert bool, asOf string, commandId int) error {
+	if backupName == "" && asOf != "" {
+		asOfTime, err := parseAsOf(asOf)
+		if err != nil {
+			return err
+		}
+		ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+		if err != nil {
+			return err
+		}
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		log := apexLog.WithField("operation", "restore_remote")
+		if backupName, err = b.selectRemoteBackupAsOf(ctx, asOfTime, log); err != nil {
+			return err
+		}
+		log.WithField("backup", backupName).Infof("selected as newest remote backup created at or before %s", asOfTime.Format(time.RFC3339))
+	}
 	if err := b.Download(backupName, tablePattern, partitions, schemaOnly, resume, commandId); err != nil {
 		return err
 	}
-	return b.Restore(backupName, tablePattern, databaseMapping, partitions, schemaOnly, dataOnly, dropTable, ignoreDependencies, rbacOnly, configsOnly, commandId)
+	return b.Restore(backupName, tablePattern, databaseMapping, tableMapping, partitions, schemaOnly, dataOnly, dropTable, ignoreDependencies, rbacOnly, configsOnly, viaInsert, asOf, commandId)
 }
@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+)
+
+func TestResolveZookeeperReplicas(t *testing.T) {
+	log := apexLog.WithField("logger", "test")
+	serverMacros := strings.NewReplacer("{shard}", "01", "{replica}", "host-1")
+	applyMacros := func(s string) (string, error) {
+		if strings.Contains(s, "{broken}") {
+			return s, fmt.Errorf("unknown macro")
+		}
+		return serverMacros.Replace(s), nil
+	}
+	originalTables := ListOfTables{
+		{
+			Database: "db", Table: "t1",
+			Query: "CREATE TABLE db.t1 UUID 'a3b0d3c2-1234-4bcd-8123-0123456789ab' (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/{database}/{table}/{uuid}', '{replica}') ORDER BY id",
+		},
+		{
+			Database: "db", Table: "t2",
+			Query: "CREATE TABLE db.t2 (`id` UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{uuid}', '{replica}') ORDER BY id",
+		},
+		{
+			Database: "db", Table: "t3",
+			Query: "CREATE TABLE db.t3 (`id` UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+		{
+			Database: "db", Table: "t4",
+			Query: "CREATE TABLE db.t4 (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/{broken}/t4', '{replica}') ORDER BY id",
+		},
+		{
+			Database: "db", Table: "t5",
+			Query: "CREATE TABLE db.t5 (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}') ORDER BY id",
+		},
+	}
+	changedTables := append(ListOfTables{}, originalTables...)
+	changeTableQueryToAdjustZookeeperPath(&changedTables, "/clickhouse/tables/{shard}/{database}/{table}")
+
+	replicas := resolveZookeeperReplicas(append(append(ListOfTables{}, originalTables...), changedTables...), applyMacros, log)
+	assert.Equal(t, []zookeeperReplica{
+		{Database: "db", Table: "t1", ZkPath: "/clickhouse/tables/01/db/t1/a3b0d3c2-1234-4bcd-8123-0123456789ab", Replica: "host-1"},
+		{Database: "db", Table: "t5", ZkPath: "/clickhouse/tables/01/db/t5", Replica: "host-1"},
+		{Database: "db", Table: "t1", ZkPath: "/clickhouse/tables/01/db/t1", Replica: "host-1"},
+		{Database: "db", Table: "t2", ZkPath: "/clickhouse/tables/01/db/t2", Replica: "host-1"},
+		{Database: "db", Table: "t4", ZkPath: "/clickhouse/tables/01/db/t4", Replica: "host-1"},
+	}, replicas)
+
+	assert.Empty(t, resolveZookeeperReplicas(ListOfTables{metadata.TableMetadata{Database: "db", Table: "t3", Query: originalTables[2].Query}}, applyMacros, log))
+}
+
+func TestIsDatabaseTableMapped(t *testing.T) {
+	b := &Backuper{cfg: config.DefaultConfig()}
+	b.cfg.General.RestoreTableMapping = map[string]string{"db.events": "db_restored.events", "db.users": "db.users_restored"}
+	tables := []metadata.TableTitle{{Database: "db", Table: "events"}, {Database: "db", Table: "users"}, {Database: "other", Table: "events"}}
+	assert.True(t, b.isDatabaseTableMapped("db", tables))
+	assert.False(t, b.isDatabaseTableMapped("other", tables))
+	assert.False(t, b.isDatabaseTableMapped("absent", tables))
+	// selected by table pattern tables only
+	assert.True(t, b.isDatabaseTableMapped("db", parseTablePatternForDownload(append(tables, metadata.TableTitle{Database: "db", Table: "logs"}), "db.events,db.users")))
+	assert.False(t, b.isDatabaseTableMapped("db", append(tables, metadata.TableTitle{Database: "db", Table: "logs"})))
+}
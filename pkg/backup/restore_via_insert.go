@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/clickhouse"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	apexLog "github.com/apex/log"
+	"github.com/google/uuid"
+)
+
+// replicatedEngineArgsRE - zookeeper path and replica name arguments of replicated engine, anchored to ENGINE clause, so column names and comments are not changed
+var replicatedEngineArgsRE = regexp.MustCompile(`(ENGINE\s*=\s*)Replicated(\w*MergeTree)\s*\(\s*(?:'[^']*'\s*,\s*'[^']*'\s*,?\s*)?`)
+
+// replicatedEngineRE - replicated engine without arguments, default zookeeper path and replica name from server config are used
+var replicatedEngineRE = regexp.MustCompile(`(ENGINE\s*=\s*)Replicated(\w*MergeTree)\b`)
+
+// mergeTreeEngineRE - table engine is one of MergeTree family
+var mergeTreeEngineRE = regexp.MustCompile(`ENGINE\s*=\s*\w*MergeTree\b`)
+
+// stagingTableQuery - create query for temporary table with original schema from backup
+// replicated engine is replaced with not replicated one, so temporary table is not registered in zookeeper
+func stagingTableQuery(query, database, table string) string {
+	dollarReplacer := strings.NewReplacer("$", "$$")
+	query = tableNameRE.ReplaceAllString(query, fmt.Sprintf("CREATE ${2} `%s`.`%s`", dollarReplacer.Replace(database), dollarReplacer.Replace(table)))
+	query = uuidClauseRE.ReplaceAllString(query, "")
+	query = replicatedEngineArgsRE.ReplaceAllString(query, "${1}${2}(")
+	return replicatedEngineRE.ReplaceAllString(query, "${1}${2}")
+}
+
+// isRestoreViaInsertSupported - only tables with frozen parts are restored via temporary table
+func isRestoreViaInsertSupported(table metadata.TableMetadata) bool {
+	if table.DataFormat != "" || !mergeTreeEngineRE.MatchString(table.Query) {
+		return false
+	}
+	for _, parts := range table.Parts {
+		if len(parts) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreDataViaInsert - attach backup parts to temporary table with original schema from backup, then copy data into destination table with INSERT ... SELECT
+// columns absent in destination table are skipped, columns absent in backup are filled with defaults, temporary table is dropped afterwards
+func (b *Backuper) restoreDataViaInsert(ctx context.Context, backupName string, table metadata.TableMetadata, dstDatabase, dstTableName string, disks []clickhouse.Disk, log *apexLog.Entry) error {
+	version, err := b.ch.GetVersion(ctx)
+	if err != nil {
+		return err
+	}
+	// DDL in Replicated database is executed on all replicas, so temporary table would be created and attached on each of them
+	databaseEngine, err := b.ch.GetDatabaseEngine(ctx, dstDatabase)
+	if err != nil {
+		return err
+	}
+	if databaseEngine == "Replicated" {
+		return fmt.Errorf("--via-insert is not supported for database `%s` with Replicated engine", dstDatabase)
+	}
+	stagingName := fmt.Sprintf("%s_via_insert_%s", dstTableName, strings.ReplaceAll(uuid.New().String(), "-", "")[:8])
+	stagingQuery := stagingTableQuery(table.Query, dstDatabase, stagingName)
+	if _, err = b.ch.QueryContext(ctx, stagingQuery); err != nil {
+		return fmt.Errorf("can't create temporary table `%s`.`%s`: %v", dstDatabase, stagingName, err)
+	}
+	defer func() {
+		if err := b.ch.DropTable(clickhouse.Table{Database: dstDatabase, Name: stagingName}, stagingQuery, "", false, version); err != nil {
+			log.Errorf("can't drop temporary table `%s`.`%s`: %v", dstDatabase, stagingName, err)
+		}
+	}()
+	chTables, err := b.ch.GetTables(ctx, fmt.Sprintf("%s.%s", dstDatabase, stagingName))
+	if err != nil {
+		return err
+	}
+	var stagingTable *clickhouse.Table
+	for i := range chTables {
+		if chTables[i].Database == dstDatabase && chTables[i].Name == stagingName {
+			stagingTable = &chTables[i]
+			break
+		}
+	}
+	if stagingTable == nil {
+		return fmt.Errorf("can't find temporary table `%s`.`%s` in system.tables", dstDatabase, stagingName)
+	}
+	// original table.Database and table.Table are used to find parts in backup folder
+	if err = filesystemhelper.CopyDataToDetached(backupName, table, disks, stagingTable.DataPaths, b.ch); err != nil {
+		return err
+	}
+	stagingMetadata := table
+	stagingMetadata.Database = dstDatabase
+	stagingMetadata.Table = stagingName
+	stagingMetadata.Query = stagingQuery
+	if err = b.ch.AttachPartitions(stagingMetadata, disks); err != nil {
+		return fmt.Errorf("can't attach partitions to temporary table `%s`.`%s`: %v", dstDatabase, stagingName, err)
+	}
+	log.Debugf("attached to temporary table %s", stagingName)
+
+	srcColumns, err := b.ch.GetInsertableColumns(ctx, dstDatabase, stagingName)
+	if err != nil {
+		return err
+	}
+	dstColumns, err := b.ch.GetInsertableColumns(ctx, dstDatabase, dstTableName)
+	if err != nil {
+		return err
+	}
+	insertColumns, err := mapColumns(srcColumns, dstColumns, log)
+	if err != nil {
+		return err
+	}
+	columnList, _ := exportFileColumns(insertColumns)
+	insertSQL := fmt.Sprintf("INSERT INTO `%s`.`%s` (%s) SELECT %s FROM `%s`.`%s`", dstDatabase, dstTableName, columnList, columnList, dstDatabase, stagingName)
+	if _, err = b.ch.QueryContext(ctx, insertSQL); err != nil {
+		return fmt.Errorf("can't insert from temporary table `%s`.`%s`: %v", dstDatabase, stagingName, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+)
+
+func TestStagingTableQuery(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "plain MergeTree",
+			query:    "CREATE TABLE default.t (`id` UInt64) ENGINE = MergeTree ORDER BY id",
+			expected: "CREATE TABLE `db`.`t_staging` (`id` UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+		{
+			name:     "replicated with version argument",
+			query:    "CREATE TABLE default.t UUID 'a3b0d3c2-1234-4bcd-8123-0123456789ab' (`id` UInt64, `ver` UInt32) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/t', '{replica}', ver) ORDER BY id",
+			expected: "CREATE TABLE `db`.`t_staging` (`id` UInt64, `ver` UInt32) ENGINE = ReplacingMergeTree(ver) ORDER BY id",
+		},
+		{
+			name:     "replicated with path and replica only",
+			query:    "CREATE TABLE default.t (`id` UInt64) ENGINE=ReplicatedMergeTree('/clickhouse/tables/t', 'r1') ORDER BY id",
+			expected: "CREATE TABLE `db`.`t_staging` (`id` UInt64) ENGINE=MergeTree() ORDER BY id",
+		},
+		{
+			name:     "replicated without arguments",
+			query:    "CREATE TABLE default.t (`id` UInt64) ENGINE = ReplicatedMergeTree ORDER BY id",
+			expected: "CREATE TABLE `db`.`t_staging` (`id` UInt64) ENGINE = MergeTree ORDER BY id",
+		},
+		{
+			name:     "replicated with empty arguments",
+			query:    "CREATE TABLE default.t (`id` UInt64) ENGINE = ReplicatedSummingMergeTree() ORDER BY id",
+			expected: "CREATE TABLE `db`.`t_staging` (`id` UInt64) ENGINE = SummingMergeTree() ORDER BY id",
+		},
+		{
+			name:     "column name contains Replicated",
+			query:    "CREATE TABLE default.t (`ReplicatedMergeTree_flag` UInt8, `isReplicatedMergeTree` String COMMENT 'ReplicatedMergeTree(\\'a\\', \\'b\\')') ENGINE = ReplicatedMergeTree('/p', 'r') ORDER BY ReplicatedMergeTree_flag",
+			expected: "CREATE TABLE `db`.`t_staging` (`ReplicatedMergeTree_flag` UInt8, `isReplicatedMergeTree` String COMMENT 'ReplicatedMergeTree(\\'a\\', \\'b\\')') ENGINE = MergeTree() ORDER BY ReplicatedMergeTree_flag",
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, stagingTableQuery(tc.query, "db", "t_staging"), tc.name)
+	}
+}
+
+func TestIsRestoreViaInsertSupported(t *testing.T) {
+	parts := map[string][]metadata.Part{"default": {{Name: "all_1_1_0"}}}
+	testCases := []struct {
+		name     string
+		table    metadata.TableMetadata
+		expected bool
+	}{
+		{
+			name:     "plain MergeTree",
+			table:    metadata.TableMetadata{Query: "CREATE TABLE default.t (`id` UInt64) ENGINE = MergeTree ORDER BY id", Parts: parts},
+			expected: true,
+		},
+		{
+			name:     "replicated with version argument",
+			table:    metadata.TableMetadata{Query: "CREATE TABLE default.t (`id` UInt64, `ver` UInt32) ENGINE = ReplicatedReplacingMergeTree('p', 'r', ver) ORDER BY id", Parts: parts},
+			expected: true,
+		},
+		{
+			name:     "replicated without arguments",
+			table:    metadata.TableMetadata{Query: "CREATE TABLE default.t (`id` UInt64) ENGINE = ReplicatedMergeTree ORDER BY id", Parts: parts},
+			expected: true,
+		},
+		{
+			name:     "column name contains MergeTree",
+			table:    metadata.TableMetadata{Query: "CREATE TABLE default.t (`ReplicatedMergeTree` UInt8) ENGINE = Log", Parts: parts},
+			expected: false,
+		},
+		{
+			name:  "without parts",
+			table: metadata.TableMetadata{Query: "CREATE TABLE default.t (`id` UInt64) ENGINE = MergeTree ORDER BY id", Parts: map[string][]metadata.Part{"default": {}}},
+		},
+		{
+			name:  "logical backup",
+			table: metadata.TableMetadata{Query: "CREATE TABLE default.t (`id` UInt64) ENGINE = MergeTree ORDER BY id", Parts: parts, DataFormat: metadata.DataFormatNative},
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, isRestoreViaInsertSupported(tc.table), tc.name)
+	}
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/status"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/storage"
+)
+
+// RetentionResult - retention decision for one remote backup
+type RetentionResult struct {
+	Backup       string    `json:"backup"`
+	CreationDate time.Time `json:"creation_date"`
+	Action       string    `json:"action"`
+	Reason       string    `json:"reason"`
+}
+
+// Retention - apply general->backups_to_keep_remote and retention policy to remote backups, dryRun only print what would be deleted and why
+func (b *Backuper) Retention(dryRun, forceUnlock bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	results, err := b.ApplyRetention(ctx, dryRun, forceUnlock)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return printRetentionResults(os.Stdout, results)
+	}
+	return nil
+}
+
+func printRetentionResults(w io.Writer, results []RetentionResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	for _, r := range results {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Backup, r.CreationDate.Format("02/01/2006 15:04:05"), r.Action, r.Reason); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// ApplyRetention - calculate retention decision for each remote backup, backups which are not kept are removed when dryRun is false
+func (b *Backuper) ApplyRetention(ctx context.Context, dryRun, forceUnlock bool) ([]RetentionResult, error) {
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return nil, fmt.Errorf("retention is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	policy := storage.NewRetentionPolicy(b.cfg)
+	if policy.IsEmpty() && !dryRun {
+		b.log.Warn("backups_to_keep_remote and retention are not defined, nothing to delete")
+		return nil, nil
+	}
+	if err := b.ch.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	if !dryRun {
+		lockCtx, unlock, err := bd.Lock(ctx, "retention", forceUnlock)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+		ctx = lockCtx
+	}
+	retentions, err := bd.ApplyRetention(ctx, policy, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]RetentionResult, len(retentions))
+	for i, retention := range retentions {
+		action := "delete"
+		if retention.Keep {
+			action = "keep"
+		}
+		creationDate := retention.Backup.CreationDate
+		if creationDate.IsZero() {
+			creationDate = retention.Backup.UploadDate
+		}
+		results[i] = RetentionResult{
+			Backup:       retention.Backup.BackupName,
+			CreationDate: creationDate,
+			Action:       action,
+			Reason:       strings.Join(retention.Reasons, ", "),
+		}
+	}
+	return results, nil
+}
@@ -151,9 +151,13 @@ var createRE = regexp.MustCompile(`(?m)^CREATE`)
 var attachRE = regexp.MustCompile(`(?m)^ATTACH`)
 var uuidRE = regexp.MustCompile(`UUID '[a-f\d\-]+'`)
 
-func changeTableQueryToAdjustDatabaseMapping(originTables *ListOfTables, dbMapRule map[string]string) error {
+func changeTableQueryToAdjustDatabaseMapping(originTables *ListOfTables, dbMapRule map[string]string, tableMapRule map[string]string) error {
 	for i := 0; i < len(*originTables); i++ {
 		originTable := (*originTables)[i]
+		// table mapping has priority over database mapping
+		if _, isTableMapped := tableMapRule[originTable.Database+"."+originTable.Table]; isTableMapped {
+			continue
+		}
 		if targetDB, isMapped := dbMapRule[originTable.Database]; isMapped {
 			// substitute database in the table create query
 			var substitution string
@@ -183,6 +187,95 @@ func changeTableQueryToAdjustDatabaseMapping(originTables *ListOfTables, dbMapRu
 	return nil
 }
 
+var tableNameRE = regexp.MustCompile(`(?m)^(CREATE|ATTACH) (TABLE|VIEW|MATERIALIZED VIEW|LIVE VIEW|WINDOW VIEW|DICTIONARY) (\x60[^\x60]+\x60|[^\s\x60.]+)\.(\x60[^\x60]+\x60|[^\s\x60.]+)`)
+var viewToTableRE = regexp.MustCompile(`(?m)^((?:CREATE|ATTACH) (?:MATERIALIZED|WINDOW) VIEW (?:\x60[^\x60]+\x60|[^\s\x60.]+)\.(?:\x60[^\x60]+\x60|[^\s\x60.]+)(?: UUID '[a-f\d\-]+')?(?: ON CLUSTER (?:'[^']+'|\x60[^\x60]+\x60|[^\s\x60']+))? TO )(\x60[^\x60]+\x60|[^\s\x60.]+)\.(\x60[^\x60]+\x60|[^\s\x60.]+)`)
+var uuidClauseRE = regexp.MustCompile(`\s+UUID '[a-f\d\-]+'`)
+var replicatedZookeeperPathRE = regexp.MustCompile(`(Replicated\w*MergeTree\s*\(\s*')([^']+)(')`)
+
+// splitTableMappingName - split `db.table` from restore_table_mapping, table name could contain dots
+func splitTableMappingName(name string) (string, string, error) {
+	dbAndTable := strings.SplitN(name, ".", 2)
+	if len(dbAndTable) != 2 || dbAndTable[0] == "" || dbAndTable[1] == "" {
+		return "", "", fmt.Errorf("restore-table-mapping %s should have database.table format", name)
+	}
+	return dbAndTable[0], dbAndTable[1], nil
+}
+
+// changeTableQueryToAdjustTableMapping - rename tables in create query, remove UUID, change zookeeper path for Replicated*MergeTree and TO target of materialized views
+func changeTableQueryToAdjustTableMapping(originTables *ListOfTables, tableMapRule map[string]string) error {
+	for i := 0; i < len(*originTables); i++ {
+		originTable := (*originTables)[i]
+		originTable.Query = viewToTableRE.ReplaceAllStringFunc(originTable.Query, func(match string) string {
+			groups := viewToTableRE.FindStringSubmatch(match)
+			target, isMapped := tableMapRule[strings.Trim(groups[2], "`")+"."+strings.Trim(groups[3], "`")]
+			if !isMapped {
+				return match
+			}
+			targetDB, targetTable, _ := splitTableMappingName(target)
+			return fmt.Sprintf("%s`%s`.`%s`", groups[1], targetDB, targetTable)
+		})
+		target, isMapped := tableMapRule[originTable.Database+"."+originTable.Table]
+		if !isMapped {
+			(*originTables)[i] = originTable
+			continue
+		}
+		targetDB, targetTable, err := splitTableMappingName(target)
+		if err != nil {
+			return err
+		}
+		if originTable.Query != "" {
+			if !tableNameRE.MatchString(originTable.Query) {
+				return fmt.Errorf("error when try to replace `%s`.`%s` to `%s`.`%s` in query: %s", originTable.Database, originTable.Table, targetDB, targetTable, originTable.Query)
+			}
+			originTable.Query = tableNameRE.ReplaceAllString(originTable.Query, fmt.Sprintf("${1} ${2} `%s`.`%s`", targetDB, targetTable))
+			originTable.Query = uuidClauseRE.ReplaceAllString(originTable.Query, "")
+			originTable.Query = changeReplicatedZookeeperPath(originTable.Query, originTable.Database, originTable.Table, targetDB, targetTable)
+		}
+		originTable.Database = targetDB
+		originTable.Table = targetTable
+		(*originTables)[i] = originTable
+	}
+	return nil
+}
+
+// changeReplicatedZookeeperPath - replace database and table names in zookeeper path, when path will be the same after replace, add target table name as suffix, to avoid restored table become replica of source table
+func changeReplicatedZookeeperPath(query, database, table, targetDB, targetTable string) string {
+	return replicatedZookeeperPathRE.ReplaceAllStringFunc(query, func(match string) string {
+		groups := replicatedZookeeperPathRE.FindStringSubmatch(match)
+		zkPath := groups[2]
+		segments := strings.Split(zkPath, "/")
+		for i := range segments {
+			if segments[i] == table {
+				segments[i] = targetTable
+			} else if segments[i] == database {
+				segments[i] = targetDB
+			}
+		}
+		newPath := strings.Join(segments, "/")
+		isPathChanged := newPath != zkPath || strings.Contains(zkPath, "{uuid}") ||
+			(strings.Contains(zkPath, "{table}") && table != targetTable) ||
+			(strings.Contains(zkPath, "{database}") && database != targetDB)
+		if !isPathChanged {
+			newPath = fmt.Sprintf("%s_%s_%s", zkPath, targetDB, targetTable)
+			apexLog.Warnf("zookeeper path %s of `%s`.`%s` doesn't contain table name, use %s for `%s`.`%s`", zkPath, database, table, newPath, targetDB, targetTable)
+		}
+		return groups[1] + newPath + groups[3]
+	})
+}
+
+// changeTableQueryToAdjustZookeeperPath - replace zookeeper path of Replicated*MergeTree tables by template, {database} and {table} replaced by restored table names, {uuid} and other macros will expand by clickhouse
+func changeTableQueryToAdjustZookeeperPath(originTables *ListOfTables, pathTemplate string) {
+	for i := 0; i < len(*originTables); i++ {
+		originTable := (*originTables)[i]
+		zkPath := strings.NewReplacer("{database}", originTable.Database, "{table}", originTable.Table).Replace(pathTemplate)
+		originTable.Query = replicatedZookeeperPathRE.ReplaceAllStringFunc(originTable.Query, func(match string) string {
+			groups := replicatedZookeeperPathRE.FindStringSubmatch(match)
+			return groups[1] + zkPath + groups[3]
+		})
+		(*originTables)[i] = originTable
+	}
+}
+
 func filterPartsByPartitionsFilter(tableMetadata metadata.TableMetadata, partitionsFilter common.EmptyMap) {
 	if len(partitionsFilter) > 0 {
 		for disk, parts := range tableMetadata.Parts {
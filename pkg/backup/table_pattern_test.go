@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+)
+
+func TestChangeTableQueryToAdjustTableMapping(t *testing.T) {
+	testCases := []struct {
+		name          string
+		table         metadata.TableMetadata
+		tableMapping  map[string]string
+		expectedQuery string
+		expectedDB    string
+		expectedTable string
+	}{
+		{
+			name:          "plain names",
+			table:         metadata.TableMetadata{Database: "db", Table: "t", Query: "CREATE TABLE db.t (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+			tableMapping:  map[string]string{"db.t": "db2.t2"},
+			expectedQuery: "CREATE TABLE `db2`.`t2` (`id` UInt64) ENGINE = MergeTree ORDER BY id",
+			expectedDB:    "db2",
+			expectedTable: "t2",
+		},
+		{
+			name:          "backquoted names with dots and UUID",
+			table:         metadata.TableMetadata{Database: "my-db", Table: "t.1", Query: "CREATE TABLE `my-db`.`t.1` UUID 'a3b0d3c2-1234-4bcd-8123-0123456789ab' (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+			tableMapping:  map[string]string{"my-db.t.1": "db2.t.2"},
+			expectedQuery: "CREATE TABLE `db2`.`t.2` (`id` UInt64) ENGINE = MergeTree ORDER BY id",
+			expectedDB:    "db2",
+			expectedTable: "t.2",
+		},
+		{
+			name:          "ON CLUSTER",
+			table:         metadata.TableMetadata{Database: "db", Table: "t", Query: "CREATE TABLE db.t ON CLUSTER '{cluster}' (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id"},
+			tableMapping:  map[string]string{"db.t": "db.t2"},
+			expectedQuery: "CREATE TABLE `db`.`t2` ON CLUSTER '{cluster}' (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t2', '{replica}') ORDER BY id",
+			expectedDB:    "db",
+			expectedTable: "t2",
+		},
+		{
+			name:          "materialized view renamed, TO target is not mapped",
+			table:         metadata.TableMetadata{Database: "db", Table: "mv", Query: "CREATE MATERIALIZED VIEW db.mv UUID 'a3b0d3c2-1234-4bcd-8123-0123456789ab' TO db.dst (`id` UInt64) AS SELECT id FROM db.src"},
+			tableMapping:  map[string]string{"db.mv": "db.mv2"},
+			expectedQuery: "CREATE MATERIALIZED VIEW `db`.`mv2` TO db.dst (`id` UInt64) AS SELECT id FROM db.src",
+			expectedDB:    "db",
+			expectedTable: "mv2",
+		},
+		{
+			name:          "materialized view TO backquoted target is mapped",
+			table:         metadata.TableMetadata{Database: "db", Table: "mv", Query: "CREATE MATERIALIZED VIEW db.mv TO `db`.`dst` (`id` UInt64) AS SELECT id FROM db.src"},
+			tableMapping:  map[string]string{"db.dst": "db.dst2"},
+			expectedQuery: "CREATE MATERIALIZED VIEW db.mv TO `db`.`dst2` (`id` UInt64) AS SELECT id FROM db.src",
+			expectedDB:    "db",
+			expectedTable: "mv",
+		},
+		{
+			name:          "materialized view ON CLUSTER with mapped view and TO target",
+			table:         metadata.TableMetadata{Database: "db", Table: "mv", Query: "CREATE MATERIALIZED VIEW db.mv ON CLUSTER '{cluster}' TO db.dst (`id` UInt64) AS SELECT id FROM db.src"},
+			tableMapping:  map[string]string{"db.mv": "db.mv2", "db.dst": "db.dst2"},
+			expectedQuery: "CREATE MATERIALIZED VIEW `db`.`mv2` ON CLUSTER '{cluster}' TO `db`.`dst2` (`id` UInt64) AS SELECT id FROM db.src",
+			expectedDB:    "db",
+			expectedTable: "mv2",
+		},
+		{
+			name:          "source table name inside SELECT is not changed",
+			table:         metadata.TableMetadata{Database: "db", Table: "v", Query: "CREATE VIEW db.v (`id` UInt64) AS SELECT id FROM db.t WHERE id IN (SELECT id FROM db.v_old)"},
+			tableMapping:  map[string]string{"db.t": "db.t2", "db.v": "db.v2"},
+			expectedQuery: "CREATE VIEW `db`.`v2` (`id` UInt64) AS SELECT id FROM db.t WHERE id IN (SELECT id FROM db.v_old)",
+			expectedDB:    "db",
+			expectedTable: "v2",
+		},
+		{
+			name:          "materialized view SELECT contains TO target name",
+			table:         metadata.TableMetadata{Database: "db", Table: "mv", Query: "CREATE MATERIALIZED VIEW db.mv TO db.dst (`id` UInt64) AS SELECT id FROM db.src JOIN db.dst USING id"},
+			tableMapping:  map[string]string{"db.dst": "db.dst2"},
+			expectedQuery: "CREATE MATERIALIZED VIEW db.mv TO `db`.`dst2` (`id` UInt64) AS SELECT id FROM db.src JOIN db.dst USING id",
+			expectedDB:    "db",
+			expectedTable: "mv",
+		},
+		{
+			name:          "not mapped table",
+			table:         metadata.TableMetadata{Database: "db", Table: "t", Query: "CREATE TABLE db.t (`id` UInt64) ENGINE = MergeTree ORDER BY id"},
+			tableMapping:  map[string]string{"db.t2": "db.t3"},
+			expectedQuery: "CREATE TABLE db.t (`id` UInt64) ENGINE = MergeTree ORDER BY id",
+			expectedDB:    "db",
+			expectedTable: "t",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tables := ListOfTables{tc.table}
+			assert.NoError(t, changeTableQueryToAdjustTableMapping(&tables, tc.tableMapping))
+			assert.Equal(t, tc.expectedQuery, tables[0].Query)
+			assert.Equal(t, tc.expectedDB, tables[0].Database)
+			assert.Equal(t, tc.expectedTable, tables[0].Table)
+		})
+	}
+}
+
+func TestChangeTableQueryToAdjustTableMappingErrors(t *testing.T) {
+	tables := ListOfTables{{Database: "db", Table: "t", Query: "CREATE TABLE db.t (`id` UInt64) ENGINE = MergeTree ORDER BY id"}}
+	assert.Error(t, changeTableQueryToAdjustTableMapping(&tables, map[string]string{"db.t": "t2"}))
+	tables = ListOfTables{{Database: "db", Table: "t", Query: "CREATE TEMPORARY TABLE t (`id` UInt64) ENGINE = Memory"}}
+	assert.Error(t, changeTableQueryToAdjustTableMapping(&tables, map[string]string{"db.t": "db.t2"}))
+}
+
+func TestTableNameRE(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"CREATE TABLE db.t (`id` UInt64) ENGINE = Memory", []string{"CREATE", "TABLE", "db", "t"}},
+		{"ATTACH TABLE `my db`.`my.table` UUID 'a3b0d3c2-1234-4bcd-8123-0123456789ab' (`id` UInt64) ENGINE = Memory", []string{"ATTACH", "TABLE", "`my db`", "`my.table`"}},
+		{"CREATE MATERIALIZED VIEW db.mv TO db.dst AS SELECT * FROM db.src", []string{"CREATE", "MATERIALIZED VIEW", "db", "mv"}},
+		{"CREATE LIVE VIEW db.lv AS SELECT count() FROM db.t", []string{"CREATE", "LIVE VIEW", "db", "lv"}},
+		{"CREATE WINDOW VIEW db.wv TO db.dst AS SELECT count() FROM db.t GROUP BY tumble(ts, INTERVAL '1' SECOND)", []string{"CREATE", "WINDOW VIEW", "db", "wv"}},
+		{"CREATE DICTIONARY db.dict (`id` UInt64) PRIMARY KEY id SOURCE(CLICKHOUSE(TABLE 't')) LAYOUT(FLAT()) LIFETIME(0)", []string{"CREATE", "DICTIONARY", "db", "dict"}},
+		{"CREATE TABLE db.t ON CLUSTER 'c' (`id` UInt64) ENGINE = Memory", []string{"CREATE", "TABLE", "db", "t"}},
+		{"CREATE TEMPORARY TABLE t (`id` UInt64) ENGINE = Memory", nil},
+		{"SELECT * FROM db.t", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			groups := tableNameRE.FindStringSubmatch(tc.query)
+			if tc.expected == nil {
+				assert.Nil(t, groups)
+			} else if assert.NotNil(t, groups) {
+				assert.Equal(t, tc.expected, groups[1:])
+			}
+		})
+	}
+}
+
+func TestViewToTableRE(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"CREATE MATERIALIZED VIEW db.mv TO db.dst (`id` UInt64) AS SELECT id FROM db.src", []string{"CREATE MATERIALIZED VIEW db.mv TO ", "db", "dst"}},
+		{"ATTACH MATERIALIZED VIEW `db`.`m.v` UUID 'a3b0d3c2-1234-4bcd-8123-0123456789ab' TO `db`.`d.st` (`id` UInt64) AS SELECT id FROM db.src", []string{"ATTACH MATERIALIZED VIEW `db`.`m.v` UUID 'a3b0d3c2-1234-4bcd-8123-0123456789ab' TO ", "`db`", "`d.st`"}},
+		{"CREATE MATERIALIZED VIEW db.mv ON CLUSTER '{cluster}' TO db.dst AS SELECT id FROM db.src", []string{"CREATE MATERIALIZED VIEW db.mv ON CLUSTER '{cluster}' TO ", "db", "dst"}},
+		{"CREATE WINDOW VIEW db.wv TO db.dst AS SELECT count() FROM db.t GROUP BY tumble(ts, INTERVAL '1' SECOND)", []string{"CREATE WINDOW VIEW db.wv TO ", "db", "dst"}},
+		{"CREATE MATERIALIZED VIEW db.mv ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.src WHERE id IN (SELECT id FROM db.src2) TO db.fake", nil},
+		{"CREATE VIEW db.v AS SELECT id FROM db.src", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			groups := viewToTableRE.FindStringSubmatch(tc.query)
+			if tc.expected == nil {
+				assert.Nil(t, groups)
+			} else if assert.NotNil(t, groups) {
+				assert.Equal(t, tc.expected, groups[1:])
+			}
+		})
+	}
+}
+
+func TestChangeReplicatedZookeeperPath(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "database and table in path",
+			query:    "CREATE TABLE db.t (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db/t', '{replica}') ORDER BY id",
+			expected: "CREATE TABLE db.t (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/db2/t2', '{replica}') ORDER BY id",
+		},
+		{
+			name:     "macros in path",
+			query:    "CREATE TABLE db.t (`id` UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}', ver) ORDER BY id",
+			expected: "CREATE TABLE db.t (`id` UInt64) ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/{database}/{table}', '{replica}', ver) ORDER BY id",
+		},
+		{
+			name:     "path without table name",
+			query:    "CREATE TABLE db.t (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/some_path', '{replica}') ORDER BY id",
+			expected: "CREATE TABLE db.t (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/some_path_db2_t2', '{replica}') ORDER BY id",
+		},
+		{
+			name:     "table name only as part of segment",
+			query:    "CREATE TABLE db.t (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/db_t', '{replica}') ORDER BY id",
+			expected: "CREATE TABLE db.t (`id` UInt64) ENGINE = ReplicatedMergeTree('/clickhouse/tables/db_t_db2_t2', '{replica}') ORDER BY id",
+		},
+		{
+			name:     "table name inside SELECT of not replicated table",
+			query:    "CREATE MATERIALIZED VIEW db.mv ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.t WHERE s = '/db/t'",
+			expected: "CREATE MATERIALIZED VIEW db.mv ENGINE = MergeTree ORDER BY id AS SELECT id FROM db.t WHERE s = '/db/t'",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, changeReplicatedZookeeperPath(tc.query, "db", "t", "db2", "t2"))
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/status"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/storage"
+)
+
+// TierResult - tier decision for one remote backup
+type TierResult struct {
+	Backup       string    `json:"backup"`
+	CreationDate time.Time `json:"creation_date"`
+	StorageTier  string    `json:"storage_tier"`
+	Action       string    `json:"action"`
+}
+
+// Tier - move data of remote backups older than general->tier_after to tier_storage_class, dryRun only print what would be moved
+func (b *Backuper) Tier(dryRun, forceUnlock bool, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	results, err := b.ApplyTier(ctx, dryRun, forceUnlock)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return printTierResults(os.Stdout, results)
+	}
+	return nil
+}
+
+func printTierResults(w io.Writer, results []TierResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+	for _, r := range results {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Backup, r.CreationDate.Format("02/01/2006 15:04:05"), r.StorageTier, r.Action); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// ApplyTier - calculate tier decision for each remote backup, data of aged backups is moved to tier_storage_class when dryRun is false
+func (b *Backuper) ApplyTier(ctx context.Context, dryRun, forceUnlock bool) ([]TierResult, error) {
+	if b.cfg.General.TierAfterDuration <= 0 {
+		return nil, fmt.Errorf("general->tier_after is not defined")
+	}
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return nil, fmt.Errorf("tier is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if err := b.ch.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	bd, err := storage.NewBackupDestination(ctx, b.cfg, b.ch, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = bd.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("can't connect to remote storage: %v", err)
+	}
+	defer func() {
+		if err := bd.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	storageClass := bd.TierStorageClass()
+	if storageClass == "" {
+		return nil, fmt.Errorf("tier_storage_class is not defined for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	if !dryRun {
+		lockCtx, unlock, err := bd.Lock(ctx, "tier", forceUnlock)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+		ctx = lockCtx
+	}
+	backupList, err := bd.BackupList(ctx, true, "")
+	if err != nil {
+		return nil, err
+	}
+	results := make([]TierResult, len(backupList))
+	for i, backup := range backupList {
+		creationDate := backup.CreationDate
+		if creationDate.IsZero() {
+			creationDate = backup.UploadDate
+		}
+		results[i] = TierResult{
+			Backup:       backup.BackupName,
+			CreationDate: creationDate,
+			StorageTier:  backup.StorageTier,
+			Action:       "skip",
+		}
+		if !backup.IsTierRequired(storageClass, b.cfg.General.TierAfterDuration) {
+			continue
+		}
+		// shared parts/ are referenced by newer backups and stay in current storage class, so backup can't be marked as tiered
+		contentParts, err := bd.GetContentPartsRefs(ctx, backup.BackupName)
+		if err != nil {
+			return results, err
+		}
+		if len(contentParts) > 0 {
+			results[i].Action = "skip, content addressed parts"
+			continue
+		}
+		results[i].Action = "tier to " + storageClass
+		if dryRun {
+			continue
+		}
+		if err = bd.TierBackup(ctx, backup, storageClass, int(b.cfg.General.UploadConcurrency)); err != nil {
+			return results, err
+		}
+		results[i].StorageTier = storageClass
+	}
+	return results, nil
+}
+
+// restoreArchivedBackup - data of tiered backup and its required backups for download_by_part shall be restored from archive storage class before download
+func (b *Backuper) restoreArchivedBackup(ctx context.Context, remoteBackup storage.Backup) error {
+	if remoteBackup.StorageTier == "" && b.dst.TierStorageClass() == "" {
+		return nil
+	}
+	backupName := remoteBackup.BackupName
+	requiredBackup := remoteBackup.RequiredBackup
+	for {
+		if err := b.dst.RestoreArchivedBackup(ctx, backupName, int(b.cfg.General.DownloadConcurrency), b.cfg.General.ArchiveRestoreDuration); err != nil {
+			return err
+		}
+		if !b.cfg.General.DownloadByPart || requiredBackup == "" || requiredBackup == backupName {
+			return nil
+		}
+		requiredMetadata, err := b.ReadBackupMetadataRemote(ctx, requiredBackup)
+		if err != nil {
+			return fmt.Errorf("can't read required backup %s metadata: %v", requiredBackup, err)
+		}
+		backupName, requiredBackup = requiredBackup, requiredMetadata.RequiredBackup
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/AlexAkulov/clickhouse-backup/pkg/clickhouse"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
 	"github.com/AlexAkulov/clickhouse-backup/pkg/custom"
 	"github.com/AlexAkulov/clickhouse-backup/pkg/resumable"
 	"github.com/AlexAkulov/clickhouse-backup/pkg/status"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/storage"
 	"github.com/eapache/go-resiliency/retrier"
 	"io"
 	"os"
@@ -16,6 +19,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -30,7 +34,35 @@ import (
 	"github.com/yargevad/filepathx"
 )
 
-func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume bool, commandId int) error {
+// Upload - upload local backup to general->remote_storage and replicate it to each storage from remote_storages section, when storage is not selected with --storage
+func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume, forceUnlock bool, commandId int) error {
+	if len(b.cfg.RemoteStorages) == 0 {
+		return b.upload(backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, forceUnlock, commandId)
+	}
+	storageNames := b.cfg.GetStorageNames()
+	var uploadErrors []string
+	for _, storageName := range storageNames {
+		storageCfg, err := b.cfg.GetStorageConfig(storageName)
+		if err != nil {
+			return err
+		}
+		b.log.WithFields(apexLog.Fields{
+			"backup":    backupName,
+			"operation": "upload",
+			"storage":   storageName,
+		}).Info("start")
+		if err := NewBackuper(storageCfg).upload(backupName, diffFrom, diffFromRemote, tablePattern, partitions, schemaOnly, resume, forceUnlock, commandId); err != nil {
+			b.log.WithField("storage", storageName).Errorf("upload %s error: %v", backupName, err)
+			uploadErrors = append(uploadErrors, fmt.Sprintf("%s: %v", storageName, err))
+		}
+	}
+	if len(uploadErrors) > 0 {
+		return fmt.Errorf("upload failed for %d of %d remote storages: %s", len(uploadErrors), len(storageNames), strings.Join(uploadErrors, "; "))
+	}
+	return nil
+}
+
+func (b *Backuper) upload(backupName, diffFrom, diffFromRemote, tablePattern string, partitions []string, schemaOnly, resume, forceUnlock bool, commandId int) error {
 	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
 	if err != nil {
 		return err
@@ -59,21 +91,34 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 	if _, disks, err = b.getLocalBackup(ctx, backupName, nil); err != nil {
 		return fmt.Errorf("can't find local backup: %v", err)
 	}
-	if err := b.init(ctx, disks); err != nil {
+	// create_remote --format logical already connected and locked remote storage to stream exported data
+	if b.logicalStream == nil {
+		if err := b.init(ctx, disks); err != nil {
+			return err
+		}
+		defer func() {
+			if err := b.dst.Close(ctx); err != nil {
+				b.log.Warnf("can't close BackupDestination error: %v", err)
+			}
+		}()
+	}
+	ctx, unlock, err := b.dst.Lock(ctx, "upload "+backupName, forceUnlock)
+	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := b.dst.Close(ctx); err != nil {
-			b.log.Warnf("can't close BackupDestination error: %v", err)
-		}
-	}()
+	defer unlock()
+	// GCS bucket retention policy is detected only during connect
+	if retainUntil, _ := b.dst.ObjectLock(); retainUntil != nil && b.cfg.General.ContentAddressedParts {
+		return fmt.Errorf("general->content_addressed_parts can't be used when remote storage retains objects until %s, reused parts keep retain-until date of first upload", retainUntil.Format(time.RFC3339))
+	}
 
 	remoteBackups, err := b.dst.BackupList(ctx, false, "")
 	if err != nil {
 		return err
 	}
 	for i := range remoteBackups {
-		if backupName == remoteBackups[i].BackupName {
+		// streamed data is already placed on remote storage, openLogicalStream checked that backup didn't exist
+		if backupName == remoteBackups[i].BackupName && b.logicalStream == nil {
 			if !b.resume {
 				return fmt.Errorf("'%s' already exists on remote storage", backupName)
 			} else {
@@ -109,7 +154,12 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		}
 	}
 	if b.resume {
-		b.resumableState = resumable.NewState(b.DefaultDataPath, backupName, "upload")
+		stateCommand := "upload"
+		// each named remote storage shall have own upload state
+		if b.cfg.StorageName != "" && b.cfg.StorageName != config.DefaultStorageName {
+			stateCommand = "upload." + b.cfg.StorageName
+		}
+		b.resumableState = resumable.NewState(b.DefaultDataPath, backupName, stateCommand)
 	}
 
 	compressedDataSize := int64(0)
@@ -138,15 +188,28 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		uploadGroup.Go(func() error {
 			defer uploadSemaphore.Release(1)
 			var uploadedBytes int64
-			if !schemaOnly {
+			streamed, isStreamed := b.logicalStream.get(metadata.TableTitle{Database: tablesForUpload[idx].Database, Table: tablesForUpload[idx].Table})
+			if err := b.setContentPartKeys(backupName, &tablesForUpload[idx], b.cfg.General.ContentAddressedParts && !b.isEmbedded && !schemaOnly && !isStreamed); err != nil {
+				return err
+			}
+			if isStreamed {
+				uploadedBytes = streamed.storedSize
+				atomic.AddInt64(&compressedDataSize, uploadedBytes)
+				tablesForUpload[idx].Checksums = streamed.checksums
+				tablesForUpload[idx].RemoteSizes = streamed.remoteSizes
+			} else if !schemaOnly {
 				var files map[string][]string
+				var checksums map[string]string
+				var remoteSizes map[string]int64
 				var err error
-				files, uploadedBytes, err = b.uploadTableData(uploadCtx, backupName, tablesForUpload[idx])
+				files, checksums, remoteSizes, uploadedBytes, err = b.uploadTableData(uploadCtx, backupName, tablesForUpload[idx])
 				if err != nil {
 					return err
 				}
 				atomic.AddInt64(&compressedDataSize, uploadedBytes)
 				tablesForUpload[idx].Files = files
+				tablesForUpload[idx].Checksums = checksums
+				tablesForUpload[idx].RemoteSizes = remoteSizes
 			}
 			tableMetadataSize, err := b.uploadTableMetadata(uploadCtx, backupName, tablesForUpload[idx])
 			if err != nil {
@@ -167,12 +230,12 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 
 	if !b.isEmbedded {
 		// upload rbac for backup
-		if backupMetadata.RBACSize, err = b.uploadRBACData(ctx, backupName); err != nil {
+		if backupMetadata.RBACSize, backupMetadata.RBACChecksum, err = b.uploadRBACData(ctx, backupName); err != nil {
 			return err
 		}
 
 		// upload configs for backup
-		if backupMetadata.ConfigSize, err = b.uploadConfigData(ctx, backupName); err != nil {
+		if backupMetadata.ConfigSize, backupMetadata.ConfigChecksum, err = b.uploadConfigData(ctx, backupName); err != nil {
 			return err
 		}
 	}
@@ -188,11 +251,17 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		}
 	}
 	backupMetadata.Tables = tt
-	if b.cfg.GetCompressionFormat() != "none" {
+	// streamed parts are stored as plain files like with compression_format: none
+	if b.cfg.GetCompressionFormat() != "none" && b.logicalStream == nil {
 		backupMetadata.DataFormat = b.cfg.GetCompressionFormat()
 	} else {
 		backupMetadata.DataFormat = "directory"
 	}
+	backupMetadata.EncryptionCipher, backupMetadata.EncryptionKeyID = b.dst.Encryption()
+	backupMetadata.RetainUntil, backupMetadata.ObjectHold = b.dst.ObjectLock()
+	if err = b.uploadContentPartsRefs(ctx, backupName, tablesForUpload); err != nil {
+		return err
+	}
 	newBackupMetadataBody, err := json.MarshalIndent(backupMetadata, "", "\t")
 	if err != nil {
 		return err
@@ -209,6 +278,7 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		if b.resume {
 			b.resumableState.AppendToState(remoteBackupMetaFile)
 		}
+		b.dst.AddToRemoteIndex(ctx, *backupMetadata)
 	}
 	if b.isEmbedded {
 		localClickHouseBackupFile := path.Join(b.EmbeddedBackupDataPath, backupName, ".backup")
@@ -226,7 +296,7 @@ func (b *Backuper) Upload(backupName, diffFrom, diffFromRemote, tablePattern str
 		Info("done")
 
 	// Clean
-	if err = b.dst.RemoveOldBackups(ctx, b.cfg.General.BackupsToKeepRemote); err != nil {
+	if err = b.dst.RemoveOldBackups(ctx, storage.NewRetentionPolicy(b.cfg)); err != nil {
 		return fmt.Errorf("can't remove old backups on remote storage: %v", err)
 	}
 	return nil
@@ -342,6 +412,12 @@ func (b *Backuper) validateUploadParams(ctx context.Context, backupName string,
 		_ = b.PrintLocalBackups(ctx, "all")
 		return fmt.Errorf("select backup for upload")
 	}
+	if backupName == storage.ContentPartsPrefix {
+		return fmt.Errorf("'%s' is reserved for content addressed parts and can't be used as backup name", backupName)
+	}
+	if backupName == storage.ClusterManifestsPrefix {
+		return fmt.Errorf("'%s' is reserved for cluster manifests and can't be used as backup name", backupName)
+	}
 	if backupName == diffFrom || backupName == diffFromRemote {
 		return fmt.Errorf("you cannot upload diff from the same backup")
 	}
@@ -363,7 +439,7 @@ func (b *Backuper) validateUploadParams(ctx context.Context, backupName string,
 	return nil
 }
 
-func (b *Backuper) uploadConfigData(ctx context.Context, backupName string) (uint64, error) {
+func (b *Backuper) uploadConfigData(ctx context.Context, backupName string) (uint64, string, error) {
 	configBackupPath := path.Join(b.DefaultDataPath, "backup", backupName, "configs")
 	configFilesGlobPattern := path.Join(configBackupPath, "**/*.*")
 	remoteConfigsArchive := path.Join(backupName, fmt.Sprintf("configs.%s", b.cfg.GetArchiveExtension()))
@@ -371,50 +447,58 @@ func (b *Backuper) uploadConfigData(ctx context.Context, backupName string) (uin
 
 }
 
-func (b *Backuper) uploadRBACData(ctx context.Context, backupName string) (uint64, error) {
+func (b *Backuper) uploadRBACData(ctx context.Context, backupName string) (uint64, string, error) {
 	rbacBackupPath := path.Join(b.DefaultDataPath, "backup", backupName, "access")
 	accessFilesGlobPattern := path.Join(rbacBackupPath, "*.*")
 	remoteRBACArchive := path.Join(backupName, fmt.Sprintf("access.%s", b.cfg.GetArchiveExtension()))
 	return b.uploadAndArchiveBackupRelatedDir(ctx, rbacBackupPath, accessFilesGlobPattern, remoteRBACArchive)
 }
 
-func (b *Backuper) uploadAndArchiveBackupRelatedDir(ctx context.Context, localBackupRelatedDir, localFilesGlobPattern, remoteFile string) (uint64, error) {
+// uploadAndArchiveBackupRelatedDir - upload access or configs archive, return remote size and checksum of archive
+func (b *Backuper) uploadAndArchiveBackupRelatedDir(ctx context.Context, localBackupRelatedDir, localFilesGlobPattern, remoteFile string) (uint64, string, error) {
 	if _, err := os.Stat(localBackupRelatedDir); os.IsNotExist(err) {
-		return 0, nil
+		return 0, "", nil
 	}
 	if b.resume && b.resumableState.IsAlreadyProcessed(remoteFile) {
-		return 0, nil
+		return 0, "", nil
 	}
 	var localFiles []string
 	var err error
 	if localFiles, err = filepathx.Glob(localFilesGlobPattern); err != nil || localFiles == nil || len(localFiles) == 0 {
-		return 0, fmt.Errorf("list %s return list=%v with err=%v", localFilesGlobPattern, localFiles, err)
+		return 0, "", fmt.Errorf("list %s return list=%v with err=%v", localFilesGlobPattern, localFiles, err)
 	}
 	for i := range localFiles {
 		localFiles[i] = strings.Replace(localFiles[i], localBackupRelatedDir, "", 1)
 	}
 
 	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	var checksum string
 	err = retry.RunCtx(ctx, func(ctx context.Context) error {
-		return b.dst.UploadCompressedStream(ctx, localBackupRelatedDir, localFiles, remoteFile)
+		var err error
+		checksum, _, err = b.dst.UploadCompressedStream(ctx, localBackupRelatedDir, localFiles, remoteFile)
+		return err
 	})
 
 	if err != nil {
-		return 0, fmt.Errorf("can't RBAC or config upload: %v", err)
+		return 0, "", fmt.Errorf("can't RBAC or config upload: %v", err)
 	}
 	remoteUploaded, err := b.dst.StatFile(ctx, remoteFile)
 	if err != nil {
-		return 0, fmt.Errorf("can't check uploaded %s file: %v", remoteFile, err)
+		return 0, "", fmt.Errorf("can't check uploaded %s file: %v", remoteFile, err)
 	}
 	if b.resume {
 		b.resumableState.AppendToState(remoteFile)
 	}
-	return uint64(remoteUploaded.Size()), nil
+	return uint64(remoteUploaded.Size()), checksum, nil
 }
 
-func (b *Backuper) uploadTableData(ctx context.Context, backupName string, table metadata.TableMetadata) (map[string][]string, int64, error) {
+// uploadTableData - upload table parts, return uploaded archives, checksums and sizes of uploaded objects relative to table shadow remote path, and uploaded size
+func (b *Backuper) uploadTableData(ctx context.Context, backupName string, table metadata.TableMetadata) (map[string][]string, map[string]string, map[string]int64, int64, error) {
 	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
 	uploadedFiles := map[string][]string{}
+	uploadedChecksums := map[string]string{}
+	uploadedSizes := map[string]int64{}
+	var uploadedChecksumsMutex sync.Mutex
 	capacity := 0
 	for disk := range table.Parts {
 		capacity += len(table.Parts[disk])
@@ -425,6 +509,17 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, table
 	g, ctx := errgroup.WithContext(ctx)
 	var uploadedBytes int64
 
+	contentKeys := map[string]map[string]string{}
+	for disk := range table.Parts {
+		for _, part := range table.Parts[disk] {
+			if part.ContentKey != "" {
+				if _, exists := contentKeys[disk]; !exists {
+					contentKeys[disk] = map[string]string{}
+				}
+				contentKeys[disk][part.Name] = part.ContentKey
+			}
+		}
+	}
 	splitParts := make(map[string][]metadata.SplitPartFiles, 0)
 	splitPartsOffset := make(map[string]int, 0)
 	splitPartsCapacity := 0
@@ -432,7 +527,7 @@ func (b *Backuper) uploadTableData(ctx context.Context, backupName string, table
 		backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
 		splitPartsList, err := b.splitPartFiles(backupPath, table.Parts[disk])
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, nil, 0, err
 		}
 		splitParts[disk] = splitPartsList
 		splitPartsOffset[disk] = 0
@@ -454,19 +549,50 @@ breakByError:
 			partFiles := splitPart.Files
 			splitPartsOffset[disk] += 1
 			baseRemoteDataPath := path.Join(backupName, "shadow", common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
-			if b.cfg.GetCompressionFormat() == "none" {
+			if contentKey, isContentAddressed := contentKeys[disk][partSuffix]; isContentAddressed {
+				checksumPrefix := path.Join(disk, partSuffix)
+				g.Go(func() error {
+					defer s.Release(1)
+					checksums, sizes, partBytes, err := b.uploadContentPart(ctx, path.Join(backupPath, partSuffix), partSuffix, partFiles, contentKey)
+					if err != nil {
+						return fmt.Errorf("can't upload %s: %v", contentKey, err)
+					}
+					uploadedChecksumsMutex.Lock()
+					for fileName, checksum := range checksums {
+						checksumKey := path.Join(checksumPrefix, fileName)
+						if fileName == contentKey {
+							checksumKey = contentKey
+						}
+						uploadedChecksums[checksumKey] = checksum
+						if size, exists := sizes[fileName]; exists {
+							uploadedSizes[checksumKey] = size
+						}
+					}
+					uploadedChecksumsMutex.Unlock()
+					atomic.AddInt64(&uploadedBytes, partBytes)
+					return nil
+				})
+			} else if b.cfg.GetCompressionFormat() == "none" {
 				remotePath := path.Join(baseRemoteDataPath, disk)
 				remotePathFull := path.Join(remotePath, partSuffix)
+				checksumPrefix := disk
 				g.Go(func() error {
 					defer s.Release(1)
 					if b.resume && b.resumableState.IsAlreadyProcessed(remotePathFull) {
 						return nil
 					}
 					log.Debugf("start upload %d files to %s", len(partFiles), remotePath)
-					if err := b.dst.UploadPath(ctx, 0, backupPath, partFiles, remotePath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration); err != nil {
+					checksums, sizes, err := b.dst.UploadPath(ctx, 0, backupPath, partFiles, remotePath, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration)
+					if err != nil {
 						log.Errorf("UploadPath return error: %v", err)
 						return fmt.Errorf("can't upload: %v", err)
 					}
+					uploadedChecksumsMutex.Lock()
+					for fileName, checksum := range checksums {
+						uploadedChecksums[path.Join(checksumPrefix, fileName)] = checksum
+						uploadedSizes[path.Join(checksumPrefix, fileName)] = sizes[fileName]
+					}
+					uploadedChecksumsMutex.Unlock()
 					if b.resume {
 						b.resumableState.AppendToState(remotePathFull)
 					}
@@ -485,13 +611,21 @@ breakByError:
 					}
 					log.Debugf("start upload %d files to %s", len(localFiles), remoteDataFile)
 					retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+					var checksum string
+					var size int64
 					err := retry.RunCtx(ctx, func(ctx context.Context) error {
-						return b.dst.UploadCompressedStream(ctx, backupPath, localFiles, remoteDataFile)
+						var err error
+						checksum, size, err = b.dst.UploadCompressedStream(ctx, backupPath, localFiles, remoteDataFile)
+						return err
 					})
 					if err != nil {
 						log.Errorf("UploadCompressedStream return error: %v", err)
 						return fmt.Errorf("can't upload: %v", err)
 					}
+					uploadedChecksumsMutex.Lock()
+					uploadedChecksums[fileName] = checksum
+					uploadedSizes[fileName] = size
+					uploadedChecksumsMutex.Unlock()
 					remoteFile, err := b.dst.StatFile(ctx, remoteDataFile)
 					if err != nil {
 						return fmt.Errorf("can't check uploaded file: %v", err)
@@ -507,10 +641,155 @@ breakByError:
 		}
 	}
 	if err := g.Wait(); err != nil {
-		return nil, 0, fmt.Errorf("one of uploadTableData go-routine return error: %v", err)
+		return nil, nil, nil, 0, fmt.Errorf("one of uploadTableData go-routine return error: %v", err)
 	}
 	log.Debugf("finish %s.%s with concurrency=%d len(table.Parts[...])=%d uploadedFiles=%v, uploadedBytes=%v", table.Database, table.Table, b.cfg.General.UploadConcurrency, capacity, uploadedFiles, uploadedBytes)
-	return uploadedFiles, uploadedBytes, nil
+	return uploadedFiles, uploadedChecksums, uploadedSizes, uploadedBytes, nil
+}
+
+// setContentPartKeys - calculate key in shared parts store for each part which shall be uploaded, parts without checksums.txt uploaded inside backup as usual
+// keys from local metadata of downloaded backup are always replaced, they could point to other remote storage
+func (b *Backuper) setContentPartKeys(backupName string, table *metadata.TableMetadata, isEnabled bool) error {
+	dbAndTablePath := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	archiveExtension := ""
+	if b.cfg.GetCompressionFormat() != "none" {
+		archiveExtension = b.cfg.GetArchiveExtension()
+	}
+	for disk := range table.Parts {
+		backupPath := b.getLocalBackupDataPathForTable(backupName, disk, dbAndTablePath)
+		for i := range table.Parts[disk] {
+			table.Parts[disk][i].ContentKey = ""
+			if !isEnabled || table.Parts[disk][i].Required {
+				continue
+			}
+			partPath := path.Join(backupPath, table.Parts[disk][i].Name)
+			if _, err := os.Stat(path.Join(partPath, "checksums.txt")); os.IsNotExist(err) {
+				continue
+			}
+			contentKey, err := storage.GetContentPartKey(partPath, archiveExtension)
+			if err != nil {
+				return fmt.Errorf("can't calculate content key for %s: %v", partPath, err)
+			}
+			table.Parts[disk][i].ContentKey = contentKey
+		}
+	}
+	return nil
+}
+
+// uploadContentPart - upload part into shared parts store when it is not uploaded yet by any other backup, return checksums, sizes of stored objects and uploaded size, archive checksum returned with contentKey
+// checksums and sizes of existing part are read from its sidecar, they are empty for parts uploaded by previous versions
+func (b *Backuper) uploadContentPart(ctx context.Context, partPath, partName string, partFiles []string, contentKey string) (map[string]string, map[string]int64, int64, error) {
+	log := b.log.WithField("logger", "uploadContentPart")
+	exists := b.resume && b.resumableState.IsAlreadyProcessed(contentKey)
+	var err error
+	if !exists {
+		if exists, err = b.dst.IsContentPartExists(ctx, contentKey); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	if exists {
+		checksums, sizes, err := b.dst.GetContentPartChecksums(ctx, contentKey)
+		// part was uploaded by backup without encryption, encrypted backup can't reference it, upload it again with encryption
+		if errors.Is(err, storage.ErrUnencrypted) {
+			log.Warnf("%s is not encrypted, upload it again", contentKey)
+		} else if err != nil {
+			return nil, nil, 0, fmt.Errorf("can't read %s%s: %v", contentKey, storage.ContentPartChecksumsSuffix, err)
+		} else {
+			log.Debugf("%s already exists, skip upload %s", contentKey, partPath)
+			if checksums == nil {
+				log.Debugf("%s doesn't have %s sidecar, checksums will not verify", contentKey, storage.ContentPartChecksumsSuffix)
+			}
+			return checksums, sizes, 0, nil
+		}
+	}
+	// files shall be relative to part directory, the same content could have other part name in other backups
+	files := make([]string, 0, len(partFiles))
+	checksumsFile := ""
+	for _, f := range partFiles {
+		f = strings.TrimPrefix(strings.TrimPrefix(f, "/"), partName+"/")
+		if f == "checksums.txt" {
+			checksumsFile = f
+			continue
+		}
+		files = append(files, f)
+	}
+	// directory part is complete only after checksums.txt uploaded
+	if checksumsFile != "" {
+		files = append(files, checksumsFile)
+	}
+	log.Debugf("start upload %d files to %s", len(files), contentKey)
+	uploadedBytes := int64(0)
+	checksums := map[string]string{}
+	sizes := map[string]int64{}
+	if storage.IsContentPartArchive(contentKey) {
+		retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+		err = retry.RunCtx(ctx, func(ctx context.Context) error {
+			checksum, size, err := b.dst.UploadCompressedStream(ctx, partPath, files, contentKey)
+			checksums[contentKey] = checksum
+			sizes[contentKey] = size
+			return err
+		})
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		remoteFile, err := b.dst.StatFile(ctx, contentKey)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("can't check uploaded file: %v", err)
+		}
+		uploadedBytes = remoteFile.Size()
+	} else {
+		if checksums, sizes, err = b.dst.UploadPath(ctx, 0, partPath, files, contentKey, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	err = retry.RunCtx(ctx, func(ctx context.Context) error {
+		return b.dst.PutContentPartChecksums(ctx, contentKey, checksums, sizes)
+	})
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("can't upload %s%s: %v", contentKey, storage.ContentPartChecksumsSuffix, err)
+	}
+	if b.resume {
+		b.resumableState.AppendToState(contentKey)
+	}
+	log.Debugf("finish upload %s", contentKey)
+	return checksums, sizes, uploadedBytes, nil
+}
+
+// uploadContentPartsRefs - save list of content addressed parts used by backup, it is used for reference counting during delete
+func (b *Backuper) uploadContentPartsRefs(ctx context.Context, backupName string, tables ListOfTables) error {
+	contentKeys := common.EmptyMap{}
+	for _, table := range tables {
+		for disk := range table.Parts {
+			for _, part := range table.Parts[disk] {
+				if part.ContentKey != "" {
+					contentKeys[part.ContentKey] = struct{}{}
+				}
+			}
+		}
+	}
+	if len(contentKeys) == 0 {
+		return nil
+	}
+	remoteRefsFile := path.Join(backupName, storage.ContentPartsRefsFile)
+	if b.resume && b.resumableState.IsAlreadyProcessed(remoteRefsFile) {
+		return nil
+	}
+	keys := make([]string, 0, len(contentKeys))
+	for key := range contentKeys {
+		keys = append(keys, key)
+	}
+	retry := retrier.New(retrier.ConstantBackoff(b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration), nil)
+	err := retry.RunCtx(ctx, func(ctx context.Context) error {
+		return b.dst.PutContentPartsRefs(ctx, backupName, keys)
+	})
+	if err != nil {
+		return fmt.Errorf("can't upload %s: %v", remoteRefsFile, err)
+	}
+	if b.resume {
+		b.resumableState.AppendToState(remoteRefsFile)
+	}
+	return nil
 }
 
 func (b *Backuper) uploadTableMetadata(ctx context.Context, backupName string, tableMetadata metadata.TableMetadata) (int64, error) {
@@ -582,6 +861,10 @@ func (b *Backuper) uploadTableMetadataEmbedded(ctx context.Context, backupName s
 
 func (b *Backuper) markDuplicatedParts(backup *metadata.BackupMetadata, existsTable *metadata.TableMetadata, newTable *metadata.TableMetadata, checkLocal bool) {
 	log := b.log.WithField("logger", "markDuplicatedParts")
+	// exported data has the same part name in each backup, but content is always different
+	if newTable.DataFormat != "" {
+		return
+	}
 	for disk, newParts := range newTable.Parts {
 		if _, diskExists := existsTable.Parts[disk]; diskExists {
 			if len(existsTable.Parts[disk]) == 0 {
@@ -0,0 +1,465 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/common"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/filesystemhelper"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/status"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/storage"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// VerifyTableResult - verification report for one table of remote backup
+type VerifyTableResult struct {
+	Backup string   `json:"backup"`
+	Table  string   `json:"table"`
+	Files  int      `json:"files"`
+	Parts  int      `json:"parts"`
+	Size   int64    `json:"size"`
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+	// Skipped - reason why table data is not backed up, table has data_skipped status, it is not treated as verification failure
+	Skipped string `json:"skipped,omitempty"`
+}
+
+func (r *VerifyTableResult) addError(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+	r.Status = "error"
+}
+
+// Verify - check remote backup and all required backups without restore, print report to stdout
+func (b *Backuper) Verify(backupName string, checksums bool, format string, commandId int) error {
+	ctx, cancel, err := status.Current.GetContextWithCancel(commandId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	results, err := b.VerifyRemote(ctx, backupName, checksums)
+	if err != nil {
+		return err
+	}
+	if err = printVerifyResults(os.Stdout, results, format); err != nil {
+		return err
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Status == "error" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("'%s' verification failed, %d of %d tables have errors", backupName, failed, len(results))
+	}
+	return nil
+}
+
+func printVerifyResults(w io.Writer, results []VerifyTableResult, format string) error {
+	switch format {
+	case "json":
+		body, err := json.MarshalIndent(results, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(body))
+		return err
+	case "text", "":
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', tabwriter.DiscardEmptyColumns)
+		for _, r := range results {
+			details := strings.Join(r.Errors, "; ")
+			if r.Skipped != "" {
+				details = r.Skipped
+			}
+			if _, err := fmt.Fprintf(tw, "%s\t%s\t%d files\t%d parts\t%s\t%s\t%s\n", r.Backup, r.Table, r.Files, r.Parts, utils.FormatBytes(uint64(r.Size)), r.Status, details); err != nil {
+				return err
+			}
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("'%s' undefined", format)
+	}
+}
+
+// VerifyRemote - check each table of remote backup and of all backups in RequiredBackup chain
+func (b *Backuper) VerifyRemote(ctx context.Context, backupName string, checksums bool) ([]VerifyTableResult, error) {
+	backupName = utils.CleanBackupNameRE.ReplaceAllString(backupName, "")
+	if backupName == "" {
+		return nil, fmt.Errorf("backup name is required")
+	}
+	if b.cfg.General.RemoteStorage == "none" || b.cfg.General.RemoteStorage == "custom" {
+		return nil, fmt.Errorf("verify is not supported for remote_storage: %s", b.cfg.General.RemoteStorage)
+	}
+	log := b.log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "verify",
+	})
+	start := time.Now()
+	if err := b.ch.Connect(); err != nil {
+		return nil, fmt.Errorf("can't connect to clickhouse: %v", err)
+	}
+	defer b.ch.Close()
+	if err := b.init(ctx, nil); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := b.dst.Close(ctx); err != nil {
+			b.log.Warnf("can't close BackupDestination error: %v", err)
+		}
+	}()
+	tmpDir := ""
+	if checksums {
+		var err error
+		if tmpDir, err = os.MkdirTemp("", "clickhouse-backup-verify-"); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := os.RemoveAll(tmpDir); err != nil {
+				log.Warnf("can't remove %s: %v", tmpDir, err)
+			}
+		}()
+	}
+
+	backup, err := b.ReadBackupMetadataRemote(ctx, backupName)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]VerifyTableResult, 0)
+	tableMetadataCache := &sync.Map{}
+	backupMetadataCache := &sync.Map{}
+	backupMetadataCache.Store(backupName, backup)
+	for backup != nil {
+		backupResults, err := b.verifyBackupTables(ctx, backup, checksums, tmpDir, tableMetadataCache, backupMetadataCache)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, backupResults...)
+		if backup.RequiredBackup == "" {
+			break
+		}
+		if backup, err = b.readBackupMetadataRemote(ctx, backup.RequiredBackup, backupMetadataCache); err != nil {
+			return nil, err
+		}
+	}
+	log.WithField("duration", utils.HumanizeDuration(time.Since(start))).Info("done")
+	return results, nil
+}
+
+// verifyBackupTables - check tables listed in metadata.json of backup, each backup in RequiredBackup chain has own list of tables
+func (b *Backuper) verifyBackupTables(ctx context.Context, backup *metadata.BackupMetadata, checksums bool, tmpDir string, tableMetadataCache, backupMetadataCache *sync.Map) ([]VerifyTableResult, error) {
+	isEmbedded := strings.Contains(backup.Tags, "embedded")
+	results := make([]VerifyTableResult, len(backup.Tables))
+	s := semaphore.NewWeighted(int64(b.cfg.General.DownloadConcurrency))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, t := range backup.Tables {
+		results[i] = VerifyTableResult{
+			Backup: backup.BackupName,
+			Table:  fmt.Sprintf("%s.%s", t.Database, t.Table),
+			Status: "ok",
+		}
+		if err := s.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		idx := i
+		tableTitle := t
+		g.Go(func() error {
+			defer s.Release(1)
+			b.verifyTable(ctx, backup, tableTitle, checksums && !isEmbedded, tmpDir, tableMetadataCache, backupMetadataCache, &results[idx])
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (b *Backuper) verifyTable(ctx context.Context, backup *metadata.BackupMetadata, tableTitle metadata.TableTitle, checksums bool, tmpDir string, tableMetadataCache, backupMetadataCache *sync.Map, result *VerifyTableResult) {
+	table, err := b.readTableMetadataRemote(ctx, backup.BackupName, tableTitle, tableMetadataCache)
+	if err != nil {
+		result.addError("can't read table metadata: %v", err)
+		return
+	}
+	if table.MetadataOnly {
+		return
+	}
+	if table.DataSkipped != "" {
+		result.Status = "data_skipped"
+		result.Skipped = table.DataSkipped
+		return
+	}
+	dbAndTableDir := path.Join(common.TablePathEncode(table.Database), common.TablePathEncode(table.Table))
+	tableRemotePath := path.Join(backup.BackupName, "shadow", dbAndTableDir)
+	tableTmpDir := ""
+	if checksums {
+		tableTmpDir = path.Join(tmpDir, backup.BackupName, dbAndTableDir)
+		defer func() {
+			if err := os.RemoveAll(tableTmpDir); err != nil {
+				b.log.Warnf("can't remove %s: %v", tableTmpDir, err)
+			}
+		}()
+	}
+	for disk, parts := range table.Parts {
+		for _, part := range parts {
+			result.Parts++
+			if part.Required {
+				if err := b.verifyRequiredPart(ctx, backup.RequiredBackup, tableTitle, disk, part.Name, tableMetadataCache, backupMetadataCache); err != nil {
+					result.addError("%s: %v", part.Name, err)
+				}
+				continue
+			}
+			if part.ContentKey != "" {
+				partTmpDir := ""
+				if checksums {
+					partTmpDir = path.Join(tableTmpDir, disk, part.Name)
+				}
+				b.verifyContentPart(ctx, table, disk, part, partTmpDir, result)
+				continue
+			}
+			if backup.DataFormat != "directory" {
+				continue
+			}
+			partRemotePath := path.Join(tableRemotePath, disk, part.Name)
+			files, size, err := b.verifyRemotePath(ctx, partRemotePath, filterRemoteSizesByPrefix(table.RemoteSizes, path.Join(disk, part.Name)))
+			if err != nil {
+				result.addError("%s: %v", partRemotePath, err)
+				continue
+			}
+			result.Files += files
+			result.Size += size
+			if checksums {
+				partTmpDir := path.Join(tableTmpDir, disk, part.Name)
+				if err := b.dst.DownloadPath(ctx, size, partRemotePath, partTmpDir, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, filterChecksumsByPrefix(table.Checksums, path.Join(disk, part.Name))); err != nil {
+					result.addError("can't download %s: %v", partRemotePath, err)
+				} else if err = filesystemhelper.VerifyPartChecksums(partTmpDir); err != nil {
+					result.addError("%s: %v", partRemotePath, err)
+				}
+				if err := os.RemoveAll(partTmpDir); err != nil {
+					b.log.Warnf("can't remove %s: %v", partTmpDir, err)
+				}
+			}
+		}
+	}
+	if backup.DataFormat == "directory" {
+		return
+	}
+	for disk, archives := range table.Files {
+		for _, archive := range archives {
+			remoteFile := path.Join(tableRemotePath, archive)
+			remoteFileInfo, err := b.dst.StatFile(ctx, remoteFile)
+			if err != nil {
+				result.addError("%s: %v", remoteFile, err)
+				continue
+			}
+			if remoteFileInfo.Size() == 0 {
+				result.addError("%s: file is empty", remoteFile)
+				continue
+			}
+			if err = checkRemoteSize(table.RemoteSizes, archive, remoteFileInfo.Size()); err != nil {
+				result.addError("%s: %v", remoteFile, err)
+				continue
+			}
+			result.Files++
+			result.Size += remoteFileInfo.Size()
+			if checksums {
+				archiveTmpDir := path.Join(tableTmpDir, disk, archive)
+				if err := b.dst.DownloadCompressedStream(ctx, remoteFile, archiveTmpDir, table.Checksums[archive]); err != nil {
+					result.addError("can't download %s: %v", remoteFile, err)
+				} else if err = verifyExtractedParts(archiveTmpDir); err != nil {
+					result.addError("%s: %v", remoteFile, err)
+				}
+				if err := os.RemoveAll(archiveTmpDir); err != nil {
+					b.log.Warnf("can't remove %s: %v", archiveTmpDir, err)
+				}
+			}
+		}
+	}
+}
+
+// verifyContentPart - check part in shared parts store, download and validate checksums.txt when partTmpDir is not empty
+func (b *Backuper) verifyContentPart(ctx context.Context, table *metadata.TableMetadata, disk string, part metadata.Part, partTmpDir string, result *VerifyTableResult) {
+	if storage.IsContentPartArchive(part.ContentKey) {
+		remoteFileInfo, err := b.dst.StatFile(ctx, part.ContentKey)
+		if err != nil {
+			result.addError("%s: %v", part.ContentKey, err)
+			return
+		}
+		if err = checkRemoteSize(table.RemoteSizes, part.ContentKey, remoteFileInfo.Size()); err != nil {
+			result.addError("%s: %v", part.ContentKey, err)
+			return
+		}
+		result.Files++
+		result.Size += remoteFileInfo.Size()
+	} else {
+		files, size, err := b.verifyRemotePath(ctx, part.ContentKey, filterRemoteSizesByPrefix(table.RemoteSizes, path.Join(disk, part.Name)))
+		if err != nil {
+			result.addError("%s: %v", part.ContentKey, err)
+			return
+		}
+		result.Files += files
+		result.Size += size
+	}
+	if partTmpDir == "" {
+		return
+	}
+	var err error
+	if storage.IsContentPartArchive(part.ContentKey) {
+		err = b.dst.DownloadCompressedStream(ctx, part.ContentKey, partTmpDir, table.Checksums[part.ContentKey])
+	} else {
+		err = b.dst.DownloadPath(ctx, 0, part.ContentKey, partTmpDir, b.cfg.General.RetriesOnFailure, b.cfg.General.RetriesDuration, filterChecksumsByPrefix(table.Checksums, path.Join(disk, part.Name)))
+	}
+	if err != nil {
+		result.addError("can't download %s: %v", part.ContentKey, err)
+	} else if err = filesystemhelper.VerifyPartChecksums(partTmpDir); err != nil {
+		result.addError("%s: %v", part.ContentKey, err)
+	}
+	if err := os.RemoveAll(partTmpDir); err != nil {
+		b.log.Warnf("can't remove %s: %v", partTmpDir, err)
+	}
+}
+
+// verifyRemotePath - return files count and total size of remote directory, part directory shall contain checksums.txt
+// size of each file is compared with expectedSizes, keys are relative to remotePath
+func (b *Backuper) verifyRemotePath(ctx context.Context, remotePath string, expectedSizes map[string]int64) (int, int64, error) {
+	files := 0
+	size := int64(0)
+	checksumsFound := false
+	err := b.dst.Walk(ctx, remotePath, true, func(ctx context.Context, f storage.RemoteFile) error {
+		if b.dst.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
+			return nil
+		}
+		fileName := strings.Trim(f.Name(), "/")
+		if fileName == "checksums.txt" {
+			checksumsFound = true
+		}
+		if err := checkRemoteSize(expectedSizes, fileName, f.Size()); err != nil {
+			return fmt.Errorf("%s: %v", fileName, err)
+		}
+		files++
+		size += f.Size()
+		return nil
+	})
+	if err != nil {
+		return files, size, err
+	}
+	if files == 0 {
+		return files, size, fmt.Errorf("part is not found on remote storage")
+	}
+	if !checksumsFound {
+		return files, size, fmt.Errorf("checksums.txt is not found on remote storage")
+	}
+	return files, size, nil
+}
+
+// checkRemoteSize - compare size of remote object with size recorded during upload, backups created before sizes were recorded are not checked
+func checkRemoteSize(expectedSizes map[string]int64, key string, size int64) error {
+	if expectedSize, exists := expectedSizes[key]; exists && expectedSize != size {
+		return fmt.Errorf("size mismatch, remote object is corrupted: got %d bytes, expected %d bytes", size, expectedSize)
+	}
+	return nil
+}
+
+func filterRemoteSizesByPrefix(sizes map[string]int64, prefix string) map[string]int64 {
+	filtered := make(map[string]int64)
+	for key, size := range sizes {
+		if strings.HasPrefix(key, prefix+"/") {
+			filtered[strings.TrimPrefix(key, prefix+"/")] = size
+		}
+	}
+	return filtered
+}
+
+func verifyExtractedParts(extractedDir string) error {
+	parts, err := os.ReadDir(extractedDir)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if !part.IsDir() {
+			continue
+		}
+		if err := filesystemhelper.VerifyPartChecksums(path.Join(extractedDir, part.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyRequiredPart - follow RequiredBackup chain until backup which contains part data
+func (b *Backuper) verifyRequiredPart(ctx context.Context, requiredBackupName string, tableTitle metadata.TableTitle, disk, partName string, tableMetadataCache, backupMetadataCache *sync.Map) error {
+	for requiredBackupName != "" {
+		requiredBackup, err := b.readBackupMetadataRemote(ctx, requiredBackupName, backupMetadataCache)
+		if err != nil {
+			return fmt.Errorf("required backup %s: %v", requiredBackupName, err)
+		}
+		requiredTable, err := b.readTableMetadataRemote(ctx, requiredBackupName, tableTitle, tableMetadataCache)
+		if err != nil {
+			return fmt.Errorf("required backup %s: %v", requiredBackupName, err)
+		}
+		found := false
+		for _, part := range requiredTable.Parts[disk] {
+			if part.Name != partName {
+				continue
+			}
+			if !part.Required {
+				return nil
+			}
+			found = true
+			break
+		}
+		if !found {
+			return fmt.Errorf("part is not found in required backup %s", requiredBackupName)
+		}
+		requiredBackupName = requiredBackup.RequiredBackup
+	}
+	return fmt.Errorf("part marked as required, but RequiredBackup chain is ended")
+}
+
+func (b *Backuper) readBackupMetadataRemote(ctx context.Context, backupName string, backupMetadataCache *sync.Map) (*metadata.BackupMetadata, error) {
+	if cached, isCached := backupMetadataCache.Load(backupName); isCached {
+		return cached.(*metadata.BackupMetadata), nil
+	}
+	backup, err := b.ReadBackupMetadataRemote(ctx, backupName)
+	if err != nil {
+		return nil, err
+	}
+	backupMetadataCache.Store(backupName, backup)
+	return backup, nil
+}
+
+func (b *Backuper) readTableMetadataRemote(ctx context.Context, backupName string, tableTitle metadata.TableTitle, tableMetadataCache *sync.Map) (*metadata.TableMetadata, error) {
+	remoteMetadataFile := path.Join(backupName, "metadata", common.TablePathEncode(tableTitle.Database), fmt.Sprintf("%s.json", common.TablePathEncode(tableTitle.Table)))
+	if cached, isCached := tableMetadataCache.Load(remoteMetadataFile); isCached {
+		return cached.(*metadata.TableMetadata), nil
+	}
+	tmReader, err := b.dst.GetFileReader(ctx, remoteMetadataFile)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(tmReader)
+	if err != nil {
+		return nil, err
+	}
+	if err = tmReader.Close(); err != nil {
+		return nil, err
+	}
+	tableMetadata := &metadata.TableMetadata{}
+	if err = json.Unmarshal(body, tableMetadata); err != nil {
+		return nil, err
+	}
+	tableMetadataCache.Store(remoteMetadataFile, tableMetadata)
+	return tableMetadata, nil
+}
@@ -0,0 +1,32 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintVerifyResults(t *testing.T) {
+	results := []VerifyTableResult{
+		{Backup: "b1", Table: "db.t1", Files: 2, Parts: 1, Size: 1024, Status: "ok"},
+		{Backup: "b1", Table: "db.set", Status: "data_skipped", Skipped: "Set engine doesn't support SELECT: code: 48"},
+		{Backup: "b1", Table: "db.t2", Parts: 1, Status: "error", Errors: []string{"part1: not found", "part2: file is empty"}},
+	}
+	out := &bytes.Buffer{}
+	assert.NoError(t, printVerifyResults(out, results, "text"))
+	assert.Equal(t, ""+
+		"b1   db.t1    2 files   1 parts   1.00KiB   ok             \n"+
+		"b1   db.set   0 files   0 parts   0B        data_skipped   Set engine doesn't support SELECT: code: 48\n"+
+		"b1   db.t2    0 files   1 parts   0B        error          part1: not found; part2: file is empty\n",
+		out.String())
+
+	out.Reset()
+	assert.NoError(t, printVerifyResults(out, results, "json"))
+	var parsed []VerifyTableResult
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &parsed))
+	assert.Equal(t, results, parsed)
+
+	assert.Error(t, printVerifyResults(out, results, "yaml"))
+}
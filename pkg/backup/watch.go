@@ -90,8 +90,10 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 	lastFullBackup := time.Now()
 	createRemoteErrCount := 0
 	deleteLocalErrCount := 0
+	tierErrCount := 0
 	var createRemoteErr error
 	var deleteLocalErr error
+	var tierErr error
 	for {
 		select {
 		case <-ctx.Done():
@@ -99,7 +101,15 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 		default:
 			if cliCtx != nil {
 				if cfg, err := config.LoadConfig(config.GetConfigPath(cliCtx)); err == nil {
-					b.cfg = cfg
+					// keep remote storage selected with --storage
+					if b.cfg.StorageName != "" {
+						cfg, err = cfg.GetStorageConfig(b.cfg.StorageName)
+					}
+					if err == nil {
+						b.cfg = cfg
+					} else {
+						b.log.Warnf("watch config.GetStorageConfig error: %v", err)
+					}
 				} else {
 					b.log.Warnf("watch config.LoadConfig error: %v", err)
 				}
@@ -118,14 +128,14 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 			}
 			if metrics != nil {
 				createRemoteErr, createRemoteErrCount = metrics.ExecuteWithMetrics("create_remote", createRemoteErrCount, func() error {
-					return b.CreateToRemote(backupName, "", diffFromRemote, tablePattern, partitions, schemaOnly, rbac, backupConfig, false, version, commandId)
+					return b.CreateToRemote(backupName, "", diffFromRemote, tablePattern, partitions, schemaOnly, rbac, backupConfig, false, false, "", version, commandId)
 				})
 				deleteLocalErr, deleteLocalErrCount = metrics.ExecuteWithMetrics("delete", deleteLocalErrCount, func() error {
 					return b.RemoveBackupLocal(ctx, backupName, nil)
 				})
 
 			} else {
-				createRemoteErr = b.CreateToRemote(backupName, "", diffFromRemote, tablePattern, partitions, schemaOnly, rbac, backupConfig, false, version, commandId)
+				createRemoteErr = b.CreateToRemote(backupName, "", diffFromRemote, tablePattern, partitions, schemaOnly, rbac, backupConfig, false, false, "", version, commandId)
 				if createRemoteErr != nil {
 					log.Errorf("create_remote %s return error: %v", backupName, createRemoteErr)
 					createRemoteErrCount += 1
@@ -142,6 +152,21 @@ func (b *Backuper) Watch(watchInterval, fullInterval, watchBackupNameTemplate, t
 
 			}
 
+			// tier errors don't break backup sequence, aged backups will be moved during next iteration
+			if createRemoteErr == nil && b.cfg.General.TierAfterDuration > 0 {
+				if metrics != nil {
+					tierErr, tierErrCount = metrics.ExecuteWithMetrics("tier", tierErrCount, func() error {
+						_, err := b.ApplyTier(ctx, false, false)
+						return err
+					})
+				} else {
+					_, tierErr = b.ApplyTier(ctx, false, false)
+				}
+				if tierErr != nil {
+					log.Errorf("tier return error: %v", tierErr)
+				}
+			}
+
 			if createRemoteErrCount > b.cfg.General.BackupsToKeepRemote || deleteLocalErrCount > b.cfg.General.BackupsToKeepLocal {
 				return fmt.Errorf("too many errors create_remote: %d, delete local: %d, during watch full_interval: %s, abort watching", createRemoteErrCount, deleteLocalErrCount, b.cfg.General.FullInterval)
 			}
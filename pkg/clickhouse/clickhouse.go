@@ -7,6 +7,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -68,29 +71,9 @@ func (ch *ClickHouse) Connect() error {
 		params.Add("secure", "true")
 		params.Add("skip_verify", strconv.FormatBool(ch.Config.SkipVerify))
 		if ch.Config.TLSKey != "" || ch.Config.TLSCert != "" || ch.Config.TLSCa != "" {
-			tlsConfig := &tls.Config{
-				InsecureSkipVerify: ch.Config.SkipVerify,
-			}
-			if ch.Config.TLSCert != "" || ch.Config.TLSKey != "" {
-				cert, err := tls.LoadX509KeyPair(ch.Config.TLSCert, ch.Config.TLSKey)
-				if err != nil {
-					ch.Log.Errorf("tls.LoadX509KeyPair error: %v", err)
-					return err
-				}
-				tlsConfig.Certificates = []tls.Certificate{cert}
-			}
-			if ch.Config.TLSCa != "" {
-				caCert, err := os.ReadFile(ch.Config.TLSCa)
-				if err != nil {
-					ch.Log.Errorf("read `tls_ca` file %s return error: %v ", ch.Config.TLSCa, err)
-					return err
-				}
-				caCertPool := x509.NewCertPool()
-				if caCertPool.AppendCertsFromPEM(caCert) != true {
-					ch.Log.Errorf("AppendCertsFromPEM %s return false", ch.Config.TLSCa)
-					return fmt.Errorf("AppendCertsFromPEM %s return false", ch.Config.TLSCa)
-				}
-				tlsConfig.RootCAs = caCertPool
+			tlsConfig, err := ch.newTLSConfig()
+			if err != nil {
+				return err
 			}
 			err = clickhouse.RegisterTLSConfig("clickhouse-backup", tlsConfig)
 			if err != nil {
@@ -129,6 +112,73 @@ func (ch *ClickHouse) Connect() error {
 	return err
 }
 
+// newTLSConfig - TLS settings for native and HTTP connections
+func (ch *ClickHouse) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: ch.Config.SkipVerify,
+	}
+	if ch.Config.TLSCert != "" || ch.Config.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(ch.Config.TLSCert, ch.Config.TLSKey)
+		if err != nil {
+			ch.Log.Errorf("tls.LoadX509KeyPair error: %v", err)
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if ch.Config.TLSCa != "" {
+		caCert, err := os.ReadFile(ch.Config.TLSCa)
+		if err != nil {
+			ch.Log.Errorf("read `tls_ca` file %s return error: %v ", ch.Config.TLSCa, err)
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		if caCertPool.AppendCertsFromPEM(caCert) != true {
+			ch.Log.Errorf("AppendCertsFromPEM %s return false", ch.Config.TLSCa)
+			return nil, fmt.Errorf("AppendCertsFromPEM %s return false", ch.Config.TLSCa)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	return tlsConfig, nil
+}
+
+// QueryStream - execute query via HTTP interface and return raw result in FORMAT of query, native protocol of clickhouse-go returns only parsed rows
+// clickhouse-server reports errors which happen after the first block is sent inside response body, so caller shall not trust partial result of canceled query
+func (ch *ClickHouse) QueryStream(ctx context.Context, query string) (io.ReadCloser, error) {
+	client := &http.Client{}
+	scheme := "http"
+	if ch.Config.Secure {
+		scheme = "https"
+		tlsConfig, err := ch.newTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	params := url.Values{}
+	if !ch.Config.LogSQLQueries {
+		params.Add("log_queries", "0")
+	}
+	queryURL := fmt.Sprintf("%s://%s/?%s", scheme, net.JoinHostPort(ch.Config.Host, strconv.Itoa(int(ch.Config.HTTPPort))), params.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, queryURL, strings.NewReader(ch.LogQuery(query)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-ClickHouse-User", ch.Config.Username)
+	req.Header.Set("X-ClickHouse-Key", ch.Config.Password)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if err = resp.Body.Close(); err != nil {
+			ch.Log.Warnf("can't close HTTP response body: %v", err)
+		}
+		return nil, fmt.Errorf("clickhouse HTTP interface return %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.Body, nil
+}
+
 // GetDisks - return data from system.disks table
 func (ch *ClickHouse) GetDisks(ctx context.Context) ([]Disk, error) {
 	version, err := ch.GetVersion(ctx)
@@ -198,6 +248,46 @@ func (ch *ClickHouse) GetDefaultPath(disks []Disk) (string, error) {
 	return defaultPath, nil
 }
 
+// GetUserFilesPath - return user_files_path of clickhouse-server, file() table function can read and write only inside it
+func (ch *ClickHouse) GetUserFilesPath(ctx context.Context, disks []Disk) (string, error) {
+	if ch.Config.UserFilesPath != "" {
+		return ch.Config.UserFilesPath, nil
+	}
+	var rows []string
+	// system.server_settings is available in 23.3+
+	if err := ch.SelectContext(ctx, &rows, "SELECT value FROM system.server_settings WHERE name='user_files_path'"); err == nil && len(rows) > 0 && rows[0] != "" {
+		return rows[0], nil
+	}
+	defaultPath, err := ch.GetDefaultPath(disks)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(defaultPath, "user_files"), nil
+}
+
+// GetInsertableColumns - return columns which could be used in INSERT, MATERIALIZED, ALIAS and EPHEMERAL columns are excluded
+func (ch *ClickHouse) GetInsertableColumns(ctx context.Context, database, table string) ([]Column, error) {
+	columns := make([]Column, 0)
+	query := "SELECT name, type FROM system.columns WHERE database=? AND table=? AND default_kind NOT IN ('MATERIALIZED','ALIAS','EPHEMERAL') ORDER BY position"
+	if err := ch.SelectContext(ctx, &columns, query, database, table); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("can't get columns for `%s`.`%s` from system.columns", database, table)
+	}
+	return columns, nil
+}
+
+// GetPartitionIDs - return partition_id of active parts
+func (ch *ClickHouse) GetPartitionIDs(ctx context.Context, database, table string) ([]string, error) {
+	partitionIDs := make([]string, 0)
+	query := "SELECT DISTINCT partition_id FROM system.parts WHERE active AND database=? AND table=? ORDER BY partition_id"
+	if err := ch.SelectContext(ctx, &partitionIDs, query, database, table); err != nil {
+		return nil, err
+	}
+	return partitionIDs, nil
+}
+
 func (ch *ClickHouse) getDisksFromSystemSettings(ctx context.Context) ([]Disk, error) {
 	select {
 	case <-ctx.Done():
@@ -490,13 +580,8 @@ func (ch *ClickHouse) FreezeTableOldWay(ctx context.Context, table *Table, name
 	return nil
 }
 
-// FreezeTable - freeze all partitions for table
-// This way available for ClickHouse since v19.1
-func (ch *ClickHouse) FreezeTable(ctx context.Context, table *Table, name string) error {
-	version, err := ch.GetVersion(ctx)
-	if err != nil {
-		return err
-	}
+// SyncReplica - execute SYSTEM SYNC REPLICA for Replicated tables when sync_replicated_tables enabled
+func (ch *ClickHouse) SyncReplica(ctx context.Context, table *Table) {
 	if strings.HasPrefix(table.Engine, "Replicated") && ch.Config.SyncReplicatedTables {
 		query := fmt.Sprintf("SYSTEM SYNC REPLICA `%s`.`%s`;", table.Database, table.Name)
 		if _, err := ch.QueryContext(ctx, query); err != nil {
@@ -505,6 +590,21 @@ func (ch *ClickHouse) FreezeTable(ctx context.Context, table *Table, name string
 			ch.Log.WithField("table", fmt.Sprintf("%s.%s", table.Database, table.Name)).Debugf("replica synced")
 		}
 	}
+}
+
+// FreezeTable - freeze all partitions for table
+// This way available for ClickHouse since v19.1
+func (ch *ClickHouse) FreezeTable(ctx context.Context, table *Table, name string) error {
+	ch.SyncReplica(ctx, table)
+	return ch.FreezeTableWithoutSync(ctx, table, name)
+}
+
+// FreezeTableWithoutSync - freeze all partitions for table, replica shall be synced before with SyncReplica when it required
+func (ch *ClickHouse) FreezeTableWithoutSync(ctx context.Context, table *Table, name string) error {
+	version, err := ch.GetVersion(ctx)
+	if err != nil {
+		return err
+	}
 	if version < 19001005 || ch.Config.FreezeByPart {
 		return ch.FreezeTableOldWay(ctx, table, name)
 	}
@@ -851,6 +951,18 @@ func (ch *ClickHouse) IsAtomic(database string) (bool, error) {
 	return len(isDatabaseAtomic) > 0 && isDatabaseAtomic[0] == "Atomic", nil
 }
 
+// GetDatabaseEngine - return engine of database from system.databases, empty string when database doesn't exist
+func (ch *ClickHouse) GetDatabaseEngine(ctx context.Context, database string) (string, error) {
+	var engines []string
+	if err := ch.SelectContext(ctx, &engines, "SELECT engine FROM system.databases WHERE name = ?", database); err != nil {
+		return "", err
+	}
+	if len(engines) == 0 {
+		return "", nil
+	}
+	return engines[0], nil
+}
+
 // GetAccessManagementPath @todo think about how to properly extract access_management_path from /etc/clickhouse-server/
 func (ch *ClickHouse) GetAccessManagementPath(ctx context.Context, disks []Disk) (string, error) {
 	accessPath := "/var/lib/clickhouse/access"
@@ -922,6 +1034,18 @@ func (ch *ClickHouse) CalculateMaxFileSize(ctx context.Context, cfg *config.Conf
 	return 0, nil
 }
 
+// GetClusterReplicas - return all replicas of cluster from system.clusters ordered by shard and replica
+func (ch *ClickHouse) GetClusterReplicas(ctx context.Context, cluster string) ([]ClusterReplica, error) {
+	replicas := make([]ClusterReplica, 0)
+	if err := ch.SelectContext(ctx, &replicas, "SELECT shard_num, replica_num, host_name, is_local FROM system.clusters WHERE cluster=? ORDER BY shard_num, replica_num", cluster); err != nil {
+		return nil, err
+	}
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("cluster '%s' not found in system.clusters", cluster)
+	}
+	return replicas, nil
+}
+
 func (ch *ClickHouse) ApplyMacros(ctx context.Context, s string) (string, error) {
 	macrosExists := make([]int, 0)
 	err := ch.SelectContext(ctx, &macrosExists, "SELECT count() AS is_macros_exists FROM system.tables WHERE database='system' AND name='macros'")
@@ -942,3 +1066,16 @@ func (ch *ClickHouse) ApplyMacros(ctx context.Context, s string) (string, error)
 	s = strings.NewReplacer(replaces...).Replace(s)
 	return s, nil
 }
+
+// GetReplicaPaths - return replica_path for all replicated tables from system.replicas, replica_path is zookeeper_path + '/replicas/' + replica_name
+func (ch *ClickHouse) GetReplicaPaths(ctx context.Context) (map[string]struct{}, error) {
+	replicaPaths := make([]string, 0)
+	if err := ch.SelectContext(ctx, &replicaPaths, "SELECT replica_path FROM system.replicas"); err != nil {
+		return nil, err
+	}
+	result := make(map[string]struct{}, len(replicaPaths))
+	for _, replicaPath := range replicaPaths {
+		result[replicaPath] = struct{}{}
+	}
+	return result, nil
+}
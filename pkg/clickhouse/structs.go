@@ -35,6 +35,12 @@ type Disk struct {
 	IsBackup bool
 }
 
+// Column - Clickhouse system.columns struct
+type Column struct {
+	Name string `db:"name"`
+	Type string `db:"type"`
+}
+
 // Database - Clickhouse system.databases struct
 type Database struct {
 	Name   string `db:"name"`
@@ -48,6 +54,14 @@ type Function struct {
 	CreateQuery string `db:"create_query"`
 }
 
+// ClusterReplica - info from system.clusters
+type ClusterReplica struct {
+	ShardNum   uint32 `db:"shard_num"`
+	ReplicaNum uint32 `db:"replica_num"`
+	HostName   string `db:"host_name"`
+	IsLocal    uint8  `db:"is_local"`
+}
+
 // macro - info from system.macros
 type macro struct {
 	Macro        string `db:"macro"`
@@ -72,3 +72,8 @@ func GetDisksByPaths(disks []Disk, dataPaths []string) map[string]string {
 	}
 	return result
 }
+
+// QuoteString - escape backslashes and single quotes and wrap s into single quotes for use as string literal in SQL query
+func QuoteString(s string) string {
+	return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(s) + "'"
+}
@@ -6,11 +6,12 @@ import (
 	"math"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/apex/log"
-	"github.com/aws/aws-sdk-go/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/urfave/cli"
 	"gopkg.in/yaml.v2"
@@ -18,6 +19,8 @@ import (
 
 const (
 	DefaultConfigPath = "/etc/clickhouse-backup/config.yml"
+	// DefaultStorageName - name of general->remote_storage for --storage parameter
+	DefaultStorageName = "default"
 )
 
 // Config - config file format
@@ -30,8 +33,76 @@ type Config struct {
 	API        APIConfig        `yaml:"api" envconfig:"_"`
 	FTP        FTPConfig        `yaml:"ftp" envconfig:"_"`
 	SFTP       SFTPConfig       `yaml:"sftp" envconfig:"_"`
+	FS         FSConfig         `yaml:"fs" envconfig:"_"`
+	WebDAV     WebDAVConfig     `yaml:"webdav" envconfig:"_"`
+	HDFS       HDFSConfig       `yaml:"hdfs" envconfig:"_"`
 	AzureBlob  AzureBlobConfig  `yaml:"azblob" envconfig:"_"`
 	Custom     CustomConfig     `yaml:"custom" envconfig:"_"`
+	Encryption EncryptionConfig `yaml:"encryption" envconfig:"_"`
+	Bandwidth  BandwidthConfig  `yaml:"bandwidth" envconfig:"_"`
+	Cluster    ClusterConfig    `yaml:"cluster" envconfig:"_"`
+	Retention  RetentionConfig  `yaml:"retention" envconfig:"_"`
+	// RemoteStorages - additional named remote storages, upload replicate backup to each of them
+	RemoteStorages map[string]RemoteStorageConfig `yaml:"remote_storages" ignored:"true"`
+	// StorageName - name of remote storage selected with --storage, empty when not selected
+	StorageName string `yaml:"-" ignored:"true"`
+	// StorageBandwidth - bandwidth limit of named remote storage, applied together with global Bandwidth
+	StorageBandwidth BandwidthConfig `yaml:"-" ignored:"true"`
+}
+
+// BandwidthConfig - upload and download bandwidth limits in bytes per second, 0 means unlimited
+type BandwidthConfig struct {
+	UploadMaxBytesPerSecond   uint64                    `yaml:"upload_max_bytes_per_second" envconfig:"UPLOAD_MAX_BYTES_PER_SECOND"`
+	DownloadMaxBytesPerSecond uint64                    `yaml:"download_max_bytes_per_second" envconfig:"DOWNLOAD_MAX_BYTES_PER_SECOND"`
+	Schedule                  []BandwidthScheduleConfig `yaml:"schedule" ignored:"true"`
+}
+
+// BandwidthScheduleConfig - time of day window in local time, which override bandwidth limits, window could cross midnight
+type BandwidthScheduleConfig struct {
+	Start                     string `yaml:"start"`
+	End                       string `yaml:"end"`
+	UploadMaxBytesPerSecond   uint64 `yaml:"upload_max_bytes_per_second"`
+	DownloadMaxBytesPerSecond uint64 `yaml:"download_max_bytes_per_second"`
+}
+
+// ClusterConfig - create_cluster and restore_cluster settings, commands are sent to REST API of clickhouse-backup server on each host from system.clusters
+type ClusterConfig struct {
+	Name                   string `yaml:"name" envconfig:"CLUSTER_NAME"`
+	APIPort                int    `yaml:"api_port" envconfig:"CLUSTER_API_PORT"`
+	PollInterval           string `yaml:"poll_interval" envconfig:"CLUSTER_POLL_INTERVAL"`
+	Timeout                string `yaml:"timeout" envconfig:"CLUSTER_TIMEOUT"`
+	RequestTimeout         string `yaml:"request_timeout" envconfig:"CLUSTER_REQUEST_TIMEOUT"`
+	TLSCa                  string `yaml:"tls_ca" envconfig:"CLUSTER_TLS_CA"`
+	PollDuration           time.Duration
+	TimeoutDuration        time.Duration
+	RequestTimeoutDuration time.Duration
+}
+
+// RetentionConfig - grandfather-father-son retention of remote backups, keep the newest backup in each of the last N hours, days, weeks, months and years
+// it is applied together with general->backups_to_keep_remote, 0 means period is not used
+type RetentionConfig struct {
+	Hourly  int `yaml:"hourly" envconfig:"RETENTION_HOURLY"`
+	Daily   int `yaml:"daily" envconfig:"RETENTION_DAILY"`
+	Weekly  int `yaml:"weekly" envconfig:"RETENTION_WEEKLY"`
+	Monthly int `yaml:"monthly" envconfig:"RETENTION_MONTHLY"`
+	Yearly  int `yaml:"yearly" envconfig:"RETENTION_YEARLY"`
+}
+
+// RemoteStorageConfig - named remote storage section, storage settings which are not defined inherited from top level sections
+type RemoteStorageConfig struct {
+	RemoteStorage       string          `yaml:"remote_storage"`
+	BackupsToKeepRemote int             `yaml:"backups_to_keep_remote"`
+	S3                  S3Config        `yaml:"s3"`
+	GCS                 GCSConfig       `yaml:"gcs"`
+	COS                 COSConfig       `yaml:"cos"`
+	FTP                 FTPConfig       `yaml:"ftp"`
+	SFTP                SFTPConfig      `yaml:"sftp"`
+	FS                  FSConfig        `yaml:"fs"`
+	WebDAV              WebDAVConfig    `yaml:"webdav"`
+	HDFS                HDFSConfig      `yaml:"hdfs"`
+	AzureBlob           AzureBlobConfig `yaml:"azblob"`
+	Bandwidth           BandwidthConfig `yaml:"bandwidth"`
+	Retention           RetentionConfig `yaml:"retention"`
 }
 
 // GeneralConfig - general setting section
@@ -45,18 +116,33 @@ type GeneralConfig struct {
 	AllowEmptyBackups       bool              `yaml:"allow_empty_backups" envconfig:"ALLOW_EMPTY_BACKUPS"`
 	DownloadConcurrency     uint8             `yaml:"download_concurrency" envconfig:"DOWNLOAD_CONCURRENCY"`
 	UploadConcurrency       uint8             `yaml:"upload_concurrency" envconfig:"UPLOAD_CONCURRENCY"`
+	FreezeConcurrency       uint8             `yaml:"freeze_concurrency" envconfig:"FREEZE_CONCURRENCY"`
+	LogicalBackupFormat     string            `yaml:"logical_backup_format" envconfig:"LOGICAL_BACKUP_FORMAT"`
+	LogicalByPartition      bool              `yaml:"logical_backup_by_partition" envconfig:"LOGICAL_BACKUP_BY_PARTITION"`
 	RestoreSchemaOnCluster  string            `yaml:"restore_schema_on_cluster" envconfig:"RESTORE_SCHEMA_ON_CLUSTER"`
+	RestoreZookeeperPath    string            `yaml:"restore_zookeeper_path" envconfig:"RESTORE_ZOOKEEPER_PATH"`
+	RestoreDropReplica      bool              `yaml:"restore_drop_replica" envconfig:"RESTORE_DROP_REPLICA"`
 	UploadByPart            bool              `yaml:"upload_by_part" envconfig:"UPLOAD_BY_PART"`
 	DownloadByPart          bool              `yaml:"download_by_part" envconfig:"DOWNLOAD_BY_PART"`
+	ContentAddressedParts   bool              `yaml:"content_addressed_parts" envconfig:"CONTENT_ADDRESSED_PARTS"`
+	PartsGracePeriod        string            `yaml:"content_parts_grace_period" envconfig:"CONTENT_PARTS_GRACE_PERIOD"`
 	RestoreDatabaseMapping  map[string]string `yaml:"restore_database_mapping" envconfig:"RESTORE_DATABASE_MAPPING"`
+	RestoreTableMapping     map[string]string `yaml:"restore_table_mapping" envconfig:"RESTORE_TABLE_MAPPING"`
 	RetriesOnFailure        int               `yaml:"retries_on_failure" envconfig:"RETRIES_ON_FAILURE"`
 	RetriesPause            string            `yaml:"upload_retries_pause" envconfig:"RETRIES_PAUSE"`
 	WatchInterval           string            `yaml:"watch_interval" envconfig:"WATCH_INTERVAL"`
 	FullInterval            string            `yaml:"full_interval" envconfig:"FULL_INTERVAL"`
 	WatchBackupNameTemplate string            `yaml:"watch_backup_name_template" envconfig:"WATCH_BACKUP_NAME_TEMPLATE"`
+	RemoteLockTTL           string            `yaml:"remote_lock_ttl" envconfig:"REMOTE_LOCK_TTL"`
+	TierAfter               string            `yaml:"tier_after" envconfig:"TIER_AFTER"`
+	ArchiveRestoreTimeout   string            `yaml:"archive_restore_timeout" envconfig:"ARCHIVE_RESTORE_TIMEOUT"`
 	RetriesDuration         time.Duration
 	WatchDuration           time.Duration
 	FullDuration            time.Duration
+	RemoteLockDuration      time.Duration
+	TierAfterDuration       time.Duration
+	ArchiveRestoreDuration  time.Duration
+	PartsGraceDuration      time.Duration
 }
 
 // GCSConfig - GCS settings section
@@ -71,6 +157,8 @@ type GCSConfig struct {
 	Debug                  bool   `yaml:"debug" envconfig:"GCS_DEBUG"`
 	Endpoint               string `yaml:"endpoint" envconfig:"GCS_ENDPOINT"`
 	StorageClass           string `yaml:"storage_class" envconfig:"GCS_STORAGE_CLASS"`
+	ObjectHold             string `yaml:"object_hold" envconfig:"GCS_OBJECT_HOLD"`
+	TierStorageClass       string `yaml:"tier_storage_class" envconfig:"GCS_TIER_STORAGE_CLASS"`
 }
 
 // AzureBlobConfig - Azure Blob settings section
@@ -90,6 +178,10 @@ type AzureBlobConfig struct {
 	MaxBuffers            int    `yaml:"buffer_count" envconfig:"AZBLOB_MAX_BUFFERS"`
 	MaxPartsCount         int    `yaml:"max_parts_count" envconfig:"AZBLOB_MAX_PARTS_COUNT"`
 	Timeout               string `yaml:"timeout" envconfig:"AZBLOB_TIMEOUT"`
+	ImmutabilityMode      string `yaml:"immutability_policy_mode" envconfig:"AZBLOB_IMMUTABILITY_POLICY_MODE"`
+	ImmutabilityDays      int    `yaml:"immutability_days" envconfig:"AZBLOB_IMMUTABILITY_DAYS"`
+	TierStorageClass      string `yaml:"tier_storage_class" envconfig:"AZBLOB_TIER_STORAGE_CLASS"`
+	RehydratePriority     string `yaml:"rehydrate_priority" envconfig:"AZBLOB_REHYDRATE_PRIORITY"`
 }
 
 // S3Config - s3 settings section
@@ -101,6 +193,8 @@ type S3Config struct {
 	Region                  string `yaml:"region" envconfig:"S3_REGION"`
 	ACL                     string `yaml:"acl" envconfig:"S3_ACL"`
 	AssumeRoleARN           string `yaml:"assume_role_arn" envconfig:"S3_ASSUME_ROLE_ARN"`
+	Profile                 string `yaml:"profile" envconfig:"S3_PROFILE"`
+	WebIdentityTokenFile    string `yaml:"web_identity_token_file" envconfig:"S3_WEB_IDENTITY_TOKEN_FILE"`
 	ForcePathStyle          bool   `yaml:"force_path_style" envconfig:"S3_FORCE_PATH_STYLE"`
 	Path                    string `yaml:"path" envconfig:"S3_PATH"`
 	DisableSSL              bool   `yaml:"disable_ssl" envconfig:"S3_DISABLE_SSL"`
@@ -110,10 +204,16 @@ type S3Config struct {
 	DisableCertVerification bool   `yaml:"disable_cert_verification" envconfig:"S3_DISABLE_CERT_VERIFICATION"`
 	UseCustomStorageClass   bool   `yaml:"use_custom_storage_class" envconfig:"S3_USE_CUSTOM_STORAGE_CLASS"`
 	StorageClass            string `yaml:"storage_class" envconfig:"S3_STORAGE_CLASS"`
+	ChecksumAlgorithm       string `yaml:"checksum_algorithm" envconfig:"S3_CHECKSUM_ALGORITHM"`
 	Concurrency             int    `yaml:"concurrency" envconfig:"S3_CONCURRENCY"`
 	PartSize                int64  `yaml:"part_size" envconfig:"S3_PART_SIZE"`
 	MaxPartsCount           int64  `yaml:"max_parts_count" envconfig:"S3_MAX_PARTS_COUNT"`
 	AllowMultipartDownload  bool   `yaml:"allow_multipart_download" envconfig:"S3_ALLOW_MULTIPART_DOWNLOAD"`
+	ObjectLockMode          string `yaml:"object_lock_mode" envconfig:"S3_OBJECT_LOCK_MODE"`
+	ObjectLockDays          int    `yaml:"object_lock_days" envconfig:"S3_OBJECT_LOCK_DAYS"`
+	TierStorageClass        string `yaml:"tier_storage_class" envconfig:"S3_TIER_STORAGE_CLASS"`
+	RestoreDays             int    `yaml:"restore_days" envconfig:"S3_RESTORE_DAYS"`
+	RestoreTier             string `yaml:"restore_tier" envconfig:"S3_RESTORE_TIER"`
 	Debug                   bool   `yaml:"debug" envconfig:"S3_DEBUG"`
 }
 
@@ -157,6 +257,40 @@ type SFTPConfig struct {
 	Debug             bool   `yaml:"debug" envconfig:"SFTP_DEBUG"`
 }
 
+// FSConfig - local filesystem or mounted network share (NFS, SMB) settings section
+type FSConfig struct {
+	Path              string `yaml:"path" envconfig:"FS_PATH"`
+	CompressionFormat string `yaml:"compression_format" envconfig:"FS_COMPRESSION_FORMAT"`
+	CompressionLevel  int    `yaml:"compression_level" envconfig:"FS_COMPRESSION_LEVEL"`
+	Fsync             bool   `yaml:"fsync" envconfig:"FS_FSYNC"`
+}
+
+// WebDAVConfig - WebDAV settings section
+type WebDAVConfig struct {
+	URL                string `yaml:"url" envconfig:"WEBDAV_URL"`
+	Username           string `yaml:"username" envconfig:"WEBDAV_USERNAME"`
+	Password           string `yaml:"password" envconfig:"WEBDAV_PASSWORD"`
+	AuthType           string `yaml:"auth_type" envconfig:"WEBDAV_AUTH_TYPE"`
+	Path               string `yaml:"path" envconfig:"WEBDAV_PATH"`
+	Timeout            string `yaml:"timeout" envconfig:"WEBDAV_TIMEOUT"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" envconfig:"WEBDAV_INSECURE_SKIP_VERIFY"`
+	CACertFile         string `yaml:"ca_cert_file" envconfig:"WEBDAV_CA_CERT_FILE"`
+	CompressionFormat  string `yaml:"compression_format" envconfig:"WEBDAV_COMPRESSION_FORMAT"`
+	CompressionLevel   int    `yaml:"compression_level" envconfig:"WEBDAV_COMPRESSION_LEVEL"`
+	Debug              bool   `yaml:"debug" envconfig:"WEBDAV_DEBUG"`
+}
+
+// HDFSConfig - HDFS settings section
+type HDFSConfig struct {
+	Address             string `yaml:"address" envconfig:"HDFS_ADDRESS"`
+	User                string `yaml:"user" envconfig:"HDFS_USER"`
+	Path                string `yaml:"path" envconfig:"HDFS_PATH"`
+	UseDatanodeHostname bool   `yaml:"use_datanode_hostname" envconfig:"HDFS_USE_DATANODE_HOSTNAME"`
+	Timeout             string `yaml:"timeout" envconfig:"HDFS_TIMEOUT"`
+	CompressionFormat   string `yaml:"compression_format" envconfig:"HDFS_COMPRESSION_FORMAT"`
+	CompressionLevel    int    `yaml:"compression_level" envconfig:"HDFS_COMPRESSION_LEVEL"`
+}
+
 // CustomConfig - custom CLI storage settings section
 type CustomConfig struct {
 	UploadCommand          string `yaml:"upload_command" envconfig:"CUSTOM_UPLOAD_COMMAND"`
@@ -167,12 +301,24 @@ type CustomConfig struct {
 	CommandTimeoutDuration time.Duration
 }
 
+// EncryptionConfig - client-side encryption settings section
+type EncryptionConfig struct {
+	Cipher      string `yaml:"cipher" envconfig:"ENCRYPTION_CIPHER"`
+	KeyProvider string `yaml:"key_provider" envconfig:"ENCRYPTION_KEY_PROVIDER"`
+	KeyFile     string `yaml:"key_file" envconfig:"ENCRYPTION_KEY_FILE"`
+	Key         string `yaml:"key" envconfig:"ENCRYPTION_KEY"`
+	KeyID       string `yaml:"key_id" envconfig:"ENCRYPTION_KEY_ID"`
+	// AllowUnencrypted - read backups which were uploaded before encryption was configured, their metadata.json is not authenticated
+	AllowUnencrypted bool `yaml:"allow_unencrypted" envconfig:"ENCRYPTION_ALLOW_UNENCRYPTED"`
+}
+
 // ClickHouseConfig - clickhouse settings section
 type ClickHouseConfig struct {
 	Username                         string            `yaml:"username" envconfig:"CLICKHOUSE_USERNAME"`
 	Password                         string            `yaml:"password" envconfig:"CLICKHOUSE_PASSWORD"`
 	Host                             string            `yaml:"host" envconfig:"CLICKHOUSE_HOST"`
 	Port                             uint              `yaml:"port" envconfig:"CLICKHOUSE_PORT"`
+	HTTPPort                         uint              `yaml:"http_port" envconfig:"CLICKHOUSE_HTTP_PORT"`
 	DiskMapping                      map[string]string `yaml:"disk_mapping" envconfig:"CLICKHOUSE_DISK_MAPPING"`
 	SkipTables                       []string          `yaml:"skip_tables" envconfig:"CLICKHOUSE_SKIP_TABLES"`
 	Timeout                          string            `yaml:"timeout" envconfig:"CLICKHOUSE_TIMEOUT"`
@@ -192,6 +338,11 @@ type ClickHouseConfig struct {
 	TLSCert                          string            `yaml:"tls_cert" envconfig:"CLICKHOUSE_TLS_CERT"`
 	TLSCa                            string            `yaml:"tls_ca" envconfig:"CLICKHOUSE_TLS_CA"`
 	Debug                            bool              `yaml:"debug" envconfig:"CLICKHOUSE_DEBUG"`
+	ConsistentStopMerges             bool              `yaml:"consistent_stop_merges" envconfig:"CLICKHOUSE_CONSISTENT_STOP_MERGES"`
+	ConsistentFreezeTimeout          string            `yaml:"consistent_freeze_timeout" envconfig:"CLICKHOUSE_CONSISTENT_FREEZE_TIMEOUT"`
+	ConsistentFreezeConcurrency      int               `yaml:"consistent_freeze_concurrency" envconfig:"CLICKHOUSE_CONSISTENT_FREEZE_CONCURRENCY"`
+	UserFilesPath                    string            `yaml:"user_files_path" envconfig:"CLICKHOUSE_USER_FILES_PATH"`
+	ConsistentFreezeDuration         time.Duration
 }
 
 type APIConfig struct {
@@ -233,6 +384,12 @@ func (cfg *Config) GetArchiveExtension() string {
 		return ArchiveExtensions[cfg.FTP.CompressionFormat]
 	case "sftp":
 		return ArchiveExtensions[cfg.SFTP.CompressionFormat]
+	case "fs":
+		return ArchiveExtensions[cfg.FS.CompressionFormat]
+	case "webdav":
+		return ArchiveExtensions[cfg.WebDAV.CompressionFormat]
+	case "hdfs":
+		return ArchiveExtensions[cfg.HDFS.CompressionFormat]
 	case "azblob":
 		return ArchiveExtensions[cfg.AzureBlob.CompressionFormat]
 	default:
@@ -252,6 +409,12 @@ func (cfg *Config) GetCompressionFormat() string {
 		return cfg.FTP.CompressionFormat
 	case "sftp":
 		return cfg.SFTP.CompressionFormat
+	case "fs":
+		return cfg.FS.CompressionFormat
+	case "webdav":
+		return cfg.WebDAV.CompressionFormat
+	case "hdfs":
+		return cfg.HDFS.CompressionFormat
 	case "azblob":
 		return cfg.AzureBlob.CompressionFormat
 	case "none", "custom":
@@ -277,14 +440,129 @@ func LoadConfig(configLocation string) (*Config, error) {
 	cfg.AzureBlob.Path = strings.TrimPrefix(cfg.AzureBlob.Path, "/")
 	cfg.S3.Path = strings.TrimPrefix(cfg.S3.Path, "/")
 	cfg.GCS.Path = strings.TrimPrefix(cfg.GCS.Path, "/")
+	if err := loadRemoteStorages(cfg, configYaml); err != nil {
+		return nil, err
+	}
 	log.SetLevelFromString(cfg.General.LogLevel)
 	return cfg, ValidateConfig(cfg)
 }
 
+// loadRemoteStorages - parse remote_storages section again over top level storage settings, to inherit everything which is not defined for named storage
+func loadRemoteStorages(cfg *Config, configYaml []byte) error {
+	rawConfig := struct {
+		RemoteStorages map[string]yaml.MapSlice `yaml:"remote_storages"`
+	}{}
+	if err := yaml.Unmarshal(configYaml, &rawConfig); err != nil {
+		return fmt.Errorf("can't parse remote_storages: %v", err)
+	}
+	cfg.RemoteStorages = make(map[string]RemoteStorageConfig, len(rawConfig.RemoteStorages))
+	for name, rawStorage := range rawConfig.RemoteStorages {
+		if name == "" || name == DefaultStorageName {
+			return fmt.Errorf("remote_storages: '%s' is reserved name", name)
+		}
+		storageYaml, err := yaml.Marshal(rawStorage)
+		if err != nil {
+			return fmt.Errorf("can't parse remote_storages->%s: %v", name, err)
+		}
+		storageCfg := RemoteStorageConfig{
+			BackupsToKeepRemote: cfg.General.BackupsToKeepRemote,
+			S3:                  cfg.S3,
+			GCS:                 cfg.GCS,
+			COS:                 cfg.COS,
+			FTP:                 cfg.FTP,
+			SFTP:                cfg.SFTP,
+			FS:                  cfg.FS,
+			WebDAV:              cfg.WebDAV,
+			HDFS:                cfg.HDFS,
+			AzureBlob:           cfg.AzureBlob,
+			Retention:           cfg.Retention,
+		}
+		if err := yaml.Unmarshal(storageYaml, &storageCfg); err != nil {
+			return fmt.Errorf("can't parse remote_storages->%s: %v", name, err)
+		}
+		storageCfg.AzureBlob.Path = strings.TrimPrefix(storageCfg.AzureBlob.Path, "/")
+		storageCfg.S3.Path = strings.TrimPrefix(storageCfg.S3.Path, "/")
+		storageCfg.GCS.Path = strings.TrimPrefix(storageCfg.GCS.Path, "/")
+		cfg.RemoteStorages[name] = storageCfg
+	}
+	return nil
+}
+
+// GetStorageNames - return general->remote_storage as DefaultStorageName when defined, and sorted names from remote_storages section
+func (cfg *Config) GetStorageNames() []string {
+	names := make([]string, 0, len(cfg.RemoteStorages)+1)
+	if cfg.General.RemoteStorage != "none" {
+		names = append(names, DefaultStorageName)
+	}
+	namedStorages := make([]string, 0, len(cfg.RemoteStorages))
+	for name := range cfg.RemoteStorages {
+		namedStorages = append(namedStorages, name)
+	}
+	sort.Strings(namedStorages)
+	return append(names, namedStorages...)
+}
+
+// GetStorageConfig - return copy of config where general->remote_storage and storage sections replaced with named storage, empty name or DefaultStorageName return general->remote_storage
+func (cfg *Config) GetStorageConfig(name string) (*Config, error) {
+	storageCfg := *cfg
+	storageCfg.RemoteStorages = nil
+	storageCfg.StorageName = name
+	if name == "" || name == DefaultStorageName {
+		return &storageCfg, nil
+	}
+	namedStorage, exists := cfg.RemoteStorages[name]
+	if !exists {
+		return nil, fmt.Errorf("'%s' is not found in remote_storages section", name)
+	}
+	storageCfg.General.RemoteStorage = namedStorage.RemoteStorage
+	storageCfg.General.BackupsToKeepRemote = namedStorage.BackupsToKeepRemote
+	storageCfg.S3 = namedStorage.S3
+	storageCfg.GCS = namedStorage.GCS
+	storageCfg.COS = namedStorage.COS
+	storageCfg.FTP = namedStorage.FTP
+	storageCfg.SFTP = namedStorage.SFTP
+	storageCfg.FS = namedStorage.FS
+	storageCfg.WebDAV = namedStorage.WebDAV
+	storageCfg.HDFS = namedStorage.HDFS
+	storageCfg.AzureBlob = namedStorage.AzureBlob
+	storageCfg.StorageBandwidth = namedStorage.Bandwidth
+	storageCfg.Retention = namedStorage.Retention
+	return &storageCfg, nil
+}
+
 func ValidateConfig(cfg *Config) error {
 	if cfg.GetCompressionFormat() == "unknown" {
 		return fmt.Errorf("'%s' is unknown remote storage", cfg.General.RemoteStorage)
 	}
+	if cfg.General.ContentAddressedParts && !cfg.General.UploadByPart {
+		return fmt.Errorf("general->content_addressed_parts require general->upload_by_part: true")
+	}
+	if cfg.General.LogicalBackupFormat != "native" && cfg.General.LogicalBackupFormat != "parquet" {
+		return fmt.Errorf("invalid general->logical_backup_format '%s', expected native or parquet", cfg.General.LogicalBackupFormat)
+	}
+	for _, name := range cfg.GetStorageNames() {
+		if name == DefaultStorageName {
+			continue
+		}
+		if remoteStorage := cfg.RemoteStorages[name].RemoteStorage; remoteStorage == "" || remoteStorage == "none" || remoteStorage == "custom" {
+			return fmt.Errorf("remote_storages->%s->remote_storage '%s' is not supported", name, remoteStorage)
+		}
+		storageCfg, err := cfg.GetStorageConfig(name)
+		if err != nil {
+			return err
+		}
+		if err = ValidateConfig(storageCfg); err != nil {
+			return fmt.Errorf("remote_storages->%s: %v", name, err)
+		}
+	}
+	for _, schedule := range append(cfg.Bandwidth.Schedule, cfg.StorageBandwidth.Schedule...) {
+		if _, err := time.Parse("15:04", schedule.Start); err != nil {
+			return fmt.Errorf("invalid bandwidth schedule start '%s', expected HH:MM: %v", schedule.Start, err)
+		}
+		if _, err := time.Parse("15:04", schedule.End); err != nil {
+			return fmt.Errorf("invalid bandwidth schedule end '%s', expected HH:MM: %v", schedule.End, err)
+		}
+	}
 	if cfg.General.RemoteStorage == "ftp" && (cfg.FTP.Concurrency < cfg.General.DownloadConcurrency || cfg.FTP.Concurrency < cfg.General.UploadConcurrency) {
 		return fmt.Errorf(
 			"FTP_CONCURRENCY=%d should be great or equal than DOWNLOAD_CONCURRENCY=%d and UPLOAD_CONCURRENCY=%d",
@@ -313,6 +591,15 @@ func ValidateConfig(cfg *Config) error {
 	if _, err := time.ParseDuration(cfg.FTP.Timeout); err != nil {
 		return fmt.Errorf("invalid ftp timeout: %v", err)
 	}
+	if _, err := time.ParseDuration(cfg.WebDAV.Timeout); err != nil {
+		return fmt.Errorf("invalid webdav timeout: %v", err)
+	}
+	if _, err := time.ParseDuration(cfg.HDFS.Timeout); err != nil {
+		return fmt.Errorf("invalid hdfs timeout: %v", err)
+	}
+	if cfg.WebDAV.AuthType != "basic" && cfg.WebDAV.AuthType != "digest" {
+		return fmt.Errorf("'%s' is unsupported webdav auth_type, shall be `basic` or `digest`", cfg.WebDAV.AuthType)
+	}
 	if _, err := time.ParseDuration(cfg.AzureBlob.Timeout); err != nil {
 		return fmt.Errorf("invalid azblob timeout: %v", err)
 	}
@@ -320,10 +607,14 @@ func ValidateConfig(cfg *Config) error {
 		return fmt.Errorf("invalid azblob timeout: %v", err)
 	}
 	storageClassOk := false
+	var storageClasses []string
+	for _, storageClass := range s3types.StorageClass("").Values() {
+		storageClasses = append(storageClasses, string(storageClass))
+	}
 	if cfg.S3.UseCustomStorageClass {
 		storageClassOk = true
 	} else {
-		for _, storageClass := range s3.StorageClass_Values() {
+		for _, storageClass := range storageClasses {
 			if strings.ToUpper(cfg.S3.StorageClass) == storageClass {
 				storageClassOk = true
 				break
@@ -332,7 +623,61 @@ func ValidateConfig(cfg *Config) error {
 	}
 	if !storageClassOk {
 		return fmt.Errorf("'%s' is bad S3_STORAGE_CLASS, select one of: %s",
-			cfg.S3.StorageClass, strings.Join(s3.StorageClass_Values(), ", "))
+			cfg.S3.StorageClass, strings.Join(storageClasses, ", "))
+	}
+	if cfg.S3.TierStorageClass != "" && !cfg.S3.UseCustomStorageClass {
+		tierStorageClassOk := false
+		for _, storageClass := range storageClasses {
+			if strings.ToUpper(cfg.S3.TierStorageClass) == storageClass {
+				tierStorageClassOk = true
+				break
+			}
+		}
+		if !tierStorageClassOk {
+			return fmt.Errorf("'%s' is bad S3_TIER_STORAGE_CLASS, select one of: %s",
+				cfg.S3.TierStorageClass, strings.Join(storageClasses, ", "))
+		}
+	}
+	if cfg.S3.ChecksumAlgorithm != "" {
+		checksumAlgorithmOk := false
+		var checksumAlgorithms []string
+		for _, checksumAlgorithm := range s3types.ChecksumAlgorithm("").Values() {
+			checksumAlgorithms = append(checksumAlgorithms, string(checksumAlgorithm))
+			if strings.ToUpper(cfg.S3.ChecksumAlgorithm) == string(checksumAlgorithm) {
+				checksumAlgorithmOk = true
+			}
+		}
+		if !checksumAlgorithmOk {
+			return fmt.Errorf("'%s' is bad S3_CHECKSUM_ALGORITHM, select one of: %s",
+				cfg.S3.ChecksumAlgorithm, strings.Join(checksumAlgorithms, ", "))
+		}
+	}
+	switch strings.ToUpper(cfg.S3.ObjectLockMode) {
+	case "":
+	case "GOVERNANCE", "COMPLIANCE":
+		if cfg.S3.ObjectLockDays < 1 {
+			return fmt.Errorf("s3->object_lock_days must be greater than 0 for `object_lock_mode: %s`", cfg.S3.ObjectLockMode)
+		}
+	default:
+		return fmt.Errorf("'%s' is bad S3_OBJECT_LOCK_MODE, select one of: GOVERNANCE, COMPLIANCE", cfg.S3.ObjectLockMode)
+	}
+	switch cfg.GCS.ObjectHold {
+	case "", "temporary", "event_based":
+	default:
+		return fmt.Errorf("'%s' is bad GCS_OBJECT_HOLD, select one of: temporary, event_based", cfg.GCS.ObjectHold)
+	}
+	switch cfg.AzureBlob.ImmutabilityMode {
+	case "":
+	case "unlocked", "locked":
+		if cfg.AzureBlob.ImmutabilityDays < 1 {
+			return fmt.Errorf("azblob->immutability_days must be greater than 0 for `immutability_policy_mode: %s`", cfg.AzureBlob.ImmutabilityMode)
+		}
+	default:
+		return fmt.Errorf("'%s' is bad AZBLOB_IMMUTABILITY_POLICY_MODE, select one of: unlocked, locked", cfg.AzureBlob.ImmutabilityMode)
+	}
+	// reused content addressed part keeps retain-until date of its first upload, so it becomes deletable earlier than backup which references it
+	if cfg.General.ContentAddressedParts && ((cfg.General.RemoteStorage == "s3" && cfg.S3.ObjectLockMode != "") || (cfg.General.RemoteStorage == "azblob" && cfg.AzureBlob.ImmutabilityMode != "")) {
+		return fmt.Errorf("general->content_addressed_parts can't be used with s3->object_lock_mode or azblob->immutability_policy_mode")
 	}
 	if cfg.API.Secure {
 		if cfg.API.CertificateFile == "" {
@@ -355,6 +700,18 @@ func ValidateConfig(cfg *Config) error {
 	} else {
 		return fmt.Errorf("empty custom command timeout")
 	}
+	switch cfg.Encryption.Cipher {
+	case "", "none":
+	case "aes-256-gcm":
+		if cfg.Encryption.KeyProvider != "file" {
+			return fmt.Errorf("'%s' is unsupported encryption key_provider", cfg.Encryption.KeyProvider)
+		}
+		if cfg.Encryption.KeyFile == "" && cfg.Encryption.Key == "" {
+			return fmt.Errorf("encryption.key_file or encryption.key must be defined for `cipher: %s`", cfg.Encryption.Cipher)
+		}
+	default:
+		return fmt.Errorf("'%s' is unsupported encryption cipher", cfg.Encryption.Cipher)
+	}
 	if cfg.General.RetriesPause != "" {
 		if duration, err := time.ParseDuration(cfg.General.RetriesPause); err != nil {
 			return fmt.Errorf("invalid retries pause: %v", err)
@@ -378,6 +735,62 @@ func ValidateConfig(cfg *Config) error {
 			cfg.General.FullDuration = duration
 		}
 	}
+	if cfg.General.RemoteLockTTL != "" {
+		if duration, err := time.ParseDuration(cfg.General.RemoteLockTTL); err != nil {
+			return fmt.Errorf("invalid remote lock ttl: %v", err)
+		} else {
+			cfg.General.RemoteLockDuration = duration
+		}
+	}
+	if cfg.General.PartsGracePeriod != "" {
+		if duration, err := time.ParseDuration(cfg.General.PartsGracePeriod); err != nil {
+			return fmt.Errorf("invalid content parts grace period: %v", err)
+		} else {
+			cfg.General.PartsGraceDuration = duration
+		}
+	}
+	if cfg.General.TierAfter != "" {
+		if duration, err := time.ParseDuration(cfg.General.TierAfter); err != nil {
+			return fmt.Errorf("invalid tier_after: %v", err)
+		} else {
+			cfg.General.TierAfterDuration = duration
+		}
+	}
+	if cfg.General.ArchiveRestoreTimeout != "" {
+		if duration, err := time.ParseDuration(cfg.General.ArchiveRestoreTimeout); err != nil {
+			return fmt.Errorf("invalid archive_restore_timeout: %v", err)
+		} else {
+			cfg.General.ArchiveRestoreDuration = duration
+		}
+	}
+	if cfg.ClickHouse.ConsistentFreezeTimeout != "" {
+		if duration, err := time.ParseDuration(cfg.ClickHouse.ConsistentFreezeTimeout); err != nil {
+			return fmt.Errorf("invalid clickhouse consistent_freeze_timeout: %v", err)
+		} else {
+			cfg.ClickHouse.ConsistentFreezeDuration = duration
+		}
+	}
+	if cfg.Cluster.PollInterval != "" {
+		if duration, err := time.ParseDuration(cfg.Cluster.PollInterval); err != nil {
+			return fmt.Errorf("invalid cluster poll interval: %v", err)
+		} else {
+			cfg.Cluster.PollDuration = duration
+		}
+	}
+	if cfg.Cluster.Timeout != "" {
+		if duration, err := time.ParseDuration(cfg.Cluster.Timeout); err != nil {
+			return fmt.Errorf("invalid cluster timeout: %v", err)
+		} else {
+			cfg.Cluster.TimeoutDuration = duration
+		}
+	}
+	if cfg.Cluster.RequestTimeout != "" {
+		if duration, err := time.ParseDuration(cfg.Cluster.RequestTimeout); err != nil {
+			return fmt.Errorf("invalid cluster request timeout: %v", err)
+		} else {
+			cfg.Cluster.RequestTimeoutDuration = duration
+		}
+	}
 	return nil
 }
 
@@ -409,6 +822,9 @@ func DefaultConfig() *Config {
 			DisableProgressBar:      true,
 			UploadConcurrency:       availableConcurrency,
 			DownloadConcurrency:     availableConcurrency,
+			FreezeConcurrency:       1,
+			LogicalBackupFormat:     "native",
+			LogicalByPartition:      true,
 			RestoreSchemaOnCluster:  "",
 			UploadByPart:            true,
 			DownloadByPart:          true,
@@ -420,13 +836,21 @@ func DefaultConfig() *Config {
 			FullInterval:            "24h",
 			FullDuration:            24 * time.Hour,
 			WatchBackupNameTemplate: "shard{shard}-{type}-{time:20060102150405}",
+			RemoteLockTTL:           "10m",
+			RemoteLockDuration:      10 * time.Minute,
+			ArchiveRestoreTimeout:   "48h",
+			ArchiveRestoreDuration:  48 * time.Hour,
+			PartsGracePeriod:        "24h",
+			PartsGraceDuration:      24 * time.Hour,
 			RestoreDatabaseMapping:  make(map[string]string, 0),
+			RestoreTableMapping:     make(map[string]string, 0),
 		},
 		ClickHouse: ClickHouseConfig{
 			Username: "default",
 			Password: "",
 			Host:     "localhost",
 			Port:     9000,
+			HTTPPort: 8123,
 			SkipTables: []string{
 				"system.*",
 				"INFORMATION_SCHEMA.*",
@@ -441,6 +865,9 @@ func DefaultConfig() *Config {
 			IgnoreNotExistsErrorDuringFreeze: true,
 			CheckReplicasBeforeAttach:        true,
 			UseEmbeddedBackupRestore:         false,
+			ConsistentFreezeTimeout:          "1m",
+			ConsistentFreezeConcurrency:      16,
+			ConsistentFreezeDuration:         time.Minute,
 		},
 		AzureBlob: AzureBlobConfig{
 			EndpointSchema:    "https",
@@ -451,6 +878,7 @@ func DefaultConfig() *Config {
 			MaxBuffers:        3,
 			MaxPartsCount:     10000,
 			Timeout:           "15m",
+			RehydratePriority: "Standard",
 		},
 		S3: S3Config{
 			Region:                  "us-east-1",
@@ -461,10 +889,12 @@ func DefaultConfig() *Config {
 			CompressionFormat:       "tar",
 			DisableCertVerification: false,
 			UseCustomStorageClass:   false,
-			StorageClass:            s3.StorageClassStandard,
+			StorageClass:            string(s3types.StorageClassStandard),
 			Concurrency:             1,
 			PartSize:                0,
 			MaxPartsCount:           10000,
+			RestoreDays:             1,
+			RestoreTier:             string(s3types.TierStandard),
 		},
 		GCS: GCSConfig{
 			CompressionLevel:  1,
@@ -496,10 +926,39 @@ func DefaultConfig() *Config {
 			CompressionLevel:  1,
 			Concurrency:       1,
 		},
+		FS: FSConfig{
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+			Fsync:             true,
+		},
+		WebDAV: WebDAVConfig{
+			AuthType:          "basic",
+			Timeout:           "5m",
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+		},
+		HDFS: HDFSConfig{
+			Timeout:           "1m",
+			CompressionFormat: "tar",
+			CompressionLevel:  1,
+		},
 		Custom: CustomConfig{
 			CommandTimeout:         "4h",
 			CommandTimeoutDuration: 4 * time.Hour,
 		},
+		Encryption: EncryptionConfig{
+			Cipher:      "none",
+			KeyProvider: "file",
+		},
+		Cluster: ClusterConfig{
+			APIPort:                7171,
+			PollInterval:           "5s",
+			PollDuration:           5 * time.Second,
+			Timeout:                "24h",
+			TimeoutDuration:        24 * time.Hour,
+			RequestTimeout:         "1m",
+			RequestTimeoutDuration: time.Minute,
+		},
 	}
 }
 
@@ -512,6 +971,20 @@ func GetConfigFromCli(ctx *cli.Context) *Config {
 	return cfg
 }
 
+// GetStorageConfigFromCli - load config and select named remote storage from --storage parameter, when --storage is empty and replicate is true keep all remote storages
+func GetStorageConfigFromCli(ctx *cli.Context, replicate bool) *Config {
+	cfg := GetConfigFromCli(ctx)
+	storageName := ctx.String("storage")
+	if storageName == "" && replicate {
+		return cfg
+	}
+	storageCfg, err := cfg.GetStorageConfig(storageName)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return storageCfg
+}
+
 func GetConfigPath(ctx *cli.Context) string {
 	if ctx.String("config") != DefaultConfigPath {
 		return ctx.String("config")
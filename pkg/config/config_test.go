@@ -0,0 +1,66 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamedStorageInheritRetention(t *testing.T) {
+	configFile := path.Join(t.TempDir(), "config.yml")
+	configYaml := `
+general:
+  remote_storage: fs
+  backups_to_keep_remote: 7
+fs:
+  path: /var/backups/default
+retention:
+  daily: 7
+  monthly: 12
+remote_storages:
+  nfs:
+    remote_storage: fs
+    fs:
+      path: /var/backups/nfs
+  archive:
+    remote_storage: fs
+    backups_to_keep_remote: 3
+    fs:
+      path: /var/backups/archive
+    retention:
+      yearly: 5
+`
+	assert.NoError(t, os.WriteFile(configFile, []byte(configYaml), 0640))
+	cfg, err := LoadConfig(configFile)
+	assert.NoError(t, err)
+
+	nfsCfg, err := cfg.GetStorageConfig("nfs")
+	assert.NoError(t, err)
+	assert.Equal(t, RetentionConfig{Daily: 7, Monthly: 12}, nfsCfg.Retention)
+	assert.Equal(t, 7, nfsCfg.General.BackupsToKeepRemote)
+	assert.Equal(t, "/var/backups/nfs", nfsCfg.FS.Path)
+
+	archiveCfg, err := cfg.GetStorageConfig("archive")
+	assert.NoError(t, err)
+	assert.Equal(t, RetentionConfig{Daily: 7, Monthly: 12, Yearly: 5}, archiveCfg.Retention)
+	assert.Equal(t, 3, archiveCfg.General.BackupsToKeepRemote)
+
+	defaultCfg, err := cfg.GetStorageConfig(DefaultStorageName)
+	assert.NoError(t, err)
+	assert.Equal(t, RetentionConfig{Daily: 7, Monthly: 12}, defaultCfg.Retention)
+}
+
+func TestValidateContentAddressedPartsWithObjectLock(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.General.RemoteStorage = "s3"
+	cfg.General.UploadByPart = true
+	cfg.General.ContentAddressedParts = true
+	assert.NoError(t, ValidateConfig(cfg))
+	cfg.S3.ObjectLockMode = "GOVERNANCE"
+	cfg.S3.ObjectLockDays = 7
+	assert.Error(t, ValidateConfig(cfg))
+	cfg.General.ContentAddressedParts = false
+	assert.NoError(t, ValidateConfig(cfg))
+}
@@ -0,0 +1,157 @@
+package filesystemhelper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ClickHouse/ch-go/compress"
+	"github.com/go-faster/city"
+)
+
+// hashingBlockSize - DBMS_DEFAULT_HASHING_BLOCK_SIZE, ClickHouse calculate file hash block by block with this size
+const hashingBlockSize = 2048
+
+// ChecksumsTxtFile - file description from part checksums.txt
+type ChecksumsTxtFile struct {
+	Size uint64
+	Hash city.U128
+}
+
+// ParseChecksumsTxt - read checksums.txt of data part, support binary formats version 3 and 4
+func ParseChecksumsTxt(r io.Reader) (map[string]ChecksumsTxtFile, error) {
+	bufReader := bufio.NewReader(r)
+	versionLine, err := bufReader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("can't read checksums.txt header: %v", err)
+	}
+	var version int
+	if _, err = fmt.Sscanf(versionLine, "checksums format version: %d\n", &version); err != nil {
+		return nil, fmt.Errorf("can't parse checksums.txt header %q: %v", versionLine, err)
+	}
+	switch version {
+	case 3:
+		return parseChecksumsTxtV3(bufReader)
+	case 4:
+		return parseChecksumsTxtV3(bufio.NewReader(compress.NewReader(bufReader)))
+	default:
+		return nil, fmt.Errorf("checksums.txt format version %d is not supported", version)
+	}
+}
+
+func parseChecksumsTxtV3(r *bufio.Reader) (map[string]ChecksumsTxtFile, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]ChecksumsTxtFile, count)
+	for i := uint64(0); i < count; i++ {
+		nameLen, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, nameLen)
+		if _, err = io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		var file ChecksumsTxtFile
+		if file.Size, err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+		if file.Hash, err = readU128(r); err != nil {
+			return nil, err
+		}
+		isCompressed, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if isCompressed != 0 {
+			// uncompressed_size and uncompressed_hash, don't need it
+			if _, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+			if _, err = readU128(r); err != nil {
+				return nil, err
+			}
+		}
+		files[string(name)] = file
+	}
+	return files, nil
+}
+
+func readU128(r io.Reader) (city.U128, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return city.U128{}, err
+	}
+	return city.U128{
+		Low:  binary.LittleEndian.Uint64(buf[:8]),
+		High: binary.LittleEndian.Uint64(buf[8:]),
+	}, nil
+}
+
+// HashFileLikeClickHouse - calculate CityHash128 the same way as ClickHouse HashingWriteBuffer
+func HashFileLikeClickHouse(filePath string) (city.U128, uint64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return city.U128{}, 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var state city.U128
+	var size uint64
+	buf := make([]byte, hashingBlockSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			state = city.CH128Seed(buf[:n], state)
+			size += uint64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return state, size, nil
+		}
+		if err != nil {
+			return city.U128{}, 0, err
+		}
+	}
+}
+
+// VerifyPartChecksums - compare size and hash of each file in part directory with part checksums.txt
+func VerifyPartChecksums(partPath string) error {
+	f, err := os.Open(path.Join(partPath, "checksums.txt"))
+	if err != nil {
+		return err
+	}
+	files, err := ParseChecksumsTxt(f)
+	if closeErr := f.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path.Join(partPath, "checksums.txt"), err)
+	}
+	for name, expected := range files {
+		// projection checksum calculated from projection checksums.txt, it will check separately
+		if strings.HasSuffix(name, ".proj") {
+			if err := VerifyPartChecksums(path.Join(partPath, name)); err != nil {
+				return err
+			}
+			continue
+		}
+		hash, size, err := HashFileLikeClickHouse(path.Join(partPath, name))
+		if err != nil {
+			return err
+		}
+		if size != expected.Size {
+			return fmt.Errorf("%s: size %d, expected %d", path.Join(partPath, name), size, expected.Size)
+		}
+		if hash != expected.Hash {
+			return fmt.Errorf("%s: checksum %s, expected %s", path.Join(partPath, name), compress.FormatU128(hash), compress.FormatU128(expected.Hash))
+		}
+	}
+	return nil
+}
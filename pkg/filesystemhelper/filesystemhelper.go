@@ -36,10 +36,12 @@ func Chown(path string, ch *clickhouse.ClickHouse, disks []clickhouse.Disk, recu
 	chownLock.Lock()
 	if uid == nil {
 		if dataPath, err = ch.GetDefaultPath(disks); err != nil {
+			chownLock.Unlock()
 			return err
 		}
 		info, err := os.Stat(dataPath)
 		if err != nil {
+			chownLock.Unlock()
 			return err
 		}
 		stat := info.Sys().(*syscall.Stat_t)
@@ -208,6 +210,17 @@ func MoveShadow(shadowPath, backupPartsPath string, partitionsBackupMap common.E
 			log.Debugf("'%s' is not a regular file, skipping", filePath)
 			return nil
 		}
+		// checksums.txt is hard link to file written by clickhouse, so mtime is the time when part was created
+		if path.Base(pathParts[3]) == "checksums.txt" {
+			partName := path.Dir(pathParts[3])
+			for i := len(parts) - 1; i >= 0; i-- {
+				if parts[i].Name == partName {
+					modificationTime := info.ModTime()
+					parts[i].ModificationTime = &modificationTime
+					break
+				}
+			}
+		}
 		size += info.Size()
 		return os.Rename(filePath, dstFilePath)
 	})
@@ -4,6 +4,13 @@ import (
 	"time"
 )
 
+const (
+	// DataFormatNative - table data is exported into files in Native format, used for engines which don't support FREEZE and for logical backups
+	DataFormatNative = "native"
+	// DataFormatParquet - table data is exported into files in Parquet format, used for logical backups
+	DataFormatParquet = "parquet"
+)
+
 type TableTitle struct {
 	Database string `json:"database"`
 	Table    string `json:"table"`
@@ -26,6 +33,16 @@ type BackupMetadata struct {
 	Functions               []FunctionsMeta   `json:"functions"`
 	DataFormat              string            `json:"data_format"`
 	RequiredBackup          string            `json:"required_backup,omitempty"`
+	EncryptionCipher        string            `json:"encryption_cipher,omitempty"`
+	EncryptionKeyID         string            `json:"encryption_key_id,omitempty"`
+	RetainUntil             *time.Time        `json:"retain_until,omitempty"`
+	ObjectHold              string            `json:"object_hold,omitempty"`
+	StorageTier             string            `json:"storage_tier,omitempty"`
+	TieredAt                *time.Time        `json:"tiered_at,omitempty"`
+	ConsistentFreeze        bool              `json:"consistent_freeze,omitempty"`
+	FreezeSkewMs            int64             `json:"freeze_skew_ms,omitempty"`
+	RBACChecksum            string            `json:"rbac_checksum,omitempty"`   // xxhash64 of access archive
+	ConfigChecksum          string            `json:"config_checksum,omitempty"` // xxhash64 of configs archive
 }
 
 type DatabasesMeta struct {
@@ -41,6 +58,10 @@ type FunctionsMeta struct {
 
 type TableMetadata struct {
 	Files map[string][]string `json:"files,omitempty"`
+	// Checksums - xxhash64 of each uploaded remote object, key is path relative to table shadow remote path, or ContentKey for archive of content addressed part
+	Checksums map[string]string `json:"checksums,omitempty"`
+	// RemoteSizes - size of each uploaded remote object after compression and encryption, keys are the same as in Checksums
+	RemoteSizes map[string]int64 `json:"remote_sizes,omitempty"`
 	// Disks       map[string]string   `json:"disks"` // "default": "/var/lib/clickhouse"
 	Table       string            `json:"table"`
 	Database    string            `json:"database"`
@@ -54,12 +75,26 @@ type TableMetadata struct {
 	DependenciesTable    string           `json:"dependencies_table,omitempty"`
 	DependenciesDatabase string           `json:"dependencies_database,omitempty"`
 	MetadataOnly         bool             `json:"metadata_only"`
+	FreezeTime           *time.Time       `json:"freeze_time,omitempty"`
+	// DataFormat - empty for frozen parts, DataFormatNative or DataFormatParquet when data exported with SELECT
+	DataFormat string `json:"data_format,omitempty"`
+	// Columns - structure of exported data files, used to map columns when schema of restored table is changed
+	Columns []Column `json:"columns,omitempty"`
+	// DataSkipped - reason why data of table is not backed up, for example Set engine doesn't allow SELECT, restore and verify report such tables
+	DataSkipped string `json:"data_skipped,omitempty"`
+}
+
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
 }
 
 type Part struct {
 	Partition string `json:"partition,omitempty"`
 	Name      string `json:"name"`
 	Required  bool   `json:"required,omitempty"`
+	// ContentKey - remote key of part in shared parts store, empty when part uploaded inside backup
+	ContentKey string `json:"content_key,omitempty"`
 	// Path                              string    `json:"path"`              // TODO: make it relative? look like useless now, can be calculated from Name
 	HashOfAllFiles                    string     `json:"hash_of_all_files,omitempty"` // ???
 	HashOfUncompressedFiles           string     `json:"hash_of_uncompressed_files,omitempty"`
@@ -74,3 +109,20 @@ type SplitPartFiles struct {
 	Prefix string
 	Files  []string
 }
+
+// ClusterBackupMetadata - cluster manifest, ties backups created on each shard by create_cluster
+type ClusterBackupMetadata struct {
+	BackupName   string                `json:"backup_name"`
+	Cluster      string                `json:"cluster"`
+	CreationDate time.Time             `json:"creation_date"`
+	Shards       []ClusterShardBackups `json:"shards"`
+	// Failed - error of create_cluster, finished shard backups of failed cluster backup are deleted by retention with manifest
+	Failed string `json:"failed,omitempty"`
+}
+
+// ClusterShardBackups - backup of one shard, replicated tables are backed up only on Host
+type ClusterShardBackups struct {
+	ShardNum   uint32 `json:"shard_num"`
+	Host       string `json:"host"`
+	BackupName string `json:"backup_name"`
+}
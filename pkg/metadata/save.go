@@ -22,8 +22,10 @@ func (tm *TableMetadata) Save(location string, metadataOnly bool) (uint64, error
 		newp := make([]Part, len(p))
 		for i := range p {
 			newp[i] = Part{
-				Name:     p[i].Name,
-				Required: p[i].Required,
+				Name:             p[i].Name,
+				Required:         p[i].Required,
+				ContentKey:       p[i].ContentKey,
+				ModificationTime: p[i].ModificationTime,
 			}
 		}
 		parts[disk] = newp
@@ -31,6 +33,8 @@ func (tm *TableMetadata) Save(location string, metadataOnly bool) (uint64, error
 
 	if !metadataOnly {
 		newTM.Files = tm.Files
+		newTM.Checksums = tm.Checksums
+		newTM.RemoteSizes = tm.RemoteSizes
 		newTM.Parts = parts
 		newTM.Size = tm.Size
 		newTM.TotalBytes = tm.TotalBytes
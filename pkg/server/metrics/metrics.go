@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"fmt"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/storage"
 	apexLog "github.com/apex/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"time"
@@ -30,6 +31,8 @@ type APIMetrics struct {
 	NumberBackupsLocal          prometheus.Gauge
 	NumberBackupsRemoteExpected prometheus.Gauge
 	NumberBackupsLocalExpected  prometheus.Gauge
+	UploadBytesPerSecond        prometheus.GaugeFunc
+	DownloadBytesPerSecond      prometheus.GaugeFunc
 	log                         *apexLog.Entry
 }
 
@@ -42,7 +45,7 @@ func NewAPIMetrics() *APIMetrics {
 
 // RegisterMetrics resister prometheus metrics and define allowed measured commands list
 func (m *APIMetrics) RegisterMetrics() {
-	commandList := []string{"create", "upload", "download", "restore", "create_remote", "restore_remote", "delete"}
+	commandList := []string{"create", "upload", "download", "restore", "create_remote", "restore_remote", "delete", "copy_remote", "create_cluster", "restore_cluster", "retention", "tier", "verify"}
 	successfulCounter := map[string]prometheus.Counter{}
 	failedCounter := map[string]prometheus.Counter{}
 	lastStart := map[string]prometheus.Gauge{}
@@ -131,6 +134,18 @@ func (m *APIMetrics) RegisterMetrics() {
 		Help:      "How many backups expected on local storage",
 	})
 
+	m.UploadBytesPerSecond = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "upload_bytes_per_second",
+		Help:      "Current upload throughput to remote storage, average for last 10 seconds",
+	}, storage.UploadBytesPerSecond)
+
+	m.DownloadBytesPerSecond = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "clickhouse_backup",
+		Name:      "download_bytes_per_second",
+		Help:      "Current download throughput from remote storage, average for last 10 seconds",
+	}, storage.DownloadBytesPerSecond)
+
 	for _, command := range commandList {
 		prometheus.MustRegister(
 			m.SuccessfulCounter[command],
@@ -149,6 +164,8 @@ func (m *APIMetrics) RegisterMetrics() {
 		m.NumberBackupsLocal,
 		m.NumberBackupsRemoteExpected,
 		m.NumberBackupsLocalExpected,
+		m.UploadBytesPerSecond,
+		m.DownloadBytesPerSecond,
 	)
 
 	for _, command := range commandList {
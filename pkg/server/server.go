@@ -217,10 +217,14 @@ func (api *APIServer) registerHTTPHandlers() *http.Server {
 	r.HandleFunc("/backup/create", api.httpCreateHandler).Methods("POST")
 	r.HandleFunc("/backup/clean", api.httpCleanHandler).Methods("POST")
 	r.HandleFunc("/backup/clean/remote_broken", api.httpCleanRemoteBrokenHandler).Methods("POST")
+	r.HandleFunc("/backup/retention", api.httpRetentionHandler).Methods("POST")
+	r.HandleFunc("/backup/tier", api.httpTierHandler).Methods("POST")
 	r.HandleFunc("/backup/upload/{name}", api.httpUploadHandler).Methods("POST")
 	r.HandleFunc("/backup/download/{name}", api.httpDownloadHandler).Methods("POST")
 	r.HandleFunc("/backup/restore/{name}", api.httpRestoreHandler).Methods("POST")
 	r.HandleFunc("/backup/delete/{where}/{name}", api.httpDeleteHandler).Methods("POST")
+	r.HandleFunc("/backup/verify/{name}", api.httpVerifyHandler).Methods("POST", "GET")
+	r.HandleFunc("/backup/copy_remote/{name}", api.httpCopyRemoteHandler).Methods("POST")
 	r.HandleFunc("/backup/status", api.httpBackupStatusHandler).Methods("GET")
 
 	r.HandleFunc("/backup/actions", api.actionsLog).Methods("GET", "HEAD")
@@ -317,7 +321,7 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		case "clean_remote_broken":
-			actionsResults, err = api.actionsCleanRemoteBrokenHandler(w, row, command, actionsResults)
+			actionsResults, err = api.actionsCleanRemoteBrokenHandler(w, row, command, args, actionsResults)
 			if err != nil {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
 				return
@@ -328,7 +332,7 @@ func (api *APIServer) actions(w http.ResponseWriter, r *http.Request) {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
 				return
 			}
-		case "create", "restore", "upload", "download", "create_remote", "restore_remote":
+		case "create", "restore", "upload", "download", "create_remote", "restore_remote", "copy_remote", "create_cluster", "restore_cluster", "retention", "tier":
 			actionsResults, err = api.actionsAsyncCommandsHandler(command, args, row, actionsResults)
 			if err != nil {
 				api.writeError(w, http.StatusInternalServerError, row.Command, err)
@@ -416,7 +420,7 @@ func (api *APIServer) actionsKillHandler(row status.ActionRow, args []string, ac
 	return actionsResults, nil
 }
 
-func (api *APIServer) actionsCleanRemoteBrokenHandler(w http.ResponseWriter, row status.ActionRow, command string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
+func (api *APIServer) actionsCleanRemoteBrokenHandler(w http.ResponseWriter, row status.ActionRow, command string, args []string, actionsResults []actionsResultsRow) ([]actionsResultsRow, error) {
 	if !api.config.API.AllowParallel && status.Current.InProgress() {
 		api.log.Warn(ErrAPILocked.Error())
 		return actionsResults, ErrAPILocked
@@ -427,8 +431,14 @@ func (api *APIServer) actionsCleanRemoteBrokenHandler(w http.ResponseWriter, row
 		status.Current.Stop(commandId, err)
 		return actionsResults, err
 	}
+	forceUnlock := false
+	for _, arg := range args {
+		if arg == "--force-unlock" {
+			forceUnlock = true
+		}
+	}
 	b := backup.NewBackuper(cfg)
-	err = b.CleanRemoteBroken(commandId)
+	err = b.CleanRemoteBroken(forceUnlock, commandId)
 	if err != nil {
 		api.log.Errorf("Clean remote broken error: %v", err)
 		status.Current.Stop(commandId, err)
@@ -479,13 +489,19 @@ func (api *APIServer) actionsWatchHandler(w http.ResponseWriter, row status.Acti
 					return true, ""
 				}
 			} else {
-				return true, strings.ReplaceAll(strings.SplitN(args[i], "=", 1)[1], "\"", "")
+				return true, strings.ReplaceAll(strings.SplitN(args[i], "=", 2)[1], "\"", "")
 			}
 		}
 		return false, ""
 	}
 	for i := range args {
 		matchParam := false
+		if matchParam, storageName := simpleParseArg(i, args, "--storage"); matchParam {
+			if cfg, err = cfg.GetStorageConfig(storageName); err != nil {
+				return actionsResults, err
+			}
+			fullCommand = fmt.Sprintf("%s --storage=\"%s\"", fullCommand, storageName)
+		}
 		if matchParam, watchInterval = simpleParseArg(i, args, "--watch-interval"); matchParam {
 			fullCommand = fmt.Sprintf("%s --watch-interval=\"%s\"", fullCommand, watchInterval)
 		}
@@ -678,6 +694,13 @@ func (api *APIServer) httpListHandler(w http.ResponseWriter, r *http.Request) {
 	if wherePresent {
 		fullCommand += " " + where
 	}
+	if storageName, exist := r.URL.Query()["storage"]; exist {
+		if cfg, err = cfg.GetStorageConfig(storageName[0]); err != nil {
+			api.writeError(w, http.StatusBadRequest, "list", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --storage=\"%s\"", fullCommand, storageName[0])
+	}
 	commandId, ctx := status.Current.Start(fullCommand)
 	defer status.Current.Stop(commandId, err)
 	if err != nil {
@@ -774,6 +797,8 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 	schemaOnly := false
 	rbacOnly := false
 	configsOnly := false
+	consistent := false
+	backupFormat := ""
 	fullCommand := "create"
 	query := r.URL.Query()
 	if tp, exist := query["table"]; exist {
@@ -802,6 +827,16 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 			fullCommand = fmt.Sprintf("%s --configs", fullCommand)
 		}
 	}
+	if consistentArg, exist := query["consistent"]; exist {
+		consistent, _ = strconv.ParseBool(consistentArg[0])
+		if consistent {
+			fullCommand = fmt.Sprintf("%s --consistent", fullCommand)
+		}
+	}
+	if formatArg, exist := query["format"]; exist {
+		backupFormat = formatArg[0]
+		fullCommand = fmt.Sprintf("%s --format=%s", fullCommand, backupFormat)
+	}
 	if name, exist := query["name"]; exist {
 		backupName = utils.CleanBackupNameRE.ReplaceAllString(name[0], "")
 		fullCommand = fmt.Sprintf("%s %s", fullCommand, backupName)
@@ -811,7 +846,7 @@ func (api *APIServer) httpCreateHandler(w http.ResponseWriter, r *http.Request)
 		commandId, ctx := status.Current.Start(fullCommand)
 		err, _ := api.metrics.ExecuteWithMetrics("create", 0, func() error {
 			b := backup.NewBackuper(cfg)
-			return b.CreateBackup(backupName, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, api.clickhouseBackupVersion, commandId)
+			return b.CreateBackup(backupName, tablePattern, partitionsToBackup, schemaOnly, rbacOnly, configsOnly, consistent, backupFormat, api.clickhouseBackupVersion, commandId)
 		})
 		status.Current.Stop(commandId, err)
 		if err != nil {
@@ -854,6 +889,13 @@ func (api *APIServer) httpWatchHandler(w http.ResponseWriter, r *http.Request) {
 	watchBackupNameTemplate := ""
 	fullCommand := "watch"
 	query := r.URL.Query()
+	if storageName, exist := query["storage"]; exist {
+		if cfg, err = cfg.GetStorageConfig(storageName[0]); err != nil {
+			api.writeError(w, http.StatusBadRequest, "watch", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --storage=\"%s\"", fullCommand, storageName[0])
+	}
 	if interval, exist := query["watch_interval"]; exist {
 		watchInterval = interval[0]
 		fullCommand = fmt.Sprintf("%s --watch-interval=\"%s\"", fullCommand, watchInterval)
@@ -942,16 +984,22 @@ func (api *APIServer) httpCleanHandler(w http.ResponseWriter, _ *http.Request) {
 }
 
 // httpCleanRemoteBrokenHandler - delete all remote backups with `broken` in description
-func (api *APIServer) httpCleanRemoteBrokenHandler(w http.ResponseWriter, _ *http.Request) {
+func (api *APIServer) httpCleanRemoteBrokenHandler(w http.ResponseWriter, r *http.Request) {
 	cfg, err := api.ReloadConfig(w, "clean_remote_broken")
 	if err != nil {
 		return
 	}
-	commandId, ctx := status.Current.Start("clean_remote_broken")
+	fullCommand := "clean_remote_broken"
+	forceUnlock := false
+	if _, exist := r.URL.Query()["force-unlock"]; exist {
+		forceUnlock = true
+		fullCommand += " --force-unlock"
+	}
+	commandId, ctx := status.Current.Start(fullCommand)
 	defer status.Current.Stop(commandId, err)
 
 	b := backup.NewBackuper(cfg)
-	err = b.CleanRemoteBroken(commandId)
+	err = b.CleanRemoteBroken(forceUnlock, commandId)
 	if err != nil {
 		api.log.Errorf("Clean remote broken error: %v", err)
 		api.writeError(w, http.StatusInternalServerError, "clean_remote_broken", err)
@@ -974,6 +1022,105 @@ func (api *APIServer) httpCleanRemoteBrokenHandler(w http.ResponseWriter, _ *htt
 	})
 }
 
+// httpRetentionHandler - apply retention policy to remote backups, return retention decision for each backup
+func (api *APIServer) httpRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.config.API.AllowParallel && status.Current.InProgress() {
+		api.log.Info(ErrAPILocked.Error())
+		api.writeError(w, http.StatusLocked, "retention", ErrAPILocked)
+		return
+	}
+	cfg, err := api.ReloadConfig(w, "retention")
+	if err != nil {
+		return
+	}
+	query := r.URL.Query()
+	dryRun := false
+	forceUnlock := false
+	fullCommand := "retention"
+	if storageName, exist := query["storage"]; exist {
+		if cfg, err = cfg.GetStorageConfig(storageName[0]); err != nil {
+			api.writeError(w, http.StatusBadRequest, "retention", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --storage=\"%s\"", fullCommand, storageName[0])
+	}
+	if _, exist := query["dry-run"]; exist {
+		dryRun = true
+		fullCommand += " --dry-run"
+	}
+	if _, exist := query["force-unlock"]; exist {
+		forceUnlock = true
+		fullCommand += " --force-unlock"
+	}
+	commandId, ctx := status.Current.Start(fullCommand)
+	var results []backup.RetentionResult
+	err, _ = api.metrics.ExecuteWithMetrics("retention", 0, func() error {
+		b := backup.NewBackuper(cfg)
+		results, err = b.ApplyRetention(ctx, dryRun, forceUnlock)
+		return err
+	})
+	status.Current.Stop(commandId, err)
+	if err != nil {
+		api.log.Errorf("retention error: %v", err)
+		api.writeError(w, http.StatusInternalServerError, "retention", err)
+		return
+	}
+	if !dryRun {
+		go func() {
+			if err := api.UpdateBackupMetrics(ctx, false); err != nil {
+				api.log.Errorf("UpdateBackupMetrics return error: %v", err)
+			}
+		}()
+	}
+	api.sendJSONEachRow(w, http.StatusOK, results)
+}
+
+// httpTierHandler - move data of aged remote backups to tier_storage_class, return tier decision for each backup
+func (api *APIServer) httpTierHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.config.API.AllowParallel && status.Current.InProgress() {
+		api.log.Info(ErrAPILocked.Error())
+		api.writeError(w, http.StatusLocked, "tier", ErrAPILocked)
+		return
+	}
+	cfg, err := api.ReloadConfig(w, "tier")
+	if err != nil {
+		return
+	}
+	query := r.URL.Query()
+	dryRun := false
+	forceUnlock := false
+	fullCommand := "tier"
+	if storageName, exist := query["storage"]; exist {
+		if cfg, err = cfg.GetStorageConfig(storageName[0]); err != nil {
+			api.writeError(w, http.StatusBadRequest, "tier", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --storage=\"%s\"", fullCommand, storageName[0])
+	}
+	if _, exist := query["dry-run"]; exist {
+		dryRun = true
+		fullCommand += " --dry-run"
+	}
+	if _, exist := query["force-unlock"]; exist {
+		forceUnlock = true
+		fullCommand += " --force-unlock"
+	}
+	commandId, ctx := status.Current.Start(fullCommand)
+	var results []backup.TierResult
+	err, _ = api.metrics.ExecuteWithMetrics("tier", 0, func() error {
+		b := backup.NewBackuper(cfg)
+		results, err = b.ApplyTier(ctx, dryRun, forceUnlock)
+		return err
+	})
+	status.Current.Stop(commandId, err)
+	if err != nil {
+		api.log.Errorf("tier error: %v", err)
+		api.writeError(w, http.StatusInternalServerError, "tier", err)
+		return
+	}
+	api.sendJSONEachRow(w, http.StatusOK, results)
+}
+
 // httpUploadHandler - upload a backup to remote storage
 func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if !api.config.API.AllowParallel && status.Current.InProgress() {
@@ -994,8 +1141,17 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 	partitionsToBackup := make([]string, 0)
 	schemaOnly := false
 	resumable := false
+	forceUnlock := false
 	fullCommand := "upload"
 
+	if storageName, exist := query["storage"]; exist {
+		if cfg, err = cfg.GetStorageConfig(storageName[0]); err != nil {
+			api.writeError(w, http.StatusBadRequest, "upload", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --storage=\"%s\"", fullCommand, storageName[0])
+	}
+
 	if df, exist := query["diff-from"]; exist {
 		diffFrom = df[0]
 		fullCommand = fmt.Sprintf("%s --diff-from=\"%s\"", fullCommand, diffFrom)
@@ -1020,6 +1176,10 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		resumable = true
 		fullCommand += " --resumable"
 	}
+	if _, exist := query["force-unlock"]; exist {
+		forceUnlock = true
+		fullCommand += " --force-unlock"
+	}
 
 	fullCommand = fmt.Sprint(fullCommand, " ", name)
 
@@ -1027,7 +1187,7 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 		commandId, ctx := status.Current.Start(fullCommand)
 		err, _ := api.metrics.ExecuteWithMetrics("upload", 0, func() error {
 			b := backup.NewBackuper(cfg)
-			return b.Upload(name, diffFrom, diffFromRemote, tablePattern, partitionsToBackup, schemaOnly, resumable, commandId)
+			return b.Upload(name, diffFrom, diffFromRemote, tablePattern, partitionsToBackup, schemaOnly, resumable, forceUnlock, commandId)
 		})
 		status.Current.Stop(commandId, err)
 		if err != nil {
@@ -1056,6 +1216,7 @@ func (api *APIServer) httpUploadHandler(w http.ResponseWriter, r *http.Request)
 }
 
 var databaseMappingRE = regexp.MustCompile(`[\w+]:[\w+]`)
+var tableMappingRE = regexp.MustCompile(`^[^.:]+\.[^:]+:[^.:]+\.[^:]+$`)
 
 // httpRestoreHandler - restore a backup from local storage
 func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request) {
@@ -1071,6 +1232,7 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	tablePattern := ""
 	databaseMappingToRestore := make([]string, 0)
+	tableMappingToRestore := make([]string, 0)
 	partitionsToBackup := make([]string, 0)
 	schemaOnly := false
 	dataOnly := false
@@ -1078,6 +1240,8 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 	ignoreDependencies := false
 	rbacOnly := false
 	configsOnly := false
+	viaInsert := false
+	asOf := ""
 	fullCommand := "restore"
 
 	query := r.URL.Query()
@@ -1100,6 +1264,19 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 
 		fullCommand = fmt.Sprintf("%s --restore-database-mapping=\"%s\"", fullCommand, strings.Join(databaseMappingToRestore, ","))
 	}
+	if tableMappingQuery, exist := query["restore_table_mapping"]; exist {
+		for _, tableMapping := range tableMappingQuery {
+			mappingItems := strings.Split(tableMapping, ",")
+			for _, m := range mappingItems {
+				if !tableMappingRE.MatchString(m) {
+					api.writeError(w, http.StatusInternalServerError, "restore", fmt.Errorf("invalid values in restore_table_mapping %s", m))
+					return
+				}
+			}
+			tableMappingToRestore = append(tableMappingToRestore, mappingItems...)
+		}
+		fullCommand = fmt.Sprintf("%s --restore-table-mapping=\"%s\"", fullCommand, strings.Join(tableMappingToRestore, ","))
+	}
 	if partitions, exist := query["partitions"]; exist {
 		partitionsToBackup = strings.Split(partitions[0], ",")
 		fullCommand = fmt.Sprintf("%s --partitions=\"%s\"", fullCommand, partitions)
@@ -1132,6 +1309,14 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		configsOnly = true
 		fullCommand += " --configs"
 	}
+	if _, exist := query["via_insert"]; exist {
+		viaInsert = true
+		fullCommand += " --via-insert"
+	}
+	if asOfQuery, exist := query["as_of"]; exist {
+		asOf = asOfQuery[0]
+		fullCommand = fmt.Sprintf("%s --as-of=\"%s\"", fullCommand, asOf)
+	}
 
 	name := utils.CleanBackupNameRE.ReplaceAllString(vars["name"], "")
 	fullCommand += fmt.Sprintf(" %s", name)
@@ -1140,7 +1325,7 @@ func (api *APIServer) httpRestoreHandler(w http.ResponseWriter, r *http.Request)
 		commandId, _ := status.Current.Start(fullCommand)
 		err, _ := api.metrics.ExecuteWithMetrics("restore", 0, func() error {
 			b := backup.NewBackuper(api.config)
-			return b.Restore(name, tablePattern, databaseMappingToRestore, partitionsToBackup, schemaOnly, dataOnly, dropTable, ignoreDependencies, rbacOnly, configsOnly, commandId)
+			return b.Restore(name, tablePattern, databaseMappingToRestore, tableMappingToRestore, partitionsToBackup, schemaOnly, dataOnly, dropTable, ignoreDependencies, rbacOnly, configsOnly, viaInsert, asOf, commandId)
 		})
 		status.Current.Stop(commandId, err)
 		if err != nil {
@@ -1180,6 +1365,14 @@ func (api *APIServer) httpDownloadHandler(w http.ResponseWriter, r *http.Request
 	resumable := false
 	fullCommand := "download"
 
+	if storageName, exist := query["storage"]; exist {
+		if cfg, err = cfg.GetStorageConfig(storageName[0]); err != nil {
+			api.writeError(w, http.StatusBadRequest, "download", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --storage=\"%s\"", fullCommand, storageName[0])
+	}
+
 	if tp, exist := query["table"]; exist {
 		tablePattern = tp[0]
 		fullCommand = fmt.Sprintf("%s --tables=\"%s\"", fullCommand, tablePattern)
@@ -1236,14 +1429,27 @@ func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	vars := mux.Vars(r)
-	fullCommand := fmt.Sprintf("delete %s %s", vars["where"], vars["name"])
+	fullCommand := "delete"
+	if storageName, exist := r.URL.Query()["storage"]; exist {
+		if cfg, err = cfg.GetStorageConfig(storageName[0]); err != nil {
+			api.writeError(w, http.StatusBadRequest, "delete", err)
+			return
+		}
+		fullCommand = fmt.Sprintf("%s --storage=\"%s\"", fullCommand, storageName[0])
+	}
+	forceUnlock := false
+	if _, exist := r.URL.Query()["force-unlock"]; exist {
+		forceUnlock = true
+		fullCommand += " --force-unlock"
+	}
+	fullCommand = fmt.Sprintf("%s %s %s", fullCommand, vars["where"], vars["name"])
 	commandId, ctx := status.Current.Start(fullCommand)
 	b := backup.NewBackuper(cfg)
 	switch vars["where"] {
 	case "local":
 		err = b.RemoveBackupLocal(ctx, vars["name"], nil)
 	case "remote":
-		err = b.RemoveBackupRemote(ctx, vars["name"])
+		err = b.RemoveBackupRemote(ctx, vars["name"], forceUnlock)
 	default:
 		err = fmt.Errorf("backup location must be 'local' or 'remote'")
 	}
@@ -1271,6 +1477,130 @@ func (api *APIServer) httpDeleteHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// httpVerifyHandler - verify remote backup without restore in background, tables with errors are logged
+func (api *APIServer) httpVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.config.API.AllowParallel && status.Current.InProgress() {
+		api.log.Info(ErrAPILocked.Error())
+		api.writeError(w, http.StatusLocked, "verify", ErrAPILocked)
+		return
+	}
+	cfg, err := api.ReloadConfig(w, "verify")
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	name := strings.ReplaceAll(vars["name"], "/", "")
+	checksums := false
+	fullCommand := "verify"
+	if _, exist := r.URL.Query()["checksums"]; exist {
+		checksums = true
+		fullCommand += " --checksums"
+	}
+	fullCommand += fmt.Sprintf(" %s", name)
+	go func() {
+		commandId, ctx := status.Current.Start(fullCommand)
+		err, _ := api.metrics.ExecuteWithMetrics("verify", 0, func() error {
+			b := backup.NewBackuper(cfg)
+			results, err := b.VerifyRemote(ctx, name, checksums)
+			if err != nil {
+				return err
+			}
+			failed := 0
+			for _, result := range results {
+				if result.Status == "error" {
+					failed++
+					api.log.Errorf("verify %s table %s: %s", result.Backup, result.Table, strings.Join(result.Errors, "; "))
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("'%s' verification failed, %d of %d tables have errors", name, failed, len(results))
+			}
+			return nil
+		})
+		status.Current.Stop(commandId, err)
+		if err != nil {
+			api.log.Errorf("Verify error: %v", err)
+		}
+	}()
+	api.sendJSONEachRow(w, http.StatusOK, struct {
+		Status     string `json:"status"`
+		Operation  string `json:"operation"`
+		BackupName string `json:"backup_name"`
+	}{
+		Status:     "acknowledged",
+		Operation:  "verify",
+		BackupName: name,
+	})
+}
+
+// httpCopyRemoteHandler - copy remote backup to another path or bucket of the same remote storage
+func (api *APIServer) httpCopyRemoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.config.API.AllowParallel && status.Current.InProgress() {
+		api.log.Info(ErrAPILocked.Error())
+		api.writeError(w, http.StatusLocked, "copy_remote", ErrAPILocked)
+		return
+	}
+	cfg, err := api.ReloadConfig(w, "copy_remote")
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	name := utils.CleanBackupNameRE.ReplaceAllString(vars["name"], "")
+	toPath := ""
+	toBucket := ""
+	withRequired := false
+	fullCommand := "copy_remote"
+	if p, exist := query["to-path"]; exist {
+		toPath = p[0]
+		fullCommand = fmt.Sprintf("%s --to-path=\"%s\"", fullCommand, toPath)
+	}
+	if b, exist := query["to-bucket"]; exist {
+		toBucket = b[0]
+		fullCommand = fmt.Sprintf("%s --to-bucket=\"%s\"", fullCommand, toBucket)
+	}
+	if _, exist := query["with-required"]; exist {
+		withRequired = true
+		fullCommand += " --with-required"
+	}
+	if toPath == "" && toBucket == "" {
+		api.writeError(w, http.StatusBadRequest, "copy_remote", fmt.Errorf("to-path or to-bucket query parameter is required"))
+		return
+	}
+	fullCommand = fmt.Sprint(fullCommand, " ", name)
+
+	go func() {
+		commandId, ctx := status.Current.Start(fullCommand)
+		err, _ := api.metrics.ExecuteWithMetrics("copy_remote", 0, func() error {
+			b := backup.NewBackuper(cfg)
+			return b.CopyRemote(name, toPath, toBucket, withRequired, commandId)
+		})
+		status.Current.Stop(commandId, err)
+		if err != nil {
+			api.log.Errorf("CopyRemote error: %v", err)
+			return
+		}
+		go func() {
+			if err := api.UpdateBackupMetrics(ctx, false); err != nil {
+				api.log.Errorf("UpdateBackupMetrics return error: %v", err)
+			}
+		}()
+	}()
+	api.sendJSONEachRow(w, http.StatusOK, struct {
+		Status     string `json:"status"`
+		Operation  string `json:"operation"`
+		BackupName string `json:"backup_name"`
+		ToPath     string `json:"to_path,omitempty"`
+		ToBucket   string `json:"to_bucket,omitempty"`
+	}{
+		Status:     "acknowledged",
+		Operation:  "copy_remote",
+		BackupName: name,
+		ToPath:     toPath,
+		ToBucket:   toBucket,
+	})
+}
+
 func (api *APIServer) httpBackupStatusHandler(w http.ResponseWriter, _ *http.Request) {
 	api.sendJSONEachRow(w, http.StatusOK, status.Current.GetStatus(true, "", 0))
 }
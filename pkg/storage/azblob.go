@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
@@ -19,14 +20,16 @@ import (
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
+	apexLog "github.com/apex/log"
 	"github.com/pkg/errors"
 )
 
 // AzureBlob - presents methods for manipulate data on Azure
 type AzureBlob struct {
-	Container azblob.ContainerURL
-	CPK       azblob.ClientProvidedKeyOptions
-	Config    *config.AzureBlobConfig
+	Container   azblob.ContainerURL
+	CPK         azblob.ClientProvidedKeyOptions
+	Config      *config.AzureBlobConfig
+	retainUntil *time.Time
 }
 
 func (s *AzureBlob) Kind() string {
@@ -141,10 +144,26 @@ func (s *AzureBlob) Connect(ctx context.Context) error {
 			b64sha := base64.StdEncoding.EncodeToString(shakey[:])
 			s.CPK = azblob.NewClientProvidedKeyOptions(&b64key, &b64sha, nil)
 		}
+		if s.Config.ImmutabilityMode != "" {
+			retainUntil := time.Now().UTC().AddDate(0, 0, s.Config.ImmutabilityDays)
+			s.retainUntil = &retainUntil
+		}
 		return nil
 	}
 }
 
+// ObjectLock - retain-until date of blobs uploaded with version-level immutability policy
+func (s *AzureBlob) ObjectLock() (*time.Time, string) {
+	return s.retainUntil, ""
+}
+
+func (s *AzureBlob) immutabilityPolicy(key string) azblob.ImmutabilityPolicyOptions {
+	if s.retainUntil == nil || !isObjectLockRequired(key) {
+		return azblob.ImmutabilityPolicyOptions{}
+	}
+	return azblob.NewImmutabilityPolicyOptions(s.retainUntil, azblob.BlobImmutabilityPolicyModeType(s.Config.ImmutabilityMode), nil)
+}
+
 func (s *AzureBlob) Close(ctx context.Context) error {
 	return nil
 }
@@ -166,16 +185,133 @@ func (s *AzureBlob) PutFile(ctx context.Context, key string, r io.ReadCloser) er
 	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
 	bufferSize := s.Config.BufferSize // Configure the size of the rotating buffers that are used when uploading
 	maxBuffers := s.Config.MaxBuffers // Configure the number of rotating buffers that are used when uploading
-	_, err := x.UploadStreamToBlockBlob(ctx, r, blob, azblob.UploadStreamToBlockBlobOptions{BufferSize: bufferSize, MaxBuffers: maxBuffers}, s.CPK)
+	_, err := x.UploadStreamToBlockBlob(ctx, r, blob, azblob.UploadStreamToBlockBlobOptions{BufferSize: bufferSize, MaxBuffers: maxBuffers, ImmutabilityPolicyOptions: s.immutabilityPolicy(key)}, s.CPK)
 	return err
 }
 
+// GetFileVersioned - read small blob and return its ETag for PutFileIfVersion
+func (s *AzureBlob) GetFileVersioned(ctx context.Context, key string) ([]byte, string, error) {
+	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
+	r, err := blob.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, s.CPK)
+	if err != nil {
+		if se, ok := err.(azblob.StorageError); ok && se.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+	reader := r.Body(azblob.RetryReaderOptions{})
+	body, err := io.ReadAll(reader)
+	if closeErr := reader.Close(); closeErr != nil {
+		apexLog.Warnf("can't close Download body for %s: %v", key, closeErr)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return body, string(r.ETag()), nil
+}
+
+// PutFileIfVersion - upload blob with If-None-Match: * for new blob and If-Match: ETag for existing blob
+func (s *AzureBlob) PutFileIfVersion(ctx context.Context, key string, body []byte, version string) error {
+	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
+	conditions := azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny}
+	if version != "" {
+		conditions = azblob.ModifiedAccessConditions{IfMatch: azblob.ETag(version)}
+	}
+	_, err := blob.Upload(ctx, bytes.NewReader(body), azblob.BlobHTTPHeaders{}, azblob.Metadata{}, azblob.BlobAccessConditions{ModifiedAccessConditions: conditions}, azblob.DefaultAccessTier, nil, s.CPK, azblob.ImmutabilityPolicyOptions{})
+	if err != nil {
+		if se, ok := err.(azblob.StorageError); ok && (se.ServiceCode() == azblob.ServiceCodeConditionNotMet || se.ServiceCode() == azblob.ServiceCodeBlobAlreadyExists) {
+			return ErrPreconditionFailed
+		}
+		return err
+	}
+	return nil
+}
+
 func (s *AzureBlob) DeleteFile(ctx context.Context, key string) error {
 	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
 	_, err := blob.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
 	return err
 }
 
+// TierStorageClass - access tier for backups older than general->tier_after
+func (s *AzureBlob) TierStorageClass() string {
+	return s.Config.TierStorageClass
+}
+
+// TierObject - change access tier of existing blob, it doesn't require copy
+func (s *AzureBlob) TierObject(ctx context.Context, key string, storageClass string) error {
+	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
+	_, err := blob.SetTier(ctx, azblob.AccessTierType(storageClass), azblob.LeaseAccessConditions{}, azblob.RehydratePriorityNone)
+	return err
+}
+
+// RestoreObject - rehydrate blob from Archive to Hot access tier with azblob->rehydrate_priority, rehydrated blob stays in Hot tier
+func (s *AzureBlob) RestoreObject(ctx context.Context, key string) (bool, error) {
+	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
+	props, err := blob.GetProperties(ctx, azblob.BlobAccessConditions{}, s.CPK)
+	if err != nil {
+		return false, err
+	}
+	if props.AccessTier() != string(azblob.AccessTierArchive) {
+		return true, nil
+	}
+	// rehydrate-pending-to-hot or rehydrate-pending-to-cool
+	if props.ArchiveStatus() != "" {
+		return false, nil
+	}
+	_, err = blob.SetTier(ctx, azblob.AccessTierHot, azblob.LeaseAccessConditions{}, azblob.RehydratePriorityType(s.Config.RehydratePriority))
+	return false, err
+}
+
+// CopyObject - server-side copy to another container or path of the same storage account, wait until asynchronous copy finished
+func (s *AzureBlob) CopyObject(ctx context.Context, key string, dst RemoteStorage) error {
+	dstAzure, ok := dst.(*AzureBlob)
+	if !ok {
+		return fmt.Errorf("can't copy %s from azblob to %s", key, dst.Kind())
+	}
+	// copy blob with customer-provided key is not supported by Azure, stream it through the host
+	if s.Config.SSEKey != "" || dstAzure.Config.SSEKey != "" {
+		r, err := s.GetFileReader(ctx, key)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := r.Close(); err != nil {
+				apexLog.Warnf("can't close GetFileReader descriptor %v: %v", r, err)
+			}
+		}()
+		return dstAzure.PutFile(ctx, key, r)
+	}
+	srcBlob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
+	dstBlob := dstAzure.Container.NewBlockBlobURL(path.Join(dstAzure.Config.Path, key))
+	resp, err := dstBlob.StartCopyFromURL(ctx, srcBlob.URL(), azblob.Metadata{}, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{}, azblob.DefaultAccessTier, nil)
+	if err != nil {
+		return err
+	}
+	copyStatus := resp.CopyStatus()
+	for copyStatus == azblob.CopyStatusPending {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+		props, err := dstBlob.GetProperties(ctx, azblob.BlobAccessConditions{}, dstAzure.CPK)
+		if err != nil {
+			return err
+		}
+		copyStatus = props.CopyStatus()
+	}
+	if copyStatus != azblob.CopyStatusSuccess {
+		return fmt.Errorf("copy %s finished with status %s", key, copyStatus)
+	}
+	if policy := dstAzure.immutabilityPolicy(key); policy.ImmutabilityPolicyUntilDate != nil {
+		if _, err = dstBlob.SetImmutabilityPolicy(ctx, *policy.ImmutabilityPolicyUntilDate, policy.ImmutabilityPolicyMode, nil); err != nil {
+			return fmt.Errorf("can't set immutability policy for %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
 func (s *AzureBlob) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 	blob := s.Container.NewBlockBlobURL(path.Join(s.Config.Path, key))
 	r, err := blob.GetProperties(ctx, azblob.BlobAccessConditions{}, s.CPK)
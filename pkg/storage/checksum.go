@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// ChecksumMismatchError - downloaded content of remote object doesn't match checksum stored in table metadata
+type ChecksumMismatchError struct {
+	RemoteKey string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for remote key %s: got xxhash64=%s, expected xxhash64=%s", e.RemoteKey, e.Actual, e.Expected)
+}
+
+func newChecksumHash() hash.Hash64 {
+	return xxhash.New()
+}
+
+func formatChecksum(h hash.Hash64) string {
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+type checksumReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// sizeReadCloser - count bytes which remote storage read from stream, it is size of stored object
+type sizeReadCloser struct {
+	io.ReadCloser
+	size int64
+}
+
+func (r *sizeReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += int64(n)
+	return n, err
+}
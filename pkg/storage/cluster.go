@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+)
+
+// ClusterManifestsPrefix - prefix for cluster manifests created by create_cluster, can't be used as backup name
+const ClusterManifestsPrefix = "clusters"
+
+func clusterManifestKey(backupName string) string {
+	return path.Join(ClusterManifestsPrefix, backupName+".json")
+}
+
+// PutClusterManifest - upload cluster manifest, shall be uploaded after all shard backups
+func (bd *BackupDestination) PutClusterManifest(ctx context.Context, manifest metadata.ClusterBackupMetadata) error {
+	body, err := json.MarshalIndent(&manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return bd.PutFile(ctx, clusterManifestKey(manifest.BackupName), io.NopCloser(bytes.NewReader(body)))
+}
+
+// GetClusterManifest - read cluster manifest created by create_cluster
+func (bd *BackupDestination) GetClusterManifest(ctx context.Context, backupName string) (*metadata.ClusterBackupMetadata, error) {
+	key := clusterManifestKey(backupName)
+	if _, err := bd.StatFile(ctx, key); err != nil {
+		if err == ErrNotFound {
+			return nil, fmt.Errorf("cluster backup '%s' not found on remote storage", backupName)
+		}
+		return nil, err
+	}
+	r, err := bd.GetFileReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(r)
+	if closeErr := r.Close(); closeErr != nil {
+		bd.Log.Warnf("can't close GetFileReader descriptor %v: %v", r, closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := metadata.ClusterBackupMetadata{}
+	if err = json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", key, err)
+	}
+	return &manifest, nil
+}
+
+// GetClusterManifests - read all cluster manifests, retention applies policy to them instead of shard backups
+func (bd *BackupDestination) GetClusterManifests(ctx context.Context) ([]metadata.ClusterBackupMetadata, error) {
+	backupNames := make([]string, 0)
+	err := bd.Walk(ctx, ClusterManifestsPrefix+"/", false, func(ctx context.Context, f RemoteFile) error {
+		if name := path.Base(f.Name()); strings.HasSuffix(name, ".json") {
+			backupNames = append(backupNames, strings.TrimSuffix(name, ".json"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list %s: %v", ClusterManifestsPrefix, err)
+	}
+	manifests := make([]metadata.ClusterBackupMetadata, 0, len(backupNames))
+	for _, backupName := range backupNames {
+		manifest, err := bd.GetClusterManifest(ctx, backupName)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, *manifest)
+	}
+	return manifests, nil
+}
+
+// RemoveClusterManifest - delete cluster manifest, shall be called after all shard backups are deleted
+func (bd *BackupDestination) RemoveClusterManifest(ctx context.Context, backupName string) error {
+	return bd.DeleteFile(ctx, clusterManifestKey(backupName))
+}
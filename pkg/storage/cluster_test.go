@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFSClusterRetention(t *testing.T) {
+	ctx := context.Background()
+	bd := newTestFSDestination(t)
+	manifests, err := bd.GetClusterManifests(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, manifests)
+
+	now := time.Now().UTC()
+	putBackup := func(backupName string, creationDate time.Time) {
+		body, err := json.Marshal(metadata.BackupMetadata{BackupName: backupName, CreationDate: creationDate, DataFormat: "tar"})
+		assert.NoError(t, err)
+		assert.NoError(t, bd.PutFile(ctx, backupName+"/metadata.json", io.NopCloser(bytes.NewReader(body))))
+	}
+	for i, clusterBackup := range []string{"cluster1", "cluster2"} {
+		creationDate := now.Add(time.Duration(i-3) * time.Hour)
+		manifest := metadata.ClusterBackupMetadata{BackupName: clusterBackup, CreationDate: creationDate}
+		for shardNum := uint32(1); shardNum <= 2; shardNum++ {
+			shardBackup := metadata.ClusterShardBackups{ShardNum: shardNum, BackupName: fmt.Sprintf("%s_shard%d", clusterBackup, shardNum)}
+			putBackup(shardBackup.BackupName, creationDate)
+			manifest.Shards = append(manifest.Shards, shardBackup)
+		}
+		assert.NoError(t, bd.PutClusterManifest(ctx, manifest))
+	}
+	putBackup("host1", now.Add(-2*time.Hour))
+	putBackup("host2", now.Add(-time.Hour))
+
+	_, err = bd.ApplyRetention(ctx, RetentionPolicy{Last: 1}, false)
+	assert.NoError(t, err)
+	backupList, err := bd.BackupListFull(ctx, true)
+	assert.NoError(t, err)
+	var backupNames []string
+	for _, backup := range backupList {
+		backupNames = append(backupNames, backup.BackupName)
+	}
+	assert.ElementsMatch(t, []string{"cluster2_shard1", "cluster2_shard2", "host2"}, backupNames)
+	manifests, err = bd.GetClusterManifests(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(manifests))
+	assert.Equal(t, "cluster2", manifests[0].BackupName)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
 	"io"
 	"net/http"
@@ -145,6 +146,17 @@ func (c *COS) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
 	return err
 }
 
+// CopyObject - server-side copy to another bucket or path, objects larger than 5Gb copied by parts
+func (c *COS) CopyObject(ctx context.Context, key string, dst RemoteStorage) error {
+	dstCOS, ok := dst.(*COS)
+	if !ok {
+		return fmt.Errorf("can't copy %s from COS to %s", key, dst.Kind())
+	}
+	sourceURL := c.client.BaseURL.BucketURL.Host + "/" + path.Join(c.Config.Path, key)
+	_, _, err := dstCOS.client.Object.MultiCopy(ctx, path.Join(dstCOS.Config.Path, key), sourceURL, nil)
+	return err
+}
+
 type cosFile struct {
 	size         int64
 	lastModified time.Time
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+)
+
+const (
+	// EncryptionCipherAES256GCM - envelope encryption, each remote file has own random data key wrapped by master key
+	EncryptionCipherAES256GCM = "aes-256-gcm"
+
+	encryptionMagic         = "CHBKENC1"
+	encryptionChunkSize     = 64 * 1024
+	encryptionLastChunkFlag = uint32(1 << 31)
+	encryptionKeySize       = 32
+)
+
+// ErrUnencrypted is returned when plain remote file is read with encryption configured and plain files are not allowed for it
+var ErrUnencrypted = errors.New("remote file is not encrypted, but encryption is configured and backup metadata doesn't allow unencrypted files")
+
+// KeyProvider - wrap and unwrap per-file data keys, implement it to plug external KMS
+type KeyProvider interface {
+	KeyID() string
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+// StaticKeyProvider - wrap data keys with AES-256-GCM master key loaded from config
+type StaticKeyProvider struct {
+	keyID string
+	aead  cipher.AEAD
+}
+
+func NewStaticKeyProvider(keyID string, masterKey []byte) (*StaticKeyProvider, error) {
+	if len(masterKey) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption master key must be %d bytes, got %d", encryptionKeySize, len(masterKey))
+	}
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		fingerprint := sha256.Sum256(masterKey)
+		keyID = hex.EncodeToString(fingerprint[:8])
+	}
+	return &StaticKeyProvider{keyID: keyID, aead: aead}, nil
+}
+
+func (p *StaticKeyProvider) KeyID() string {
+	return p.keyID
+}
+
+func (p *StaticKeyProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, p.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return p.aead.Seal(nonce, nonce, dataKey, []byte(p.keyID)), nil
+}
+
+func (p *StaticKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	if keyID != p.keyID {
+		return nil, fmt.Errorf("file encrypted with key_id=%s, but configured key_id=%s", keyID, p.keyID)
+	}
+	nonceSize := p.aead.NonceSize()
+	if len(wrappedKey) < nonceSize {
+		return nil, fmt.Errorf("wrapped data key is too short")
+	}
+	dataKey, err := p.aead.Open(nil, wrappedKey[:nonceSize], wrappedKey[nonceSize:], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("can't unwrap data key with key_id=%s: %v", keyID, err)
+	}
+	return dataKey, nil
+}
+
+// NewKeyProvider - return nil when encryption is disabled in config
+func NewKeyProvider(cfg *config.EncryptionConfig) (KeyProvider, error) {
+	if cfg.Cipher == "" || cfg.Cipher == "none" {
+		return nil, nil
+	}
+	if cfg.Cipher != EncryptionCipherAES256GCM {
+		return nil, fmt.Errorf("'%s' is unsupported encryption cipher", cfg.Cipher)
+	}
+	switch cfg.KeyProvider {
+	case "file":
+		keyData := []byte(cfg.Key)
+		if cfg.KeyFile != "" {
+			var err error
+			if keyData, err = os.ReadFile(cfg.KeyFile); err != nil {
+				return nil, fmt.Errorf("can't read encryption key_file: %v", err)
+			}
+		}
+		masterKey, err := decodeEncryptionKey(keyData)
+		if err != nil {
+			return nil, err
+		}
+		return NewStaticKeyProvider(cfg.KeyID, masterKey)
+	default:
+		return nil, fmt.Errorf("'%s' is unsupported encryption key_provider", cfg.KeyProvider)
+	}
+}
+
+// decodeEncryptionKey - accept raw 32 bytes, hex or base64 encoded key
+func decodeEncryptionKey(keyData []byte) ([]byte, error) {
+	if len(keyData) == encryptionKeySize {
+		return keyData, nil
+	}
+	text := strings.TrimSpace(string(keyData))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("malformed encryption key, must be %d raw bytes, hex or base64 encoded", encryptionKeySize)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeEncryptionHeader - magic, key_id and wrapped data key, each variable field prefixed with uint16 length
+func writeEncryptionHeader(w io.Writer, keyID string, wrappedKey []byte) error {
+	header := bytes.NewBufferString(encryptionMagic)
+	for _, field := range [][]byte{[]byte(keyID), wrappedKey} {
+		if err := binary.Write(header, binary.BigEndian, uint16(len(field))); err != nil {
+			return err
+		}
+		header.Write(field)
+	}
+	_, err := w.Write(header.Bytes())
+	return err
+}
+
+func readEncryptionHeaderField(r io.Reader) ([]byte, error) {
+	var fieldLen uint16
+	if err := binary.Read(r, binary.BigEndian, &fieldLen); err != nil {
+		return nil, err
+	}
+	field := make([]byte, fieldLen)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+// encryptWriter - split stream to chunks, each chunk sealed separately, last chunk flagged to detect truncation
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+func newEncryptWriter(ctx context.Context, w io.Writer, keyProvider KeyProvider) (*encryptWriter, error) {
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := keyProvider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("can't wrap data key: %v", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEncryptionHeader(w, keyProvider.KeyID(), wrappedKey); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+copied]
+		p = p[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// Close - write last chunk, underlying writer shall be closed by caller
+func (e *encryptWriter) Close() error {
+	return e.flush(true)
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	chunkHeader := make([]byte, 4)
+	chunkLen := uint32(len(e.buf) + e.aead.Overhead())
+	if last {
+		chunkLen |= encryptionLastChunkFlag
+	}
+	binary.BigEndian.PutUint32(chunkHeader, chunkLen)
+	sealed := e.aead.Seal(chunkHeader, chunkNonce(e.aead, e.counter), e.buf, chunkHeader)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) readChunk() error {
+	chunkHeader := make([]byte, 4)
+	if _, err := io.ReadFull(d.r, chunkHeader); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("encrypted stream is truncated: %v", io.ErrUnexpectedEOF)
+		}
+		return err
+	}
+	chunkLen := binary.BigEndian.Uint32(chunkHeader)
+	last := chunkLen&encryptionLastChunkFlag != 0
+	chunkLen &^= encryptionLastChunkFlag
+	if chunkLen < uint32(d.aead.Overhead()) || chunkLen > uint32(encryptionChunkSize+d.aead.Overhead()) {
+		return fmt.Errorf("encrypted stream is corrupted: wrong chunk length %d", chunkLen)
+	}
+	sealed := make([]byte, chunkLen)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("encrypted stream is truncated: %v", err)
+	}
+	plain, err := d.aead.Open(sealed[:0], chunkNonce(d.aead, d.counter), sealed, chunkHeader)
+	if err != nil {
+		return fmt.Errorf("can't decrypt chunk %d: %v", d.counter, err)
+	}
+	d.counter++
+	d.buf = plain
+	d.done = last
+	return nil
+}
+
+func chunkNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+type decryptReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// NewDecryptReader - decrypt reader when it starts with encryption header, plain reader is returned as is only when encryption is not configured or isPlainAllowed
+func NewDecryptReader(ctx context.Context, r io.ReadCloser, keyProvider KeyProvider, isPlainAllowed bool) (io.ReadCloser, error) {
+	bufReader := bufio.NewReader(r)
+	magic, err := bufReader.Peek(len(encryptionMagic))
+	if err != nil || string(magic) != encryptionMagic {
+		if keyProvider != nil && !isPlainAllowed {
+			return nil, ErrUnencrypted
+		}
+		return decryptReadCloser{bufReader, r}, nil
+	}
+	if _, err = bufReader.Discard(len(encryptionMagic)); err != nil {
+		return nil, err
+	}
+	keyID, err := readEncryptionHeaderField(bufReader)
+	if err != nil {
+		return nil, fmt.Errorf("can't read encryption header: %v", err)
+	}
+	wrappedKey, err := readEncryptionHeaderField(bufReader)
+	if err != nil {
+		return nil, fmt.Errorf("can't read encryption header: %v", err)
+	}
+	if keyProvider == nil {
+		return nil, fmt.Errorf("remote file encrypted with key_id=%s, but encryption is not configured", string(keyID))
+	}
+	dataKey, err := keyProvider.UnwrapKey(ctx, string(keyID), wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return decryptReadCloser{&decryptReader{r: bufReader, aead: aead}, r}, nil
+}
+
+// encryptReadCloser - Close waits until encryption goroutine stops reading source, so source could be reused after Close
+type encryptReadCloser struct {
+	*io.PipeReader
+	done chan struct{}
+	err  error
+}
+
+// Close - return error of encryption goroutine, io.ErrClosedPipe means encrypted stream was not read till the end
+func (e *encryptReadCloser) Close() error {
+	closeErr := e.PipeReader.Close()
+	<-e.done
+	if e.err != nil {
+		return e.err
+	}
+	return closeErr
+}
+
+// NewEncryptReader - return reader which produce encrypted content of r
+func NewEncryptReader(ctx context.Context, r io.Reader, keyProvider KeyProvider) io.ReadCloser {
+	body, w := io.Pipe()
+	encReader := &encryptReadCloser{PipeReader: body, done: make(chan struct{})}
+	go func() {
+		defer close(encReader.done)
+		encWriter, err := newEncryptWriter(ctx, w, keyProvider)
+		if err == nil {
+			if _, err = io.Copy(encWriter, r); err == nil {
+				err = encWriter.Close()
+			}
+		}
+		encReader.err = err
+		_ = w.CloseWithError(err)
+	}()
+	return encReader
+}
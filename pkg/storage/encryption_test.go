@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewStaticKeyProvider("", bytes.Repeat([]byte{1}, encryptionKeySize))
+	assert.NoError(t, err)
+	for _, size := range []int{0, 10, encryptionChunkSize, 2*encryptionChunkSize + 5} {
+		plain := bytes.Repeat([]byte{'x'}, size)
+		encrypted, err := io.ReadAll(NewEncryptReader(ctx, bytes.NewReader(plain), keyProvider))
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(encrypted, []byte(encryptionMagic)))
+
+		r, err := NewDecryptReader(ctx, io.NopCloser(bytes.NewReader(encrypted)), keyProvider, false)
+		assert.NoError(t, err)
+		decrypted, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, plain, decrypted)
+
+		r, err = NewDecryptReader(ctx, io.NopCloser(bytes.NewReader(encrypted[:len(encrypted)-1])), keyProvider, false)
+		assert.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.Error(t, err)
+	}
+}
+
+func TestDecryptReaderPlainAndWrongKey(t *testing.T) {
+	ctx := context.Background()
+	plain := []byte(`{"backup_name":"test"}`)
+	r, err := NewDecryptReader(ctx, io.NopCloser(bytes.NewReader(plain)), nil, false)
+	assert.NoError(t, err)
+	decrypted, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+
+	keyProvider, err := NewStaticKeyProvider("first", bytes.Repeat([]byte{1}, encryptionKeySize))
+	assert.NoError(t, err)
+	encrypted, err := io.ReadAll(NewEncryptReader(ctx, bytes.NewReader(plain), keyProvider))
+	assert.NoError(t, err)
+	_, err = NewDecryptReader(ctx, io.NopCloser(bytes.NewReader(encrypted)), nil, false)
+	assert.Error(t, err)
+	otherProvider, err := NewStaticKeyProvider("second", bytes.Repeat([]byte{2}, encryptionKeySize))
+	assert.NoError(t, err)
+	_, err = NewDecryptReader(ctx, io.NopCloser(bytes.NewReader(encrypted)), otherProvider, false)
+	assert.Error(t, err)
+
+	_, err = NewDecryptReader(ctx, io.NopCloser(bytes.NewReader(plain)), keyProvider, false)
+	assert.Error(t, err)
+	r, err = NewDecryptReader(ctx, io.NopCloser(bytes.NewReader(plain)), keyProvider, true)
+	assert.NoError(t, err)
+	decrypted, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+}
+
+type failedReader struct{}
+
+func (failedReader) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("source is broken")
+}
+
+func TestEncryptReaderCloseWaitsEncryption(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewStaticKeyProvider("", bytes.Repeat([]byte{1}, encryptionKeySize))
+	assert.NoError(t, err)
+
+	source := bytes.NewReader(bytes.Repeat([]byte{'x'}, 4*encryptionChunkSize))
+	r := NewEncryptReader(ctx, source, keyProvider)
+	_, err = io.ReadFull(r, make([]byte, 10))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, r.Close(), io.ErrClosedPipe)
+	// encryption goroutine is stopped, so source could be seeked and read again
+	_, err = source.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+
+	r = NewEncryptReader(ctx, failedReader{}, keyProvider)
+	_, err = io.ReadAll(r)
+	assert.Error(t, err)
+	assert.EqualError(t, r.Close(), "source is broken")
+}
+
+func TestIsPlainAllowed(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewStaticKeyProvider("", bytes.Repeat([]byte{1}, encryptionKeySize))
+	assert.NoError(t, err)
+	bd := newTestFSDestination(t, withTestKeyProvider(keyProvider))
+	assert.True(t, bd.isPlainAllowed(RemoteLockFile))
+	assert.False(t, bd.isPlainAllowed("backup1.tar.gz"))
+	assert.False(t, bd.isPlainAllowed("backup1/metadata.json"))
+	assert.False(t, bd.isPlainAllowed("backup1/metadata/db/table.json"))
+	assert.False(t, bd.isPlainAllowed("parts/ab/abcdef.tar"))
+
+	// plain backup is not trusted without encryption->allow_unencrypted
+	bd.rememberBackupEncryption(Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "backup1"}})
+	bd.rememberBackupEncryption(Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "backup2", EncryptionCipher: EncryptionCipherAES256GCM}})
+	assert.False(t, bd.isPlainAllowed("backup1/metadata/db/table.json"))
+
+	bd = newTestFSDestination(t, withTestKeyProvider(keyProvider), withTestAllowUnencrypted())
+	bd.rememberBackupEncryption(Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "backup1"}})
+	bd.rememberBackupEncryption(Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "backup2", EncryptionCipher: EncryptionCipherAES256GCM}})
+	assert.True(t, bd.isPlainAllowed("backup1/metadata.json"))
+	assert.True(t, bd.isPlainAllowed("backup1/metadata/db/table.json"))
+	assert.False(t, bd.isPlainAllowed("backup2/metadata/db/table.json"))
+
+	// only parts referenced by plain backup could be read without decryption
+	assert.NoError(t, bd.PutContentPartsRefs(ctx, "backup1", []string{"parts/ab/abcdef.tar"}))
+	assert.NoError(t, bd.PutContentPartsRefs(ctx, "backup2", []string{"parts/cd/cdef01"}))
+	assert.NoError(t, bd.AllowPlainContentParts(ctx, "backup1"))
+	assert.NoError(t, bd.AllowPlainContentParts(ctx, "backup2"))
+	assert.True(t, bd.isPlainAllowed("parts/ab/abcdef.tar"))
+	assert.True(t, bd.isPlainAllowed("parts/ab/abcdef.tar.checksums.json"))
+	assert.False(t, bd.isPlainAllowed("parts/cd/cdef01/data.bin"))
+
+	bd.keyProvider = nil
+	assert.True(t, bd.isPlainAllowed("backup2/metadata/db/table.json"))
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+)
+
+// FS - Implement RemoteStorage on local filesystem or mounted network share (NFS, SMB, etc.)
+type FS struct {
+	Config *config.FSConfig
+	Log    *apexLog.Entry
+}
+
+func (fs *FS) Kind() string {
+	return "FS"
+}
+
+func (fs *FS) Connect(ctx context.Context) error {
+	if fs.Config.Path == "" {
+		return fmt.Errorf("fs.path shall not be empty")
+	}
+	if err := os.MkdirAll(fs.Config.Path, 0750); err != nil {
+		return fmt.Errorf("can't create fs.path=%s: %v", fs.Config.Path, err)
+	}
+	info, err := os.Stat(fs.Config.Path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("fs.path=%s is not a directory", fs.Config.Path)
+	}
+	return nil
+}
+
+func (fs *FS) Close(ctx context.Context) error {
+	return nil
+}
+
+func (fs *FS) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	info, err := os.Stat(path.Join(fs.Config.Path, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &fsFile{
+		size:         info.Size(),
+		lastModified: info.ModTime(),
+		name:         info.Name(),
+	}, nil
+}
+
+// DeleteFile - remove file or whole directory, RemoveBackup pass backup name for this storage kind
+func (fs *FS) DeleteFile(ctx context.Context, key string) error {
+	filePath := path.Join(fs.Config.Path, key)
+	fs.Log.Debugf("delete %s", filePath)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if info.IsDir() {
+		return os.RemoveAll(filePath)
+	}
+	return os.Remove(filePath)
+}
+
+// Walk - return only regular files when recursive, names relative to remotePath
+func (fs *FS) Walk(ctx context.Context, remotePath string, recursive bool, process func(context.Context, RemoteFile) error) error {
+	dir := path.Join(fs.Config.Path, remotePath)
+	if recursive {
+		err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || isFSTempFile(info.Name()) {
+				return nil
+			}
+			relName, err := filepath.Rel(dir, filePath)
+			if err != nil {
+				return err
+			}
+			return process(ctx, &fsFile{
+				size:         info.Size(),
+				lastModified: info.ModTime(),
+				name:         filepath.ToSlash(relName),
+			})
+		})
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if isFSTempFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err = process(ctx, &fsFile{
+			size:         info.Size(),
+			lastModified: info.ModTime(),
+			name:         entry.Name(),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *FS) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(path.Join(fs.Config.Path, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs *FS) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return fs.GetFileReader(ctx, key)
+}
+
+// PutFile - write to temporary file in the same directory and rename it after complete, readers never see partial files
+func (fs *FS) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	filePath := path.Join(fs.Config.Path, key)
+	dirPath := path.Dir(filePath)
+	if err := os.MkdirAll(dirPath, 0750); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(dirPath, "."+path.Base(filePath)+fsTempFileSuffix+"*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	cleanup := func() {
+		if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+			fs.Log.Warnf("can't remove %s: %v", tmpPath, err)
+		}
+	}
+	if _, err = io.Copy(tmpFile, r); err != nil {
+		_ = tmpFile.Close()
+		cleanup()
+		return err
+	}
+	if fs.Config.Fsync {
+		if err = tmpFile.Sync(); err != nil {
+			_ = tmpFile.Close()
+			cleanup()
+			return fmt.Errorf("can't fsync %s: %v", tmpPath, err)
+		}
+	}
+	if err = tmpFile.Close(); err != nil {
+		cleanup()
+		return err
+	}
+	if err = os.Chmod(tmpPath, 0640); err != nil {
+		cleanup()
+		return err
+	}
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		cleanup()
+		return err
+	}
+	if fs.Config.Fsync {
+		return fsyncDir(dirPath)
+	}
+	return nil
+}
+
+// GetFileVersioned - read small file, content hash is used as version for PutFileIfVersion
+func (fs *FS) GetFileVersioned(ctx context.Context, key string) ([]byte, string, error) {
+	body, err := os.ReadFile(path.Join(fs.Config.Path, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+	return body, fsFileVersion(body), nil
+}
+
+// PutFileIfVersion - writers are serialized with O_CREATE|O_EXCL mutex file, version is compared under mutex and new content is renamed over the old one
+func (fs *FS) PutFileIfVersion(ctx context.Context, key string, body []byte, version string) error {
+	filePath := path.Join(fs.Config.Path, key)
+	dirPath := path.Dir(filePath)
+	if err := os.MkdirAll(dirPath, 0750); err != nil {
+		return err
+	}
+	mutexPath := path.Join(dirPath, "."+path.Base(filePath)+".cas"+fsTempFileSuffix)
+	mutex, err := os.OpenFile(mutexPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if os.IsExist(err) {
+		// mutex file left by crashed process
+		if info, statErr := os.Stat(mutexPath); statErr == nil && time.Since(info.ModTime()) > fsMutexTimeout {
+			fs.Log.Warnf("remove stale %s", mutexPath)
+			if err = os.Remove(mutexPath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			mutex, err = os.OpenFile(mutexPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+		}
+	}
+	if err != nil {
+		if os.IsExist(err) {
+			return ErrPreconditionFailed
+		}
+		return err
+	}
+	if err = mutex.Close(); err != nil {
+		fs.Log.Warnf("can't close %s: %v", mutexPath, err)
+	}
+	defer func() {
+		if err := os.Remove(mutexPath); err != nil {
+			fs.Log.Warnf("can't remove %s: %v", mutexPath, err)
+		}
+	}()
+	current, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if (err == nil && fsFileVersion(current) != version) || (err != nil && version != "") {
+		return ErrPreconditionFailed
+	}
+	return fs.PutFile(ctx, key, io.NopCloser(bytes.NewReader(body)))
+}
+
+func fsFileVersion(body []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(body))
+}
+
+// fsMutexTimeout - PutFileIfVersion mutex file older than timeout doesn't belong to alive writer
+const fsMutexTimeout = time.Minute
+
+const fsTempFileSuffix = ".tmp"
+
+func isFSTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, fsTempFileSuffix)
+}
+
+// fsyncDir - persist rename in parent directory entry
+func fsyncDir(dirPath string) error {
+	d, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+		return fmt.Errorf("can't fsync directory %s: %v", dirPath, err)
+	}
+	return d.Close()
+}
+
+// Implement RemoteFile
+type fsFile struct {
+	size         int64
+	lastModified time.Time
+	name         string
+}
+
+func (file *fsFile) Size() int64 {
+	return file.size
+}
+
+func (file *fsFile) LastModified() time.Time {
+	return file.lastModified
+}
+
+func (file *fsFile) Name() string {
+	return file.name
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFSOption func(bd *BackupDestination)
+
+func withTestFSPath(fsPath string) testFSOption {
+	return func(bd *BackupDestination) {
+		bd.RemoteStorage.(*FS).Config.Path = fsPath
+	}
+}
+
+func withTestKeyProvider(keyProvider KeyProvider) testFSOption {
+	return func(bd *BackupDestination) {
+		bd.keyProvider = keyProvider
+	}
+}
+
+func withTestLockTTL(lockTTL time.Duration) testFSOption {
+	return func(bd *BackupDestination) {
+		bd.lockTTL = lockTTL
+	}
+}
+
+func withTestContentPartsGrace(grace time.Duration) testFSOption {
+	return func(bd *BackupDestination) {
+		bd.contentPartsGrace = grace
+	}
+}
+
+func withTestAllowUnencrypted() testFSOption {
+	return func(bd *BackupDestination) {
+		bd.allowUnencrypted = true
+	}
+}
+
+// newTestFSDestination - connected BackupDestination over FS in temporary directory
+func newTestFSDestination(t *testing.T, opts ...testFSOption) *BackupDestination {
+	log := apexLog.WithField("logger", "FS")
+	bd := &BackupDestination{
+		RemoteStorage:      &FS{Config: &config.FSConfig{Path: t.TempDir()}, Log: log},
+		Log:                log,
+		compressionFormat:  "tar",
+		compressionLevel:   1,
+		disableProgressBar: true,
+	}
+	for _, opt := range opts {
+		opt(bd)
+	}
+	assert.NoError(t, bd.Connect(context.Background()))
+	return bd
+}
+
+func TestFSPutWalkDelete(t *testing.T) {
+	ctx := context.Background()
+	fs := &FS{Config: &config.FSConfig{Path: t.TempDir(), Fsync: true}, Log: apexLog.WithField("logger", "FS")}
+	assert.NoError(t, fs.Connect(ctx))
+	for _, key := range []string{"backup1/metadata.json", "backup1/shadow/db/table/default_all_1_1_0.tar"} {
+		assert.NoError(t, fs.PutFile(ctx, key, io.NopCloser(bytes.NewReader([]byte(key)))))
+	}
+
+	var names []string
+	assert.NoError(t, fs.Walk(ctx, "backup1", true, func(ctx context.Context, f RemoteFile) error {
+		names = append(names, f.Name())
+		return nil
+	}))
+	sort.Strings(names)
+	assert.Equal(t, []string{"metadata.json", "shadow/db/table/default_all_1_1_0.tar"}, names)
+
+	names = names[:0]
+	assert.NoError(t, fs.Walk(ctx, "/", false, func(ctx context.Context, f RemoteFile) error {
+		names = append(names, f.Name())
+		return nil
+	}))
+	assert.Equal(t, []string{"backup1"}, names)
+
+	r, err := fs.GetFileReader(ctx, "backup1/metadata.json")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "backup1/metadata.json", string(content))
+
+	assert.NoError(t, fs.DeleteFile(ctx, "backup1"))
+	_, err = fs.StatFile(ctx, "backup1/metadata.json")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = fs.GetFileReader(ctx, "backup1/metadata.json")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, fs.DeleteFile(ctx, "backup1"))
+}
+
+func TestFSCopyObject(t *testing.T) {
+	ctx := context.Background()
+	src := newTestFSDestination(t)
+	dst := newTestFSDestination(t)
+	key := "backup1/shadow/db/table/default_all_1_1_0.tar"
+	assert.NoError(t, src.PutFile(ctx, key, io.NopCloser(bytes.NewReader([]byte(key)))))
+
+	assert.NoError(t, src.CopyObject(ctx, key, dst))
+	r, err := dst.GetFileReader(ctx, key)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, key, string(content))
+}
+
+func TestUploadPathRemoteSizes(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewStaticKeyProvider("", bytes.Repeat([]byte{1}, encryptionKeySize))
+	assert.NoError(t, err)
+	localPath := t.TempDir()
+	assert.NoError(t, os.MkdirAll(path.Join(localPath, "all_1_1_0"), 0750))
+	files := []string{"all_1_1_0/data.bin", "all_1_1_0/checksums.txt"}
+	for i, f := range files {
+		assert.NoError(t, os.WriteFile(path.Join(localPath, f), bytes.Repeat([]byte{'x'}, (i+1)*encryptionChunkSize+7), 0640))
+	}
+	for _, provider := range []KeyProvider{nil, keyProvider} {
+		bd := newTestFSDestination(t, withTestKeyProvider(provider))
+		_, sizes, err := bd.UploadPath(ctx, 0, localPath, files, "backup1/shadow/db/table/default", 0, 0)
+		assert.NoError(t, err)
+		for _, f := range files {
+			remoteFile, err := bd.StatFile(ctx, path.Join("backup1/shadow/db/table/default", f))
+			assert.NoError(t, err)
+			assert.Equal(t, remoteFile.Size(), sizes[f])
+		}
+	}
+}
+
+func TestUploadStream(t *testing.T) {
+	ctx := context.Background()
+	keyProvider, err := NewStaticKeyProvider("", bytes.Repeat([]byte{1}, encryptionKeySize))
+	assert.NoError(t, err)
+	content := bytes.Repeat([]byte{'x'}, encryptionChunkSize+7)
+	bd := newTestFSDestination(t, withTestKeyProvider(keyProvider))
+	key := "backup1/shadow/db/table/default/all/data.native"
+	checksum, size, storedSize, err := bd.UploadStream(ctx, key, io.NopCloser(bytes.NewReader(content)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	remoteFile, err := bd.StatFile(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, remoteFile.Size(), storedSize)
+
+	localPath := t.TempDir()
+	assert.NoError(t, bd.DownloadPath(ctx, 0, "backup1/shadow/db/table/default/all", localPath, 0, 0, map[string]string{"data.native": checksum}))
+	downloaded, err := os.ReadFile(path.Join(localPath, "data.native"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+	assert.Error(t, bd.DownloadPath(ctx, 0, "backup1/shadow/db/table/default/all", localPath, 0, 0, map[string]string{"data.native": "0000000000000000"}))
+}
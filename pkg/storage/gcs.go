@@ -3,10 +3,12 @@ package storage
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/apex/log"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	googleHTTPTransport "google.golang.org/api/transport/http"
@@ -22,8 +25,9 @@ import (
 
 // GCS - presents methods for manipulate data on GCS
 type GCS struct {
-	client *storage.Client
-	Config *config.GCSConfig
+	client      *storage.Client
+	Config      *config.GCSConfig
+	retainUntil *time.Time
 }
 
 type debugGCSTransport struct {
@@ -95,7 +99,24 @@ func (gcs *GCS) Connect(ctx context.Context) error {
 	}
 
 	gcs.client, err = storage.NewClient(ctx, clientOptions...)
-	return err
+	if err != nil {
+		return err
+	}
+	// bucket retention policy applies to each object, retention period of object with event-based hold starts only after hold released
+	if gcs.Config.ObjectHold != "event_based" {
+		if bucketAttrs, err := gcs.client.Bucket(gcs.Config.Bucket).Attrs(ctx); err != nil {
+			log.Debugf("can't get bucket retention policy: %v", err)
+		} else if bucketAttrs.RetentionPolicy != nil && bucketAttrs.RetentionPolicy.RetentionPeriod > 0 {
+			retainUntil := time.Now().UTC().Add(bucketAttrs.RetentionPolicy.RetentionPeriod)
+			gcs.retainUntil = &retainUntil
+		}
+	}
+	return nil
+}
+
+// ObjectLock - retain-until date from bucket retention policy and hold which is set to each uploaded object
+func (gcs *GCS) ObjectLock() (*time.Time, string) {
+	return gcs.retainUntil, gcs.Config.ObjectHold
 }
 
 func (gcs *GCS) Close(ctx context.Context) error {
@@ -161,6 +182,10 @@ func (gcs *GCS) PutFile(ctx context.Context, key string, r io.ReadCloser) error
 	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(key)
 	writer := obj.NewWriter(ctx)
 	writer.StorageClass = gcs.Config.StorageClass
+	if isObjectLockRequired(key) {
+		writer.TemporaryHold = gcs.Config.ObjectHold == "temporary"
+		writer.EventBasedHold = gcs.Config.ObjectHold == "event_based"
+	}
 	defer func() {
 		if err := writer.Close(); err != nil {
 			log.Warnf("can't close writer: %+v", err)
@@ -171,6 +196,89 @@ func (gcs *GCS) PutFile(ctx context.Context, key string, r io.ReadCloser) error
 	return err
 }
 
+// GetFileVersioned - read small object and return its generation for PutFileIfVersion
+func (gcs *GCS) GetFileVersioned(ctx context.Context, key string) ([]byte, string, error) {
+	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key))
+	reader, err := obj.NewReader(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+	body, err := io.ReadAll(reader)
+	if closeErr := reader.Close(); closeErr != nil {
+		log.Warnf("can't close reader for %s: %v", key, closeErr)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return body, strconv.FormatInt(reader.Attrs.Generation, 10), nil
+}
+
+// PutFileIfVersion - write object with DoesNotExist precondition for new object and GenerationMatch for existing object
+func (gcs *GCS) PutFileIfVersion(ctx context.Context, key string, body []byte, version string) error {
+	conditions := storage.Conditions{DoesNotExist: true}
+	if version != "" {
+		generation, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid generation %s for %s: %v", version, key, err)
+		}
+		conditions = storage.Conditions{GenerationMatch: generation}
+	}
+	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key)).If(conditions)
+	writer := obj.NewWriter(ctx)
+	writer.StorageClass = gcs.Config.StorageClass
+	if _, err := writer.Write(body); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+			return ErrPreconditionFailed
+		}
+		return err
+	}
+	return nil
+}
+
+// CopyObject - server-side copy to another bucket or path, object rewritten in chunks by GCS when it is large
+func (gcs *GCS) CopyObject(ctx context.Context, key string, dst RemoteStorage) error {
+	dstGCS, ok := dst.(*GCS)
+	if !ok {
+		return fmt.Errorf("can't copy %s from GCS to %s", key, dst.Kind())
+	}
+	src := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key))
+	copier := dstGCS.client.Bucket(dstGCS.Config.Bucket).Object(path.Join(dstGCS.Config.Path, key)).CopierFrom(src)
+	copier.StorageClass = dstGCS.Config.StorageClass
+	if isObjectLockRequired(key) {
+		copier.TemporaryHold = dstGCS.Config.ObjectHold == "temporary"
+		copier.EventBasedHold = dstGCS.Config.ObjectHold == "event_based"
+	}
+	_, err := copier.Run(ctx)
+	return err
+}
+
+// TierStorageClass - storage class for backups older than general->tier_after
+func (gcs *GCS) TierStorageClass() string {
+	return gcs.Config.TierStorageClass
+}
+
+// TierObject - change storage class of existing object with rewrite to the same name
+func (gcs *GCS) TierObject(ctx context.Context, key string, storageClass string) error {
+	obj := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key))
+	copier := obj.CopierFrom(obj)
+	copier.StorageClass = storageClass
+	_, err := copier.Run(ctx)
+	return err
+}
+
+// RestoreObject - NEARLINE, COLDLINE and ARCHIVE objects could be read immediately
+func (gcs *GCS) RestoreObject(ctx context.Context, key string) (bool, error) {
+	return true, nil
+}
+
 func (gcs *GCS) StatFile(ctx context.Context, key string) (RemoteFile, error) {
 	objAttr, err := gcs.client.Bucket(gcs.Config.Bucket).Object(path.Join(gcs.Config.Path, key)).Attrs(ctx)
 	if err != nil {
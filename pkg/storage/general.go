@@ -2,6 +2,7 @@ package storage
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -53,60 +54,395 @@ type BackupDestination struct {
 	compressionFormat  string
 	compressionLevel   int
 	disableProgressBar bool
+	keyProvider        KeyProvider
+	bandwidth          *bandwidth
+	lockTTL            time.Duration
+	// contentPartsGrace - content addressed parts which are not referenced by any backup are removed only after this period since upload
+	contentPartsGrace time.Duration
+	lock              *remoteLockLease
+	// plainBackups - backups which metadata.json doesn't contain encryption_cipher, their files are read without decryption
+	plainBackups sync.Map
+	// plainContentParts - content addressed parts referenced by plainBackups, look AllowPlainContentParts
+	plainContentParts sync.Map
+	// allowUnencrypted - encryption->allow_unencrypted, without it plain metadata.json is not trusted when encryption is configured
+	allowUnencrypted bool
 }
 
 var metadataCacheLock sync.RWMutex
 
-func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, keep int) error {
-	if keep < 1 {
+// PutFile - encrypt content before upload when encryption enabled
+func (bd *BackupDestination) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	_, err := bd.putFile(ctx, key, r)
+	return err
+}
+
+// putFile - return size of stored object, it differs from size of r when content is encrypted
+func (bd *BackupDestination) putFile(ctx context.Context, key string, r io.ReadCloser) (int64, error) {
+	if bd.keyProvider == nil {
+		body := &sizeReadCloser{ReadCloser: r}
+		err := bd.RemoteStorage.PutFile(ctx, key, body)
+		return body.size, err
+	}
+	body := &sizeReadCloser{ReadCloser: NewEncryptReader(ctx, r, bd.keyProvider)}
+	err := bd.RemoteStorage.PutFile(ctx, key, body)
+	// wait encryption goroutine, r could be read again after return, UploadPath retry seek it to the beginning
+	if closeErr := body.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("can't encrypt %s: %v", key, closeErr)
+	}
+	return body.size, err
+}
+
+// GetFileReader - transparently decrypt content when remote file is encrypted
+func (bd *BackupDestination) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := bd.RemoteStorage.GetFileReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	decryptedReader, err := NewDecryptReader(ctx, r, bd.keyProvider, bd.isPlainAllowed(key))
+	if err != nil {
+		if closeErr := r.Close(); closeErr != nil {
+			bd.Log.Warnf("can't close GetFileReader descriptor %v: %v", r, closeErr)
+		}
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return decryptedReader, nil
+}
+
+// getServiceFile - read lock or index object with version for putServiceFile, version is empty when remote storage doesn't support conditional put
+func (bd *BackupDestination) getServiceFile(ctx context.Context, key string) ([]byte, string, error) {
+	var r io.ReadCloser
+	version := ""
+	if putter, ok := bd.RemoteStorage.(ConditionalPutter); ok {
+		body, bodyVersion, err := putter.GetFileVersioned(ctx, key)
+		if err != nil {
+			return nil, "", err
+		}
+		if r, err = NewDecryptReader(ctx, io.NopCloser(bytes.NewReader(body)), bd.keyProvider, bd.isPlainAllowed(key)); err != nil {
+			return nil, "", fmt.Errorf("%s: %v", key, err)
+		}
+		version = bodyVersion
+	} else {
+		if _, err := bd.StatFile(ctx, key); err != nil {
+			return nil, "", err
+		}
+		var err error
+		if r, err = bd.GetFileReader(ctx, key); err != nil {
+			return nil, "", err
+		}
+	}
+	body, err := io.ReadAll(r)
+	if closeErr := r.Close(); closeErr != nil {
+		bd.Log.Warnf("can't close %s: %v", key, closeErr)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return body, version, nil
+}
+
+// putServiceFile - replace lock or index object only when it still has version returned by getServiceFile, ErrPreconditionFailed means concurrent change
+// remote storages which don't implement ConditionalPutter just overwrite object, callers shall verify result for them
+func (bd *BackupDestination) putServiceFile(ctx context.Context, key string, body []byte, version string) error {
+	putter, ok := bd.RemoteStorage.(ConditionalPutter)
+	if !ok {
+		return bd.PutFile(ctx, key, io.NopCloser(bytes.NewReader(body)))
+	}
+	if bd.keyProvider != nil {
+		r := NewEncryptReader(ctx, bytes.NewReader(body), bd.keyProvider)
+		encrypted, err := io.ReadAll(r)
+		if closeErr := r.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("can't encrypt %s: %v", key, err)
+		}
+		body = encrypted
+	}
+	return putter.PutFileIfVersion(ctx, key, body, version)
+}
+
+// isConditionalPutSupported - putServiceFile detects concurrent changes
+func (bd *BackupDestination) isConditionalPutSupported() bool {
+	_, ok := bd.RemoteStorage.(ConditionalPutter)
+	return ok
+}
+
+// isPlainAllowed - when encryption is configured, only service files could be read without decryption
+// files of backups uploaded without encryption are allowed only with encryption->allow_unencrypted, each backup is checked by own metadata.json
+func (bd *BackupDestination) isPlainAllowed(key string) bool {
+	if bd.keyProvider == nil {
+		return true
+	}
+	backupName, fileName, isInsideBackup := strings.Cut(strings.Trim(key, "/"), "/")
+	if !isInsideBackup {
+		// remote lock and remote index are placed in root and could be created before encryption was configured
+		if backupName == RemoteLockFile || backupName == RemoteIndexFile {
+			return true
+		}
+		// legacy backup archives
+		return bd.allowUnencrypted
+	}
+	switch backupName {
+	case ClusterManifestsPrefix:
+		// cluster manifests contain only backup names of shards, they could be created before encryption was configured
+		return true
+	case ContentPartsPrefix:
+		// content addressed part could be read without decryption only for backup which was uploaded without encryption
+		_, isPlain := bd.plainContentParts.Load(getContentPartKeyByFile(fileName))
+		return isPlain && bd.allowUnencrypted
+	}
+	// metadata.json defines is backup encrypted or not, attacker with write access could replace it with plain one
+	if fileName == "metadata.json" {
+		return bd.allowUnencrypted
+	}
+	_, isPlain := bd.plainBackups.Load(backupName)
+	return isPlain && bd.allowUnencrypted
+}
+
+// AllowPlainContentParts - content addressed parts referenced by backup uploaded without encryption could be read without decryption, requires encryption->allow_unencrypted
+func (bd *BackupDestination) AllowPlainContentParts(ctx context.Context, backupName string) error {
+	if bd.keyProvider == nil || !bd.allowUnencrypted {
 		return nil
 	}
-	start := time.Now()
-	backupList, err := bd.BackupList(ctx, true, "")
+	if _, isPlain := bd.plainBackups.Load(backupName); !isPlain {
+		return nil
+	}
+	keys, err := bd.GetContentPartsRefs(ctx, backupName)
 	if err != nil {
 		return err
 	}
-	backupsToDelete := GetBackupsToDelete(backupList, keep)
+	for _, key := range keys {
+		bd.plainContentParts.Store(key, struct{}{})
+	}
+	return nil
+}
+
+func (bd *BackupDestination) rememberBackupEncryption(backup Backup) {
+	if backup.EncryptionCipher == "" {
+		bd.plainBackups.Store(backup.BackupName, struct{}{})
+	} else {
+		bd.plainBackups.Delete(backup.BackupName)
+	}
+}
+
+// ObjectLock - return retain-until date and object hold for uploaded files, nil and empty string when remote storage doesn't protect objects from deletion
+func (bd *BackupDestination) ObjectLock() (*time.Time, string) {
+	if locker, ok := bd.RemoteStorage.(ObjectLocker); ok {
+		return locker.ObjectLock()
+	}
+	return nil, ""
+}
+
+// isObjectLockRequired - lock and index objects are overwritten and deleted all the time, so they are never protected
+func isObjectLockRequired(key string) bool {
+	name := path.Base(key)
+	return name != RemoteLockFile && name != RemoteIndexFile
+}
+
+// Encryption - return cipher and key_id for uploaded files, empty strings when encryption disabled
+func (bd *BackupDestination) Encryption() (string, string) {
+	if bd.keyProvider == nil {
+		return "", ""
+	}
+	return EncryptionCipherAES256GCM, bd.keyProvider.KeyID()
+}
+
+// RemoveOldBackups - remove remote backups which are not kept by retention policy
+func (bd *BackupDestination) RemoveOldBackups(ctx context.Context, policy RetentionPolicy) error {
+	_, err := bd.ApplyRetention(ctx, policy, false)
+	return err
+}
+
+// ApplyRetention - calculate retention decision for each remote backup, backups which are not kept are removed when dryRun is false
+func (bd *BackupDestination) ApplyRetention(ctx context.Context, policy RetentionPolicy, dryRun bool) ([]BackupRetention, error) {
+	if policy.IsEmpty() && !dryRun {
+		return nil, nil
+	}
+	if !dryRun {
+		lockCtx, unlock, err := bd.Lock(ctx, "RemoveOldBackups", false)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+		ctx = lockCtx
+	}
+	start := time.Now()
+	// backups absent in _index.json still reference content addressed parts, so retention always use Walk
+	backupList, err := bd.BackupListFull(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := bd.GetClusterManifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	retentions, clusterRetentions := ApplyClusterRetentionPolicy(backupList, manifests, policy)
+	for _, retention := range retentions {
+		if retention.ObjectLocked {
+			bd.Log.Warnf("%s is not matched by retention policy, skip delete: %s", retention.Backup.BackupName, strings.Join(retention.Reasons, ", "))
+		}
+	}
 	bd.Log.WithFields(apexLog.Fields{
 		"operation": "RemoveOldBackups",
 		"duration":  utils.HumanizeDuration(time.Since(start)),
 	}).Info("calculate backup list for delete")
-	for _, backupToDelete := range backupsToDelete {
+	if dryRun {
+		return retentions, nil
+	}
+	var contentParts []string
+	deletedBackups := map[string]struct{}{}
+	for _, retention := range retentions {
+		if retention.Keep {
+			continue
+		}
+		backupToDelete := retention.Backup
 		startDelete := time.Now()
-		if err := bd.RemoveBackup(ctx, backupToDelete); err != nil {
+		refs, err := bd.getBackupContentPartsRefs(ctx, backupToDelete)
+		if err != nil {
+			bd.Log.Warnf("can't read %s refs, skip delete: %v", backupToDelete.BackupName, err)
+			continue
+		}
+		if err := bd.removeBackup(ctx, backupToDelete); err != nil {
 			bd.Log.Warnf("can't delete %s return error : %v", backupToDelete.BackupName, err)
+			continue
 		}
+		contentParts = append(contentParts, refs...)
+		deletedBackups[backupToDelete.BackupName] = struct{}{}
 		bd.Log.WithFields(apexLog.Fields{
 			"operation": "RemoveOldBackups",
 			"location":  "remote",
 			"backup":    backupToDelete.BackupName,
+			"reason":    strings.Join(retention.Reasons, ", "),
 			"duration":  utils.HumanizeDuration(time.Since(startDelete)),
 		}).Info("done")
 	}
+	keptBackups := make([]Backup, 0, len(backupList))
+	for _, backup := range backupList {
+		if _, isDeleted := deletedBackups[backup.BackupName]; !isDeleted {
+			keptBackups = append(keptBackups, backup)
+		}
+	}
+	bd.removeClusterManifests(ctx, clusterRetentions, keptBackups)
+	if err := bd.removeUnreferencedContentParts(ctx, contentParts, keptBackups); err != nil {
+		bd.Log.Warnf("can't remove unreferenced parts: %v", err)
+	}
+	if err := bd.removeOrphanedContentParts(ctx, keptBackups); err != nil {
+		bd.Log.Warnf("can't remove orphaned parts: %v", err)
+	}
 	bd.Log.WithFields(apexLog.Fields{"operation": "RemoveOldBackups", "duration": utils.HumanizeDuration(time.Since(start))}).Info("done")
-	return nil
+	return retentions, nil
+}
+
+// removeClusterManifests - delete manifests which are not kept by retention, manifest is deleted only when all own shard backups are deleted
+func (bd *BackupDestination) removeClusterManifests(ctx context.Context, clusterRetentions []ClusterRetention, keptBackups []Backup) {
+	keptBackupNames := make(map[string]struct{}, len(keptBackups))
+	for _, backup := range keptBackups {
+		keptBackupNames[backup.BackupName] = struct{}{}
+	}
+	for _, clusterRetention := range clusterRetentions {
+		if clusterRetention.Keep {
+			continue
+		}
+		isShardKept := false
+		for _, shard := range clusterRetention.Manifest.Shards {
+			if _, isKept := keptBackupNames[shard.BackupName]; isKept {
+				isShardKept = true
+				break
+			}
+		}
+		if isShardKept {
+			bd.Log.Warnf("cluster backup %s is not deleted, some shard backups are not deleted", clusterRetention.Manifest.BackupName)
+			continue
+		}
+		if err := bd.RemoveClusterManifest(ctx, clusterRetention.Manifest.BackupName); err != nil {
+			bd.Log.Warnf("can't delete cluster manifest %s: %v", clusterRetention.Manifest.BackupName, err)
+			continue
+		}
+		bd.Log.WithFields(apexLog.Fields{
+			"operation": "RemoveOldBackups",
+			"location":  "remote",
+			"backup":    clusterRetention.Manifest.BackupName,
+		}).Info("cluster manifest deleted")
+	}
 }
 
+// RemoveBackup - remove backup and content addressed parts which is not referenced by other backups anymore
 func (bd *BackupDestination) RemoveBackup(ctx context.Context, backup Backup) error {
-	if bd.Kind() == "SFTP" || bd.Kind() == "FTP" {
+	refs, err := bd.getBackupContentPartsRefs(ctx, backup)
+	if err != nil {
+		return fmt.Errorf("can't read %s refs: %v", backup.BackupName, err)
+	}
+	if err = bd.removeBackup(ctx, backup); err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	backupList, err := bd.BackupList(ctx, false, "")
+	if err != nil {
+		return err
+	}
+	return bd.removeUnreferencedContentParts(ctx, refs, backupList)
+}
+
+func (bd *BackupDestination) getBackupContentPartsRefs(ctx context.Context, backup Backup) ([]string, error) {
+	if backup.Legacy {
+		return nil, nil
+	}
+	return bd.GetContentPartsRefs(ctx, backup.BackupName)
+}
+
+func (bd *BackupDestination) removeBackup(ctx context.Context, backup Backup) error {
+	if !backup.Legacy {
+		bd.removeFromRemoteIndex(ctx, backup.BackupName)
+	}
+	if bd.Kind() == "SFTP" || bd.Kind() == "FTP" || bd.Kind() == "FS" || bd.Kind() == "WebDAV" || bd.Kind() == "HDFS" {
 		return bd.DeleteFile(ctx, backup.BackupName)
 	}
 	if backup.Legacy {
 		archiveName := fmt.Sprintf("%s.%s", backup.BackupName, backup.FileExtension)
 		return bd.DeleteFile(ctx, archiveName)
 	}
-	return bd.Walk(ctx, backup.BackupName+"/", true, func(ctx context.Context, f RemoteFile) error {
+	return bd.removePath(ctx, backup.BackupName, false)
+}
+
+// removePath - remove one file or all files with remotePath prefix
+func (bd *BackupDestination) removePath(ctx context.Context, remotePath string, isFile bool) error {
+	if isFile || bd.Kind() == "SFTP" || bd.Kind() == "FTP" || bd.Kind() == "FS" || bd.Kind() == "WebDAV" || bd.Kind() == "HDFS" {
+		return bd.DeleteFile(ctx, remotePath)
+	}
+	return bd.Walk(ctx, remotePath+"/", true, func(ctx context.Context, f RemoteFile) error {
 		if bd.Kind() == "azblob" {
 			if f.Size() > 0 || !f.LastModified().IsZero() {
-				return bd.DeleteFile(ctx, path.Join(backup.BackupName, f.Name()))
+				return bd.DeleteFile(ctx, path.Join(remotePath, f.Name()))
 			} else {
 				return nil
 			}
 		}
-		return bd.DeleteFile(ctx, path.Join(backup.BackupName, f.Name()))
+		return bd.DeleteFile(ctx, path.Join(remotePath, f.Name()))
 	})
 }
 
+// CopyObject - copy remote file as is to other destination with the same remote_storage, use server-side copy when storage supports it, otherwise stream data through the host
+func (bd *BackupDestination) CopyObject(ctx context.Context, key string, dst *BackupDestination) error {
+	if bd.Kind() != dst.Kind() {
+		return fmt.Errorf("can't copy %s from %s to %s remote storage", key, bd.Kind(), dst.Kind())
+	}
+	if copier, ok := bd.RemoteStorage.(RemoteCopier); ok {
+		return copier.CopyObject(ctx, key, dst.RemoteStorage)
+	}
+	// don't decrypt content, backup files shall be copied without any changes
+	r, err := bd.RemoteStorage.GetFileReader(ctx, key)
+	if err != nil {
+		return err
+	}
+	err = dst.RemoteStorage.PutFile(ctx, key, r)
+	if closeErr := r.Close(); closeErr != nil {
+		bd.Log.Warnf("can't close GetFileReader descriptor %v: %v", r, closeErr)
+	}
+	return err
+}
+
 func isLegacyBackup(backupName string) (bool, string, string) {
 	for _, suffix := range config.ArchiveExtensions {
 		if strings.HasSuffix(backupName, "."+suffix) {
@@ -116,8 +452,13 @@ func isLegacyBackup(backupName string) (bool, string, string) {
 	return false, backupName, ""
 }
 
+// metadataCacheFile - local cache is unique for each remote storage location, configs with different buckets or paths shall not share it
+func (bd *BackupDestination) metadataCacheFile() string {
+	return path.Join(os.TempDir(), fmt.Sprintf(".clickhouse-backup-metadata.cache.%s.%s", bd.Kind(), bd.remoteLocationHash()))
+}
+
 func (bd *BackupDestination) loadMetadataCache(ctx context.Context) (map[string]Backup, error) {
-	listCacheFile := path.Join(os.TempDir(), fmt.Sprintf(".clickhouse-backup-metadata.cache.%s", bd.Kind()))
+	listCacheFile := bd.metadataCacheFile()
 	listCache := map[string]Backup{}
 	if info, err := os.Stat(listCacheFile); os.IsNotExist(err) || info.IsDir() {
 		bd.Log.Debugf("%s not found, load %d elements", listCacheFile, len(listCache))
@@ -153,7 +494,7 @@ func (bd *BackupDestination) loadMetadataCache(ctx context.Context) (map[string]
 }
 
 func (bd *BackupDestination) saveMetadataCache(ctx context.Context, listCache map[string]Backup, actualList []Backup) error {
-	listCacheFile := path.Join(os.TempDir(), fmt.Sprintf(".clickhouse-backup-metadata.cache.%s", bd.Kind()))
+	listCacheFile := bd.metadataCacheFile()
 	f, err := os.OpenFile(listCacheFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		bd.Log.Warnf("can't open %s return error %v", listCacheFile, err)
@@ -200,14 +541,38 @@ func (bd *BackupDestination) saveMetadataCache(ctx context.Context, listCache ma
 	}
 }
 
+// BackupList - return valid backups from _index.json without Walk when parseMetadata and index was verified recently, otherwise Walk remote storage and rewrite stale index
 func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool, parseMetadataOnly string) ([]Backup, error) {
+	return bd.backupList(ctx, parseMetadata, parseMetadataOnly, true)
+}
+
+// BackupListFull - always Walk remote storage, result contains broken and legacy backups and backups changed without _index.json update
+func (bd *BackupDestination) BackupListFull(ctx context.Context, parseMetadata bool) ([]Backup, error) {
+	return bd.backupList(ctx, parseMetadata, "", false)
+}
+
+func (bd *BackupDestination) backupList(ctx context.Context, parseMetadata bool, parseMetadataOnly string, useIndex bool) ([]Backup, error) {
 	result := make([]Backup, 0)
 	metadataCacheLock.Lock()
 	defer metadataCacheLock.Unlock()
+	// remote index is shared by all hosts, it is more actual than local cache
+	indexBackups, verifiedAt, indexVersion, isIndexExists := bd.loadRemoteIndex(ctx)
+	isIndexVerified := isIndexExists && isRemoteIndexVerified(verifiedAt)
+	if _, isIndexed := indexBackups[parseMetadataOnly]; useIndex && parseMetadata && isIndexVerified && (parseMetadataOnly == "" || isIndexed) {
+		for _, backup := range indexBackups {
+			bd.rememberBackupEncryption(backup)
+			result = append(result, backup)
+		}
+		sortBackupList(result)
+		return result, nil
+	}
 	listCache, err := bd.loadMetadataCache(ctx)
 	if err != nil {
 		return nil, err
 	}
+	for backupName, backup := range indexBackups {
+		listCache[backupName] = backup
+	}
 	err = bd.Walk(ctx, "/", false, func(ctx context.Context, o RemoteFile) error {
 		// Legacy backup
 		if ok, backupName, fileExtension := isLegacyBackup(strings.TrimPrefix(o.Name(), "/")); ok {
@@ -224,8 +589,13 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 			return nil
 		}
 		backupName := strings.Trim(o.Name(), "/")
+		// shared store of content addressed parts, remote index, remote lock and cluster manifests are not backups
+		if backupName == ContentPartsPrefix || backupName == RemoteIndexFile || backupName == RemoteLockFile || backupName == ClusterManifestsPrefix {
+			return nil
+		}
 		if !parseMetadata || (parseMetadataOnly != "" && parseMetadataOnly != backupName) {
 			if cachedMetadata, isCached := listCache[backupName]; isCached {
+				bd.rememberBackupEncryption(cachedMetadata)
 				result = append(result, cachedMetadata)
 			} else {
 				result = append(result, Backup{
@@ -238,6 +608,7 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 			return nil
 		}
 		if cachedMetadata, isCached := listCache[backupName]; isCached {
+			bd.rememberBackupEncryption(cachedMetadata)
 			result = append(result, cachedMetadata)
 			return nil
 		}
@@ -304,26 +675,39 @@ func (bd *BackupDestination) BackupList(ctx context.Context, parseMetadata bool,
 			m, false, "", "", mf.LastModified(),
 		}
 		listCache[backupName] = goodBackup
+		bd.rememberBackupEncryption(goodBackup)
 		result = append(result, goodBackup)
 		return nil
 	})
+	// partial list can't be saved into local cache and remote index, backups absent in it will be treated as removed
 	if err != nil {
-		bd.Log.Warnf("BackupList bd.Walk return error: %v", err)
+		return nil, fmt.Errorf("BackupList bd.Walk return error: %v", err)
 	}
-	// sort by name for the same not parsed metadata.json
-	sort.SliceStable(result, func(i, j int) bool {
-		return result[i].BackupName > result[j].BackupName
-	})
-	sort.SliceStable(result, func(i, j int) bool {
-		return result[i].UploadDate.Before(result[j].UploadDate)
-	})
+	sortBackupList(result)
 	if err = bd.saveMetadataCache(ctx, listCache, result); err != nil {
 		return nil, err
 	}
-	return result, err
+	// listCache contains only valid backups which exist on remote storage after saveMetadataCache, it is complete only when all metadata.json parsed
+	if parseMetadata && parseMetadataOnly == "" && (!isIndexVerified || isRemoteIndexStale(indexBackups, listCache)) {
+		if err := bd.saveRemoteIndex(ctx, listCache, time.Now(), indexVersion); err != nil {
+			bd.Log.Warnf("can't save %s: %v", RemoteIndexFile, err)
+		}
+	}
+	return result, nil
+}
+
+// sortBackupList - sort by upload date, and by name for the same not parsed metadata.json
+func sortBackupList(backupList []Backup) {
+	sort.SliceStable(backupList, func(i, j int) bool {
+		return backupList[i].BackupName > backupList[j].BackupName
+	})
+	sort.SliceStable(backupList, func(i, j int) bool {
+		return backupList[i].UploadDate.Before(backupList[j].UploadDate)
+	})
 }
 
-func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remotePath string, localPath string) error {
+// DownloadCompressedStream - extract remote archive to localPath, when expectedChecksum is not empty compare it with checksum of whole archive after extract
+func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remotePath string, localPath string, expectedChecksum string) error {
 	if err := os.MkdirAll(localPath, 0750); err != nil {
 		return err
 	}
@@ -334,7 +718,7 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 	}
 	filesize := file.Size()
 
-	reader, err := bd.GetFileReaderWithLocalPath(ctx, remotePath, localPath)
+	reader, err := bd.RemoteStorage.GetFileReaderWithLocalPath(ctx, remotePath, localPath)
 	if err != nil {
 		return err
 	}
@@ -354,8 +738,12 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 	bar := progressbar.StartNewByteBar(!bd.disableProgressBar, filesize)
 	buf := buffer.New(BufferSize)
 	defer bar.Finish()
-	bufReader := nio.NewReader(reader, buf)
+	bufReader := nio.NewReader(bandwidthReadCloser{bd.bandwidth.DownloadReader(ctx, reader), reader}, buf)
 	proxyReader := bar.NewProxyReader(bufReader)
+	decryptedReader, err := NewDecryptReader(ctx, io.NopCloser(proxyReader), bd.keyProvider, bd.isPlainAllowed(remotePath))
+	if err != nil {
+		return fmt.Errorf("%s: %v", remotePath, err)
+	}
 	compressionFormat := bd.compressionFormat
 	if !checkArchiveExtension(path.Ext(remotePath), compressionFormat) {
 		bd.Log.Warnf("remote file backup extension %s not equal with %s", remotePath, compressionFormat)
@@ -365,7 +753,9 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 	if err != nil {
 		return err
 	}
-	if err := z.Extract(ctx, proxyReader, nil, func(ctx context.Context, file archiver.File) error {
+	checksum := newChecksumHash()
+	checksumReader := io.TeeReader(decryptedReader, checksum)
+	if err := z.Extract(ctx, checksumReader, nil, func(ctx context.Context, file archiver.File) error {
 		f, err := file.Open()
 		if err != nil {
 			return fmt.Errorf("can't open %s", file.NameInArchive)
@@ -404,20 +794,30 @@ func (bd *BackupDestination) DownloadCompressedStream(ctx context.Context, remot
 	}); err != nil {
 		return err
 	}
+	if expectedChecksum != "" {
+		// archive reader could stop before end of stream, tar padding and compression footer shall be included to checksum
+		if _, err := io.Copy(io.Discard, checksumReader); err != nil {
+			return err
+		}
+		if actualChecksum := formatChecksum(checksum); actualChecksum != expectedChecksum {
+			return &ChecksumMismatchError{RemoteKey: remotePath, Expected: expectedChecksum, Actual: actualChecksum}
+		}
+	}
 	return nil
 }
 
-func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLocalPath string, files []string, remotePath string) error {
+// UploadCompressedStream - archive files to remotePath, return checksum of whole archive and size of stored archive
+func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLocalPath string, files []string, remotePath string) (string, int64, error) {
 	if _, err := bd.StatFile(ctx, remotePath); err != nil {
 		if err != ErrNotFound && !os.IsNotExist(err) {
-			return err
+			return "", 0, err
 		}
 	}
 	var totalBytes int64
 	for _, filename := range files {
 		fInfo, err := os.Stat(path.Join(baseLocalPath, filename))
 		if err != nil {
-			return err
+			return "", 0, err
 		}
 		if fInfo.Mode().IsRegular() {
 			totalBytes += fInfo.Size()
@@ -428,8 +828,10 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 	pipeBuffer := buffer.New(BufferSize)
 	body, w := nio.Pipe(pipeBuffer)
 	g, ctx := errgroup.WithContext(context.Background())
+	checksum := newChecksumHash()
 
 	var writerErr, readerErr error
+	var storedSize int64
 	g.Go(func() error {
 		defer func() {
 			if writerErr != nil {
@@ -467,7 +869,7 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 			archiveFiles = append(archiveFiles, file)
 			//bd.Log.Debugf("add %s to archive %s", filePath, remotePath)
 		}
-		if writerErr = z.Archive(ctx, w, archiveFiles); writerErr != nil {
+		if writerErr = z.Archive(ctx, io.MultiWriter(w, checksum), archiveFiles); writerErr != nil {
 			return writerErr
 		}
 		return nil
@@ -484,13 +886,17 @@ func (bd *BackupDestination) UploadCompressedStream(ctx context.Context, baseLoc
 				}
 			}
 		}()
-		readerErr = bd.PutFile(ctx, remotePath, body)
+		storedSize, readerErr = bd.putFile(ctx, remotePath, bandwidthReadCloser{bd.bandwidth.UploadReader(ctx, body), body})
 		return readerErr
 	})
-	return g.Wait()
+	if err := g.Wait(); err != nil {
+		return "", 0, err
+	}
+	return formatChecksum(checksum), storedSize, nil
 }
 
-func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remotePath string, localPath string, RetriesOnFailure int, RetriesDuration time.Duration) error {
+// DownloadPath - download all files from remotePath, expectedChecksums keys are file names relative to remotePath
+func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remotePath string, localPath string, RetriesOnFailure int, RetriesDuration time.Duration, expectedChecksums map[string]string) error {
 	var bar *progressbar.Bar
 	if !bd.disableProgressBar {
 		totalBytes := size
@@ -531,7 +937,8 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remot
 				log.Error(err.Error())
 				return err
 			}
-			if _, err := io.CopyBuffer(dst, r, nil); err != nil {
+			checksum := newChecksumHash()
+			if _, err := io.CopyBuffer(io.MultiWriter(dst, checksum), bd.bandwidth.DownloadReader(ctx, r), nil); err != nil {
 				log.Error(err.Error())
 				return err
 			}
@@ -543,6 +950,11 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remot
 				log.Error(err.Error())
 				return err
 			}
+			if expectedChecksum, exists := expectedChecksums[strings.TrimPrefix(f.Name(), "/")]; exists {
+				if actualChecksum := formatChecksum(checksum); actualChecksum != expectedChecksum {
+					return &ChecksumMismatchError{RemoteKey: path.Join(remotePath, f.Name()), Expected: expectedChecksum, Actual: actualChecksum}
+				}
+			}
 			return nil
 		})
 		if err != nil {
@@ -555,7 +967,19 @@ func (bd *BackupDestination) DownloadPath(ctx context.Context, size int64, remot
 	})
 }
 
-func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLocalPath string, files []string, remotePath string, RetriesOnFailure int, RetriesDuration time.Duration) error {
+// UploadStream - upload r which can't be read again, so upload is not retried, return checksum and size of content and size of stored object
+func (bd *BackupDestination) UploadStream(ctx context.Context, key string, r io.ReadCloser) (string, int64, int64, error) {
+	checksum := newChecksumHash()
+	content := &sizeReadCloser{ReadCloser: r}
+	storedSize, err := bd.putFile(ctx, key, checksumReadCloser{bd.bandwidth.UploadReader(ctx, io.TeeReader(content, checksum)), content})
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return formatChecksum(checksum), content.size, storedSize, nil
+}
+
+// UploadPath - upload files one by one, return checksum and size of stored object for each file
+func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLocalPath string, files []string, remotePath string, RetriesOnFailure int, RetriesDuration time.Duration) (map[string]string, map[string]int64, error) {
 	var bar *progressbar.Bar
 	if !bd.disableProgressBar {
 		totalBytes := size
@@ -563,7 +987,7 @@ func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLoc
 			for _, filename := range files {
 				fInfo, err := os.Stat(path.Join(baseLocalPath, filename))
 				if err != nil {
-					return err
+					return nil, nil, err
 				}
 				if fInfo.Mode().IsRegular() {
 					totalBytes += fInfo.Size()
@@ -574,27 +998,39 @@ func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLoc
 		defer bar.Finish()
 	}
 
+	checksums := make(map[string]string, len(files))
+	sizes := make(map[string]int64, len(files))
 	for _, filename := range files {
 		f, err := os.Open(filepath.Clean(path.Join(baseLocalPath, filename)))
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 		closeFile := func() {
 			if err := f.Close(); err != nil {
 				bd.Log.Warnf("can't close UploadPath file descriptor %v: %v", f, err)
 			}
 		}
+		checksum := newChecksumHash()
+		var storedSize int64
 		retry := retrier.New(retrier.ConstantBackoff(RetriesOnFailure, RetriesDuration), nil)
 		err = retry.RunCtx(ctx, func(ctx context.Context) error {
-			return bd.PutFile(ctx, path.Join(remotePath, filename), f)
+			// each retry shall upload and calculate checksum from the beginning of file
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			checksum.Reset()
+			storedSize, err = bd.putFile(ctx, path.Join(remotePath, filename), checksumReadCloser{bd.bandwidth.UploadReader(ctx, io.TeeReader(f, checksum)), f})
+			return err
 		})
 		if err != nil {
 			closeFile()
-			return err
+			return nil, nil, err
 		}
+		checksums[filename] = formatChecksum(checksum)
+		sizes[filename] = storedSize
 		fi, err := f.Stat()
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 		if !bd.disableProgressBar {
 			bar.Add64(fi.Size())
@@ -602,12 +1038,19 @@ func (bd *BackupDestination) UploadPath(ctx context.Context, size int64, baseLoc
 		closeFile()
 	}
 
-	return nil
+	return checksums, sizes, nil
 }
 
 func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhouse.ClickHouse, calcMaxSize bool) (*BackupDestination, error) {
 	log := apexLog.WithField("logger", "NewBackupDestination")
-	var err error
+	keyProvider, err := NewKeyProvider(&cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	bandwidthLimits, err := newBandwidth(cfg)
+	if err != nil {
+		return nil, err
+	}
 	// https://github.com/AlexAkulov/clickhouse-backup/issues/404
 	if calcMaxSize {
 		maxFileSize, err := ch.CalculateMaxFileSize(ctx, cfg)
@@ -642,11 +1085,16 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 		}
 		azblobStorage.Config.BufferSize = bufferSize
 		return &BackupDestination{
-			azblobStorage,
-			log.WithField("logger", "azure"),
-			cfg.AzureBlob.CompressionFormat,
-			cfg.AzureBlob.CompressionLevel,
-			cfg.General.DisableProgressBar,
+			RemoteStorage:      azblobStorage,
+			Log:                log.WithField("logger", "azure"),
+			compressionFormat:  cfg.AzureBlob.CompressionFormat,
+			compressionLevel:   cfg.AzureBlob.CompressionLevel,
+			disableProgressBar: cfg.General.DisableProgressBar,
+			keyProvider:        keyProvider,
+			bandwidth:          bandwidthLimits,
+			lockTTL:            cfg.General.RemoteLockDuration,
+			contentPartsGrace:  cfg.General.PartsGraceDuration,
+			allowUnencrypted:   cfg.Encryption.AllowUnencrypted,
 		}, nil
 	case "s3":
 		partSize := cfg.S3.PartSize
@@ -671,11 +1119,16 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			return nil, err
 		}
 		return &BackupDestination{
-			s3Storage,
-			log.WithField("logger", "s3"),
-			cfg.S3.CompressionFormat,
-			cfg.S3.CompressionLevel,
-			cfg.General.DisableProgressBar,
+			RemoteStorage:      s3Storage,
+			Log:                log.WithField("logger", "s3"),
+			compressionFormat:  cfg.S3.CompressionFormat,
+			compressionLevel:   cfg.S3.CompressionLevel,
+			disableProgressBar: cfg.General.DisableProgressBar,
+			keyProvider:        keyProvider,
+			bandwidth:          bandwidthLimits,
+			lockTTL:            cfg.General.RemoteLockDuration,
+			contentPartsGrace:  cfg.General.PartsGraceDuration,
+			allowUnencrypted:   cfg.Encryption.AllowUnencrypted,
 		}, nil
 	case "gcs":
 		googleCloudStorage := &GCS{Config: &cfg.GCS}
@@ -684,11 +1137,16 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			return nil, err
 		}
 		return &BackupDestination{
-			googleCloudStorage,
-			log.WithField("logger", "gcs"),
-			cfg.GCS.CompressionFormat,
-			cfg.GCS.CompressionLevel,
-			cfg.General.DisableProgressBar,
+			RemoteStorage:      googleCloudStorage,
+			Log:                log.WithField("logger", "gcs"),
+			compressionFormat:  cfg.GCS.CompressionFormat,
+			compressionLevel:   cfg.GCS.CompressionLevel,
+			disableProgressBar: cfg.General.DisableProgressBar,
+			keyProvider:        keyProvider,
+			bandwidth:          bandwidthLimits,
+			lockTTL:            cfg.General.RemoteLockDuration,
+			contentPartsGrace:  cfg.General.PartsGraceDuration,
+			allowUnencrypted:   cfg.Encryption.AllowUnencrypted,
 		}, nil
 	case "cos":
 		tencentStorage := &COS{Config: &cfg.COS}
@@ -697,11 +1155,16 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			return nil, err
 		}
 		return &BackupDestination{
-			tencentStorage,
-			log.WithField("logger", "cos"),
-			cfg.COS.CompressionFormat,
-			cfg.COS.CompressionLevel,
-			cfg.General.DisableProgressBar,
+			RemoteStorage:      tencentStorage,
+			Log:                log.WithField("logger", "cos"),
+			compressionFormat:  cfg.COS.CompressionFormat,
+			compressionLevel:   cfg.COS.CompressionLevel,
+			disableProgressBar: cfg.General.DisableProgressBar,
+			keyProvider:        keyProvider,
+			bandwidth:          bandwidthLimits,
+			lockTTL:            cfg.General.RemoteLockDuration,
+			contentPartsGrace:  cfg.General.PartsGraceDuration,
+			allowUnencrypted:   cfg.Encryption.AllowUnencrypted,
 		}, nil
 	case "ftp":
 		ftpStorage := &FTP{
@@ -713,11 +1176,16 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			return nil, err
 		}
 		return &BackupDestination{
-			ftpStorage,
-			log.WithField("logger", "FTP"),
-			cfg.FTP.CompressionFormat,
-			cfg.FTP.CompressionLevel,
-			cfg.General.DisableProgressBar,
+			RemoteStorage:      ftpStorage,
+			Log:                log.WithField("logger", "FTP"),
+			compressionFormat:  cfg.FTP.CompressionFormat,
+			compressionLevel:   cfg.FTP.CompressionLevel,
+			disableProgressBar: cfg.General.DisableProgressBar,
+			keyProvider:        keyProvider,
+			bandwidth:          bandwidthLimits,
+			lockTTL:            cfg.General.RemoteLockDuration,
+			contentPartsGrace:  cfg.General.PartsGraceDuration,
+			allowUnencrypted:   cfg.Encryption.AllowUnencrypted,
 		}, nil
 	case "sftp":
 		sftpStorage := &SFTP{
@@ -728,11 +1196,79 @@ func NewBackupDestination(ctx context.Context, cfg *config.Config, ch *clickhous
 			return nil, err
 		}
 		return &BackupDestination{
-			sftpStorage,
-			log.WithField("logger", "SFTP"),
-			cfg.SFTP.CompressionFormat,
-			cfg.SFTP.CompressionLevel,
-			cfg.General.DisableProgressBar,
+			RemoteStorage:      sftpStorage,
+			Log:                log.WithField("logger", "SFTP"),
+			compressionFormat:  cfg.SFTP.CompressionFormat,
+			compressionLevel:   cfg.SFTP.CompressionLevel,
+			disableProgressBar: cfg.General.DisableProgressBar,
+			keyProvider:        keyProvider,
+			bandwidth:          bandwidthLimits,
+			lockTTL:            cfg.General.RemoteLockDuration,
+			contentPartsGrace:  cfg.General.PartsGraceDuration,
+			allowUnencrypted:   cfg.Encryption.AllowUnencrypted,
+		}, nil
+	case "fs":
+		fsStorage := &FS{
+			Config: &cfg.FS,
+			Log:    log.WithField("logger", "FS"),
+		}
+		fsStorage.Config.Path, err = ch.ApplyMacros(ctx, fsStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &BackupDestination{
+			RemoteStorage:      fsStorage,
+			Log:                log.WithField("logger", "FS"),
+			compressionFormat:  cfg.FS.CompressionFormat,
+			compressionLevel:   cfg.FS.CompressionLevel,
+			disableProgressBar: cfg.General.DisableProgressBar,
+			keyProvider:        keyProvider,
+			bandwidth:          bandwidthLimits,
+			lockTTL:            cfg.General.RemoteLockDuration,
+			contentPartsGrace:  cfg.General.PartsGraceDuration,
+			allowUnencrypted:   cfg.Encryption.AllowUnencrypted,
+		}, nil
+	case "webdav":
+		webdavStorage := &WebDAV{
+			Config: &cfg.WebDAV,
+			Log:    log.WithField("logger", "WebDAV"),
+		}
+		webdavStorage.Config.Path, err = ch.ApplyMacros(ctx, webdavStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &BackupDestination{
+			RemoteStorage:      webdavStorage,
+			Log:                log.WithField("logger", "WebDAV"),
+			compressionFormat:  cfg.WebDAV.CompressionFormat,
+			compressionLevel:   cfg.WebDAV.CompressionLevel,
+			disableProgressBar: cfg.General.DisableProgressBar,
+			keyProvider:        keyProvider,
+			bandwidth:          bandwidthLimits,
+			lockTTL:            cfg.General.RemoteLockDuration,
+			contentPartsGrace:  cfg.General.PartsGraceDuration,
+			allowUnencrypted:   cfg.Encryption.AllowUnencrypted,
+		}, nil
+	case "hdfs":
+		hdfsStorage := &HDFS{
+			Config: &cfg.HDFS,
+			Log:    log.WithField("logger", "HDFS"),
+		}
+		hdfsStorage.Config.Path, err = ch.ApplyMacros(ctx, hdfsStorage.Config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &BackupDestination{
+			RemoteStorage:      hdfsStorage,
+			Log:                log.WithField("logger", "HDFS"),
+			compressionFormat:  cfg.HDFS.CompressionFormat,
+			compressionLevel:   cfg.HDFS.CompressionLevel,
+			disableProgressBar: cfg.General.DisableProgressBar,
+			keyProvider:        keyProvider,
+			bandwidth:          bandwidthLimits,
+			lockTTL:            cfg.General.RemoteLockDuration,
+			contentPartsGrace:  cfg.General.PartsGraceDuration,
+			allowUnencrypted:   cfg.Encryption.AllowUnencrypted,
 		}, nil
 	default:
 		return nil, fmt.Errorf("storage type '%s' is not supported", cfg.General.RemoteStorage)
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/colinmarc/hdfs/v2"
+)
+
+// HDFS - Implement RemoteStorage over HDFS namenode RPC, only simple authentication supported
+type HDFS struct {
+	client *hdfs.Client
+	Config *config.HDFSConfig
+	Log    *apexLog.Entry
+}
+
+func (h *HDFS) Kind() string {
+	return "HDFS"
+}
+
+func (h *HDFS) Connect(ctx context.Context) error {
+	timeout, err := time.ParseDuration(h.Config.Timeout)
+	if err != nil {
+		return err
+	}
+	hdfsUser := h.Config.User
+	if hdfsUser == "" {
+		currentUser, err := user.Current()
+		if err != nil {
+			return err
+		}
+		hdfsUser = currentUser.Username
+	}
+	dialFunc := (&net.Dialer{Timeout: timeout, KeepAlive: timeout}).DialContext
+	h.client, err = hdfs.NewClient(hdfs.ClientOptions{
+		Addresses:           strings.Split(h.Config.Address, ","),
+		User:                hdfsUser,
+		UseDatanodeHostname: h.Config.UseDatanodeHostname,
+		NamenodeDialFunc:    dialFunc,
+		DatanodeDialFunc:    dialFunc,
+	})
+	if err != nil {
+		return fmt.Errorf("can't connect to hdfs namenode %s: %v", h.Config.Address, err)
+	}
+	return h.client.MkdirAll(path.Join("/", h.Config.Path), 0750)
+}
+
+func (h *HDFS) Close(ctx context.Context) error {
+	return h.client.Close()
+}
+
+func (h *HDFS) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	info, err := h.client.Stat(h.fullPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &hdfsFile{
+		size:         info.Size(),
+		lastModified: info.ModTime(),
+		name:         info.Name(),
+	}, nil
+}
+
+// DeleteFile - remove file or directory recursively, RemoveBackup pass backup name for this storage kind
+func (h *HDFS) DeleteFile(ctx context.Context, key string) error {
+	h.Log.Debugf("delete %s", h.fullPath(key))
+	return h.client.RemoveAll(h.fullPath(key))
+}
+
+// Walk - return only files when recursive, names relative to remotePath
+func (h *HDFS) Walk(ctx context.Context, remotePath string, recursive bool, process func(context.Context, RemoteFile) error) error {
+	dir := h.fullPath(remotePath)
+	if recursive {
+		err := h.client.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			relName, err := hdfsRelativeName(dir, filePath)
+			if err != nil {
+				return err
+			}
+			return process(ctx, &hdfsFile{
+				size:         info.Size(),
+				lastModified: info.ModTime(),
+				name:         relName,
+			})
+		})
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	entries, err := h.client.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err = process(ctx, &hdfsFile{
+			size:         entry.Size(),
+			lastModified: entry.ModTime(),
+			name:         entry.Name(),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HDFS) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return h.client.Open(h.fullPath(key))
+}
+
+func (h *HDFS) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return h.GetFileReader(ctx, key)
+}
+
+func (h *HDFS) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	filePath := h.fullPath(key)
+	if err := h.client.MkdirAll(path.Dir(filePath), 0750); err != nil {
+		return err
+	}
+	// HDFS files are immutable, overwrite the same way as object storages do
+	if err := h.client.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	w, err := h.client.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return err
+	}
+	// last block could still be replicated, namenode shall complete file after replication done
+	for retry := 0; ; retry++ {
+		err = w.Close()
+		if !hdfs.IsErrReplicating(err) || retry >= 10 {
+			return err
+		}
+		h.Log.Debugf("%s is replicating, retry close after %d ms", filePath, (retry+1)*100)
+		time.Sleep(time.Duration(retry+1) * 100 * time.Millisecond)
+	}
+}
+
+// fullPath - absolute HDFS path for key, keys are relative to hdfs->path
+func (h *HDFS) fullPath(key string) string {
+	return path.Join("/", h.Config.Path, key)
+}
+
+// hdfsRelativeName - name of walked file relative to walked directory, the same as other storages return from Walk
+func hdfsRelativeName(dir, filePath string) (string, error) {
+	relName, err := filepath.Rel(dir, filePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relName), nil
+}
+
+// Implement RemoteFile
+type hdfsFile struct {
+	size         int64
+	lastModified time.Time
+	name         string
+}
+
+func (file *hdfsFile) Size() int64 {
+	return file.size
+}
+
+func (file *hdfsFile) LastModified() time.Time {
+	return file.lastModified
+}
+
+func (file *hdfsFile) Name() string {
+	return file.name
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHDFSFullPath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		key      string
+		expected string
+	}{
+		{"", "backup1/metadata.json", "/backup1/metadata.json"},
+		{"/", "backup1/metadata.json", "/backup1/metadata.json"},
+		{"clickhouse/shard%2D1", "backup1/metadata.json", "/clickhouse/shard%2D1/backup1/metadata.json"},
+		{"/clickhouse/shard%2D1/", "/backup1/shadow/db/table%2D1/default_all_1_1_0.tar", "/clickhouse/shard%2D1/backup1/shadow/db/table%2D1/default_all_1_1_0.tar"},
+		{"/clickhouse", "/", "/clickhouse"},
+		{"/clickhouse", "", "/clickhouse"},
+		{"/clickhouse", "backup1/", "/clickhouse/backup1"},
+		{"/clickhouse", "parts/ab/abcdef.tar.checksums.json", "/clickhouse/parts/ab/abcdef.tar.checksums.json"},
+	}
+	for _, tc := range testCases {
+		h := &HDFS{Config: &config.HDFSConfig{Path: tc.path}}
+		assert.Equal(t, tc.expected, h.fullPath(tc.key), "path=%s key=%s", tc.path, tc.key)
+	}
+}
+
+func TestHDFSRelativeName(t *testing.T) {
+	h := &HDFS{Config: &config.HDFSConfig{Path: "/clickhouse/"}}
+	testCases := []struct {
+		remotePath string
+		filePath   string
+		expected   string
+	}{
+		{"backup1/", "/clickhouse/backup1/metadata.json", "metadata.json"},
+		{"/backup1", "/clickhouse/backup1/shadow/db/table%2D1/default_all_1_1_0.tar", "shadow/db/table%2D1/default_all_1_1_0.tar"},
+		{"/", "/clickhouse/backup1/metadata.json", "backup1/metadata.json"},
+		{"", "/clickhouse/_index.json", "_index.json"},
+	}
+	for _, tc := range testCases {
+		relName, err := hdfsRelativeName(h.fullPath(tc.remotePath), tc.filePath)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, relName, "remotePath=%s filePath=%s", tc.remotePath, tc.filePath)
+	}
+}
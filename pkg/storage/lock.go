@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// RemoteLockFile - lease object which prevents concurrent upload and delete from different hosts on the same remote storage
+const RemoteLockFile = "_lock.json"
+
+// lockVerifyDelay - pause between put and read back of lock object, when two hosts put lock at the same time, only one of them will read own owner
+var lockVerifyDelay = 2 * time.Second
+
+// RemoteLock - content of remote lock object
+type RemoteLock struct {
+	Owner      string    `json:"owner"`
+	Operation  string    `json:"operation"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// IsExpired - lease is not renewed by owner anymore, so lock could be overridden
+func (l *RemoteLock) IsExpired() bool {
+	return time.Now().After(l.ExpiresAt)
+}
+
+func (l *RemoteLock) String() string {
+	return fmt.Sprintf("%s by %s since %s, expires at %s", l.Operation, l.Owner, l.AcquiredAt.Format(time.RFC3339), l.ExpiresAt.Format(time.RFC3339))
+}
+
+type remoteLockLease struct {
+	lock RemoteLock
+	// cancel - stop operation which holds the lock when lease is lost
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// errRemoteLockLost - lock object was removed or overridden by another owner
+var errRemoteLockLost = errors.New("remote lock is lost")
+
+// newLockOwner - unique owner id for each acquired lock, hostname helps to find who holds the lock
+func newLockOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	random := make([]byte, 4)
+	if _, err = rand.Read(random); err != nil {
+		return fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), hex.EncodeToString(random))
+}
+
+// GetRemoteLock - return current remote lock, nil when remote storage is not locked
+func (bd *BackupDestination) GetRemoteLock(ctx context.Context) (*RemoteLock, error) {
+	lock, _, err := bd.getRemoteLock(ctx)
+	return lock, err
+}
+
+// getRemoteLock - return current remote lock with object version for conditional put, version is returned even when lock can't be parsed
+func (bd *BackupDestination) getRemoteLock(ctx context.Context) (*RemoteLock, string, error) {
+	body, version, err := bd.getServiceFile(ctx, RemoteLockFile)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	lock := RemoteLock{}
+	if err = json.Unmarshal(body, &lock); err != nil {
+		return nil, version, fmt.Errorf("can't parse %s: %v", RemoteLockFile, err)
+	}
+	return &lock, version, nil
+}
+
+// Lock - acquire remote lock for operation and renew it in background until returned unlock function is called
+// returned context is canceled when lease is lost, so operation which holds the lock shall use it instead of ctx
+// S3, GCS, Azure Blob and FS put lock with precondition on object version, so only one of concurrent hosts acquires it
+// other remote storages don't have conditional put, for them lock is put and read back after lockVerifyDelay, the last writer wins
+// lock is reentrant for the same BackupDestination, so RemoveOldBackups could be called during upload
+// forceUnlock overrides lock which is held by another owner, use it only when owner is dead and you can't wait for lease expiration
+func (bd *BackupDestination) Lock(ctx context.Context, operation string, forceUnlock bool) (context.Context, func(), error) {
+	if bd.lockTTL <= 0 || bd.lock != nil {
+		return ctx, func() {}, nil
+	}
+	existsLock, version, err := bd.getRemoteLock(ctx)
+	// lock object exists, but can't be read, so conditional put is not possible
+	isUnconditional := false
+	if err != nil {
+		if !forceUnlock {
+			return nil, nil, fmt.Errorf("can't read %s: %v, use --force-unlock to override it", RemoteLockFile, err)
+		}
+		bd.Log.Warnf("can't read %s: %v, will override it", RemoteLockFile, err)
+		isUnconditional = version == ""
+	} else if existsLock != nil {
+		if !existsLock.IsExpired() {
+			if !forceUnlock {
+				return nil, nil, fmt.Errorf("remote storage is locked: %s, wait until lock expired or use --force-unlock", existsLock)
+			}
+			bd.Log.Warnf("force unlock %s", existsLock)
+		} else {
+			bd.Log.Warnf("lock is expired and will be overridden: %s", existsLock)
+		}
+	}
+	now := time.Now().UTC()
+	lock := RemoteLock{
+		Owner:      newLockOwner(),
+		Operation:  operation,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(bd.lockTTL),
+	}
+	body, err := json.Marshal(&lock)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isUnconditional {
+		err = bd.PutFile(ctx, RemoteLockFile, io.NopCloser(bytes.NewReader(body)))
+	} else {
+		err = bd.putServiceFile(ctx, RemoteLockFile, body, version)
+	}
+	if err != nil {
+		if errors.Is(err, ErrPreconditionFailed) {
+			return nil, nil, fmt.Errorf("remote storage is concurrently locked, %s was changed after read", RemoteLockFile)
+		}
+		return nil, nil, fmt.Errorf("can't put %s: %v", RemoteLockFile, err)
+	}
+	if !bd.isConditionalPutSupported() {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(lockVerifyDelay):
+		}
+		actualLock, err := bd.GetRemoteLock(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't verify %s: %v", RemoteLockFile, err)
+		}
+		if actualLock == nil || actualLock.Owner != lock.Owner {
+			return nil, nil, fmt.Errorf("remote storage is concurrently locked: %v", actualLock)
+		}
+	}
+	bd.Log.Debugf("lock acquired %s", &lock)
+	leaseCtx, cancel := context.WithCancel(ctx)
+	lease := &remoteLockLease{
+		lock:   lock,
+		cancel: cancel,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	bd.lock = lease
+	go bd.renewRemoteLock(lease)
+	return leaseCtx, func() {
+		bd.unlock(lease)
+	}, nil
+}
+
+// renewRemoteLock - extend lease every lockTTL/3, operation is canceled when lock was taken by another owner or lease expires before next renewal
+func (bd *BackupDestination) renewRemoteLock(lease *remoteLockLease) {
+	defer close(lease.done)
+	ticker := time.NewTicker(bd.lockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lease.stop:
+			return
+		case <-ticker.C:
+			err := bd.renewLease(lease)
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, errRemoteLockLost) || time.Until(lease.lock.ExpiresAt) < bd.lockTTL/3 {
+				bd.Log.Errorf("lease of %s is lost, cancel %s: %v", RemoteLockFile, lease.lock.Operation, err)
+				lease.cancel()
+				return
+			}
+			bd.Log.Warnf("can't renew %s: %v", RemoteLockFile, err)
+		}
+	}
+}
+
+// renewLease - read lock back and put it with new expiration only when it still belongs to lease owner
+func (bd *BackupDestination) renewLease(lease *remoteLockLease) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bd.lockTTL/3)
+	defer cancel()
+	actualLock, version, err := bd.getRemoteLock(ctx)
+	if err != nil {
+		return err
+	}
+	if actualLock == nil || actualLock.Owner != lease.lock.Owner {
+		return fmt.Errorf("%w, actual lock: %v", errRemoteLockLost, actualLock)
+	}
+	lock := lease.lock
+	lock.ExpiresAt = time.Now().UTC().Add(bd.lockTTL)
+	body, err := json.Marshal(&lock)
+	if err != nil {
+		return err
+	}
+	if err = bd.putServiceFile(ctx, RemoteLockFile, body, version); err != nil {
+		if errors.Is(err, ErrPreconditionFailed) {
+			return fmt.Errorf("%w, %s was changed during renew", errRemoteLockLost, RemoteLockFile)
+		}
+		return err
+	}
+	lease.lock.ExpiresAt = lock.ExpiresAt
+	return nil
+}
+
+// unlock - remove lock object only when it still belongs to lease owner, it could be overridden with --force-unlock
+func (bd *BackupDestination) unlock(lease *remoteLockLease) {
+	close(lease.stop)
+	<-lease.done
+	lease.cancel()
+	bd.lock = nil
+	ctx := context.Background()
+	actualLock, err := bd.GetRemoteLock(ctx)
+	if err != nil {
+		bd.Log.Warnf("can't read %s during unlock: %v", RemoteLockFile, err)
+		return
+	}
+	if actualLock == nil || actualLock.Owner != lease.lock.Owner {
+		bd.Log.Warnf("lock %s was overridden by %v", lease.lock.Owner, actualLock)
+		return
+	}
+	if err = bd.DeleteFile(ctx, RemoteLockFile); err != nil {
+		bd.Log.Warnf("can't delete %s: %v", RemoteLockFile, err)
+		return
+	}
+	bd.Log.Debugf("lock released %s", &lease.lock)
+}
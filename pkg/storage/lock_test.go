@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFSRemoteLock(t *testing.T) {
+	ctx := context.Background()
+	fsPath := t.TempDir()
+	first := newTestFSDestination(t, withTestFSPath(fsPath), withTestLockTTL(time.Minute))
+	second := newTestFSDestination(t, withTestFSPath(fsPath), withTestLockTTL(time.Minute))
+
+	firstCtx, unlockFirst, err := first.Lock(ctx, "upload", false)
+	assert.NoError(t, err)
+	remoteLock, err := second.GetRemoteLock(ctx)
+	assert.NoError(t, err)
+	assert.NotNil(t, remoteLock)
+	assert.Equal(t, "upload", remoteLock.Operation)
+	assert.False(t, remoteLock.IsExpired())
+
+	// lock is reentrant for the same destination
+	reentrantCtx, unlockReentrant, err := first.Lock(firstCtx, "RemoveOldBackups", false)
+	assert.NoError(t, err)
+	assert.Equal(t, firstCtx, reentrantCtx)
+	unlockReentrant()
+	remoteLock, err = second.GetRemoteLock(ctx)
+	assert.NoError(t, err)
+	assert.NotNil(t, remoteLock)
+
+	_, _, err = second.Lock(ctx, "delete", false)
+	assert.Error(t, err)
+	_, unlockSecond, err := second.Lock(ctx, "delete", true)
+	assert.NoError(t, err)
+
+	// previous owner can't renew overridden lock
+	assert.ErrorIs(t, first.renewLease(first.lock), errRemoteLockLost)
+
+	// overridden lock shall not be removed by previous owner
+	unlockFirst()
+	remoteLock, err = second.GetRemoteLock(ctx)
+	assert.NoError(t, err)
+	assert.NotNil(t, remoteLock)
+	assert.Equal(t, "delete", remoteLock.Operation)
+
+	unlockSecond()
+	remoteLock, err = first.GetRemoteLock(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, remoteLock)
+}
+
+func TestFSRemoteLockConcurrentPut(t *testing.T) {
+	ctx := context.Background()
+	log := apexLog.WithField("logger", "FS")
+	fs := &FS{Config: &config.FSConfig{Path: t.TempDir()}, Log: log}
+	assert.NoError(t, fs.Connect(ctx))
+
+	assert.NoError(t, fs.PutFileIfVersion(ctx, RemoteLockFile, []byte("first"), ""))
+	assert.ErrorIs(t, fs.PutFileIfVersion(ctx, RemoteLockFile, []byte("second"), ""), ErrPreconditionFailed)
+	body, version, err := fs.GetFileVersioned(ctx, RemoteLockFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(body))
+	assert.NoError(t, fs.PutFileIfVersion(ctx, RemoteLockFile, []byte("third"), version))
+	// version was changed by previous put
+	assert.ErrorIs(t, fs.PutFileIfVersion(ctx, RemoteLockFile, []byte("fourth"), version), ErrPreconditionFailed)
+	body, _, err = fs.GetFileVersioned(ctx, RemoteLockFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "third", string(body))
+}
+
+func TestFSRemoteLockLost(t *testing.T) {
+	ctx := context.Background()
+	fsPath := t.TempDir()
+	first := newTestFSDestination(t, withTestFSPath(fsPath), withTestLockTTL(300*time.Millisecond))
+	second := newTestFSDestination(t, withTestFSPath(fsPath), withTestLockTTL(time.Minute))
+
+	firstCtx, unlockFirst, err := first.Lock(ctx, "upload", false)
+	assert.NoError(t, err)
+	defer unlockFirst()
+	_, unlockSecond, err := second.Lock(ctx, "delete", true)
+	assert.NoError(t, err)
+	defer unlockSecond()
+
+	// operation which holds overridden lock is canceled on next renew
+	select {
+	case <-firstCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context of lost lease is not canceled")
+	}
+}
@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+)
+
+const (
+	// ContentPartsPrefix - shared prefix for content addressed parts, placed near backups and can't be used as backup name
+	ContentPartsPrefix = "parts"
+	// ContentPartsRefsFile - list of content addressed parts keys which referenced by backup, stored inside backup
+	ContentPartsRefsFile = "parts.json"
+	// ContentPartChecksumsSuffix - sidecar near content addressed part with checksums and stored sizes, backups which reuse existing part get them from sidecar
+	ContentPartChecksumsSuffix = ".checksums.json"
+)
+
+// ContentPartChecksums - content of part sidecar, keys are the same as returned by UploadCompressedStream and UploadPath for part
+type ContentPartChecksums struct {
+	Checksums map[string]string `json:"checksums"`
+	Sizes     map[string]int64  `json:"sizes"`
+}
+
+// GetContentPartKey - return remote key of part in shared parts store, key based on checksums.txt and columns.txt of part, archiveExtension is empty for directory format
+func GetContentPartKey(partPath string, archiveExtension string) (string, error) {
+	hash := sha256.New()
+	for _, fileName := range []string{"checksums.txt", "columns.txt"} {
+		content, err := os.ReadFile(path.Join(partPath, fileName))
+		if err != nil {
+			if os.IsNotExist(err) && fileName != "checksums.txt" {
+				continue
+			}
+			return "", err
+		}
+		hash.Write([]byte(fileName))
+		hash.Write(content)
+	}
+	hashStr := hex.EncodeToString(hash.Sum(nil))
+	key := path.Join(ContentPartsPrefix, hashStr[:2], hashStr)
+	if archiveExtension != "" {
+		key += "." + archiveExtension
+	}
+	return key, nil
+}
+
+// IsContentPartArchive - content addressed parts stored as one archive or as directory with the same files as local part
+func IsContentPartArchive(key string) bool {
+	return path.Ext(key) != ""
+}
+
+// IsContentPartExists - directory part is complete only when checksums.txt exists, cause it uploaded last
+func (bd *BackupDestination) IsContentPartExists(ctx context.Context, key string) (bool, error) {
+	statKey := key
+	if !IsContentPartArchive(key) {
+		statKey = path.Join(key, "checksums.txt")
+	}
+	_, err := bd.StatFile(ctx, statKey)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutContentPartChecksums - upload sidecar after part upload finished
+func (bd *BackupDestination) PutContentPartChecksums(ctx context.Context, key string, checksums map[string]string, sizes map[string]int64) error {
+	body, err := json.Marshal(ContentPartChecksums{Checksums: checksums, Sizes: sizes})
+	if err != nil {
+		return err
+	}
+	return bd.PutFile(ctx, key+ContentPartChecksumsSuffix, io.NopCloser(bytes.NewReader(body)))
+}
+
+// GetContentPartChecksums - return checksums and stored sizes from part sidecar, nil when part was uploaded without sidecar
+func (bd *BackupDestination) GetContentPartChecksums(ctx context.Context, key string) (map[string]string, map[string]int64, error) {
+	sidecarKey := key + ContentPartChecksumsSuffix
+	if _, err := bd.StatFile(ctx, sidecarKey); err != nil {
+		if err == ErrNotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	r, err := bd.GetFileReader(ctx, sidecarKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := io.ReadAll(r)
+	if closeErr := r.Close(); closeErr != nil {
+		bd.Log.Warnf("can't close GetFileReader descriptor %v: %v", r, closeErr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	sidecar := ContentPartChecksums{}
+	if err = json.Unmarshal(body, &sidecar); err != nil {
+		return nil, nil, fmt.Errorf("can't parse %s: %v", sidecarKey, err)
+	}
+	return sidecar.Checksums, sidecar.Sizes, nil
+}
+
+// PutContentPartsRefs - upload list of content addressed parts referenced by backup, shall be uploaded before metadata.json
+func (bd *BackupDestination) PutContentPartsRefs(ctx context.Context, backupName string, keys []string) error {
+	sort.Strings(keys)
+	body, err := json.MarshalIndent(keys, "", "\t")
+	if err != nil {
+		return err
+	}
+	return bd.PutFile(ctx, path.Join(backupName, ContentPartsRefsFile), io.NopCloser(bytes.NewReader(body)))
+}
+
+// GetContentPartsRefs - return list of content addressed parts referenced by backup, empty when backup doesn't use shared parts store
+func (bd *BackupDestination) GetContentPartsRefs(ctx context.Context, backupName string) ([]string, error) {
+	refsFile := path.Join(backupName, ContentPartsRefsFile)
+	if _, err := bd.StatFile(ctx, refsFile); err != nil {
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r, err := bd.GetFileReader(ctx, refsFile)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(r)
+	if closeErr := r.Close(); closeErr != nil {
+		bd.Log.Warnf("can't close GetFileReader descriptor %v: %v", r, closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err = json.Unmarshal(body, &keys); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", refsFile, err)
+	}
+	return keys, nil
+}
+
+// CopyContentPart - copy content addressed part to other destination when it doesn't exist there, checksums.txt of directory part copied last
+func (bd *BackupDestination) CopyContentPart(ctx context.Context, key string, dst *BackupDestination) error {
+	exists, err := dst.IsContentPartExists(ctx, key)
+	if err != nil || exists {
+		return err
+	}
+	if _, err = bd.StatFile(ctx, key+ContentPartChecksumsSuffix); err == nil {
+		// sidecar is copied before part, so it exists when part is found as existing on destination
+		if err = bd.CopyObject(ctx, key+ContentPartChecksumsSuffix, dst); err != nil {
+			return err
+		}
+	} else if err != ErrNotFound {
+		return err
+	}
+	if IsContentPartArchive(key) {
+		return bd.CopyObject(ctx, key, dst)
+	}
+	var files []string
+	err = bd.Walk(ctx, key+"/", true, func(ctx context.Context, f RemoteFile) error {
+		if bd.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
+			return nil
+		}
+		files = append(files, strings.Trim(f.Name(), "/"))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[j] == "checksums.txt" && files[i] != "checksums.txt"
+	})
+	for _, f := range files {
+		if err := bd.CopyObject(ctx, path.Join(key, f), dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isContentPartsGCAllowed - without remote lock upload could reuse part which is removed at the same time, so parts are never removed when remote_lock_ttl is 0
+func (bd *BackupDestination) isContentPartsGCAllowed() bool {
+	if bd.lockTTL <= 0 {
+		bd.Log.Warnf("remote_lock_ttl is 0, content addressed parts in %s/ will not remove", ContentPartsPrefix)
+		return false
+	}
+	return true
+}
+
+// getContentPartsReferences - return all content addressed parts referenced by backups, broken backups are also counted, cause they could be uploading right now
+func (bd *BackupDestination) getContentPartsReferences(ctx context.Context, backups []Backup) (map[string]struct{}, error) {
+	references := map[string]struct{}{}
+	for _, backup := range backups {
+		if backup.Legacy {
+			continue
+		}
+		refs, err := bd.GetContentPartsRefs(ctx, backup.BackupName)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s refs: %v", backup.BackupName, err)
+		}
+		for _, key := range refs {
+			references[key] = struct{}{}
+		}
+	}
+	return references, nil
+}
+
+// removeContentPart - remove part and its sidecar
+func (bd *BackupDestination) removeContentPart(ctx context.Context, key string) error {
+	if err := bd.removePath(ctx, key, IsContentPartArchive(key)); err != nil {
+		return err
+	}
+	if _, err := bd.StatFile(ctx, key+ContentPartChecksumsSuffix); err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return bd.DeleteFile(ctx, key+ContentPartChecksumsSuffix)
+}
+
+// removeUnreferencedContentParts - delete candidates which are not referenced by any of backups
+func (bd *BackupDestination) removeUnreferencedContentParts(ctx context.Context, candidates []string, backups []Backup) error {
+	if len(candidates) == 0 || !bd.isContentPartsGCAllowed() {
+		return nil
+	}
+	start := time.Now()
+	references, err := bd.getContentPartsReferences(ctx, backups)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	processed := make(map[string]struct{}, len(candidates))
+	for _, key := range candidates {
+		if _, isProcessed := processed[key]; isProcessed {
+			continue
+		}
+		processed[key] = struct{}{}
+		if _, isReferenced := references[key]; isReferenced {
+			continue
+		}
+		if err := bd.removeContentPart(ctx, key); err != nil {
+			return fmt.Errorf("can't remove %s: %v", key, err)
+		}
+		removed++
+	}
+	bd.Log.WithFields(apexLog.Fields{
+		"operation": "removeUnreferencedContentParts",
+		"removed":   removed,
+		"kept":      len(processed) - removed,
+		"duration":  utils.HumanizeDuration(time.Since(start)),
+	}).Info("done")
+	return nil
+}
+
+// getContentPartKeyByFile - return content addressed part key for file inside parts/ prefix, archive part is file itself, directory part contains files, sidecar belongs to own part
+func getContentPartKeyByFile(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if strings.HasSuffix(name, ContentPartChecksumsSuffix) {
+		return path.Join(ContentPartsPrefix, strings.TrimSuffix(name, ContentPartChecksumsSuffix))
+	}
+	// parts/<hash[:2]>/<hash>[.<archive extension>][/<file>]
+	fields := strings.SplitN(name, "/", 3)
+	if len(fields) < 2 {
+		return path.Join(ContentPartsPrefix, name)
+	}
+	return path.Join(ContentPartsPrefix, fields[0], fields[1])
+}
+
+// removeOrphanedContentParts - delete parts which are not referenced by any of backups and uploaded earlier than grace period, they are left by upload which failed before parts.json was uploaded
+func (bd *BackupDestination) removeOrphanedContentParts(ctx context.Context, backups []Backup) error {
+	start := time.Now()
+	lastModified := map[string]time.Time{}
+	err := bd.Walk(ctx, ContentPartsPrefix+"/", true, func(ctx context.Context, f RemoteFile) error {
+		if bd.Kind() == "SFTP" && (f.Name() == "." || f.Name() == "..") {
+			return nil
+		}
+		key := getContentPartKeyByFile(f.Name())
+		if f.LastModified().After(lastModified[key]) {
+			lastModified[key] = f.LastModified()
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't list %s: %v", ContentPartsPrefix, err)
+	}
+	if len(lastModified) == 0 || !bd.isContentPartsGCAllowed() {
+		return nil
+	}
+	references, err := bd.getContentPartsReferences(ctx, backups)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for key, modified := range lastModified {
+		if _, isReferenced := references[key]; isReferenced || time.Since(modified) < bd.contentPartsGrace {
+			continue
+		}
+		if err := bd.removeContentPart(ctx, key); err != nil {
+			return fmt.Errorf("can't remove %s: %v", key, err)
+		}
+		removed++
+	}
+	bd.Log.WithFields(apexLog.Fields{
+		"operation": "removeOrphanedContentParts",
+		"removed":   removed,
+		"kept":      len(lastModified) - removed,
+		"duration":  utils.HumanizeDuration(time.Since(start)),
+	}).Info("done")
+	return nil
+}
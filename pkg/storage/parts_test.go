@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetContentPartKey(t *testing.T) {
+	partPath := t.TempDir()
+	_, err := GetContentPartKey(partPath, "tar")
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(path.Join(partPath, "checksums.txt"), []byte("checksums format version: 4"), 0640))
+	archiveKey, err := GetContentPartKey(partPath, "tar")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(archiveKey, ContentPartsPrefix+"/"))
+	assert.True(t, IsContentPartArchive(archiveKey))
+
+	directoryKey, err := GetContentPartKey(partPath, "")
+	assert.NoError(t, err)
+	assert.False(t, IsContentPartArchive(directoryKey))
+	assert.Equal(t, archiveKey, directoryKey+".tar")
+
+	assert.NoError(t, os.WriteFile(path.Join(partPath, "columns.txt"), []byte("columns format version: 1"), 0640))
+	keyWithColumns, err := GetContentPartKey(partPath, "tar")
+	assert.NoError(t, err)
+	assert.NotEqual(t, archiveKey, keyWithColumns)
+}
+
+func TestFSRemoveBackupContentParts(t *testing.T) {
+	ctx := context.Background()
+	bd := newTestFSDestination(t, withTestLockTTL(time.Minute))
+	sharedPart := "parts/aa/aaaa.tar"
+	ownPart := "parts/bb/bbbb.tar"
+	for _, key := range []string{sharedPart, ownPart, "backup1/metadata.json", "backup2/metadata.json"} {
+		assert.NoError(t, bd.PutFile(ctx, key, io.NopCloser(bytes.NewReader([]byte(key)))))
+	}
+	assert.NoError(t, bd.PutContentPartsRefs(ctx, "backup1", []string{sharedPart}))
+	assert.NoError(t, bd.PutContentPartsRefs(ctx, "backup2", []string{ownPart, sharedPart}))
+
+	backupList, err := bd.BackupList(ctx, false, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(backupList))
+
+	refs, err := bd.GetContentPartsRefs(ctx, "backup2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{sharedPart, ownPart}, refs)
+
+	assert.NoError(t, bd.RemoveBackup(ctx, Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "backup2"}}))
+	exists, err := bd.IsContentPartExists(ctx, sharedPart)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = bd.IsContentPartExists(ctx, ownPart)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, bd.RemoveBackup(ctx, Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "backup1"}}))
+	exists, err = bd.IsContentPartExists(ctx, sharedPart)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestGetContentPartKeyByFile(t *testing.T) {
+	testCases := map[string]string{
+		"aa/aaaa.tar.lz4":                  "parts/aa/aaaa.tar.lz4",
+		"aa/aaaa.tar.lz4.checksums.json":   "parts/aa/aaaa.tar.lz4",
+		"bb/bbbb/checksums.txt":            "parts/bb/bbbb",
+		"bb/bbbb/projection.proj/data.bin": "parts/bb/bbbb",
+		"bb/bbbb.checksums.json":           "parts/bb/bbbb",
+		"/cc/cccc/data.bin":                "parts/cc/cccc",
+	}
+	for name, expected := range testCases {
+		assert.Equal(t, expected, getContentPartKeyByFile(name), name)
+	}
+}
+
+func TestFSContentPartChecksums(t *testing.T) {
+	ctx := context.Background()
+	bd := newTestFSDestination(t)
+	key := "parts/aa/aaaa"
+	checksums, sizes, err := bd.GetContentPartChecksums(ctx, key)
+	assert.NoError(t, err)
+	assert.Nil(t, checksums)
+	assert.Nil(t, sizes)
+
+	assert.NoError(t, bd.PutFile(ctx, path.Join(key, "checksums.txt"), io.NopCloser(bytes.NewReader([]byte("checksums")))))
+	assert.NoError(t, bd.PutContentPartChecksums(ctx, key, map[string]string{"checksums.txt": "1"}, map[string]int64{"checksums.txt": 9}))
+	checksums, sizes, err = bd.GetContentPartChecksums(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"checksums.txt": "1"}, checksums)
+	assert.Equal(t, map[string]int64{"checksums.txt": 9}, sizes)
+
+	other := newTestFSDestination(t)
+	assert.NoError(t, bd.CopyContentPart(ctx, key, other))
+	checksums, _, err = other.GetContentPartChecksums(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"checksums.txt": "1"}, checksums)
+
+	assert.NoError(t, bd.removeContentPart(ctx, key))
+	exists, err := bd.IsContentPartExists(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	checksums, _, err = bd.GetContentPartChecksums(ctx, key)
+	assert.NoError(t, err)
+	assert.Nil(t, checksums)
+}
+
+func TestFSRemoveOrphanedContentParts(t *testing.T) {
+	ctx := context.Background()
+	remotePath := t.TempDir()
+	bd := newTestFSDestination(t, withTestFSPath(remotePath), withTestContentPartsGrace(time.Hour))
+	referencedPart := "parts/aa/aaaa.tar"
+	orphanedPart := "parts/bb/bbbb"
+	freshPart := "parts/cc/cccc.tar"
+	for _, key := range []string{referencedPart, path.Join(orphanedPart, "data.bin"), path.Join(orphanedPart, "checksums.txt"), freshPart, "backup1/metadata.json"} {
+		assert.NoError(t, bd.PutFile(ctx, key, io.NopCloser(bytes.NewReader([]byte(key)))))
+	}
+	assert.NoError(t, bd.PutContentPartChecksums(ctx, orphanedPart, map[string]string{}, map[string]int64{}))
+	assert.NoError(t, bd.PutContentPartsRefs(ctx, "backup1", []string{referencedPart}))
+	old := time.Now().Add(-2 * time.Hour)
+	for _, key := range []string{referencedPart, path.Join(orphanedPart, "data.bin"), path.Join(orphanedPart, "checksums.txt"), orphanedPart + ContentPartChecksumsSuffix} {
+		assert.NoError(t, os.Chtimes(path.Join(remotePath, key), old, old))
+	}
+	backupList, err := bd.BackupList(ctx, false, "")
+	assert.NoError(t, err)
+
+	// parts are never removed without remote lock
+	assert.NoError(t, bd.removeOrphanedContentParts(ctx, backupList))
+	exists, err := bd.IsContentPartExists(ctx, orphanedPart)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	bd.lockTTL = time.Minute
+	assert.NoError(t, bd.removeOrphanedContentParts(ctx, backupList))
+	for key, expected := range map[string]bool{referencedPart: true, orphanedPart: false, freshPart: true} {
+		exists, err = bd.IsContentPartExists(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, exists, key)
+	}
+	_, err = bd.StatFile(ctx, orphanedPart+ContentPartChecksumsSuffix)
+	assert.Equal(t, ErrNotFound, err)
+}
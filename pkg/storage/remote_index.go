@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+)
+
+// RemoteIndexFile - index of all valid backups on remote storage, allow BackupList to skip read each metadata.json
+const RemoteIndexFile = "_index.json"
+
+// remoteIndexVerifyInterval - BackupList returns index without Walk during this interval after last Walk, so backups changed without index update become visible after it
+const remoteIndexVerifyInterval = time.Hour
+
+type remoteIndex struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	// VerifiedAt - time of last Walk which compared index with remote storage, upload and delete don't change it
+	VerifiedAt time.Time `json:"verified_at"`
+	Backups    []Backup  `json:"backups"`
+}
+
+// remoteLocation - unique remote storage location, used as a key for local metadata cache
+func (bd *BackupDestination) remoteLocation() string {
+	switch s := bd.RemoteStorage.(type) {
+	case *S3:
+		return fmt.Sprintf("s3://%s/%s/%s", s.Config.Endpoint, s.Config.Bucket, s.Config.Path)
+	case *GCS:
+		return fmt.Sprintf("gcs://%s/%s", s.Config.Bucket, s.Config.Path)
+	case *COS:
+		return fmt.Sprintf("cos://%s/%s", s.Config.RowURL, s.Config.Path)
+	case *AzureBlob:
+		return fmt.Sprintf("azblob://%s.%s/%s/%s", s.Config.AccountName, s.Config.EndpointSuffix, s.Config.Container, s.Config.Path)
+	case *FTP:
+		return fmt.Sprintf("ftp://%s/%s", s.Config.Address, s.Config.Path)
+	case *SFTP:
+		return fmt.Sprintf("sftp://%s:%d/%s", s.Config.Address, s.Config.Port, s.Config.Path)
+	case *FS:
+		return fmt.Sprintf("fs://%s", s.Config.Path)
+	case *WebDAV:
+		return fmt.Sprintf("webdav://%s/%s", s.Config.URL, s.Config.Path)
+	case *HDFS:
+		return fmt.Sprintf("hdfs://%s/%s", s.Config.Address, s.Config.Path)
+	}
+	return bd.Kind()
+}
+
+func (bd *BackupDestination) remoteLocationHash() string {
+	hash := sha256.Sum256([]byte(bd.remoteLocation()))
+	return hex.EncodeToString(hash[:8])
+}
+
+// loadRemoteIndex - return backups from remote index, time of last Walk and index version for saveRemoteIndex, last value is false when index not exists or can't be read, so it shall be rebuilt
+func (bd *BackupDestination) loadRemoteIndex(ctx context.Context) (map[string]Backup, time.Time, string, bool) {
+	backups := map[string]Backup{}
+	body, version, err := bd.getServiceFile(ctx, RemoteIndexFile)
+	if err != nil {
+		if err != ErrNotFound {
+			bd.Log.Warnf("can't read %s: %v", RemoteIndexFile, err)
+		}
+		return backups, time.Time{}, "", false
+	}
+	index := remoteIndex{}
+	if err = json.Unmarshal(body, &index); err != nil {
+		bd.Log.Warnf("can't parse %s: %v", RemoteIndexFile, err)
+		return backups, time.Time{}, version, false
+	}
+	for _, backup := range index.Backups {
+		backups[backup.BackupName] = backup
+	}
+	bd.Log.Debugf("%s load %d elements", RemoteIndexFile, len(backups))
+	return backups, index.VerifiedAt, version, true
+}
+
+// isRemoteIndexVerified - index could be returned without Walk, when it was compared with remote storage less than remoteIndexVerifyInterval ago
+func isRemoteIndexVerified(verifiedAt time.Time) bool {
+	return !verifiedAt.IsZero() && time.Since(verifiedAt) < remoteIndexVerifyInterval
+}
+
+// saveRemoteIndex - replace whole index with one put, so readers always get full index, ErrPreconditionFailed means index was changed after loadRemoteIndex returned version
+func (bd *BackupDestination) saveRemoteIndex(ctx context.Context, backups map[string]Backup, verifiedAt time.Time, version string) error {
+	index := remoteIndex{
+		UpdatedAt:  time.Now().UTC(),
+		VerifiedAt: verifiedAt.UTC(),
+		Backups:    make([]Backup, 0, len(backups)),
+	}
+	for _, backup := range backups {
+		index.Backups = append(index.Backups, backup)
+	}
+	body, err := json.Marshal(&index)
+	if err != nil {
+		return err
+	}
+	if err = bd.putServiceFile(ctx, RemoteIndexFile, body, version); err != nil {
+		return err
+	}
+	bd.Log.Debugf("%s save %d elements", RemoteIndexFile, len(backups))
+	return nil
+}
+
+// isRemoteIndexStale - index is stale when set of backups is different with actual valid backups
+func isRemoteIndexStale(index map[string]Backup, actual map[string]Backup) bool {
+	if len(index) != len(actual) {
+		return true
+	}
+	for backupName := range actual {
+		if _, exists := index[backupName]; !exists {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIndexUpdateRetries - attempts to apply change when index is concurrently changed by another host
+const remoteIndexUpdateRetries = 5
+
+// updateRemoteIndex - apply change to existing remote index, when index not exists it will be created by next BackupList
+// read-modify-write is retried with conditional put, remote storages without conditional put serialize writers with remote lock
+func (bd *BackupDestination) updateRemoteIndex(ctx context.Context, update func(backups map[string]Backup)) {
+	if !bd.isConditionalPutSupported() {
+		lockCtx, unlock, err := bd.Lock(ctx, "update "+RemoteIndexFile, false)
+		if err != nil {
+			bd.Log.Warnf("can't update %s: %v", RemoteIndexFile, err)
+			return
+		}
+		defer unlock()
+		ctx = lockCtx
+	}
+	metadataCacheLock.Lock()
+	defer metadataCacheLock.Unlock()
+	for attempt := 1; ; attempt++ {
+		backups, verifiedAt, version, isExists := bd.loadRemoteIndex(ctx)
+		if !isExists {
+			return
+		}
+		update(backups)
+		err := bd.saveRemoteIndex(ctx, backups, verifiedAt, version)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, ErrPreconditionFailed) || attempt >= remoteIndexUpdateRetries {
+			bd.Log.Warnf("can't update %s: %v", RemoteIndexFile, err)
+			return
+		}
+		bd.Log.Debugf("%s was changed concurrently, retry update", RemoteIndexFile)
+	}
+}
+
+// AddToRemoteIndex - add uploaded backup to remote index, shall be called after metadata.json uploaded
+func (bd *BackupDestination) AddToRemoteIndex(ctx context.Context, backupMetadata metadata.BackupMetadata) {
+	mf, err := bd.StatFile(ctx, path.Join(backupMetadata.BackupName, "metadata.json"))
+	if err != nil {
+		bd.Log.Warnf("can't stat %s/metadata.json, skip %s update: %v", backupMetadata.BackupName, RemoteIndexFile, err)
+		return
+	}
+	bd.updateRemoteIndex(ctx, func(backups map[string]Backup) {
+		backups[backupMetadata.BackupName] = Backup{
+			BackupMetadata: backupMetadata,
+			UploadDate:     mf.LastModified(),
+		}
+	})
+}
+
+func (bd *BackupDestination) removeFromRemoteIndex(ctx context.Context, backupName string) {
+	bd.updateRemoteIndex(ctx, func(backups map[string]Backup) {
+		delete(backups, backupName)
+	})
+}
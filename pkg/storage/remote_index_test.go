@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFSRemoteIndex(t *testing.T) {
+	ctx := context.Background()
+	bd := newTestFSDestination(t)
+	other := newTestFSDestination(t)
+	assert.NotEqual(t, bd.metadataCacheFile(), other.metadataCacheFile())
+
+	putMetadata := func(backupName string, body []byte) {
+		assert.NoError(t, bd.PutFile(ctx, backupName+"/metadata.json", io.NopCloser(bytes.NewReader(body))))
+	}
+	for _, backupName := range []string{"backup1", "backup2"} {
+		body, err := json.Marshal(metadata.BackupMetadata{BackupName: backupName, DataFormat: "tar"})
+		assert.NoError(t, err)
+		putMetadata(backupName, body)
+	}
+	backupList, err := bd.BackupList(ctx, true, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(backupList))
+	indexBackups, _, _, isIndexExists := bd.loadRemoteIndex(ctx)
+	assert.True(t, isIndexExists)
+	assert.Equal(t, 2, len(indexBackups))
+
+	// metadata.json shall not be read when backup exists in remote index
+	putMetadata("backup1", []byte("broken"))
+	assert.NoError(t, os.Remove(bd.metadataCacheFile()))
+	backupList, err = bd.BackupList(ctx, true, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(backupList))
+	for _, backup := range backupList {
+		assert.Equal(t, "", backup.Broken)
+		assert.Equal(t, "tar", backup.DataFormat)
+	}
+
+	assert.NoError(t, bd.RemoveBackup(ctx, Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "backup2"}}))
+	indexBackups, _, _, isIndexExists = bd.loadRemoteIndex(ctx)
+	assert.True(t, isIndexExists)
+	_, exists := indexBackups["backup2"]
+	assert.False(t, exists)
+	assert.Equal(t, 1, len(indexBackups))
+}
+
+func TestFSRemoteIndexVerify(t *testing.T) {
+	ctx := context.Background()
+	bd := newTestFSDestination(t)
+	putMetadata := func(backupName string) {
+		body, err := json.Marshal(metadata.BackupMetadata{BackupName: backupName, DataFormat: "tar"})
+		assert.NoError(t, err)
+		assert.NoError(t, bd.PutFile(ctx, backupName+"/metadata.json", io.NopCloser(bytes.NewReader(body))))
+	}
+	putMetadata("backup1")
+	backupList, err := bd.BackupList(ctx, true, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(backupList))
+	_, verifiedAt, _, isIndexExists := bd.loadRemoteIndex(ctx)
+	assert.True(t, isIndexExists)
+	assert.True(t, isRemoteIndexVerified(verifiedAt))
+
+	// backups uploaded without index update and broken backups are invisible while index is verified
+	putMetadata("backup2")
+	assert.NoError(t, bd.PutFile(ctx, "broken/shadow/file", io.NopCloser(bytes.NewReader([]byte("data")))))
+	backupList, err = bd.BackupList(ctx, true, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(backupList))
+	// requested backup which is absent in index is looked up with Walk
+	backupList, err = bd.BackupList(ctx, true, "backup2")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(backupList))
+	backupList, err = bd.BackupListFull(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(backupList))
+	brokenCount := 0
+	for _, backup := range backupList {
+		if backup.Broken != "" {
+			brokenCount++
+		}
+	}
+	assert.Equal(t, 1, brokenCount)
+
+	// upload and delete update index, but keep verified_at
+	indexBackups, _, version, _ := bd.loadRemoteIndex(ctx)
+	oldVerifiedAt := time.Now().Add(-2 * remoteIndexVerifyInterval)
+	assert.NoError(t, bd.saveRemoteIndex(ctx, map[string]Backup{"backup1": indexBackups["backup1"]}, oldVerifiedAt, version))
+	// index which was changed after load can't be overwritten
+	assert.ErrorIs(t, bd.saveRemoteIndex(ctx, indexBackups, time.Now(), version), ErrPreconditionFailed)
+	putMetadata("backup3")
+	bd.AddToRemoteIndex(ctx, metadata.BackupMetadata{BackupName: "backup3"})
+	indexBackups, verifiedAt, _, _ = bd.loadRemoteIndex(ctx)
+	assert.True(t, oldVerifiedAt.Equal(verifiedAt))
+	assert.Equal(t, 2, len(indexBackups))
+
+	// stale index is rebuilt with Walk
+	backupList, err = bd.BackupList(ctx, true, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(backupList))
+	indexBackups, verifiedAt, _, _ = bd.loadRemoteIndex(ctx)
+	assert.True(t, isRemoteIndexVerified(verifiedAt))
+	assert.Equal(t, 3, len(indexBackups))
+	_, isIndexed := indexBackups["backup2"]
+	assert.True(t, isIndexed)
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	"github.com/AlexAkulov/clickhouse-backup/pkg/metadata"
+)
+
+// RetentionPolicy - keep Last newest backups and the newest backup in each of the last Hourly hours, Daily days, Weekly ISO weeks, Monthly months and Yearly years which have backups
+// periods are calculated by CreationDate in UTC, backups required by kept incremental backups are kept too
+type RetentionPolicy struct {
+	Last    int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+// NewRetentionPolicy - remote retention policy from general->backups_to_keep_remote and retention section
+func NewRetentionPolicy(cfg *config.Config) RetentionPolicy {
+	return RetentionPolicy{
+		Last:    cfg.General.BackupsToKeepRemote,
+		Hourly:  cfg.Retention.Hourly,
+		Daily:   cfg.Retention.Daily,
+		Weekly:  cfg.Retention.Weekly,
+		Monthly: cfg.Retention.Monthly,
+		Yearly:  cfg.Retention.Yearly,
+	}
+}
+
+// IsEmpty - policy is not defined and all backups shall be kept
+func (p RetentionPolicy) IsEmpty() bool {
+	return p.Last < 1 && p.Hourly < 1 && p.Daily < 1 && p.Weekly < 1 && p.Monthly < 1 && p.Yearly < 1
+}
+
+// BackupRetention - retention decision for one backup with reasons why backup is kept or deleted
+// ObjectLocked means backup is not matched by retention policy, but can't be deleted due to object lock or hold
+type BackupRetention struct {
+	Backup       Backup
+	Keep         bool
+	ObjectLocked bool
+	Reasons      []string
+}
+
+// ObjectLockReason - why remote storage will reject delete of backup objects, empty string when backup is not protected
+func (b Backup) ObjectLockReason() string {
+	if b.ObjectHold != "" {
+		return b.ObjectHold + " object hold"
+	}
+	if b.RetainUntil != nil && b.RetainUntil.After(time.Now()) {
+		return "object lock until " + b.RetainUntil.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+type retentionPeriod struct {
+	name string
+	keep int
+	key  func(t time.Time) string
+}
+
+func (p RetentionPolicy) periods() []retentionPeriod {
+	return []retentionPeriod{
+		{"hourly", p.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15h") }},
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+// retentionDate - CreationDate is not defined for legacy and broken backups, UploadDate is used for them
+func retentionDate(backup Backup) time.Time {
+	if !backup.CreationDate.IsZero() {
+		return backup.CreationDate.UTC()
+	}
+	return backup.UploadDate.UTC()
+}
+
+// ApplyRetentionPolicy - return retention decision for each backup, the newest backups first
+func ApplyRetentionPolicy(backups []Backup, policy RetentionPolicy) []BackupRetention {
+	result := make([]BackupRetention, len(backups))
+	for i := range backups {
+		result[i] = BackupRetention{Backup: backups[i]}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return retentionDate(result[i].Backup).After(retentionDate(result[j].Backup))
+	})
+	keep := func(i int, reason string) {
+		result[i].Keep = true
+		result[i].Reasons = append(result[i].Reasons, reason)
+	}
+	if policy.IsEmpty() {
+		for i := range result {
+			keep(i, "retention policy is not defined")
+		}
+		return result
+	}
+	// backup with UploadDate `0001-01-01 00:00:00` could be uploaded right now by another shard
+	// fix https://github.com/AlexAkulov/clickhouse-backup/issues/409
+	candidates := make([]int, 0, len(result))
+	for i := range result {
+		if result[i].Backup.UploadDate.IsZero() {
+			keep(i, "upload in progress")
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+	for n, i := range candidates {
+		if n >= policy.Last {
+			break
+		}
+		keep(i, fmt.Sprintf("last %d", policy.Last))
+	}
+	for _, period := range policy.periods() {
+		if period.keep < 1 {
+			continue
+		}
+		kept := 0
+		lastKey := ""
+		for _, i := range candidates {
+			if kept >= period.keep {
+				break
+			}
+			if result[i].Backup.Broken != "" {
+				continue
+			}
+			key := period.key(retentionDate(result[i].Backup))
+			if key == lastKey {
+				continue
+			}
+			lastKey = key
+			kept++
+			keep(i, fmt.Sprintf("%s %s", period.name, key))
+		}
+	}
+	// backup protected by object lock can't be deleted before retain-until date, so it is kept with its required backups
+	for _, i := range candidates {
+		if result[i].Keep {
+			continue
+		}
+		if reason := result[i].Backup.ObjectLockReason(); reason != "" {
+			result[i].ObjectLocked = true
+			keep(i, "retained by "+reason)
+		}
+	}
+	// KeepRemoteBackups should respect incremental backups sequences and don't delete required backups
+	// fix https://github.com/AlexAkulov/clickhouse-backup/issues/111
+	// fix https://github.com/AlexAkulov/clickhouse-backup/issues/385
+	// fix https://github.com/AlexAkulov/clickhouse-backup/issues/525
+	backupIndex := make(map[string]int, len(result))
+	for i := range result {
+		backupIndex[result[i].Backup.BackupName] = i
+	}
+	var keepRequiredBackup func(i int)
+	keepRequiredBackup = func(i int) {
+		requiredBackup := result[i].Backup.RequiredBackup
+		if requiredBackup == "" || requiredBackup == result[i].Backup.BackupName {
+			return
+		}
+		if j, exists := backupIndex[requiredBackup]; exists {
+			isKept := result[j].Keep
+			keep(j, "required by "+result[i].Backup.BackupName)
+			if !isKept {
+				keepRequiredBackup(j)
+			}
+		}
+	}
+	for i := range result {
+		if result[i].Keep {
+			keepRequiredBackup(i)
+		}
+	}
+	for i := range result {
+		if !result[i].Keep {
+			result[i].Reasons = append(result[i].Reasons, "not matched by retention policy")
+		}
+	}
+	return result
+}
+
+// ClusterRetention - retention decision for cluster manifest, each shard backup of manifest gets the same decision
+type ClusterRetention struct {
+	Manifest metadata.ClusterBackupMetadata
+	Keep     bool
+}
+
+// ApplyClusterRetentionPolicy - shard backups owned by cluster manifests are not counted by per-host retention, policy is applied to manifests by creation date
+// and shard backups are kept or deleted together with own manifest, manifest is kept when one of shard backups is protected by object lock
+// failed manifests are not counted by policy, they are deleted with finished shard backups
+func ApplyClusterRetentionPolicy(backups []Backup, manifests []metadata.ClusterBackupMetadata, policy RetentionPolicy) ([]BackupRetention, []ClusterRetention) {
+	shardBackups := make(map[string][]Backup, len(manifests))
+	owners := make(map[string]string)
+	for _, manifest := range manifests {
+		for _, shard := range manifest.Shards {
+			owners[shard.BackupName] = manifest.BackupName
+		}
+	}
+	hostBackups := make([]Backup, 0, len(backups))
+	for _, backup := range backups {
+		if manifestName, isOwned := owners[backup.BackupName]; isOwned {
+			shardBackups[manifestName] = append(shardBackups[manifestName], backup)
+			continue
+		}
+		hostBackups = append(hostBackups, backup)
+	}
+	result := ApplyRetentionPolicy(hostBackups, policy)
+	if len(manifests) == 0 {
+		return result, nil
+	}
+	manifestBackups := make([]Backup, 0, len(manifests))
+	manifestRetentions := make(map[string]BackupRetention, len(manifests))
+	for _, manifest := range manifests {
+		if manifest.Failed != "" {
+			manifestRetentions[manifest.BackupName] = BackupRetention{Reasons: []string{fmt.Sprintf("failed: %s", manifest.Failed)}}
+			continue
+		}
+		manifestBackups = append(manifestBackups, Backup{
+			BackupMetadata: metadata.BackupMetadata{BackupName: manifest.BackupName, CreationDate: manifest.CreationDate},
+			UploadDate:     manifest.CreationDate,
+		})
+	}
+	for _, retention := range ApplyRetentionPolicy(manifestBackups, policy) {
+		manifestRetentions[retention.Backup.BackupName] = retention
+	}
+	clusterResult := make([]ClusterRetention, len(manifests))
+	for i, manifest := range manifests {
+		manifestRetention := manifestRetentions[manifest.BackupName]
+		reasons := manifestRetention.Reasons
+		lockedShards := map[string]struct{}{}
+		if !manifestRetention.Keep {
+			for _, backup := range shardBackups[manifest.BackupName] {
+				if reason := backup.ObjectLockReason(); reason != "" {
+					lockedShards[backup.BackupName] = struct{}{}
+					manifestRetention.Keep = true
+					reasons = []string{fmt.Sprintf("%s retained by %s", backup.BackupName, reason)}
+				}
+			}
+		}
+		clusterResult[i] = ClusterRetention{Manifest: manifest, Keep: manifestRetention.Keep}
+		for _, backup := range shardBackups[manifest.BackupName] {
+			_, isLocked := lockedShards[backup.BackupName]
+			shardRetention := BackupRetention{Backup: backup, Keep: manifestRetention.Keep, ObjectLocked: isLocked}
+			for _, reason := range reasons {
+				shardRetention.Reasons = append(shardRetention.Reasons, fmt.Sprintf("cluster backup %s %s", manifest.BackupName, reason))
+			}
+			result = append(result, shardRetention)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return retentionDate(result[i].Backup).After(retentionDate(result[j].Backup))
+	})
+	return result, clusterResult
+}
+
+// GetBackupsToDelete - backups which are not kept by the newest `keep` backups and their required backups
+func GetBackupsToDelete(backups []Backup, keep int) []Backup {
+	return GetBackupsToDeleteByPolicy(backups, RetentionPolicy{Last: keep})
+}
+
+// GetBackupsToDeleteByPolicy - backups which are not kept by retention policy, the newest backups first
+func GetBackupsToDeleteByPolicy(backups []Backup, policy RetentionPolicy) []Backup {
+	backupsToDelete := make([]Backup, 0)
+	for _, retention := range ApplyRetentionPolicy(backups, policy) {
+		if !retention.Keep {
+			backupsToDelete = append(backupsToDelete, retention.Backup)
+		}
+	}
+	return backupsToDelete
+}
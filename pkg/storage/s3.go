@@ -1,11 +1,15 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
@@ -14,16 +18,26 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	apexLog "github.com/apex/log"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
-	"github.com/aws/aws-sdk-go/aws/defaults"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/aws/aws-sdk-go/service/sts"
-	"github.com/pkg/errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsV2http "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsV2Config "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	awsV2Logging "github.com/aws/smithy-go/logging"
+	smithyHttp "github.com/aws/smithy-go/transport/http"
+	pkgErrors "github.com/pkg/errors"
+)
+
+const (
+	s3MinPartSize       = 5 * 1024 * 1024
+	s3MaxPartsCount     = 10000
+	s3MaxCopyObjectSize = 5 * 1024 * 1024 * 1024
 )
 
 type S3LogToApexLogAdapter struct {
@@ -36,17 +50,18 @@ func newS3Logger(log *apexLog.Entry) *S3LogToApexLogAdapter {
 	}
 }
 
-func (S3LogToApexLogAdapter *S3LogToApexLogAdapter) Log(args ...interface{}) {
-	if len(args) > 1 {
-		S3LogToApexLogAdapter.apexLog.Infof(args[0].(string), args[1:]...)
+// Logf - implement smithy-go logging.Logger
+func (S3LogToApexLogAdapter *S3LogToApexLogAdapter) Logf(classification awsV2Logging.Classification, format string, v ...interface{}) {
+	if classification == awsV2Logging.Warn {
+		S3LogToApexLogAdapter.apexLog.Warnf(format, v...)
 	} else {
-		S3LogToApexLogAdapter.apexLog.Info(args[0].(string))
+		S3LogToApexLogAdapter.apexLog.Infof(format, v...)
 	}
 }
 
 // S3 - presents methods for manipulate data on s3
 type S3 struct {
-	session     *session.Session
+	client      *s3.Client
 	uploader    *s3manager.Uploader
 	downloader  *s3manager.Downloader
 	Config      *config.S3Config
@@ -55,101 +70,122 @@ type S3 struct {
 	Concurrency int
 	BufferSize  int
 	versioning  bool
+	retainUntil *time.Time
 }
 
 func (s *S3) Kind() string {
 	return "S3"
 }
 
-// Connect - connect to s3
+// Connect - connect to s3, credentials resolved by default aws-sdk-go-v2 chain: environment, shared config profile (include SSO and credential_process), web identity (IRSA), ECS container and EC2 instance role
 func (s *S3) Connect(ctx context.Context) error {
 	var err error
-
-	awsDefaults := defaults.Get()
-	defaultCredProviders := defaults.CredProviders(awsDefaults.Config, awsDefaults.Handlers)
-	customCredProviders := defaultCredProviders
-
-	if s.Config.AccessKey != "" && s.Config.SecretKey != "" {
-		// Define custom static cred provider
-		staticCreds := &credentials.StaticProvider{Value: credentials.Value{
-			AccessKeyID:     s.Config.AccessKey,
-			SecretAccessKey: s.Config.SecretKey,
-		}}
-		// Append static creds to the defaults
-		customCredProviders = append([]credentials.Provider{staticCreds}, customCredProviders...)
-	}
-
-	awsRoleARN := os.Getenv("AWS_ROLE_ARN")
-	awsWebIdentityTokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
-	if awsRoleARN != "" && awsWebIdentityTokenFile != "" {
-		cfg := &aws.Config{
-			Region: aws.String(s.Config.Region),
-		}
-		stsSvc := sts.New(session.Must(session.NewSession(cfg)))
-		stsProvider := stscreds.NewWebIdentityRoleProviderWithOptions(stsSvc, awsRoleARN, "", stscreds.FetchTokenPath(awsWebIdentityTokenFile))
-		customCredProviders = append([]credentials.Provider{stsProvider}, customCredProviders...)
+	loadOptions := []func(*awsV2Config.LoadOptions) error{
+		awsV2Config.WithRegion(s.Config.Region),
+		awsV2Config.WithRetryer(func() aws.Retryer {
+			return retry.AddWithMaxAttempts(retry.NewStandard(), 30)
+		}),
 	}
-
-	creds := credentials.NewChainCredentials(customCredProviders)
-
-	var awsConfig = &aws.Config{
-		Credentials:      creds,
-		Region:           aws.String(s.Config.Region),
-		Endpoint:         aws.String(s.Config.Endpoint),
-		DisableSSL:       aws.Bool(s.Config.DisableSSL),
-		S3ForcePathStyle: aws.Bool(s.Config.ForcePathStyle),
-		MaxRetries:       aws.Int(30),
+	if s.Config.Profile != "" {
+		loadOptions = append(loadOptions, awsV2Config.WithSharedConfigProfile(s.Config.Profile))
+	}
+	if s.Config.AccessKey != "" && s.Config.SecretKey != "" {
+		loadOptions = append(loadOptions, awsV2Config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(s.Config.AccessKey, s.Config.SecretKey, ""),
+		))
 	}
 	if s.Config.Debug {
-		awsConfig.Logger = newS3Logger(s.Log)
-		awsConfig.LogLevel = aws.LogLevel(aws.LogDebug)
+		loadOptions = append(
+			loadOptions,
+			awsV2Config.WithLogger(newS3Logger(s.Log)),
+			awsV2Config.WithClientLogMode(aws.LogRetries|aws.LogRequest|aws.LogResponse),
+		)
 	}
-
 	if s.Config.DisableCertVerification {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		awsConfig.HTTPClient = &http.Client{Transport: tr}
+		httpClient := awsV2http.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		})
+		loadOptions = append(loadOptions, awsV2Config.WithHTTPClient(httpClient))
 	}
 
-	if s.Config.AssumeRoleARN != "" {
-		// Reference to regular credentials chain is to be copied into `stscreds` credentials.
-		awsConfig.Credentials = stscreds.NewCredentials(session.Must(session.NewSession(awsConfig)), s.Config.AssumeRoleARN)
+	awsConfig, err := awsV2Config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return err
 	}
 
-	if s.session, err = session.NewSession(awsConfig); err != nil {
-		return err
+	if s.Config.WebIdentityTokenFile != "" {
+		roleARN := s.Config.AssumeRoleARN
+		if roleARN == "" {
+			roleARN = os.Getenv("AWS_ROLE_ARN")
+		}
+		if roleARN == "" {
+			return fmt.Errorf("s3->web_identity_token_file require s3->assume_role_arn or AWS_ROLE_ARN environment variable")
+		}
+		awsConfig.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(
+			sts.NewFromConfig(awsConfig), roleARN, stscreds.IdentityTokenFile(s.Config.WebIdentityTokenFile),
+		))
+	} else if s.Config.AssumeRoleARN != "" {
+		// Reference to regular credentials chain is to be copied into `stscreds` credentials.
+		awsConfig.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsConfig), s.Config.AssumeRoleARN))
 	}
 
-	s.uploader = s3manager.NewUploader(s.session)
-	s.uploader.Concurrency = s.Concurrency
-	s.uploader.BufferProvider = s3manager.NewBufferedReadSeekerWriteToPool(s.BufferSize)
-	s.uploader.PartSize = s.PartSize
+	s.client = s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.UsePathStyle = s.Config.ForcePathStyle
+		o.EndpointOptions.DisableHTTPS = s.Config.DisableSSL
+		if s.Config.Endpoint != "" {
+			endpoint := s.Config.Endpoint
+			if !strings.Contains(endpoint, "://") {
+				if s.Config.DisableSSL {
+					endpoint = "http://" + endpoint
+				} else {
+					endpoint = "https://" + endpoint
+				}
+			}
+			o.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+		}
+	})
+
+	s.uploader = s3manager.NewUploader(s.client, func(u *s3manager.Uploader) {
+		u.Concurrency = s.Concurrency
+		u.BufferProvider = s3manager.NewBufferedReadSeekerWriteToPool(s.BufferSize)
+		u.PartSize = s.PartSize
+	})
 
-	s.downloader = s3manager.NewDownloader(s.session)
-	s.downloader.Concurrency = s.Concurrency
-	s.downloader.BufferProvider = s3manager.NewPooledBufferedWriterReadFromProvider(s.BufferSize)
-	s.downloader.PartSize = s.PartSize
+	s.downloader = s3manager.NewDownloader(s.client, func(d *s3manager.Downloader) {
+		d.Concurrency = s.Concurrency
+		d.BufferProvider = s3manager.NewPooledBufferedWriterReadFromProvider(s.BufferSize)
+		d.PartSize = s.PartSize
+	})
 
-	s.versioning = s.isVersioningEnabled()
+	s.versioning = s.isVersioningEnabled(ctx)
+	if s.Config.ObjectLockMode != "" {
+		// the same retain-until date for all objects uploaded with this connection, so it could be saved in metadata.json
+		retainUntil := time.Now().UTC().AddDate(0, 0, s.Config.ObjectLockDays)
+		s.retainUntil = &retainUntil
+	}
 
 	return nil
 }
 
+// ObjectLock - retain-until date of objects uploaded with S3 Object Lock
+func (s *S3) ObjectLock() (*time.Time, string) {
+	return s.retainUntil, ""
+}
+
 func (s *S3) Close(ctx context.Context) error {
 	return nil
 }
 
 func (s *S3) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
-	svc := s3.New(s.session)
-	req, resp := svc.GetObjectRequest(&s3.GetObjectInput{
+	params := &s3.GetObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
 		Key:    aws.String(path.Join(s.Config.Path, key)),
-	})
-	if err := req.Send(); err != nil {
+	}
+	s.enableChecksumMode(params)
+	resp, err := s.client.GetObject(ctx, params)
+	if err != nil {
 		return nil, err
 	}
-
 	return resp.Body, nil
 }
 
@@ -161,10 +197,12 @@ func (s *S3) GetFileReaderWithLocalPath(ctx context.Context, key, localPath stri
 		if err != nil {
 			return nil, err
 		}
-		_, err = s.downloader.Download(writer, &s3.GetObjectInput{
+		params := &s3.GetObjectInput{
 			Bucket: aws.String(s.Config.Bucket),
 			Key:    aws.String(path.Join(s.Config.Path, key)),
-		})
+		}
+		s.enableChecksumMode(params)
+		_, err = s.downloader.Download(ctx, writer, params)
 		if err != nil {
 			return nil, err
 		}
@@ -174,76 +212,302 @@ func (s *S3) GetFileReaderWithLocalPath(ctx context.Context, key, localPath stri
 	}
 }
 
+// enableChecksumMode - validate object checksum during download when it was uploaded with checksum_algorithm
+func (s *S3) enableChecksumMode(params *s3.GetObjectInput) {
+	if s.Config.ChecksumAlgorithm != "" {
+		params.ChecksumMode = s3types.ChecksumModeEnabled
+	}
+}
+
 func (s *S3) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
-	var sse *string
+	params := &s3.PutObjectInput{
+		ACL:          s3types.ObjectCannedACL(s.Config.ACL),
+		Bucket:       aws.String(s.Config.Bucket),
+		Key:          aws.String(path.Join(s.Config.Path, key)),
+		Body:         r,
+		StorageClass: s3types.StorageClass(strings.ToUpper(s.Config.StorageClass)),
+	}
 	if s.Config.SSE != "" {
-		sse = aws.String(s.Config.SSE)
-	}
-	_, err := s.uploader.Upload(&s3manager.UploadInput{
-		ACL:                  aws.String(s.Config.ACL),
-		Bucket:               aws.String(s.Config.Bucket),
-		Key:                  aws.String(path.Join(s.Config.Path, key)),
-		Body:                 r,
-		ServerSideEncryption: sse,
-		StorageClass:         aws.String(strings.ToUpper(s.Config.StorageClass)),
-	})
+		params.ServerSideEncryption = s3types.ServerSideEncryption(s.Config.SSE)
+	}
+	if s.Config.ChecksumAlgorithm != "" {
+		params.ChecksumAlgorithm = s3types.ChecksumAlgorithm(strings.ToUpper(s.Config.ChecksumAlgorithm))
+	}
+	if s.retainUntil != nil && isObjectLockRequired(key) {
+		params.ObjectLockMode = s3types.ObjectLockMode(strings.ToUpper(s.Config.ObjectLockMode))
+		params.ObjectLockRetainUntilDate = s.retainUntil
+		// PUT with Object Lock requires Content-MD5 or checksum header
+		if params.ChecksumAlgorithm == "" {
+			params.ChecksumAlgorithm = s3types.ChecksumAlgorithmCrc32
+		}
+	}
+	_, err := s.uploader.Upload(ctx, params)
 	return err
 }
 
+// GetFileVersioned - read small object and return its ETag for PutFileIfVersion
+func (s *S3) GetFileVersioned(ctx context.Context, key string) ([]byte, string, error) {
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(path.Join(s.Config.Path, key)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey") {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		apexLog.Warnf("can't close GetObject body for %s: %v", key, closeErr)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return body, aws.ToString(resp.ETag), nil
+}
+
+// PutFileIfVersion - conditional PutObject with If-None-Match: * for new object and If-Match: ETag for existing object
+func (s *S3) PutFileIfVersion(ctx context.Context, key string, body []byte, version string) error {
+	params := &s3.PutObjectInput{
+		ACL:    s3types.ObjectCannedACL(s.Config.ACL),
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(path.Join(s.Config.Path, key)),
+		Body:   bytes.NewReader(body),
+	}
+	if s.Config.SSE != "" {
+		params.ServerSideEncryption = s3types.ServerSideEncryption(s.Config.SSE)
+	}
+	if s.Config.ChecksumAlgorithm != "" {
+		params.ChecksumAlgorithm = s3types.ChecksumAlgorithm(strings.ToUpper(s.Config.ChecksumAlgorithm))
+	}
+	condition := smithyHttp.SetHeaderValue("If-None-Match", "*")
+	if version != "" {
+		condition = smithyHttp.SetHeaderValue("If-Match", version)
+	}
+	_, err := s.client.PutObject(ctx, params, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, condition)
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+			return ErrPreconditionFailed
+		}
+		return err
+	}
+	return nil
+}
+
 func (s *S3) DeleteFile(ctx context.Context, key string) error {
 	params := &s3.DeleteObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
 		Key:    aws.String(path.Join(s.Config.Path, key)),
 	}
 	if s.versioning {
-		objVersion, err := s.getObjectVersion(key)
+		objVersion, err := s.getObjectVersion(ctx, key)
 		if err != nil {
-			return errors.Wrapf(err, "DeleteFile, obtaining object version %+v", params)
+			return pkgErrors.Wrapf(err, "DeleteFile, obtaining object version %+v", params)
 		}
 		params.VersionId = objVersion
 	}
-	if _, err := s3.New(s.session).DeleteObject(params); err != nil {
-		return errors.Wrapf(err, "DeleteFile, deleting object %+v", params)
+	if _, err := s.client.DeleteObject(ctx, params); err != nil {
+		return pkgErrors.Wrapf(err, "DeleteFile, deleting object %+v", params)
 	}
 	return nil
 }
 
-func (s *S3) isVersioningEnabled() bool {
-	output, err := s3.New(s.session).GetBucketVersioning(&s3.GetBucketVersioningInput{
+func (s *S3) isVersioningEnabled(ctx context.Context) bool {
+	output, err := s.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
 		Bucket: aws.String(s.Config.Bucket),
 	})
 	if err != nil {
 		return false
 	}
-	return output.Status != nil && *output.Status == s3.BucketVersioningStatusEnabled
+	return output.Status == s3types.BucketVersioningStatusEnabled
 }
 
-func (s *S3) getObjectVersion(key string) (*string, error) {
-	params := &s3.GetObjectInput{
+func (s *S3) getObjectVersion(ctx context.Context, key string) (*string, error) {
+	params := &s3.HeadObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
 		Key:    aws.String(path.Join(s.Config.Path, key)),
 	}
-	object, err := s3.New(s.session).GetObject(params)
+	object, err := s.client.HeadObject(ctx, params)
 	if err != nil {
 		return nil, err
 	}
 	return object.VersionId, nil
 }
 
+// CopyObject - server-side copy to another bucket or path, objects larger than 5Gb copied with UploadPartCopy
+func (s *S3) CopyObject(ctx context.Context, key string, dst RemoteStorage) error {
+	dstS3, ok := dst.(*S3)
+	if !ok {
+		return fmt.Errorf("can't copy %s from S3 to %s", key, dst.Kind())
+	}
+	return s.copyObject(ctx, key, dstS3, dstS3.Config.StorageClass)
+}
+
+// TierStorageClass - storage class for backups older than general->tier_after
+func (s *S3) TierStorageClass() string {
+	return s.Config.TierStorageClass
+}
+
+// TierObject - change storage class of existing object with in-place server-side copy
+func (s *S3) TierObject(ctx context.Context, key string, storageClass string) error {
+	return s.copyObject(ctx, key, s, storageClass)
+}
+
+// RestoreObject - request temporary copy of GLACIER and DEEP_ARCHIVE object for s3->restore_days, other storage classes could be read immediately
+func (s *S3) RestoreObject(ctx context.Context, key string) (bool, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(path.Join(s.Config.Path, key)),
+	})
+	if err != nil {
+		return false, err
+	}
+	if head.StorageClass != s3types.StorageClassGlacier && head.StorageClass != s3types.StorageClassDeepArchive {
+		return true, nil
+	}
+	// x-amz-restore: ongoing-request="false", expiry-date="..." means restored copy is available
+	if head.Restore != nil {
+		return !strings.Contains(*head.Restore, `ongoing-request="true"`), nil
+	}
+	_, err = s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(path.Join(s.Config.Path, key)),
+		RestoreRequest: &s3types.RestoreRequest{
+			Days:                 int32(s.Config.RestoreDays),
+			GlacierJobParameters: &s3types.GlacierJobParameters{Tier: s3types.Tier(s.Config.RestoreTier)},
+		},
+	})
+	var apiErr smithy.APIError
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress") {
+		return false, err
+	}
+	return false, nil
+}
+
+func (s *S3) copyObject(ctx context.Context, key string, dstS3 *S3, dstStorageClass string) error {
+	srcKey := path.Join(s.Config.Path, key)
+	dstKey := path.Join(dstS3.Config.Path, key)
+	copySource := url.PathEscape(s.Config.Bucket + "/" + srcKey)
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Config.Bucket),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		return err
+	}
+	storageClass := s3types.StorageClass(strings.ToUpper(dstStorageClass))
+	var sse s3types.ServerSideEncryption
+	if dstS3.Config.SSE != "" {
+		sse = s3types.ServerSideEncryption(dstS3.Config.SSE)
+	}
+	var objectLockMode s3types.ObjectLockMode
+	var objectLockRetainUntil *time.Time
+	if dstS3.retainUntil != nil && isObjectLockRequired(key) {
+		objectLockMode = s3types.ObjectLockMode(strings.ToUpper(dstS3.Config.ObjectLockMode))
+		objectLockRetainUntil = dstS3.retainUntil
+	}
+	if head.ContentLength <= s3MaxCopyObjectSize {
+		_, err = dstS3.client.CopyObject(ctx, &s3.CopyObjectInput{
+			ACL:                       s3types.ObjectCannedACL(dstS3.Config.ACL),
+			Bucket:                    aws.String(dstS3.Config.Bucket),
+			Key:                       aws.String(dstKey),
+			CopySource:                aws.String(copySource),
+			StorageClass:              storageClass,
+			ServerSideEncryption:      sse,
+			ObjectLockMode:            objectLockMode,
+			ObjectLockRetainUntilDate: objectLockRetainUntil,
+		})
+		return err
+	}
+
+	partSize := s.PartSize
+	if partSize < s3MinPartSize {
+		partSize = s3MinPartSize
+	}
+	if head.ContentLength/partSize >= s3MaxPartsCount {
+		partSize = head.ContentLength/s3MaxPartsCount + 1
+	}
+	upload, err := dstS3.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		ACL:                       s3types.ObjectCannedACL(dstS3.Config.ACL),
+		Bucket:                    aws.String(dstS3.Config.Bucket),
+		Key:                       aws.String(dstKey),
+		StorageClass:              storageClass,
+		ServerSideEncryption:      sse,
+		ObjectLockMode:            objectLockMode,
+		ObjectLockRetainUntilDate: objectLockRetainUntil,
+	})
+	if err != nil {
+		return err
+	}
+	partsCount := int((head.ContentLength + partSize - 1) / partSize)
+	parts := make([]s3types.CompletedPart, partsCount)
+	concurrency := s.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	copyGroup, copyCtx := errgroup.WithContext(ctx)
+	copyGroup.SetLimit(concurrency)
+	for i := 0; i < partsCount; i++ {
+		partNumber := int32(i + 1)
+		start := int64(i) * partSize
+		end := start + partSize - 1
+		if end >= head.ContentLength {
+			end = head.ContentLength - 1
+		}
+		idx := i
+		copyGroup.Go(func() error {
+			partResp, err := dstS3.client.UploadPartCopy(copyCtx, &s3.UploadPartCopyInput{
+				Bucket:          aws.String(dstS3.Config.Bucket),
+				Key:             aws.String(dstKey),
+				CopySource:      aws.String(copySource),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+				PartNumber:      partNumber,
+				UploadId:        upload.UploadId,
+			})
+			if err != nil {
+				return err
+			}
+			parts[idx] = s3types.CompletedPart{ETag: partResp.CopyPartResult.ETag, PartNumber: partNumber}
+			return nil
+		})
+	}
+	if err = copyGroup.Wait(); err != nil {
+		if _, abortErr := dstS3.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(dstS3.Config.Bucket),
+			Key:      aws.String(dstKey),
+			UploadId: upload.UploadId,
+		}); abortErr != nil {
+			s.Log.Warnf("can't abort multipart copy %s: %v", dstKey, abortErr)
+		}
+		return err
+	}
+	_, err = dstS3.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(dstS3.Config.Bucket),
+		Key:             aws.String(dstKey),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
 func (s *S3) StatFile(ctx context.Context, key string) (RemoteFile, error) {
-	svc := s3.New(s.session)
-	head, err := svc.HeadObject(&s3.HeadObjectInput{
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(s.Config.Bucket),
 		Key:    aws.String(path.Join(s.Config.Path, key)),
 	})
 	if err != nil {
-		awsError, ok := err.(awserr.Error)
-		if ok && awsError.Code() == "NotFound" {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey") {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
-	return &s3File{*head.ContentLength, *head.LastModified, key}, nil
+	return &s3File{head.ContentLength, *head.LastModified, key}, nil
 }
 
 func (s *S3) Walk(ctx context.Context, s3Path string, recursive bool, process func(ctx context.Context, r RemoteFile) error) error {
@@ -251,7 +515,7 @@ func (s *S3) Walk(ctx context.Context, s3Path string, recursive bool, process fu
 	s3Files := make(chan *s3File)
 	g.Go(func() error {
 		defer close(s3Files)
-		return s.remotePager(path.Join(s.Config.Path, s3Path), recursive, func(page *s3.ListObjectsV2Output) {
+		return s.remotePager(ctx, path.Join(s.Config.Path, s3Path), recursive, func(page *s3.ListObjectsV2Output) {
 			for _, cp := range page.CommonPrefixes {
 				s3Files <- &s3File{
 					name: strings.TrimPrefix(*cp.Prefix, path.Join(s.Config.Path, s3Path)),
@@ -259,7 +523,7 @@ func (s *S3) Walk(ctx context.Context, s3Path string, recursive bool, process fu
 			}
 			for _, c := range page.Contents {
 				s3Files <- &s3File{
-					*c.Size,
+					c.Size,
 					*c.LastModified,
 					strings.TrimPrefix(*c.Key, path.Join(s.Config.Path, s3Path)),
 				}
@@ -278,24 +542,28 @@ func (s *S3) Walk(ctx context.Context, s3Path string, recursive bool, process fu
 	return g.Wait()
 }
 
-func (s *S3) remotePager(s3Path string, recursive bool, pager func(page *s3.ListObjectsV2Output)) error {
+func (s *S3) remotePager(ctx context.Context, s3Path string, recursive bool, pager func(page *s3.ListObjectsV2Output)) error {
 	prefix := s3Path + "/"
 	if s3Path == "" || s3Path == "/" {
 		prefix = ""
 	}
 	params := &s3.ListObjectsV2Input{
 		Bucket:  aws.String(s.Config.Bucket), // Required
-		MaxKeys: aws.Int64(1000),
+		MaxKeys: 1000,
 		Prefix:  aws.String(prefix),
 	}
 	if !recursive {
-		params.SetDelimiter("/")
+		params.Delimiter = aws.String("/")
 	}
-	wrapper := func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+	paginator := s3.NewListObjectsV2Paginator(s.client, params)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
 		pager(page)
-		return !lastPage
 	}
-	return s3.New(s.session).ListObjectsV2Pages(params, wrapper)
+	return nil
 }
 
 type s3File struct {
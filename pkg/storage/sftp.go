@@ -183,6 +183,9 @@ func (sftp *SFTP) Walk(ctx context.Context, remotePath string, recursive bool, p
 		entries, err := sftp.client.ReadDir(dir)
 		if err != nil {
 			sftp.Debug("[SFTP_DEBUG] Walk::NonRecursive::ReadDir %s return error %v", dir, err)
+			if os.IsNotExist(err) {
+				return nil
+			}
 			return err
 		}
 		for _, entry := range entries {
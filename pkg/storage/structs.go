@@ -10,6 +10,8 @@ import (
 var (
 	// ErrNotFound is returned when file/object cannot be found
 	ErrNotFound = errors.New("key not found")
+	// ErrPreconditionFailed is returned by conditional put when object was changed by somebody else
+	ErrPreconditionFailed = errors.New("precondition failed")
 )
 
 // RemoteFile - interface describe file on remote storage
@@ -31,3 +33,28 @@ type RemoteStorage interface {
 	GetFileReaderWithLocalPath(ctx context.Context, key, localPath string) (io.ReadCloser, error)
 	PutFile(ctx context.Context, key string, r io.ReadCloser) error
 }
+
+// ObjectLocker - remote storage which protects uploaded objects from deletion, return retain-until date and object hold, nil and empty string when protection is disabled
+type ObjectLocker interface {
+	ObjectLock() (*time.Time, string)
+}
+
+// RemoteTiering - remote storage which can move existing objects to colder storage class and restore archived objects before download
+// RestoreObject return true when object could be read right now, otherwise restore is requested and shall be polled again later
+type RemoteTiering interface {
+	TierStorageClass() string
+	TierObject(ctx context.Context, key string, storageClass string) error
+	RestoreObject(ctx context.Context, key string) (bool, error)
+}
+
+// RemoteCopier - remote storage which can copy key to another bucket or path without streaming data through the host
+type RemoteCopier interface {
+	CopyObject(ctx context.Context, key string, dst RemoteStorage) error
+}
+
+// ConditionalPutter - remote storage which can atomically replace small service objects like lock and index
+// version is ETag or generation returned by GetFileVersioned, empty version means object shall not exist before put
+type ConditionalPutter interface {
+	GetFileVersioned(ctx context.Context, key string) ([]byte, string, error)
+	PutFileIfVersion(ctx context.Context, key string, body []byte, version string) error
+}
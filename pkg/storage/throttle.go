@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	"golang.org/x/time/rate"
+)
+
+const (
+	bandwidthUpload   = "upload"
+	bandwidthDownload = "download"
+	// throughputWindow - seconds used for calculate current throughput
+	throughputWindow = 10
+)
+
+// bandwidthLimiters - shared token buckets, so limits are applied to all upload/download goroutines together, not per goroutine
+var bandwidthLimiters = struct {
+	sync.Mutex
+	m map[string]*bandwidthLimiter
+}{m: map[string]*bandwidthLimiter{}}
+
+var (
+	uploadThroughput   = newThroughputMeter()
+	downloadThroughput = newThroughputMeter()
+)
+
+// UploadBytesPerSecond - current upload throughput to all remote storages, average for last 10 seconds
+func UploadBytesPerSecond() float64 {
+	return uploadThroughput.rate(time.Now())
+}
+
+// DownloadBytesPerSecond - current download throughput from all remote storages, average for last 10 seconds
+func DownloadBytesPerSecond() float64 {
+	return downloadThroughput.rate(time.Now())
+}
+
+type bandwidthScheduleItem struct {
+	start, end        int
+	maxBytesPerSecond uint64
+}
+
+type bandwidthLimiter struct {
+	mu                sync.Mutex
+	limiter           *rate.Limiter
+	maxBytesPerSecond uint64
+	schedule          []bandwidthScheduleItem
+}
+
+// getBandwidthLimiter - return shared limiter for name, limits of existing limiter are replaced, so config reload applies without restart
+func getBandwidthLimiter(name string, maxBytesPerSecond uint64, schedule []bandwidthScheduleItem) *bandwidthLimiter {
+	bandwidthLimiters.Lock()
+	defer bandwidthLimiters.Unlock()
+	l, exists := bandwidthLimiters.m[name]
+	if !exists {
+		l = &bandwidthLimiter{limiter: rate.NewLimiter(rate.Inf, 0)}
+		bandwidthLimiters.m[name] = l
+	}
+	l.mu.Lock()
+	l.maxBytesPerSecond = maxBytesPerSecond
+	l.schedule = schedule
+	l.mu.Unlock()
+	return l
+}
+
+// currentLimit - return limit for time of day, first matched schedule window wins, 0 means unlimited
+func (l *bandwidthLimiter) currentLimit(now time.Time) uint64 {
+	minute := now.Hour()*60 + now.Minute()
+	for _, item := range l.schedule {
+		if item.start <= item.end && minute >= item.start && minute < item.end {
+			return item.maxBytesPerSecond
+		}
+		// window cross midnight
+		if item.start > item.end && (minute >= item.start || minute < item.end) {
+			return item.maxBytesPerSecond
+		}
+	}
+	return l.maxBytesPerSecond
+}
+
+// waitN - block until n bytes allowed, n split by burst size, because rate.Limiter.WaitN fails when n exceeds burst
+func (l *bandwidthLimiter) waitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	limit := l.currentLimit(time.Now())
+	if limit == 0 {
+		l.limiter.SetLimit(rate.Inf)
+		l.mu.Unlock()
+		return nil
+	}
+	if l.limiter.Limit() != rate.Limit(limit) {
+		l.limiter.SetLimit(rate.Limit(limit))
+		l.limiter.SetBurst(int(limit))
+	}
+	burst := l.limiter.Burst()
+	l.mu.Unlock()
+	for n > 0 {
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+		if err := l.limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// newBandwidthSchedule - windows of direction, window with 0 limit for direction is skipped, so limit outside of schedule is applied inside it
+func newBandwidthSchedule(schedule []config.BandwidthScheduleConfig, direction string) ([]bandwidthScheduleItem, error) {
+	items := make([]bandwidthScheduleItem, 0, len(schedule))
+	for _, s := range schedule {
+		start, err := time.Parse("15:04", s.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth schedule start '%s': %v", s.Start, err)
+		}
+		end, err := time.Parse("15:04", s.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth schedule end '%s': %v", s.End, err)
+		}
+		item := bandwidthScheduleItem{
+			start:             start.Hour()*60 + start.Minute(),
+			end:               end.Hour()*60 + end.Minute(),
+			maxBytesPerSecond: s.UploadMaxBytesPerSecond,
+		}
+		if direction == bandwidthDownload {
+			item.maxBytesPerSecond = s.DownloadMaxBytesPerSecond
+		}
+		if item.maxBytesPerSecond == 0 {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// bandwidth - limiters applied to BackupDestination, global limit and limit of named remote storage
+type bandwidth struct {
+	upload   []*bandwidthLimiter
+	download []*bandwidthLimiter
+}
+
+func newBandwidth(cfg *config.Config) (*bandwidth, error) {
+	b := &bandwidth{}
+	scopes := map[string]config.BandwidthConfig{"global": cfg.Bandwidth}
+	if cfg.StorageName != "" && cfg.StorageName != config.DefaultStorageName {
+		scopes["storage."+cfg.StorageName] = cfg.StorageBandwidth
+	}
+	for scope, bandwidthCfg := range scopes {
+		if bandwidthCfg.UploadMaxBytesPerSecond == 0 && bandwidthCfg.DownloadMaxBytesPerSecond == 0 && len(bandwidthCfg.Schedule) == 0 {
+			continue
+		}
+		uploadSchedule, err := newBandwidthSchedule(bandwidthCfg.Schedule, bandwidthUpload)
+		if err != nil {
+			return nil, err
+		}
+		downloadSchedule, err := newBandwidthSchedule(bandwidthCfg.Schedule, bandwidthDownload)
+		if err != nil {
+			return nil, err
+		}
+		b.upload = append(b.upload, getBandwidthLimiter(scope+"."+bandwidthUpload, bandwidthCfg.UploadMaxBytesPerSecond, uploadSchedule))
+		b.download = append(b.download, getBandwidthLimiter(scope+"."+bandwidthDownload, bandwidthCfg.DownloadMaxBytesPerSecond, downloadSchedule))
+	}
+	return b, nil
+}
+
+// UploadReader - wrap reader which will be uploaded to remote storage
+func (b *bandwidth) UploadReader(ctx context.Context, r io.Reader) io.Reader {
+	var limiters []*bandwidthLimiter
+	if b != nil {
+		limiters = b.upload
+	}
+	return &bandwidthReader{ctx: ctx, r: r, limiters: limiters, meter: uploadThroughput}
+}
+
+// DownloadReader - wrap reader which downloaded from remote storage
+func (b *bandwidth) DownloadReader(ctx context.Context, r io.Reader) io.Reader {
+	var limiters []*bandwidthLimiter
+	if b != nil {
+		limiters = b.download
+	}
+	return &bandwidthReader{ctx: ctx, r: r, limiters: limiters, meter: downloadThroughput}
+}
+
+type bandwidthReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*bandwidthLimiter
+	meter    *throughputMeter
+}
+
+func (br *bandwidthReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	if n > 0 {
+		br.meter.add(time.Now(), n)
+		for _, l := range br.limiters {
+			if waitErr := l.waitN(br.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+type bandwidthReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// throughputMeter - ring of bytes per second, current second is not included into rate
+type throughputMeter struct {
+	mu      sync.Mutex
+	seconds [throughputWindow + 1]int64
+	bytes   [throughputWindow + 1]uint64
+}
+
+func newThroughputMeter() *throughputMeter {
+	return &throughputMeter{}
+}
+
+func (m *throughputMeter) add(now time.Time, n int) {
+	sec := now.Unix()
+	i := sec % int64(len(m.seconds))
+	m.mu.Lock()
+	if m.seconds[i] != sec {
+		m.seconds[i] = sec
+		m.bytes[i] = 0
+	}
+	m.bytes[i] += uint64(n)
+	m.mu.Unlock()
+}
+
+func (m *throughputMeter) rate(now time.Time) float64 {
+	sec := now.Unix()
+	total := uint64(0)
+	m.mu.Lock()
+	for i := range m.seconds {
+		if m.seconds[i] < sec && m.seconds[i] >= sec-throughputWindow {
+			total += m.bytes[i]
+		}
+	}
+	m.mu.Unlock()
+	return float64(total) / throughputWindow
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBandwidthScheduleCurrentLimit(t *testing.T) {
+	schedule, err := newBandwidthSchedule([]config.BandwidthScheduleConfig{
+		{Start: "09:00", End: "18:00", UploadMaxBytesPerSecond: 100, DownloadMaxBytesPerSecond: 200},
+		{Start: "22:00", End: "06:00", UploadMaxBytesPerSecond: 5000},
+		{Start: "06:00", End: "07:00", DownloadMaxBytesPerSecond: 300},
+	}, bandwidthUpload)
+	assert.NoError(t, err)
+	l := &bandwidthLimiter{maxBytesPerSecond: 1000, schedule: schedule}
+	day := time.Date(2022, 10, 1, 0, 0, 0, 0, time.Local)
+	assert.Equal(t, uint64(100), l.currentLimit(day.Add(9*time.Hour)))
+	assert.Equal(t, uint64(1000), l.currentLimit(day.Add(18*time.Hour)))
+	assert.Equal(t, uint64(5000), l.currentLimit(day.Add(23*time.Hour)))
+	assert.Equal(t, uint64(5000), l.currentLimit(day.Add(5*time.Hour+59*time.Minute)))
+	// window without upload limit inherits limit outside of schedule
+	assert.Equal(t, uint64(1000), l.currentLimit(day.Add(6*time.Hour+30*time.Minute)))
+	assert.Equal(t, uint64(1000), l.currentLimit(day.Add(7*time.Hour)))
+
+	schedule, err = newBandwidthSchedule([]config.BandwidthScheduleConfig{
+		{Start: "09:00", End: "18:00", UploadMaxBytesPerSecond: 100},
+		{Start: "06:00", End: "07:00", DownloadMaxBytesPerSecond: 300},
+	}, bandwidthDownload)
+	assert.NoError(t, err)
+	l = &bandwidthLimiter{maxBytesPerSecond: 2000, schedule: schedule}
+	assert.Equal(t, uint64(2000), l.currentLimit(day.Add(9*time.Hour)))
+	assert.Equal(t, uint64(300), l.currentLimit(day.Add(6*time.Hour)))
+
+	_, err = newBandwidthSchedule([]config.BandwidthScheduleConfig{{Start: "25:00", End: "06:00"}}, bandwidthUpload)
+	assert.Error(t, err)
+}
+
+func TestThroughputMeterRate(t *testing.T) {
+	m := newThroughputMeter()
+	now := time.Unix(1664582400, 0)
+	for i := 0; i < 20; i++ {
+		m.add(now.Add(time.Duration(i)*time.Second), 100)
+	}
+	// current second is not finished yet, so not included
+	assert.Equal(t, float64(100), m.rate(now.Add(19*time.Second)))
+	assert.Equal(t, float64(0), m.rate(now.Add(60*time.Second)))
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/utils"
+	apexLog "github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// archiveRestorePollInterval - pause between checks of requested restores of archived objects
+var archiveRestorePollInterval = time.Minute
+
+// backupDataPrefix - only table data is moved to colder storage class, metadata.json, table metadata, RBAC and configs stay readable for list and restore --schema
+func backupDataPrefix(backupName string) string {
+	return path.Join(backupName, "shadow")
+}
+
+// TierStorageClass - storage class for aged backups, empty string when remote storage doesn't support tiering or tier_storage_class is not defined
+func (bd *BackupDestination) TierStorageClass() string {
+	if tiering, ok := bd.RemoteStorage.(RemoteTiering); ok {
+		return tiering.TierStorageClass()
+	}
+	return ""
+}
+
+func (bd *BackupDestination) backupDataKeys(ctx context.Context, backupName string) ([]string, error) {
+	prefix := backupDataPrefix(backupName)
+	keys := make([]string, 0)
+	err := bd.Walk(ctx, prefix, true, func(ctx context.Context, f RemoteFile) error {
+		keys = append(keys, path.Join(prefix, f.Name()))
+		return nil
+	})
+	return keys, err
+}
+
+// TierBackup - move backup data to storageClass and save storage_tier to metadata.json
+func (bd *BackupDestination) TierBackup(ctx context.Context, backup Backup, storageClass string, concurrency int) error {
+	tiering, ok := bd.RemoteStorage.(RemoteTiering)
+	if !ok {
+		return fmt.Errorf("tier is not supported for %s remote storage", bd.Kind())
+	}
+	start := time.Now()
+	keys, err := bd.backupDataKeys(ctx, backup.BackupName)
+	if err != nil {
+		return fmt.Errorf("can't list %s data: %v", backup.BackupName, err)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	tierGroup, tierCtx := errgroup.WithContext(ctx)
+	tierGroup.SetLimit(concurrency)
+	for _, key := range keys {
+		key := key
+		tierGroup.Go(func() error {
+			if err := tiering.TierObject(tierCtx, key, storageClass); err != nil {
+				return fmt.Errorf("can't tier %s: %v", key, err)
+			}
+			return nil
+		})
+	}
+	if err = tierGroup.Wait(); err != nil {
+		return err
+	}
+	tieredAt := time.Now().UTC()
+	backupMetadata := backup.BackupMetadata
+	backupMetadata.StorageTier = storageClass
+	backupMetadata.TieredAt = &tieredAt
+	body, err := json.MarshalIndent(&backupMetadata, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = bd.PutFile(ctx, path.Join(backup.BackupName, "metadata.json"), io.NopCloser(bytes.NewReader(body))); err != nil {
+		return fmt.Errorf("can't update %s/metadata.json: %v", backup.BackupName, err)
+	}
+	bd.updateRemoteIndex(ctx, func(backups map[string]Backup) {
+		if indexed, exists := backups[backup.BackupName]; exists {
+			indexed.BackupMetadata = backupMetadata
+			backups[backup.BackupName] = indexed
+		}
+	})
+	bd.Log.WithFields(apexLog.Fields{
+		"backup":        backup.BackupName,
+		"operation":     "tier",
+		"storage_class": storageClass,
+		"objects":       len(keys),
+		"duration":      utils.HumanizeDuration(time.Since(start)),
+	}).Info("done")
+	return nil
+}
+
+// RestoreArchivedBackup - request restore for all archived objects of backup data and wait until all of them could be read or timeout
+func (bd *BackupDestination) RestoreArchivedBackup(ctx context.Context, backupName string, concurrency int, timeout time.Duration) error {
+	tiering, ok := bd.RemoteStorage.(RemoteTiering)
+	if !ok {
+		return nil
+	}
+	log := bd.Log.WithFields(apexLog.Fields{
+		"backup":    backupName,
+		"operation": "restore_archived",
+	})
+	start := time.Now()
+	keys, err := bd.backupDataKeys(ctx, backupName)
+	if err != nil {
+		return fmt.Errorf("can't list %s data: %v", backupName, err)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for {
+		pending := make([]string, len(keys))
+		pendingCount := int64(0)
+		restoreGroup, restoreCtx := errgroup.WithContext(ctx)
+		restoreGroup.SetLimit(concurrency)
+		for i, key := range keys {
+			idx, key := i, key
+			restoreGroup.Go(func() error {
+				isReady, err := tiering.RestoreObject(restoreCtx, key)
+				if err != nil {
+					return fmt.Errorf("can't restore %s: %v", key, err)
+				}
+				if !isReady {
+					pending[idx] = key
+					atomic.AddInt64(&pendingCount, 1)
+				}
+				return nil
+			})
+		}
+		if err = restoreGroup.Wait(); err != nil {
+			return err
+		}
+		if pendingCount == 0 {
+			if len(keys) > 0 {
+				log.WithField("duration", utils.HumanizeDuration(time.Since(start))).Debug("done")
+			}
+			return nil
+		}
+		if timeout > 0 && time.Since(start) > timeout {
+			return fmt.Errorf("%d archived objects of %s are not restored after %s, try download later", pendingCount, backupName, utils.HumanizeDuration(timeout))
+		}
+		log.Infof("wait restore of %d archived objects, next check after %s", pendingCount, archiveRestorePollInterval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(archiveRestorePollInterval):
+		}
+		keys = keys[:0]
+		for _, key := range pending {
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+}
+
+// IsTierRequired - backup is older than tierAfter and its data is not moved to storageClass yet, embedded and legacy backups are not supported
+// protected backups are skipped, in-place copy doesn't carry over GCS hold and creates unprotected S3 object version
+func (b Backup) IsTierRequired(storageClass string, tierAfter time.Duration) bool {
+	if b.Legacy || b.Broken != "" || b.UploadDate.IsZero() || strings.Contains(b.Tags, "embedded") || b.ObjectLockReason() != "" {
+		return false
+	}
+	return b.StorageTier != storageClass && time.Since(retentionDate(b)) > tierAfter
+}
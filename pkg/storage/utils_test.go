@@ -125,3 +125,173 @@ func TestGetBackupsToDeleteWithRecursiveRequiredBackups(t *testing.T) {
 	}
 	assert.Equal(t, expectedData, GetBackupsToDelete(testData, 6))
 }
+
+func TestApplyRetentionPolicy(t *testing.T) {
+	newBackup := func(name, requiredBackup string) Backup {
+		return Backup{metadata.BackupMetadata{BackupName: name, RequiredBackup: requiredBackup, CreationDate: timeParse(name)}, false, "", "", timeParse(name)}
+	}
+	testData := []Backup{
+		newBackup("2022-08-15T01-00-00", ""),
+		newBackup("2022-08-31T01-00-00", ""),
+		newBackup("2022-09-05T01-00-00", ""),
+		newBackup("2022-09-06T01-00-00", "2022-09-05T01-00-00"),
+		newBackup("2022-09-07T01-00-00", ""),
+		newBackup("2022-09-07T13-00-00", "2022-09-07T01-00-00"),
+		newBackup("2022-09-08T01-00-00", "2022-09-07T13-00-00"),
+		// UploadDate initialized with default value, upload is in progress
+		{BackupMetadata: metadata.BackupMetadata{BackupName: "2022-09-08T02-00-00", CreationDate: timeParse("2022-09-08T02-00-00")}},
+	}
+	retentions := ApplyRetentionPolicy(testData, RetentionPolicy{Last: 1, Daily: 2, Monthly: 2})
+	actual := map[string][]string{}
+	var order []string
+	for _, r := range retentions {
+		order = append(order, r.Backup.BackupName)
+		if r.Keep {
+			actual[r.Backup.BackupName] = r.Reasons
+		}
+	}
+	assert.Equal(t, []string{"2022-09-08T02-00-00", "2022-09-08T01-00-00", "2022-09-07T13-00-00", "2022-09-07T01-00-00", "2022-09-06T01-00-00", "2022-09-05T01-00-00", "2022-08-31T01-00-00", "2022-08-15T01-00-00"}, order)
+	assert.Equal(t, map[string][]string{
+		"2022-09-08T02-00-00": {"upload in progress"},
+		"2022-09-08T01-00-00": {"last 1", "daily 2022-09-08", "monthly 2022-09"},
+		"2022-09-07T13-00-00": {"daily 2022-09-07", "required by 2022-09-08T01-00-00"},
+		"2022-09-07T01-00-00": {"required by 2022-09-07T13-00-00"},
+		"2022-08-31T01-00-00": {"monthly 2022-08"},
+	}, actual)
+
+	backupsToDelete := GetBackupsToDeleteByPolicy(testData, RetentionPolicy{Weekly: 2})
+	var deletedNames []string
+	for _, b := range backupsToDelete {
+		deletedNames = append(deletedNames, b.BackupName)
+	}
+	assert.Equal(t, []string{"2022-09-06T01-00-00", "2022-09-05T01-00-00", "2022-08-15T01-00-00"}, deletedNames)
+	assert.Equal(t, []Backup{}, GetBackupsToDeleteByPolicy(testData, RetentionPolicy{}))
+
+	// backup protected by object lock is kept together with its required backup
+	retainUntil := time.Now().Add(24 * time.Hour)
+	testData[3].RetainUntil = &retainUntil
+	testData[0].ObjectHold = "temporary"
+	backupsToDelete = GetBackupsToDeleteByPolicy(testData, RetentionPolicy{Weekly: 2})
+	assert.Empty(t, backupsToDelete)
+	for _, r := range ApplyRetentionPolicy(testData, RetentionPolicy{Weekly: 2}) {
+		switch r.Backup.BackupName {
+		case "2022-09-06T01-00-00":
+			assert.True(t, r.ObjectLocked)
+			assert.Equal(t, []string{"retained by object lock until " + retainUntil.UTC().Format(time.RFC3339)}, r.Reasons)
+		case "2022-09-05T01-00-00":
+			assert.Equal(t, []string{"required by 2022-09-06T01-00-00"}, r.Reasons)
+		case "2022-08-15T01-00-00":
+			assert.Equal(t, []string{"retained by temporary object hold"}, r.Reasons)
+		}
+	}
+}
+
+func TestApplyClusterRetentionPolicy(t *testing.T) {
+	newBackup := func(name, createdAt string) Backup {
+		return Backup{metadata.BackupMetadata{BackupName: name, CreationDate: timeParse(createdAt)}, false, "", "", timeParse(createdAt)}
+	}
+	newManifest := func(name, createdAt string) metadata.ClusterBackupMetadata {
+		return metadata.ClusterBackupMetadata{
+			BackupName:   name,
+			CreationDate: timeParse(createdAt),
+			Shards: []metadata.ClusterShardBackups{
+				{ShardNum: 1, BackupName: name + "_shard1"},
+				{ShardNum: 2, BackupName: name + "_shard2"},
+			},
+		}
+	}
+	testData := []Backup{
+		newBackup("host1", "2022-09-01T01-00-00"),
+		newBackup("host2", "2022-09-03T01-00-00"),
+		newBackup("cluster1_shard1", "2022-09-02T01-00-00"),
+		newBackup("cluster1_shard2", "2022-09-02T01-00-10"),
+		newBackup("cluster2_shard1", "2022-09-04T01-00-00"),
+		newBackup("cluster2_shard2", "2022-09-04T01-00-10"),
+	}
+	manifests := []metadata.ClusterBackupMetadata{
+		newManifest("cluster1", "2022-09-02T01-00-00"),
+		newManifest("cluster2", "2022-09-04T01-00-00"),
+	}
+	collect := func(retentions []BackupRetention, clusterRetentions []ClusterRetention) (map[string]bool, map[string]bool) {
+		keep := map[string]bool{}
+		for _, r := range retentions {
+			keep[r.Backup.BackupName] = r.Keep
+		}
+		clusterKeep := map[string]bool{}
+		for _, r := range clusterRetentions {
+			clusterKeep[r.Manifest.BackupName] = r.Keep
+		}
+		return keep, clusterKeep
+	}
+
+	// shard backups are not counted by per-host backups_to_keep_remote, policy is applied to manifests
+	keep, clusterKeep := collect(ApplyClusterRetentionPolicy(testData, manifests, RetentionPolicy{Last: 1}))
+	assert.Equal(t, map[string]bool{
+		"host1":           false,
+		"host2":           true,
+		"cluster1_shard1": false,
+		"cluster1_shard2": false,
+		"cluster2_shard1": true,
+		"cluster2_shard2": true,
+	}, keep)
+	assert.Equal(t, map[string]bool{"cluster1": false, "cluster2": true}, clusterKeep)
+
+	// one protected shard keeps whole cluster backup
+	testData[3].ObjectHold = "legal"
+	retentions, clusterRetentions := ApplyClusterRetentionPolicy(testData, manifests, RetentionPolicy{Last: 1})
+	keep, clusterKeep = collect(retentions, clusterRetentions)
+	assert.True(t, keep["cluster1_shard1"])
+	assert.True(t, keep["cluster1_shard2"])
+	assert.True(t, clusterKeep["cluster1"])
+	for _, r := range retentions {
+		if r.Backup.BackupName == "cluster1_shard2" {
+			assert.True(t, r.ObjectLocked)
+			assert.Equal(t, []string{"cluster backup cluster1 cluster1_shard2 retained by legal object hold"}, r.Reasons)
+		}
+	}
+
+	// failed cluster backup is not counted by policy and is deleted with finished shard backups
+	testData[3].ObjectHold = ""
+	failed := newManifest("cluster3", "2022-09-05T01-00-00")
+	failed.Failed = "shard 2 return error"
+	retentions, clusterRetentions = ApplyClusterRetentionPolicy(append(testData, newBackup("cluster3_shard1", "2022-09-05T01-00-00")), append(manifests, failed), RetentionPolicy{Last: 1})
+	keep, clusterKeep = collect(retentions, clusterRetentions)
+	assert.False(t, keep["cluster3_shard1"])
+	assert.True(t, keep["cluster2_shard1"])
+	assert.Equal(t, map[string]bool{"cluster1": false, "cluster2": true, "cluster3": false}, clusterKeep)
+	for _, r := range retentions {
+		if r.Backup.BackupName == "cluster3_shard1" {
+			assert.Equal(t, []string{"cluster backup cluster3 failed: shard 2 return error"}, r.Reasons)
+		}
+	}
+
+	// without manifests all backups are handled by per-host retention
+	keep, clusterKeep = collect(ApplyClusterRetentionPolicy(testData[:2], nil, RetentionPolicy{Last: 1}))
+	assert.Equal(t, map[string]bool{"host1": false, "host2": true}, keep)
+	assert.Empty(t, clusterKeep)
+}
+
+func TestIsTierRequired(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	backup := Backup{BackupMetadata: metadata.BackupMetadata{BackupName: "old", CreationDate: old}, UploadDate: old}
+	assert.True(t, backup.IsTierRequired("GLACIER", 24*time.Hour))
+	assert.False(t, backup.IsTierRequired("GLACIER", 72*time.Hour))
+
+	backup.StorageTier = "GLACIER"
+	assert.False(t, backup.IsTierRequired("GLACIER", 24*time.Hour))
+	assert.True(t, backup.IsTierRequired("DEEP_ARCHIVE", 24*time.Hour))
+
+	backup.StorageTier = ""
+	backup.Tags = "embedded"
+	assert.False(t, backup.IsTierRequired("GLACIER", 24*time.Hour))
+	backup.Tags = ""
+	backup.Broken = "broken (can't stat metadata.json)"
+	assert.False(t, backup.IsTierRequired("GLACIER", 24*time.Hour))
+	backup.Broken = ""
+	backup.ObjectHold = "temporary"
+	assert.False(t, backup.IsTierRequired("GLACIER", 24*time.Hour))
+	backup.ObjectHold = ""
+	retainUntil := time.Now().Add(24 * time.Hour)
+	backup.RetainUntil = &retainUntil
+	assert.False(t, backup.IsTierRequired("GLACIER", 24*time.Hour))
+}
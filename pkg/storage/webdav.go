@@ -0,0 +1,494 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+)
+
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// WebDAV - Implement RemoteStorage over HTTP WebDAV (Nextcloud, Apache mod_dav, nginx dav module)
+type WebDAV struct {
+	Config        *config.WebDAVConfig
+	Log           *apexLog.Entry
+	client        *http.Client
+	baseURL       *url.URL
+	dirCache      map[string]bool
+	dirCacheMutex sync.RWMutex
+	digestMutex   sync.Mutex
+	digest        map[string]string
+	digestCount   uint32
+}
+
+func (dav *WebDAV) Kind() string {
+	return "WebDAV"
+}
+
+func (dav *WebDAV) Connect(ctx context.Context) error {
+	var err error
+	if dav.baseURL, err = url.Parse(strings.TrimSuffix(dav.Config.URL, "/")); err != nil {
+		return fmt.Errorf("invalid webdav.url: %v", err)
+	}
+	if dav.baseURL.Scheme != "http" && dav.baseURL.Scheme != "https" {
+		return fmt.Errorf("invalid webdav.url=%s, shall start with http:// or https://", dav.Config.URL)
+	}
+	timeout, err := time.ParseDuration(dav.Config.Timeout)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: dav.Config.InsecureSkipVerify}
+	if dav.Config.CACertFile != "" {
+		caCert, err := os.ReadFile(dav.Config.CACertFile)
+		if err != nil {
+			return fmt.Errorf("can't read webdav.ca_cert_file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return fmt.Errorf("can't parse webdav.ca_cert_file=%s", dav.Config.CACertFile)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout}).DialContext
+	transport.TLSClientConfig = tlsConfig
+	transport.ResponseHeaderTimeout = timeout
+	// upload_concurrency and download_concurrency requests go to the same host
+	transport.MaxIdleConnsPerHost = transport.MaxIdleConns
+	dav.client = &http.Client{Transport: transport}
+	dav.dirCacheMutex.Lock()
+	dav.dirCache = map[string]bool{}
+	dav.dirCacheMutex.Unlock()
+	// first request also receive digest challenge, so PutFile will not need to resend body
+	if _, err = dav.StatFile(ctx, ""); err != ErrNotFound {
+		return err
+	}
+	dirs := strings.Split(strings.Trim(dav.Config.Path, "/"), "/")
+	for i := range dirs {
+		u := *dav.baseURL
+		u.Path = path.Join("/", u.Path, path.Join(dirs[:i+1]...)) + "/"
+		u.RawPath = ""
+		if err = dav.mkcol(ctx, &u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dav *WebDAV) Close(ctx context.Context) error {
+	dav.client.CloseIdleConnections()
+	return nil
+}
+
+func (dav *WebDAV) StatFile(ctx context.Context, key string) (RemoteFile, error) {
+	responses, err := dav.propfind(ctx, key, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) == 0 {
+		return nil, ErrNotFound
+	}
+	return responses[0].remoteFile(path.Base(key))
+}
+
+func (dav *WebDAV) DeleteFile(ctx context.Context, key string) error {
+	resp, err := dav.do(ctx, "DELETE", key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer dav.closeBody(resp)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusAccepted, http.StatusNotFound:
+		dav.dirCacheMutex.Lock()
+		for dir := range dav.dirCache {
+			if dir == key || strings.HasPrefix(dir, strings.TrimSuffix(key, "/")+"/") {
+				delete(dav.dirCache, dir)
+			}
+		}
+		dav.dirCacheMutex.Unlock()
+		return nil
+	default:
+		return fmt.Errorf("DELETE %s return %s", key, resp.Status)
+	}
+}
+
+// Walk - PROPFIND with Depth: 1 for each collection, Depth: infinity is disabled on most servers
+func (dav *WebDAV) Walk(ctx context.Context, remotePath string, recursive bool, process func(context.Context, RemoteFile) error) error {
+	return dav.walk(ctx, strings.Trim(remotePath, "/"), "", recursive, process)
+}
+
+func (dav *WebDAV) walk(ctx context.Context, remotePath, relPath string, recursive bool, process func(context.Context, RemoteFile) error) error {
+	dirKey := path.Join(remotePath, relPath)
+	responses, err := dav.propfind(ctx, dirKey+"/", "1")
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dirPath := strings.TrimSuffix(dav.keyURL(dirKey).Path, "/")
+	for _, r := range responses {
+		hrefPath, err := r.path()
+		if err != nil {
+			return err
+		}
+		name := strings.Trim(strings.TrimPrefix(strings.TrimSuffix(hrefPath, "/"), dirPath), "/")
+		// first response in multistatus is the collection itself
+		if name == "" {
+			continue
+		}
+		name = path.Join(relPath, name)
+		file, err := r.remoteFile(name)
+		if err != nil {
+			return err
+		}
+		if recursive && r.isCollection() {
+			if err = dav.walk(ctx, remotePath, name, recursive, process); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = process(ctx, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dav *WebDAV) GetFileReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := dav.do(ctx, "GET", key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		dav.closeBody(resp)
+		return nil, ErrNotFound
+	default:
+		dav.closeBody(resp)
+		return nil, fmt.Errorf("GET %s return %s", key, resp.Status)
+	}
+}
+
+func (dav *WebDAV) GetFileReaderWithLocalPath(ctx context.Context, key, _ string) (io.ReadCloser, error) {
+	return dav.GetFileReader(ctx, key)
+}
+
+func (dav *WebDAV) PutFile(ctx context.Context, key string, r io.ReadCloser) error {
+	if err := dav.mkdirAll(ctx, path.Dir(key)); err != nil {
+		return err
+	}
+	// BackupDestination callers close r by themselves
+	resp, err := dav.do(ctx, "PUT", key, io.NopCloser(r), nil)
+	if err != nil {
+		return err
+	}
+	defer dav.closeBody(resp)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	default:
+		return fmt.Errorf("PUT %s return %s", key, resp.Status)
+	}
+}
+
+// mkdirAll - MKCOL each path component inside webdav.path, created collections are cached the same way as FTP.MkdirAll
+func (dav *WebDAV) mkdirAll(ctx context.Context, key string) error {
+	key = strings.Trim(key, "/")
+	if key == "" || key == "." {
+		return nil
+	}
+	dirs := strings.Split(key, "/")
+	for i := range dirs {
+		d := path.Join(dirs[:i+1]...)
+		dav.dirCacheMutex.RLock()
+		_, exists := dav.dirCache[d]
+		dav.dirCacheMutex.RUnlock()
+		if exists {
+			continue
+		}
+		if err := dav.mkcol(ctx, dav.keyURL(d+"/")); err != nil {
+			return err
+		}
+		dav.dirCacheMutex.Lock()
+		dav.dirCache[d] = true
+		dav.dirCacheMutex.Unlock()
+	}
+	return nil
+}
+
+func (dav *WebDAV) mkcol(ctx context.Context, u *url.URL) error {
+	resp, err := dav.doURL(ctx, "MKCOL", u, nil, nil)
+	if err != nil {
+		return err
+	}
+	dav.closeBody(resp)
+	// 405 Method Not Allowed mean collection already exists
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("MKCOL %s return %s", u.Path, resp.Status)
+	}
+	return nil
+}
+
+func (dav *WebDAV) propfind(ctx context.Context, key, depth string) ([]webdavResponse, error) {
+	resp, err := dav.do(ctx, "PROPFIND", key, strings.NewReader(webdavPropfindBody), map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer dav.closeBody(resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s return %s", key, resp.Status)
+	}
+	var multistatus webdavMultistatus
+	if err = xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return nil, fmt.Errorf("can't parse PROPFIND %s response: %v", key, err)
+	}
+	return multistatus.Responses, nil
+}
+
+func (dav *WebDAV) keyURL(key string) *url.URL {
+	u := *dav.baseURL
+	u.Path = path.Join("/", u.Path, dav.Config.Path, key)
+	if strings.HasSuffix(key, "/") || key == "" {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return &u
+}
+
+// do - send request with basic or digest authorization, requests without body repeated once when digest challenge is expired
+func (dav *WebDAV) do(ctx context.Context, method, key string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	return dav.doURL(ctx, method, dav.keyURL(key), body, headers)
+}
+
+func (dav *WebDAV) doURL(ctx context.Context, method string, u *url.URL, body io.Reader, headers map[string]string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		dav.authorize(req)
+		if dav.Config.Debug {
+			dav.Log.Infof("[WEBDAV_DEBUG] %s %s", method, u.String())
+		}
+		resp, err := dav.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if dav.Config.Debug {
+			dav.Log.Infof("[WEBDAV_DEBUG] %s %s return %s", method, u.String(), resp.Status)
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			return resp, nil
+		}
+		dav.closeBody(resp)
+		isChallengeUpdated := dav.saveDigestChallenge(resp.Header.Get("WWW-Authenticate"))
+		// body stream can't be read twice, upper level retries will send it again with new challenge
+		_, isReplayable := body.(*strings.Reader)
+		if attempt > 0 || !isChallengeUpdated || (body != nil && !isReplayable) {
+			return nil, fmt.Errorf("%s %s return %s", method, u.Path, resp.Status)
+		}
+		if isReplayable {
+			if _, err = body.(*strings.Reader).Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (dav *WebDAV) closeBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if err := resp.Body.Close(); err != nil {
+		dav.Log.Warnf("can't close response body: %v", err)
+	}
+}
+
+func (dav *WebDAV) authorize(req *http.Request) {
+	if dav.Config.Username == "" {
+		return
+	}
+	if dav.Config.AuthType != "digest" {
+		req.SetBasicAuth(dav.Config.Username, dav.Config.Password)
+		return
+	}
+	dav.digestMutex.Lock()
+	defer dav.digestMutex.Unlock()
+	if dav.digest == nil {
+		return
+	}
+	dav.digestCount++
+	cnonceBytes := make([]byte, 8)
+	_, _ = rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	nc := fmt.Sprintf("%08x", dav.digestCount)
+	uri := req.URL.RequestURI()
+	var h func() hash.Hash
+	algorithm := dav.digest["algorithm"]
+	if strings.EqualFold(algorithm, "SHA-256") {
+		h = sha256.New
+	} else {
+		algorithm = "MD5"
+		h = md5.New
+	}
+	hashHex := func(s string) string {
+		hh := h()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	ha1 := hashHex(dav.Config.Username + ":" + dav.digest["realm"] + ":" + dav.Config.Password)
+	ha2 := hashHex(req.Method + ":" + uri)
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s`, dav.Config.Username, dav.digest["realm"], dav.digest["nonce"], uri, algorithm)
+	if qop, ok := dav.digest["qop"]; ok && strings.Contains(qop, "auth") {
+		response := hashHex(ha1 + ":" + dav.digest["nonce"] + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+		authorization += fmt.Sprintf(`, response="%s", qop=auth, nc=%s, cnonce="%s"`, response, nc, cnonce)
+	} else {
+		authorization += fmt.Sprintf(`, response="%s"`, hashHex(ha1+":"+dav.digest["nonce"]+":"+ha2))
+	}
+	if opaque, ok := dav.digest["opaque"]; ok {
+		authorization += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	req.Header.Set("Authorization", authorization)
+}
+
+// saveDigestChallenge - parse `WWW-Authenticate: Digest realm="...", nonce="..."`, return true when challenge received
+func (dav *WebDAV) saveDigestChallenge(header string) bool {
+	if dav.Config.AuthType != "digest" || !strings.HasPrefix(strings.ToLower(header), "digest ") {
+		return false
+	}
+	challenge := map[string]string{}
+	for _, param := range splitDigestParams(header[len("digest "):]) {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		challenge[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	dav.digestMutex.Lock()
+	dav.digest = challenge
+	dav.digestCount = 0
+	dav.digestMutex.Unlock()
+	return true
+}
+
+// splitDigestParams - split by comma outside of quotes, qop="auth,auth-int" contains comma
+func splitDigestParams(s string) []string {
+	params := make([]string, 0)
+	quoted := false
+	start := 0
+	for i, c := range s {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				params = append(params, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(params, s[start:])
+}
+
+type webdavMultistatus struct {
+	Responses []webdavResponse `xml:"response"`
+}
+
+type webdavResponse struct {
+	Href      string `xml:"href"`
+	Propstats []struct {
+		Status string `xml:"status"`
+		Prop   struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+			ContentLength string `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+		} `xml:"prop"`
+	} `xml:"propstat"`
+}
+
+func (r *webdavResponse) path() (string, error) {
+	u, err := url.Parse(r.Href)
+	if err != nil {
+		return "", fmt.Errorf("can't parse href=%s: %v", r.Href, err)
+	}
+	return u.Path, nil
+}
+
+func (r *webdavResponse) isCollection() bool {
+	for _, propstat := range r.Propstats {
+		if propstat.Prop.ResourceType.Collection != nil {
+			return true
+		}
+	}
+	return strings.HasSuffix(r.Href, "/")
+}
+
+func (r *webdavResponse) remoteFile(name string) (RemoteFile, error) {
+	file := &webdavFile{name: name}
+	for _, propstat := range r.Propstats {
+		if !strings.Contains(propstat.Status, " 200") {
+			continue
+		}
+		if propstat.Prop.ContentLength != "" {
+			if _, err := fmt.Sscanf(propstat.Prop.ContentLength, "%d", &file.size); err != nil {
+				return nil, fmt.Errorf("can't parse getcontentlength=%s for %s: %v", propstat.Prop.ContentLength, name, err)
+			}
+		}
+		if propstat.Prop.LastModified != "" {
+			if lastModified, err := http.ParseTime(propstat.Prop.LastModified); err == nil {
+				file.lastModified = lastModified
+			}
+		}
+	}
+	return file, nil
+}
+
+// Implement RemoteFile
+type webdavFile struct {
+	size         int64
+	lastModified time.Time
+	name         string
+}
+
+func (file *webdavFile) Size() int64 {
+	return file.size
+}
+
+func (file *webdavFile) LastModified() time.Time {
+	return file.lastModified
+}
+
+func (file *webdavFile) Name() string {
+	return file.name
+}
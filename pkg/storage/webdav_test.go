@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/AlexAkulov/clickhouse-backup/pkg/config"
+	apexLog "github.com/apex/log"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVPutWalkDelete(t *testing.T) {
+	ctx := context.Background()
+	davHandler := &webdav.Handler{FileSystem: webdav.Dir(t.TempDir()), LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "backup" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		davHandler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	dav := &WebDAV{
+		Config: &config.WebDAVConfig{URL: server.URL, Username: "backup", Password: "secret", AuthType: "basic", Path: "/clickhouse/shard%2D1", Timeout: "1m"},
+		Log:    apexLog.WithField("logger", "WebDAV"),
+	}
+	assert.NoError(t, dav.Connect(ctx))
+	for _, key := range []string{"backup1/metadata.json", "backup1/shadow/db/table%2D1/default_all_1_1_0.tar"} {
+		assert.NoError(t, dav.PutFile(ctx, key, io.NopCloser(bytes.NewReader([]byte(key)))))
+	}
+
+	var names []string
+	assert.NoError(t, dav.Walk(ctx, "backup1/", true, func(ctx context.Context, f RemoteFile) error {
+		names = append(names, f.Name())
+		return nil
+	}))
+	sort.Strings(names)
+	assert.Equal(t, []string{"metadata.json", "shadow/db/table%2D1/default_all_1_1_0.tar"}, names)
+
+	names = names[:0]
+	assert.NoError(t, dav.Walk(ctx, "/", false, func(ctx context.Context, f RemoteFile) error {
+		names = append(names, f.Name())
+		return nil
+	}))
+	assert.Equal(t, []string{"backup1"}, names)
+
+	f, err := dav.StatFile(ctx, "backup1/metadata.json")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("backup1/metadata.json")), f.Size())
+
+	r, err := dav.GetFileReader(ctx, "backup1/metadata.json")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "backup1/metadata.json", string(content))
+
+	assert.NoError(t, dav.DeleteFile(ctx, "backup1"))
+	_, err = dav.StatFile(ctx, "backup1/metadata.json")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestWebDAVDigestAuth(t *testing.T) {
+	ctx := context.Background()
+	md5Hex := func(s string) string {
+		h := md5.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	davHandler := &webdav.Handler{FileSystem: webdav.Dir(t.TempDir()), LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := map[string]string{}
+		for _, param := range splitDigestParams(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest ")) {
+			if key, value, found := strings.Cut(strings.TrimSpace(param), "="); found {
+				params[key] = strings.Trim(value, `"`)
+			}
+		}
+		ha1 := md5Hex("backup:test:secret")
+		ha2 := md5Hex(r.Method + ":" + params["uri"])
+		if params["response"] != md5Hex(ha1+":nonce1:"+params["nc"]+":"+params["cnonce"]+":auth:"+ha2) {
+			w.Header().Set("WWW-Authenticate", `Digest realm="test", nonce="nonce1", qop="auth,auth-int", opaque="opaque1"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		davHandler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	dav := &WebDAV{
+		Config: &config.WebDAVConfig{URL: server.URL, Username: "backup", Password: "secret", AuthType: "digest", Timeout: "1m"},
+		Log:    apexLog.WithField("logger", "WebDAV"),
+	}
+	assert.NoError(t, dav.Connect(ctx))
+	assert.NoError(t, dav.PutFile(ctx, "backup1/metadata.json", io.NopCloser(bytes.NewReader([]byte("{}")))))
+	f, err := dav.StatFile(ctx, "backup1/metadata.json")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), f.Size())
+}
@@ -577,12 +577,24 @@ func TestIntegrationSFTPAuthKey(t *testing.T) {
 	runMainIntegrationScenario(t, "SFTP")
 }
 
+func TestIntegrationFS(t *testing.T) {
+	r := require.New(t)
+	r.NoError(dockerCP("config-fs.yml", "clickhouse:/etc/clickhouse-backup/config.yml"))
+	runMainIntegrationScenario(t, "FS")
+}
+
 func TestIntegrationFTP(t *testing.T) {
 	r := require.New(t)
 	r.NoError(dockerCP("config-ftp.yaml", "clickhouse:/etc/clickhouse-backup/config.yml"))
 	runMainIntegrationScenario(t, "FTP")
 }
 
+func TestIntegrationHDFS(t *testing.T) {
+	r := require.New(t)
+	r.NoError(dockerCP("config-hdfs.yml", "clickhouse:/etc/clickhouse-backup/config.yml"))
+	runMainIntegrationScenario(t, "HDFS")
+}
+
 func TestIntegrationCustom(t *testing.T) {
 	r := require.New(t)
 